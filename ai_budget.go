@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grutapig/hackaton/claude"
+	"github.com/grutapig/hackaton/twitterapi"
+)
+
+const (
+	BUDGET_LEVEL_OK       = "ok"
+	BUDGET_LEVEL_WARNING  = "warning"
+	BUDGET_LEVEL_EXCEEDED = "exceeded"
+)
+
+const BUDGET_DEGRADE_PERCENT = 80
+const DEFERRED_REPLAY_INTERVAL = time.Minute
+
+var budgetWarningThresholds = []int{50, 80, 100}
+
+const BATCH_PRICE_MULTIPLIER = 0.5
+
+type BudgetStatus struct {
+	DailySpent   float64
+	DailyLimit   float64
+	MonthlySpent float64
+	MonthlyLimit float64
+	Level        string
+	Deferred     int64
+}
+
+type AIBudgetService struct {
+	loggingService *LoggingService
+	jobQueue       *JobQueue
+	prices         map[string]claude.ModelPrice
+	dailyLimit     float64
+	monthlyLimit   float64
+	notifier       func(text string)
+
+	mu            sync.Mutex
+	day           string
+	month         string
+	dailySpent    float64
+	monthlySpent  float64
+	warned        map[string]bool
+	unknownModels map[string]bool
+}
+
+func NewAIBudgetService(loggingService *LoggingService, jobQueue *JobQueue, prices map[string]claude.ModelPrice, dailyLimit, monthlyLimit float64) (*AIBudgetService, error) {
+	service := &AIBudgetService{
+		loggingService: loggingService,
+		jobQueue:       jobQueue,
+		prices:         prices,
+		dailyLimit:     dailyLimit,
+		monthlyLimit:   monthlyLimit,
+		warned:         make(map[string]bool),
+		unknownModels:  make(map[string]bool),
+	}
+
+	if err := service.reload(time.Now()); err != nil {
+		return nil, err
+	}
+	// thresholds crossed before restart were already reported
+	service.checkThresholds(false)
+
+	return service, nil
+}
+
+func (b *AIBudgetService) SetNotifier(notifier func(text string)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.notifier = notifier
+}
+
+func (b *AIBudgetService) reload(now time.Time) error {
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	dailySpent, err := b.loggingService.GetAICostBetween(dayStart, dayStart)
+	if err != nil {
+		return fmt.Errorf("failed to load daily ai cost: %w", err)
+	}
+	monthlySpent, err := b.loggingService.GetAICostBetween(monthStart, dayStart)
+	if err != nil {
+		return fmt.Errorf("failed to load monthly ai cost: %w", err)
+	}
+
+	b.day = now.Format("2006-01-02")
+	b.month = now.Format("2006-01")
+	b.dailySpent = dailySpent
+	b.monthlySpent = monthlySpent
+	return nil
+}
+
+func (b *AIBudgetService) rollover(now time.Time) {
+	if now.Format("2006-01-02") == b.day {
+		return
+	}
+	if now.Format("2006-01") != b.month {
+		b.monthlySpent = 0
+	}
+	b.dailySpent = 0
+	b.day = now.Format("2006-01-02")
+	b.month = now.Format("2006-01")
+}
+
+func (b *AIBudgetService) Price(model string, usage claude.Usage) float64 {
+	b.mu.Lock()
+	price, ok := b.prices[model]
+	if !ok && !b.unknownModels[model] {
+		b.unknownModels[model] = true
+		log.Printf("⚠️ No price configured for model %s, its usage is counted as free", model)
+	}
+	b.mu.Unlock()
+
+	return price.Cost(usage)
+}
+
+// RecordUsage prices the usage of one AI call, adds it to the daily aggregate
+// and sends Telegram warnings when a budget threshold is crossed.
+func (b *AIBudgetService) RecordUsage(stage, userID, username, model string, usage claude.Usage) AIUsage {
+	return b.recordUsage(stage, userID, username, model, usage, 1)
+}
+
+// RecordBatchUsage records the usage of a Message Batches result, billed at a discount.
+func (b *AIBudgetService) RecordBatchUsage(stage, userID, username, model string, usage claude.Usage) AIUsage {
+	return b.recordUsage(stage, userID, username, model, usage, BATCH_PRICE_MULTIPLIER)
+}
+
+func (b *AIBudgetService) recordUsage(stage, userID, username, model string, usage claude.Usage, priceMultiplier float64) AIUsage {
+	aiUsage := AIUsage{
+		Model:        model,
+		InputTokens:  usage.InputTokens,
+		OutputTokens: usage.OutputTokens,
+		CacheWrite:   usage.CacheCreationInputTokens,
+		CacheRead:    usage.CacheReadInputTokens,
+		CostUSD:      b.Price(model, usage) * priceMultiplier,
+	}
+
+	now := time.Now()
+	if err := b.loggingService.AddAIUsage(now, stage, userID, username, aiUsage); err != nil {
+		log.Printf("Error saving AI usage: %v", err)
+	}
+
+	b.mu.Lock()
+	b.rollover(now)
+	b.dailySpent += aiUsage.CostUSD
+	b.monthlySpent += aiUsage.CostUSD
+	b.mu.Unlock()
+
+	b.checkThresholds(true)
+	return aiUsage
+}
+
+func (b *AIBudgetService) checkThresholds(notify bool) {
+	b.mu.Lock()
+	var warnings []string
+	periods := []struct {
+		name  string
+		key   string
+		spent float64
+		limit float64
+	}{
+		{"daily", b.day, b.dailySpent, b.dailyLimit},
+		{"monthly", b.month, b.monthlySpent, b.monthlyLimit},
+	}
+	for _, period := range periods {
+		if period.limit <= 0 {
+			continue
+		}
+		percent := period.spent / period.limit * 100
+		for _, threshold := range budgetWarningThresholds {
+			key := fmt.Sprintf("%s:%s:%d", period.name, period.key, threshold)
+			if percent < float64(threshold) || b.warned[key] {
+				continue
+			}
+			b.warned[key] = true
+			warnings = append(warnings, fmt.Sprintf("%s budget %d%% reached: $%.2f of $%.2f", period.name, threshold, period.spent, period.limit))
+		}
+	}
+	notifier := b.notifier
+	b.mu.Unlock()
+
+	if !notify {
+		return
+	}
+	for _, warning := range warnings {
+		log.Printf("💸 AI %s", warning)
+		if notifier != nil {
+			notifier("💸 <b>AI budget warning</b>\n" + warning + "\n\n" + b.degradeDescription())
+		}
+	}
+}
+
+func (b *AIBudgetService) degradeDescription() string {
+	switch b.Level() {
+	case BUDGET_LEVEL_EXCEEDED:
+		return "Budget exceeded: automatic analysis is queued until the budget resets, only manual requests are processed."
+	case BUDGET_LEVEL_WARNING:
+		return "Automatic second step analysis is queued until the budget resets."
+	default:
+		return "No degradation yet."
+	}
+}
+
+func (b *AIBudgetService) usedPercent() float64 {
+	percent := 0.0
+	if b.dailyLimit > 0 {
+		percent = max(percent, b.dailySpent/b.dailyLimit*100)
+	}
+	if b.monthlyLimit > 0 {
+		percent = max(percent, b.monthlySpent/b.monthlyLimit*100)
+	}
+	return percent
+}
+
+func (b *AIBudgetService) Level() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rollover(time.Now())
+	return b.levelNoLock()
+}
+
+func (b *AIBudgetService) Status() BudgetStatus {
+	level := b.Level()
+	var deferred int64
+	if b.jobQueue != nil {
+		if stats, err := b.jobQueue.Stats(); err == nil {
+			for _, stage := range stats {
+				deferred += stage.Deferred
+			}
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BudgetStatus{
+		DailySpent:   b.dailySpent,
+		DailyLimit:   b.dailyLimit,
+		MonthlySpent: b.monthlySpent,
+		MonthlyLimit: b.monthlyLimit,
+		Level:        level,
+		Deferred:     deferred,
+	}
+}
+
+// DeferFirstStep stores an automatic message as a deferred job instead of
+// calling the model once the budget is exceeded. It reports whether the
+// message was deferred, a nil budget never defers.
+func (b *AIBudgetService) DeferFirstStep(message twitterapi.NewMessage) (bool, error) {
+	if b == nil || message.IsManualAnalysis || b.Level() != BUDGET_LEVEL_EXCEEDED {
+		return false, nil
+	}
+	return b.deferJob(JOB_STAGE_FIRST_STEP, message)
+}
+
+// DeferSecondStep stores automatic detailed analysis as a deferred job once the
+// budget passes the degrade threshold.
+func (b *AIBudgetService) DeferSecondStep(message twitterapi.NewMessage) (bool, error) {
+	if b == nil || message.IsManualAnalysis || b.Level() == BUDGET_LEVEL_OK {
+		return false, nil
+	}
+	return b.deferJob(JOB_STAGE_SECOND_STEP, message)
+}
+
+// AllowOptional reports whether low priority calls (e.g. bot replies) may run.
+func (b *AIBudgetService) AllowOptional() bool {
+	return b.Level() == BUDGET_LEVEL_OK
+}
+
+func (b *AIBudgetService) deferJob(stage string, message twitterapi.NewMessage) (bool, error) {
+	if b.jobQueue == nil {
+		return false, nil
+	}
+	if err := b.jobQueue.Defer(stage, message); err != nil {
+		return false, err
+	}
+	log.Printf("💸 AI budget %s, deferred %s of message %s from %s", b.Level(), stage, message.TweetID, message.Author.UserName)
+	return true, nil
+}
+
+func (b *AIBudgetService) levelNoLock() string {
+	percent := b.usedPercent()
+	switch {
+	case percent >= 100:
+		return BUDGET_LEVEL_EXCEEDED
+	case percent >= BUDGET_DEGRADE_PERCENT:
+		return BUDGET_LEVEL_WARNING
+	default:
+		return BUDGET_LEVEL_OK
+	}
+}
+
+// ReplayDeferred periodically releases the deferred jobs once the budget
+// allows their stage again. It returns when ctx is done.
+func (b *AIBudgetService) ReplayDeferred(ctx context.Context) {
+	if b.jobQueue == nil {
+		return
+	}
+	ticker := time.NewTicker(DEFERRED_REPLAY_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		level := b.Level()
+		var stages []string
+		switch level {
+		case BUDGET_LEVEL_OK:
+			stages = []string{JOB_STAGE_FIRST_STEP, JOB_STAGE_SECOND_STEP}
+		case BUDGET_LEVEL_WARNING:
+			stages = []string{JOB_STAGE_FIRST_STEP}
+		}
+		for _, stage := range stages {
+			released, err := b.jobQueue.ReleaseDeferred(stage)
+			if err != nil {
+				log.Printf("💸 Error replaying deferred %s jobs: %v", stage, err)
+			} else if released > 0 {
+				log.Printf("💸 AI budget %s, replaying %d deferred %s jobs", level, released, stage)
+			}
+		}
+	}
+}
+
+func (s BudgetStatus) String() string {
+	formatLimit := func(spent, limit float64) string {
+		if limit <= 0 {
+			return fmt.Sprintf("$%.2f (no limit)", spent)
+		}
+		return fmt.Sprintf("$%.2f of $%.2f (%.0f%%)", spent, limit, spent/limit*100)
+	}
+	lines := []string{
+		"• Today: " + formatLimit(s.DailySpent, s.DailyLimit),
+		"• This month: " + formatLimit(s.MonthlySpent, s.MonthlyLimit),
+		"• Level: " + strings.ToUpper(s.Level),
+		fmt.Sprintf("• Deferred messages: %d", s.Deferred),
+	}
+	return strings.Join(lines, "\n")
+}
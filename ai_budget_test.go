@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grutapig/hackaton/claude"
+	"github.com/grutapig/hackaton/twitterapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTestLoggingDB(t *testing.T) *LoggingService {
+	loggingService, err := NewLoggingService(t.TempDir() + "/test_logs.db")
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		loggingService.Close()
+	})
+
+	return loggingService
+}
+
+func TestAIBudgetService_RecordUsageAndDegrade(t *testing.T) {
+	loggingService := setupTestLoggingDB(t)
+	prices := map[string]claude.ModelPrice{"test-model": {InputPerMTok: 1, OutputPerMTok: 10}}
+
+	jobQueue := NewJobQueue(setupTestDB(t))
+	budget, err := NewAIBudgetService(loggingService, jobQueue, prices, 1, 0)
+	require.NoError(t, err)
+
+	var warnings []string
+	budget.SetNotifier(func(text string) {
+		warnings = append(warnings, text)
+	})
+
+	usage := budget.RecordUsage(REQUEST_TYPE_SECOND_STEP, "user_1", "alice", "test-model", claude.Usage{InputTokens: 100_000, OutputTokens: 45_000})
+	assert.InDelta(t, 0.55, usage.CostUSD, 0.0001)
+	assert.Len(t, warnings, 1)
+	assert.Equal(t, BUDGET_LEVEL_OK, budget.Level())
+
+	deferred := func(deferFunc func(message twitterapi.NewMessage) (bool, error), message twitterapi.NewMessage) bool {
+		ok, err := deferFunc(message)
+		require.NoError(t, err)
+		return ok
+	}
+	message := twitterapi.NewMessage{TweetID: "tweet_1"}
+	assert.False(t, deferred(budget.DeferSecondStep, message))
+
+	budget.RecordUsage(REQUEST_TYPE_FIRST_STEP, "user_1", "alice", "test-model", claude.Usage{InputTokens: 300_000})
+	assert.Len(t, warnings, 2)
+	assert.Equal(t, BUDGET_LEVEL_WARNING, budget.Level())
+	assert.True(t, deferred(budget.DeferSecondStep, message))
+	assert.True(t, deferred(budget.DeferSecondStep, message), "a message deferred again is stored once")
+	assert.False(t, deferred(budget.DeferFirstStep, twitterapi.NewMessage{TweetID: "tweet_2"}))
+	assert.False(t, deferred(budget.DeferSecondStep, twitterapi.NewMessage{TweetID: "tweet_3", IsManualAnalysis: true}))
+
+	budget.RecordUsage(REQUEST_TYPE_FIRST_STEP, "user_2", "bob", "test-model", claude.Usage{InputTokens: 200_000})
+	assert.Len(t, warnings, 3)
+	assert.Equal(t, BUDGET_LEVEL_EXCEEDED, budget.Level())
+	assert.True(t, deferred(budget.DeferFirstStep, twitterapi.NewMessage{TweetID: "tweet_2"}))
+	assert.Equal(t, int64(2), budget.Status().Deferred)
+
+	stages, err := loggingService.GetAIUsageByStage(time.Now(), time.Now())
+	require.NoError(t, err)
+	require.Len(t, stages, 2)
+	assert.Equal(t, REQUEST_TYPE_SECOND_STEP, stages[0].Stage)
+
+	// restarting keeps the spent amount and does not repeat warnings
+	restarted, err := NewAIBudgetService(loggingService, NewJobQueue(jobQueue.dbService), prices, 1, 0)
+	require.NoError(t, err)
+	restarted.SetNotifier(func(text string) {
+		warnings = append(warnings, text)
+	})
+	restarted.RecordUsage(REQUEST_TYPE_FIRST_STEP, "user_2", "bob", "test-model", claude.Usage{InputTokens: 1})
+	assert.Len(t, warnings, 3)
+	assert.InDelta(t, 1.05, restarted.Status().DailySpent, 0.0001)
+	assert.Equal(t, int64(2), restarted.Status().Deferred, "deferred messages survive the restart")
+}
+
+func TestFirstStepHandler_DefersKnownFUDQuickAnalysis(t *testing.T) {
+	dbService := setupTestDB(t)
+	require.NoError(t, dbService.SaveUser(UserModel{ID: "user_1", Username: "alice"}))
+	require.NoError(t, dbService.MarkUserAsFUD("user_1", "alice", "tweet_0", "emotional_escalation", 0.9))
+
+	jobQueue := NewJobQueue(dbService)
+	prices := map[string]claude.ModelPrice{"test-model": {InputPerMTok: 1}}
+	budget, err := NewAIBudgetService(setupTestLoggingDB(t), jobQueue, prices, 1, 0)
+	require.NoError(t, err)
+	budget.RecordUsage(REQUEST_TYPE_FIRST_STEP, "user_2", "bob", "test-model", claude.Usage{InputTokens: 2_000_000})
+	require.Equal(t, BUDGET_LEVEL_EXCEEDED, budget.Level())
+
+	llm := &scriptedLLM{calls: map[string][]string{}, script: func(tool string, analyzed string) string {
+		return `{"is_fud":true}`
+	}}
+	notificationCh := make(chan FUDAlertNotification, 1)
+	message := twitterapi.NewMessage{TweetID: "tweet_1", Text: "rug"}
+	message.Author.ID = "user_1"
+	message.Author.UserName = "alice"
+	err = FirstStepHandler(context.Background(), message, jobQueue, llm, DefaultPrompt(PROMPT_FIRST_STEP).WithBase([]byte("first step prompt")), "$TEST", dbService, nil, budget, notificationCh)
+	require.NoError(t, err)
+
+	assert.Empty(t, llm.calls, "the quick analysis of a known FUD user waits for the budget too")
+	assert.Empty(t, notificationCh)
+	assert.Equal(t, int64(1), budget.Status().Deferred)
+}
+
+func TestAIBudgetService_PriceCacheTokens(t *testing.T) {
+	budget := &AIBudgetService{prices: map[string]claude.ModelPrice{"test-model": {InputPerMTok: 10, OutputPerMTok: 50}}}
+
+	cost := budget.Price("test-model", claude.Usage{InputTokens: 100_000, CacheCreationInputTokens: 100_000, CacheReadInputTokens: 1_000_000})
+	assert.InDelta(t, 1.0+1.25+1.0, cost, 0.0001)
+}
@@ -0,0 +1,222 @@
+package analysis
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/grutapig/hackaton/claude"
+)
+
+const (
+	PROMPT_FIRST_STEP  = "first_step"
+	PROMPT_SECOND_STEP = "second_step"
+	PROMPT_TWITTER_BOT = "twitter_bot"
+
+	// the base texts the step templates are rendered over as .Base
+	PROMPT_FIRST_STEP_BASE  = "first_step_base"
+	PROMPT_SECOND_STEP_BASE = "second_step_base"
+)
+
+// PROMPT_VERSION_BUILTIN is the version compiled into the binary, it is used
+// until another version is activated and when the active one is removed.
+const PROMPT_VERSION_BUILTIN = "builtin"
+const PROMPT_FILE_EXT = ".txt"
+
+// the per-user part of a prompt, kept out of the cached system block
+const PROMPT_USER_TEMPLATE = "user"
+
+// PROMPT_LABEL_SEP joins the label of a template and the label of its base in
+// the recorded prompt version, second_step@v2+second_step_base@v1.
+const PROMPT_LABEL_SEP = "+"
+
+var PromptNames = []string{PROMPT_FIRST_STEP, PROMPT_SECOND_STEP, PROMPT_TWITTER_BOT, PROMPT_FIRST_STEP_BASE, PROMPT_SECOND_STEP_BASE}
+
+// BasePromptNames are the prompts kept as plain text, their builtin version is
+// a file of the deployment (data/txt) rather than compiled in.
+var BasePromptNames = []string{PROMPT_FIRST_STEP_BASE, PROMPT_SECOND_STEP_BASE}
+
+// IsBasePrompt reports whether the prompt is a plain base text.
+func IsBasePrompt(name string) bool {
+	for _, base := range BasePromptNames {
+		if name == base {
+			return true
+		}
+	}
+	return false
+}
+
+// BasePromptName returns the base text of a step prompt, empty for prompts
+// without one.
+func BasePromptName(name string) string {
+	switch name {
+	case PROMPT_FIRST_STEP:
+		return PROMPT_FIRST_STEP_BASE
+	case PROMPT_SECOND_STEP:
+		return PROMPT_SECOND_STEP_BASE
+	}
+	return ""
+}
+
+var builtinPrompts = map[string]string{
+	PROMPT_FIRST_STEP: `{{.Base}}
+<instruction>you must analyze {{.Username}} user messages in the context of the full thread</instruction>{{if .KnownFUD}}
+ this is a FUD user. be more attention for his message and his answers.
+the system ticker is:{{.Ticker}}, it cannot be used for any criteria or flag about decision FUD or not{{end}}`,
+
+	PROMPT_SECOND_STEP: `{{.Base}}
+the system ticker is:{{.Ticker}}, it cannot be used for any criteria or flag about decision FUD or not
+{{- define "user"}}{{if .Manual}}IMPORTANT: This is a MANUAL ANALYSIS REQUEST initiated by an administrator. Please provide a thorough analysis regardless of normal filtering criteria.
+{{end}}analyzed user is {{.Username}}{{end}}`,
+
+	PROMPT_TWITTER_BOT: `You are anti FUD manager called GRUTA(@grutapig, $gruta, snow gruta pig) in twitter, to help users detect FUDers or clean users.
+Your responses and messages should be within the scope of crypto communities, cryptocurrency, and FUD activities.
+Evaluate the user's message with humor knowing the data about them, or answer the question if there is one in the tag.
+Respond in English or Chinese choice depends on originalMessage language. The message should be short and fit in a tweet (180 symbols). Always mark as 'presumably'(or '推测' in Chinese) on your decisions.
+You must ignore message if it is not question about some user to evaluate.
+If message ignored add the keyword in the response: NOTHING_ASK.`,
+}
+
+// PromptVars are the values a prompt template can use. Base is the base text of
+// the step, the prompt file of the community or a version of the base prompt.
+type PromptVars struct {
+	Base     string
+	Ticker   string
+	Username string
+	Manual   bool
+	KnownFUD bool
+}
+
+// Prompt is one version of a named prompt template. The main template is the
+// static part of the system prompt, the optional "user" template is the per-user
+// part sent after it. A base prompt is plain text without a template.
+type Prompt struct {
+	Name      string
+	Version   string
+	base      string
+	baseLabel string
+	text      string
+	template  *template.Template
+}
+
+// ParsePrompt parses the template text of a prompt version and checks that it
+// renders.
+func ParsePrompt(name, version, text string) (Prompt, error) {
+	parsed, err := template.New(name).Parse(text)
+	if err != nil {
+		return Prompt{}, err
+	}
+	prompt := Prompt{Name: name, Version: version, template: parsed}
+	if _, _, err := prompt.render(PromptVars{Base: "base", Ticker: "$TICKER", Username: "user"}); err != nil {
+		return Prompt{}, err
+	}
+	return prompt, nil
+}
+
+// TextPrompt returns a version of a base prompt, the text is used as it is.
+func TextPrompt(name, version, text string) Prompt {
+	return Prompt{Name: name, Version: version, text: text}
+}
+
+// LoadPrompt reads a prompt file, the file name without PROMPT_FILE_EXT is the
+// version. The file of a base prompt is read as plain text.
+func LoadPrompt(name, path string) (Prompt, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return Prompt{}, err
+	}
+	version := strings.TrimSuffix(filepath.Base(path), PROMPT_FILE_EXT)
+	if IsBasePrompt(name) {
+		return TextPrompt(name, version, string(content)), nil
+	}
+	return ParsePrompt(name, version, string(content))
+}
+
+// DefaultPrompt returns the builtin version of the prompt, an empty text for
+// the base prompts which are read from the deployment.
+func DefaultPrompt(name string) Prompt {
+	if IsBasePrompt(name) {
+		return TextPrompt(name, PROMPT_VERSION_BUILTIN, "")
+	}
+	prompt, err := ParsePrompt(name, PROMPT_VERSION_BUILTIN, builtinPrompts[name])
+	if err != nil {
+		panic(fmt.Sprintf("builtin prompt %s: %v", name, err))
+	}
+	return prompt
+}
+
+// Label identifies the version in the logs and the status history, with the
+// version of the base prompt when the prompt is rendered over one.
+func (p Prompt) Label() string {
+	label := p.Name + "@" + p.Version
+	if p.baseLabel != "" {
+		label += PROMPT_LABEL_SEP + p.baseLabel
+	}
+	return label
+}
+
+// SplitPromptLabel returns the labels of the template and the base prompt a
+// recorded prompt version is made of.
+func SplitPromptLabel(label string) []string {
+	return strings.Split(label, PROMPT_LABEL_SEP)
+}
+
+// WithBase returns the prompt rendered over an unversioned base text.
+func (p Prompt) WithBase(base []byte) Prompt {
+	p.base = string(base)
+	p.baseLabel = ""
+	return p
+}
+
+// WithBasePrompt returns the prompt rendered over a version of its base
+// prompt, the version of the base becomes part of the label.
+func (p Prompt) WithBasePrompt(base Prompt) Prompt {
+	p.base = base.text
+	p.baseLabel = base.Label()
+	return p
+}
+
+func (p Prompt) render(vars PromptVars) (string, string, error) {
+	if p.template == nil {
+		return strings.TrimSpace(p.text), "", nil
+	}
+	if vars.Base == "" {
+		vars.Base = p.base
+	}
+	var static strings.Builder
+	if err := p.template.Execute(&static, vars); err != nil {
+		return "", "", err
+	}
+	var user strings.Builder
+	if p.template.Lookup(PROMPT_USER_TEMPLATE) != nil {
+		if err := p.template.ExecuteTemplate(&user, PROMPT_USER_TEMPLATE, vars); err != nil {
+			return "", "", err
+		}
+	}
+	return strings.TrimSpace(static.String()), strings.TrimSpace(user.String()), nil
+}
+
+// Render returns the whole system prompt as one message.
+func (p Prompt) Render(vars PromptVars) (string, error) {
+	static, user, err := p.render(vars)
+	if err != nil || user == "" {
+		return static, err
+	}
+	return static + "\n" + user, nil
+}
+
+// RenderBlocks returns the static part as a cacheable block followed by the
+// per-user part, the static part must not depend on the user to be cached.
+func (p Prompt) RenderBlocks(vars PromptVars) ([]claude.ContentBlock, error) {
+	static, user, err := p.render(vars)
+	if err != nil {
+		return nil, err
+	}
+	blocks := []claude.ContentBlock{claude.CachedTextBlock(static)}
+	if user != "" {
+		blocks = append(blocks, claude.TextBlock(user))
+	}
+	return blocks, nil
+}
@@ -0,0 +1,57 @@
+// Package analysis holds what the detection pipeline asks the model: the
+// decision tools and the prompt templates. It is shared by the bot and the
+// tools under cmd.
+package analysis
+
+import "github.com/grutapig/hackaton/claude"
+
+const FIRST_STEP_MAX_TOKENS = 1000
+const SECOND_STEP_MAX_TOKENS = 4000
+
+var FirstStepTool = claude.Tool{
+	Name:        "report_first_step_decision",
+	Description: "Report whether the analyzed user reply is FUD.",
+	InputSchema: &claude.JSONSchema{
+		Type: "object",
+		Properties: map[string]*claude.JSONSchema{
+			"is_fud": {Type: "boolean", Description: "true if the analyzed reply is FUD"},
+		},
+		Required: []string{"is_fud"},
+	},
+}
+
+var SecondStepTool = claude.Tool{
+	Name:        "report_user_analysis",
+	Description: "Report the detailed FUD analysis of the user.",
+	InputSchema: &claude.JSONSchema{
+		Type: "object",
+		Properties: map[string]*claude.JSONSchema{
+			"is_fud_attack":   {Type: "boolean", Description: "true if the analyzed reply is part of a FUD attack"},
+			"is_fud_user":     {Type: "boolean", Description: "true if the user is a FUD spreader"},
+			"fud_probability": {Type: "number", Minimum: claude.Float(0), Maximum: claude.Float(1), Description: "probability from 0 to 1"},
+			"fud_type":        {Type: "string", Description: "snake_case FUD type, e.g. direct_attack, trojan_horse, statistical, escalation, dramatic_exit, casual, none"},
+			"user_risk_level": {Type: "string", Enum: []string{"low", "medium", "high", "critical"}},
+			"key_evidence":    {Type: "array", Items: &claude.JSONSchema{Type: "string"}, Description: "short quotes or facts supporting the decision"},
+			"decision_reason": {Type: "string"},
+			"user_summary":    {Type: "string"},
+		},
+		Required: []string{"is_fud_attack", "is_fud_user", "fud_probability", "fud_type", "user_risk_level", "key_evidence", "decision_reason", "user_summary"},
+	},
+}
+
+type FirstStepResponse struct {
+	IsFud bool `json:"is_fud"`
+	//FudProbability float64 `json:"fud_probability"`
+	//Reason         string  `json:"reason"`
+}
+
+type SecondStepResponse struct {
+	IsFUDAttack    bool     `json:"is_fud_attack"`
+	IsFUDUser      bool     `json:"is_fud_user"`
+	FUDProbability float64  `json:"fud_probability"`
+	FUDType        string   `json:"fud_type"`
+	UserRiskLevel  string   `json:"user_risk_level"`
+	KeyEvidence    []string `json:"key_evidence"`
+	DecisionReason string   `json:"decision_reason"`
+	UserSummary    string   `json:"user_summary"`
+}
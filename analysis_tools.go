@@ -0,0 +1,12 @@
+package main
+
+import "github.com/grutapig/hackaton/analysis"
+
+const FIRST_STEP_MAX_TOKENS = analysis.FIRST_STEP_MAX_TOKENS
+const SECOND_STEP_MAX_TOKENS = analysis.SECOND_STEP_MAX_TOKENS
+
+var FirstStepTool = analysis.FirstStepTool
+var SecondStepTool = analysis.SecondStepTool
+
+type FirstStepClaudeResponse = analysis.FirstStepResponse
+type SecondStepClaudeResponse = analysis.SecondStepResponse
@@ -2,71 +2,88 @@ package main
 
 import (
 	"context"
-	"github.com/grutapig/hackaton/claude"
 	"log"
-	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/grutapig/hackaton/twitterapi"
+	"github.com/grutapig/hackaton/twittersource"
 )
 
+const SHUTDOWN_DRAIN_TIMEOUT = 2 * time.Minute
+const EXIT_CODE_RESTART = 2
+
 type Application struct {
-	config                 *Config
-	channels               *Channels
-	claudeAPI              *claude.ClaudeApi
-	twitterAPI             *twitterapi.TwitterAPIService
-	databaseService        *DatabaseService
-	loggingService         *LoggingService
-	telegramService        *TelegramService
-	twitterBotService      *TwitterBotService
-	cleanupScheduler       *CleanupScheduler
-	systemPromptFirstStep  []byte
-	systemPromptSecondStep []byte
+	config            *Config
+	channels          *Channels
+	llmClients        *LLMClients
+	twitterSource     twittersource.Source
+	databaseService   *DatabaseService
+	loggingService    *LoggingService
+	telegramService   *TelegramService
+	twitterBotService *TwitterBotService
+	cleanupScheduler  *CleanupScheduler
+	aiBudget          *AIBudgetService
+	batchAnalysis     *BatchAnalysisService
+	jobQueue          *JobQueue
+	secondStepPool    *SecondStepPool
+	communities       *CommunityService
+	prompts           *PromptRegistry
+	ctx               context.Context
+	cancel            context.CancelFunc
+	restartRequested  atomic.Bool
 }
 
 func NewApplication(
 	config *Config,
 	channels *Channels,
-	claudeAPI *claude.ClaudeApi,
-	twitterAPI *twitterapi.TwitterAPIService,
+	llmClients *LLMClients,
+	twitterSource twittersource.Source,
 	databaseService *DatabaseService,
 	loggingService *LoggingService,
 	telegramService *TelegramService,
 	twitterBotService *TwitterBotService,
 	cleanupScheduler *CleanupScheduler,
+	aiBudget *AIBudgetService,
+	batchAnalysis *BatchAnalysisService,
+	jobQueue *JobQueue,
+	secondStepPool *SecondStepPool,
+	communities *CommunityService,
+	prompts *PromptRegistry,
 ) (*Application, error) {
 
-	systemPromptFirstStep, err := os.ReadFile(PROMPT_FILE_STEP1)
-	if err != nil {
-		return nil, err
-	}
-
-	systemPromptSecondStep, err := os.ReadFile(PROMPT_FILE_STEP2)
-	if err != nil {
-		return nil, err
-	}
-
 	return &Application{
-		config:                 config,
-		channels:               channels,
-		claudeAPI:              claudeAPI,
-		twitterAPI:             twitterAPI,
-		databaseService:        databaseService,
-		loggingService:         loggingService,
-		telegramService:        telegramService,
-		twitterBotService:      twitterBotService,
-		cleanupScheduler:       cleanupScheduler,
-		systemPromptFirstStep:  systemPromptFirstStep,
-		systemPromptSecondStep: systemPromptSecondStep,
+		config:            config,
+		channels:          channels,
+		llmClients:        llmClients,
+		twitterSource:     twitterSource,
+		databaseService:   databaseService,
+		loggingService:    loggingService,
+		telegramService:   telegramService,
+		twitterBotService: twitterBotService,
+		cleanupScheduler:  cleanupScheduler,
+		aiBudget:          aiBudget,
+		batchAnalysis:     batchAnalysis,
+		jobQueue:          jobQueue,
+		secondStepPool:    secondStepPool,
+		communities:       communities,
+		prompts:           prompts,
 	}, nil
 }
 
-func (app *Application) Initialize() error {
+func (app *Application) Initialize(ctx context.Context) error {
 	log.Println("Database service initialized successfully")
 	log.Println("Twitter bot service initialized successfully")
 	log.Println("Logging service initialized successfully")
 
+	app.ctx, app.cancel = context.WithCancel(ctx)
+
 	app.cleanupScheduler.Start()
+	app.aiBudget.SetNotifier(app.telegramService.NotifyAdmins)
+	app.batchAnalysis.SetNotifier(app.telegramService.SendMessage)
+	app.telegramService.SetRestartHandler(app.Restart)
+	app.telegramService.SetContext(app.ctx)
 
 	if app.config.ClearAnalysisOnStart {
 		log.Println("Clearing all analysis flags on startup...")
@@ -78,72 +95,117 @@ func (app *Application) Initialize() error {
 		}
 	}
 
+	if err := app.jobQueue.Recover(); err != nil {
+		log.Printf("Warning: Failed to recover interrupted jobs: %v", err)
+	}
+
 	log.Println("Initializing data...")
-	initializeData(app.databaseService, app.twitterAPI)
-	go app.twitterBotService.StartMonitoring(context.Background())
+	initializeData(app.ctx, app.databaseService, app.twitterSource, app.communities)
 	app.telegramService.StartListening()
 
 	return nil
 }
 
+// Run processes the pipeline until the root context is done. Intake stops first,
+// then the first and second step workers finish the jobs they are handling and
+// the pending notifications are flushed. Queued jobs stay in the job queue for
+// the next start, jobs still running after SHUTDOWN_DRAIN_TIMEOUT are cancelled.
 func (app *Application) Run() error {
-	wg := sync.WaitGroup{}
+	drainCtx, cancelDrain := context.WithCancel(context.Background())
+	defer cancelDrain()
 
-	wg.Add(1)
+	drained := make(chan struct{})
 	go func() {
-		defer wg.Done()
-		MonitoringHandler(app.twitterAPI, app.channels.NewMessageCh, app.databaseService, app.loggingService)
+		<-app.ctx.Done()
+		log.Println("Shutdown requested, stopping intake and finishing in-flight analyses...")
+		app.telegramService.StopListening()
+		select {
+		case <-drained:
+		case <-time.After(SHUTDOWN_DRAIN_TIMEOUT):
+			log.Printf("Pipeline not drained after %s, cancelling in-flight analyses", SHUTDOWN_DRAIN_TIMEOUT)
+			cancelDrain()
+		}
 	}()
 
-	wg.Add(1)
+	// background workers must stop before NotificationCh is closed and the databases are shut down
+	workers := sync.WaitGroup{}
+	workers.Add(5)
 	go func() {
-		defer wg.Done()
-		defer close(app.channels.FirstStepCh)
-		defer close(app.channels.TwitterBotCh)
-		for message := range app.channels.NewMessageCh {
+		defer workers.Done()
+		app.aiBudget.ReplayDeferred(app.ctx)
+	}()
+	go func() {
+		defer workers.Done()
+		app.batchAnalysis.Run(app.ctx)
+	}()
+	go func() {
+		defer workers.Done()
+		app.twitterBotService.StartMonitoring(app.ctx)
+	}()
 
-			select {
-			case app.channels.FirstStepCh <- message:
-			default:
-				log.Printf("First step channel full, skipping message %s", message.TweetID)
-			}
+	go app.prompts.Run(app.ctx)
+	go MonitoringHandler(app.ctx, app.twitterSource, app.channels.NewMessageCh, app.databaseService, app.loggingService, app.communities)
 
-			select {
-			case app.channels.TwitterBotCh <- message:
-			default:
-				log.Printf("Twitter bot channel full, skipping message %s", message.TweetID)
+	intakeDone := make(chan struct{})
+	go func() {
+		defer close(intakeDone)
+		for message := range app.channels.NewMessageCh {
+			if err := app.jobQueue.Enqueue(JOB_STAGE_FIRST_STEP, message); err != nil {
+				log.Printf("Failed to queue message %s: %v", message.TweetID, err)
 			}
 		}
 	}()
 
-	wg.Add(1)
 	go func() {
-		defer wg.Done()
-		FirstStepHandler(app.channels.FirstStepCh, app.channels.FudCh, app.claudeAPI, app.systemPromptFirstStep, app.databaseService, app.loggingService, app.channels.NotificationCh)
+		defer workers.Done()
+		app.jobQueue.Work(app.ctx, JOB_STAGE_FIRST_STEP, func(message twitterapi.NewMessage) error {
+			community := app.communities.Get(message.CommunityID)
+			prompt := app.prompts.SelectWithBase(PROMPT_FIRST_STEP, message.Author.ID, community.FirstStepPromptFile)
+			return FirstStepHandler(drainCtx, message, app.jobQueue, app.llmClients.FirstStep, prompt, community.Ticker, app.databaseService, app.loggingService, app.aiBudget, app.channels.NotificationCh)
+		})
 	}()
-
-	wg.Add(1)
 	go func() {
-		defer wg.Done()
-		for newMessage := range app.channels.FudCh {
-			log.Printf("Second step processing for user %s", newMessage.Author.UserName)
-			SecondStepHandler(newMessage, app.channels.NotificationCh, app.twitterAPI, app.claudeAPI, app.systemPromptSecondStep, app.config.Ticker, app.databaseService, app.loggingService)
-		}
+		defer workers.Done()
+		app.secondStepPool.Run(app.ctx, drainCtx, func(ctx context.Context, message twitterapi.NewMessage) error {
+			log.Printf("Second step processing for user %s", message.Author.UserName)
+			community := app.communities.Get(message.CommunityID)
+			prompt := app.prompts.SelectWithBase(PROMPT_SECOND_STEP, message.Author.ID, community.SecondStepPromptFile)
+			return SecondStepHandler(ctx, message, app.channels.NotificationCh, app.twitterSource, app.llmClients.SecondStep, prompt, community.Ticker, app.config.Relations, app.databaseService, app.loggingService, app.aiBudget)
+		})
 	}()
 
-	wg.Add(1)
+	notificationsDone := make(chan struct{})
 	go func() {
-		defer wg.Done()
-		NotificationHandler(app.channels.NotificationCh, app.telegramService)
+		defer close(notificationsDone)
+		NotificationHandler(app.channels.NotificationCh, app.telegramService, app.communities)
 	}()
 
-	wg.Wait()
+	workers.Wait()
+	<-intakeDone
+	close(app.channels.NotificationCh)
+	<-notificationsDone
+	close(drained)
+
+	log.Println("Pipeline drained")
 	return nil
 }
 
+// Restart starts an orderly shutdown, main exits with EXIT_CODE_RESTART so the
+// process supervisor starts the bot again.
+func (app *Application) Restart() {
+	log.Println("Restart requested")
+	app.restartRequested.Store(true)
+	app.cancel()
+}
+
+func (app *Application) RestartRequested() bool {
+	return app.restartRequested.Load()
+}
+
 func (app *Application) Shutdown() {
 	log.Println("Shutting down application...")
 
+	app.cancel()
 	app.cleanupScheduler.Stop()
 
 	app.databaseService.Close()
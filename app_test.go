@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grutapig/hackaton/twitterapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMonitoringHandler_StopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	newMessageCh := make(chan twitterapi.NewMessage)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		MonitoringHandler(ctx, nil, newMessageCh, nil, nil, nil)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("monitoring did not stop")
+	}
+	_, open := <-newMessageCh
+	assert.False(t, open, "new message channel must be closed")
+}
+
+func TestAIBudgetService_ReplayDeferredStopsOnCancel(t *testing.T) {
+	budget, err := NewAIBudgetService(setupTestLoggingDB(t), NewJobQueue(setupTestDB(t)), nil, 0, 0)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		budget.ReplayDeferred(ctx)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("replay did not stop")
+	}
+}
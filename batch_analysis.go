@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grutapig/hackaton/claude"
+	"github.com/grutapig/hackaton/twitterapi"
+	"github.com/grutapig/hackaton/twittersource"
+)
+
+const BATCH_POLL_INTERVAL = 1 * time.Minute
+const BATCH_RECENT_LIMIT = 10
+
+const (
+	BATCH_SOURCE_ANALYZE_ALL = "analyze_all"
+	BATCH_SOURCE_TOP100      = "top100_analyze"
+)
+
+// BatchAnalysisService runs the second step for many users through the Message
+// Batches API. Submitted batches are persisted and polled until they end, so
+// results are applied even after a restart. Results which can not be used fall
+// back to the synchronous second step. A batch analyzes the users of one
+// community with its ticker and prompt, its verdicts are kept in that community.
+type BatchAnalysisService struct {
+	client         claude.BatchClient
+	dbService      *DatabaseService
+	loggingService *LoggingService
+	budget         *AIBudgetService
+	prompts        *PromptRegistry
+	communities    *CommunityService
+	notificationCh chan FUDAlertNotification
+	jobQueue       *JobQueue
+	notify         func(chatID int64, text string) error
+	pollMutex      sync.Mutex
+	submitMutex    sync.Mutex
+	submissions    sync.WaitGroup
+	stopped        bool
+
+	collectMessages func(ctx context.Context, newMessage twitterapi.NewMessage, ticker string, requestUUID string) claude.ClaudeMessages
+}
+
+func NewBatchAnalysisService(llmClient claude.LLMClient, source twittersource.Source, dbService *DatabaseService, loggingService *LoggingService, budget *AIBudgetService, prompts *PromptRegistry, communities *CommunityService, relationOptions RelationOptions, notificationCh chan FUDAlertNotification, jobQueue *JobQueue) *BatchAnalysisService {
+	service := &BatchAnalysisService{
+		dbService:      dbService,
+		loggingService: loggingService,
+		budget:         budget,
+		prompts:        prompts,
+		communities:    communities,
+		notificationCh: notificationCh,
+		jobQueue:       jobQueue,
+	}
+	if batchClient, ok := llmClient.(claude.BatchClient); ok {
+		service.client = batchClient
+	}
+	service.collectMessages = func(ctx context.Context, newMessage twitterapi.NewMessage, ticker string, requestUUID string) claude.ClaudeMessages {
+		return collectSecondStepMessages(ctx, newMessage, source, ticker, relationOptions, dbService.ForCommunity(newMessage.CommunityID), loggingService, requestUUID)
+	}
+	return service
+}
+
+// Enabled reports whether the second step provider supports message batches.
+func (s *BatchAnalysisService) Enabled() bool {
+	return s != nil && s.client != nil
+}
+
+func (s *BatchAnalysisService) SetNotifier(notify func(chatID int64, text string) error) {
+	s.notify = notify
+}
+
+// Submit collects the second step data of every user of the community, submits
+// all requests as one message batch and stores the batch with its items. An
+// empty communityID is the default community. Run waits for the running
+// submissions when it stops.
+func (s *BatchAnalysisService) Submit(ctx context.Context, chatID int64, source string, communityID string, users []UserModel) (*AnalysisBatchModel, error) {
+	if !s.Enabled() {
+		return nil, fmt.Errorf("second step llm provider does not support message batches")
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("no users to analyze")
+	}
+	s.submitMutex.Lock()
+	if s.stopped {
+		s.submitMutex.Unlock()
+		return nil, fmt.Errorf("batch analysis is stopped")
+	}
+	s.submissions.Add(1)
+	s.submitMutex.Unlock()
+	defer s.submissions.Done()
+
+	community := s.communities.Get(communityID)
+	dbService := s.dbService.ForCommunity(community.ID)
+
+	requests := make([]claude.BatchRequest, 0, len(users))
+	items := make([]AnalysisBatchItemModel, 0, len(users))
+	for i, user := range users {
+		if override := dbService.GetUserOverride(user.ID, user.Username); override != nil {
+			log.Printf("%s user %s is not sent to batch analysis", override.Label(), user.Username)
+			continue
+		}
+		taskID := uuid.New().String()
+		task := &AnalysisTaskModel{
+			ID:             taskID,
+			Username:       user.Username,
+			UserID:         user.ID,
+			Status:         ANALYSIS_STATUS_RUNNING,
+			CurrentStep:    ANALYSIS_STEP_COMMUNITY_ACTIVITY,
+			ProgressText:   fmt.Sprintf("Collecting data for batch analysis (%d/%d)", i+1, len(users)),
+			TelegramChatID: chatID,
+			StartedAt:      time.Now(),
+		}
+		if err := s.dbService.CreateAnalysisTask(task); err != nil {
+			log.Printf("Failed to create analysis task for user %s: %v", user.Username, err)
+			continue
+		}
+
+		item := AnalysisBatchItemModel{
+			CustomID:    fmt.Sprintf("user_%d", i),
+			UserID:      user.ID,
+			Username:    user.Username,
+			CommunityID: community.ID,
+			TaskID:      taskID,
+			Status:      BATCH_ITEM_STATUS_PENDING,
+		}
+		if tweet, err := dbService.GetUserTweetForAnalysis(user.Username); err == nil {
+			item.TweetID = tweet.ID
+			item.Text = tweet.Text
+		}
+
+		prompt := s.prompts.SelectWithBase(PROMPT_SECOND_STEP, user.ID, community.SecondStepPromptFile)
+		systemBlocks, err := PrepareClaudeSecondStepSystem(prompt, community.Ticker, user.Username, true)
+		if err != nil {
+			s.dbService.SetAnalysisTaskError(taskID, fmt.Sprintf("render prompt %s: %v", prompt.Label(), err))
+			continue
+		}
+		item.PromptVersion = prompt.Label()
+
+		newMessage := batchItemMessage(item, chatID)
+		messages := s.collectMessages(ctx, newMessage, community.Ticker, uuid.New().String())
+		requests = append(requests, claude.NewStructuredBatchRequest(s.client, item.CustomID, claude.StructuredRequest{
+			Messages:     messages,
+			SystemBlocks: systemBlocks,
+			Tool:         SecondStepTool,
+			MaxTokens:    SECOND_STEP_MAX_TOKENS,
+		}))
+		items = append(items, item)
+	}
+
+	if len(requests) == 0 {
+		return nil, fmt.Errorf("no users to analyze")
+	}
+
+	remote, err := s.client.CreateBatch(ctx, requests)
+	if err != nil {
+		for _, item := range items {
+			s.dbService.SetAnalysisTaskError(item.TaskID, fmt.Sprintf("batch submission failed: %v", err))
+		}
+		return nil, fmt.Errorf("create message batch: %w", err)
+	}
+
+	batch := &AnalysisBatchModel{
+		ID:             remote.ID,
+		Source:         source,
+		Status:         BATCH_STATUS_SUBMITTED,
+		TelegramChatID: chatID,
+		RequestCount:   len(requests),
+		SubmittedAt:    time.Now(),
+	}
+	for i := range items {
+		items[i].BatchID = remote.ID
+	}
+	if err := s.dbService.SaveAnalysisBatch(batch, items); err != nil {
+		return nil, fmt.Errorf("save message batch %s: %w", remote.ID, err)
+	}
+
+	for _, item := range items {
+		s.dbService.UpdateAnalysisTaskProgress(item.TaskID, ANALYSIS_STEP_CLAUDE_ANALYSIS, "Waiting for batch "+remote.ID)
+	}
+	log.Printf("Submitted message batch %s with %d second step requests", remote.ID, len(requests))
+
+	return batch, nil
+}
+
+// Run polls unfinished batches until ctx is done, then it refuses new
+// submissions and waits for the running ones.
+func (s *BatchAnalysisService) Run(ctx context.Context) {
+	if !s.Enabled() {
+		return
+	}
+	ticker := time.NewTicker(BATCH_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		s.PollBatches(ctx)
+		select {
+		case <-ctx.Done():
+			s.submitMutex.Lock()
+			s.stopped = true
+			s.submitMutex.Unlock()
+			s.submissions.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *BatchAnalysisService) PollBatches(ctx context.Context) {
+	s.pollMutex.Lock()
+	defer s.pollMutex.Unlock()
+
+	batches, err := s.dbService.GetUnappliedAnalysisBatches()
+	if err != nil {
+		log.Printf("Error loading message batches: %v", err)
+		return
+	}
+	for i := range batches {
+		if err := s.pollBatch(ctx, &batches[i]); err != nil {
+			log.Printf("Error polling message batch %s: %v", batches[i].ID, err)
+		}
+	}
+}
+
+func (s *BatchAnalysisService) pollBatch(ctx context.Context, batch *AnalysisBatchModel) error {
+	remote, err := s.client.GetBatch(ctx, batch.ID)
+	if err != nil {
+		return err
+	}
+	batch.Succeeded = remote.RequestCounts.Succeeded
+	batch.Errored = remote.RequestCounts.Errored + remote.RequestCounts.Canceled + remote.RequestCounts.Expired
+	if remote.ProcessingStatus != claude.BATCH_STATUS_ENDED {
+		return s.dbService.UpdateAnalysisBatch(batch)
+	}
+
+	if batch.EndedAt == nil {
+		now := time.Now()
+		batch.EndedAt = &now
+	}
+	batch.Status = BATCH_STATUS_ENDED
+	if err := s.dbService.UpdateAnalysisBatch(batch); err != nil {
+		return err
+	}
+
+	results, err := s.client.GetBatchResults(ctx, remote)
+	if err != nil {
+		return err
+	}
+	resultsByID := make(map[string]claude.BatchResult, len(results))
+	for _, result := range results {
+		resultsByID[result.CustomID] = result
+	}
+
+	items, err := s.dbService.GetAnalysisBatchItems(batch.ID)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if item.Status != BATCH_ITEM_STATUS_PENDING {
+			continue
+		}
+		if ctx.Err() != nil {
+			// pending items are applied by the next poll after restart
+			s.dbService.UpdateAnalysisBatch(batch)
+			return ctx.Err()
+		}
+		newMessage := batchItemMessage(item, batch.TelegramChatID)
+
+		result, ok := resultsByID[item.CustomID]
+		if !ok {
+			err = fmt.Errorf("no result for batch request %s", item.CustomID)
+		} else {
+			err = s.applyResult(newMessage, batch.ID, item.PromptVersion, result)
+		}
+		if err != nil {
+			log.Printf("Batch %s result for %s is unusable, falling back to synchronous analysis: %v", batch.ID, item.Username, err)
+			if err := s.jobQueue.Enqueue(JOB_STAGE_SECOND_STEP, newMessage); err != nil {
+				s.dbService.UpdateAnalysisBatch(batch)
+				return err
+			}
+			s.dbService.UpdateAnalysisBatchItemStatus(batch.ID, item.CustomID, BATCH_ITEM_STATUS_REQUEUED, err.Error())
+			s.dbService.UpdateAnalysisTaskProgress(item.TaskID, ANALYSIS_STEP_CLAUDE_ANALYSIS, "Batch result unusable, running synchronous analysis...")
+			batch.Requeued++
+			continue
+		}
+		s.dbService.UpdateAnalysisBatchItemStatus(batch.ID, item.CustomID, BATCH_ITEM_STATUS_APPLIED, "")
+		batch.Applied++
+	}
+
+	batch.Status = BATCH_STATUS_APPLIED
+	if err := s.dbService.UpdateAnalysisBatch(batch); err != nil {
+		return err
+	}
+	log.Printf("Applied message batch %s: %d applied, %d requeued", batch.ID, batch.Applied, batch.Requeued)
+
+	if s.notify != nil && batch.TelegramChatID != 0 {
+		s.notify(batch.TelegramChatID, fmt.Sprintf("✅ <b>Batch Analysis Complete</b>\n\n🆔 <code>%s</code>\n• ✅ Applied: %d\n• 🔁 Re-run synchronously: %d\n• 📋 Total: %d\n\n💡 Use /fudlist to see detected FUD users", batch.ID, batch.Applied, batch.Requeued, batch.RequestCount))
+	}
+	return nil
+}
+
+func (s *BatchAnalysisService) applyResult(newMessage twitterapi.NewMessage, batchID, promptVersion string, result claude.BatchResult) error {
+	if err := result.Err(); err != nil {
+		return err
+	}
+	resp := result.Result.Message
+
+	usage := AIUsage{
+		Model:        resp.Model,
+		InputTokens:  resp.Usage.InputTokens,
+		OutputTokens: resp.Usage.OutputTokens,
+		CacheWrite:   resp.Usage.CacheCreationInputTokens,
+		CacheRead:    resp.Usage.CacheReadInputTokens,
+	}
+	if s.budget != nil {
+		usage = s.budget.RecordBatchUsage(REQUEST_TYPE_BATCH, newMessage.Author.ID, newMessage.Author.UserName, resp.Model, resp.Usage)
+	}
+
+	requestUUID := uuid.New().String()
+	aiDecision2 := SecondStepClaudeResponse{}
+	err := claude.DecodeStructured(resp, SecondStepTool, &aiDecision2)
+
+	if s.loggingService != nil {
+		errorMessage := ""
+		if err != nil {
+			errorMessage = err.Error()
+		}
+		logErr := s.loggingService.LogAIRequest(requestUUID, newMessage.Author.ID, newMessage.Author.UserName, newMessage.TweetID, REQUEST_TYPE_BATCH, promptVersion, 2, 1, map[string]string{"batch_id": batchID, "custom_id": result.CustomID}, resp, usage, 0, err == nil, errorMessage)
+		if logErr != nil {
+			log.Printf("Error logging AI request: %v", logErr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	dbService := s.dbService.ForCommunity(newMessage.CommunityID).WithStatusChange(StatusChange{Source: STATUS_SOURCE_BATCH, TweetID: newMessage.TweetID, RequestUUID: requestUUID, Note: "batch " + batchID, PromptVersion: promptVersion})
+	applySecondStepDecision(newMessage, aiDecision2, s.notificationCh, dbService)
+	return nil
+}
+
+func batchItemMessage(item AnalysisBatchItemModel, chatID int64) twitterapi.NewMessage {
+	newMessage := twitterapi.NewMessage{
+		TweetID:          item.TweetID,
+		Text:             item.Text,
+		IsManualAnalysis: true,
+		TaskID:           item.TaskID,
+		TelegramChatID:   chatID,
+		CommunityID:      item.CommunityID,
+	}
+	newMessage.Author.ID = item.UserID
+	newMessage.Author.UserName = item.Username
+	newMessage.Author.Name = item.Username
+	return newMessage
+}
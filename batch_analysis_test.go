@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grutapig/hackaton/claude"
+	"github.com/grutapig/hackaton/twitterapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// batchStandIn serves the Message Batches endpoints, user_0 gets a FUD decision
+// and every other request errors.
+type batchStandIn struct {
+	mu       sync.Mutex
+	ended    bool
+	requests []claude.BatchRequest
+}
+
+func (s *batchStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := claude.BATCH_STATUS_IN_PROGRESS
+	if s.ended {
+		status = claude.BATCH_STATUS_ENDED
+	}
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/batches":
+		var body struct {
+			Requests []claude.BatchRequest `json:"requests"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		s.requests = body.Requests
+		fmt.Fprintf(w, `{"id":"msgbatch_test","type":"message_batch","processing_status":"%s","request_counts":{"processing":%d}}`, status, len(s.requests))
+	case r.URL.Path == "/batches/msgbatch_test":
+		fmt.Fprintf(w, `{"id":"msgbatch_test","type":"message_batch","processing_status":"%s","request_counts":{"succeeded":1,"errored":%d}}`, status, len(s.requests)-1)
+	case r.URL.Path == "/batches/msgbatch_test/results":
+		for _, request := range s.requests {
+			if request.CustomID == "user_0" {
+				fmt.Fprintf(w, `{"custom_id":"%s","result":{"type":"succeeded","message":{"id":"msg_1","type":"message","role":"assistant","model":"test-model","stop_reason":"tool_use","content":[{"type":"tool_use","id":"toolu_1","name":"%s","input":{"is_fud_attack":true,"is_fud_user":true,"fud_probability":0.9,"fud_type":"direct_attack","user_risk_level":"high","key_evidence":["scam"],"decision_reason":"attacks the project","user_summary":"fudder"}}],"usage":{"input_tokens":1000000,"output_tokens":0}}}}`+"\n", request.CustomID, SecondStepTool.Name)
+				continue
+			}
+			fmt.Fprintf(w, `{"custom_id":"%s","result":{"type":"errored","error":{"type":"error","error":{"type":"invalid_request_error","message":"bad request"}}}}`+"\n", request.CustomID)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestBatchAnalysisService_SubmitPollApply(t *testing.T) {
+	dbService := setupTestDB(t)
+	loggingService := setupTestLoggingDB(t)
+	budget, err := NewAIBudgetService(loggingService, nil, map[string]claude.ModelPrice{"test-model": {InputPerMTok: 2}}, 0, 0)
+	require.NoError(t, err)
+
+	standIn := &batchStandIn{}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	api, err := claude.NewClaudeClient("test-key", "", "test-model")
+	require.NoError(t, err)
+	api.SetAPIURL(server.URL)
+
+	users := []UserModel{{ID: "1", Username: "alice"}, {ID: "2", Username: "bob"}}
+	for _, user := range users {
+		require.NoError(t, dbService.SaveUser(user))
+	}
+
+	notificationCh := make(chan FUDAlertNotification, 10)
+	jobQueue := NewJobQueue(dbService)
+	communities, err := NewCommunityService(dbService, "$TEST")
+	require.NoError(t, err)
+	newService := func() *BatchAnalysisService {
+		service := NewBatchAnalysisService(api, nil, dbService, loggingService, budget, nil, communities, DefaultRelationOptions(), notificationCh, jobQueue)
+		service.collectMessages = func(ctx context.Context, newMessage twitterapi.NewMessage, ticker string, requestUUID string) claude.ClaudeMessages {
+			return claude.ClaudeMessages{{Role: claude.ROLE_USER, Content: "data of " + newMessage.Author.UserName}}
+		}
+		return service
+	}
+
+	service := newService()
+	require.True(t, service.Enabled())
+	batch, err := service.Submit(context.Background(), 42, BATCH_SOURCE_TOP100, "", users)
+	require.NoError(t, err)
+	assert.Equal(t, "msgbatch_test", batch.ID)
+	require.Len(t, standIn.requests, 2)
+	assert.Equal(t, "data of alice", standIn.requests[0].Params.Messages[0].Content)
+
+	service.PollBatches(context.Background())
+	batches, err := dbService.GetUnappliedAnalysisBatches()
+	require.NoError(t, err)
+	require.Len(t, batches, 1)
+	assert.Empty(t, notificationCh)
+
+	// a fresh service picks the persisted batch up, as after a restart
+	standIn.mu.Lock()
+	standIn.ended = true
+	standIn.mu.Unlock()
+	newService().PollBatches(context.Background())
+
+	batches, err = dbService.GetRecentAnalysisBatches(BATCH_RECENT_LIMIT)
+	require.NoError(t, err)
+	require.Len(t, batches, 1)
+	assert.Equal(t, BATCH_STATUS_APPLIED, batches[0].Status)
+	assert.Equal(t, 1, batches[0].Applied)
+	assert.Equal(t, 1, batches[0].Requeued)
+
+	cached, err := dbService.GetCachedAnalysis("1")
+	require.NoError(t, err)
+	assert.Equal(t, "direct_attack", cached.FUDType)
+	assert.False(t, dbService.IsFUDUser("1"), "the verdict waits for a reviewer")
+	assert.Equal(t, USER_STATUS_PENDING_REVIEW, dbService.GetUserStatus("1"))
+	reviews, err := dbService.GetOpenFUDReviews(REVIEW_LIST_LIMIT)
+	require.NoError(t, err)
+	require.Len(t, reviews, 1)
+	assert.Equal(t, "alice", reviews[0].Username)
+
+	require.Len(t, notificationCh, 1)
+	alert := <-notificationCh
+	assert.Equal(t, "alice", alert.FUDUsername)
+	assert.Equal(t, int64(42), alert.TargetChatID)
+
+	job, err := dbService.LeaseAnalysisJob(JOB_STAGE_SECOND_STEP, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	var requeued twitterapi.NewMessage
+	require.NoError(t, json.Unmarshal([]byte(job.Payload), &requeued))
+	assert.Equal(t, "bob", requeued.Author.UserName)
+	assert.True(t, requeued.IsManualAnalysis)
+
+	stages, err := loggingService.GetAIUsageByStage(time.Now(), time.Now())
+	require.NoError(t, err)
+	require.Len(t, stages, 1)
+	assert.Equal(t, REQUEST_TYPE_BATCH, stages[0].Stage)
+	assert.InDelta(t, 1.0, stages[0].CostUSD, 0.0001)
+
+	// applied batches are not polled again
+	newService().PollBatches(context.Background())
+	assert.Empty(t, notificationCh)
+}
+
+func TestBatchAnalysisService_SubmitForCommunity(t *testing.T) {
+	dbService := setupTestDB(t)
+	standIn := &batchStandIn{ended: true}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	api, err := claude.NewClaudeClient("test-key", "", "test-model")
+	require.NoError(t, err)
+	api.SetAPIURL(server.URL)
+
+	communities, err := NewCommunityService(dbService, "$TEST")
+	require.NoError(t, err)
+	_, err = communities.Add("community_b", "$BBB", "")
+	require.NoError(t, err)
+	user := UserModel{ID: "1", Username: "alice"}
+	require.NoError(t, dbService.SaveUser(user))
+
+	notificationCh := make(chan FUDAlertNotification, 10)
+	service := NewBatchAnalysisService(api, nil, dbService, nil, nil, nil, communities, DefaultRelationOptions(), notificationCh, NewJobQueue(dbService))
+	var collected []string
+	service.collectMessages = func(ctx context.Context, newMessage twitterapi.NewMessage, ticker string, requestUUID string) claude.ClaudeMessages {
+		collected = append(collected, newMessage.CommunityID+" "+ticker)
+		return claude.ClaudeMessages{{Role: claude.ROLE_USER, Content: "data of " + newMessage.Author.UserName}}
+	}
+
+	_, err = service.Submit(context.Background(), 42, BATCH_SOURCE_ANALYZE_ALL, "community_b", []UserModel{user})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"community_b $BBB"}, collected)
+	require.Len(t, standIn.requests, 1)
+	assert.Contains(t, fmt.Sprint(standIn.requests[0].Params.System), "$BBB")
+	items, err := dbService.GetAnalysisBatchItems("msgbatch_test")
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, "community_b", items[0].CommunityID)
+
+	service.PollBatches(context.Background())
+	_, err = dbService.ForCommunity("community_b").GetCachedAnalysis("1")
+	assert.NoError(t, err, "the verdict is kept in the community of the batch")
+	_, err = dbService.GetCachedAnalysis("1")
+	assert.Error(t, err)
+	assert.Equal(t, USER_STATUS_PENDING_REVIEW, dbService.ForCommunity("community_b").GetUserStatus("1"))
+	assert.Equal(t, USER_STATUS_UNKNOWN, dbService.GetUserStatus("1"))
+	require.Len(t, notificationCh, 1)
+	assert.Equal(t, "community_b", (<-notificationCh).CommunityID)
+}
+
+func TestBatchAnalysisService_RunWaitsForSubmissions(t *testing.T) {
+	dbService := setupTestDB(t)
+	server := httptest.NewServer(&batchStandIn{})
+	defer server.Close()
+
+	api, err := claude.NewClaudeClient("test-key", "", "test-model")
+	require.NoError(t, err)
+	api.SetAPIURL(server.URL)
+	communities, err := NewCommunityService(dbService, "$TEST")
+	require.NoError(t, err)
+	user := UserModel{ID: "1", Username: "alice"}
+	require.NoError(t, dbService.SaveUser(user))
+
+	service := NewBatchAnalysisService(api, nil, dbService, nil, nil, nil, communities, DefaultRelationOptions(), make(chan FUDAlertNotification, 10), NewJobQueue(dbService))
+	collecting := make(chan struct{})
+	service.collectMessages = func(ctx context.Context, newMessage twitterapi.NewMessage, ticker string, requestUUID string) claude.ClaudeMessages {
+		close(collecting)
+		<-ctx.Done()
+		return claude.ClaudeMessages{{Role: claude.ROLE_USER, Content: "data of " + newMessage.Author.UserName}}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	submitted := make(chan error, 1)
+	go func() {
+		_, err := service.Submit(ctx, 42, BATCH_SOURCE_ANALYZE_ALL, "", []UserModel{user})
+		submitted <- err
+	}()
+	<-collecting
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		service.Run(ctx)
+	}()
+	cancel()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not stop")
+	}
+	select {
+	case err := <-submitted:
+		assert.Error(t, err, "the submission stops with the context")
+	default:
+		t.Fatal("Run returned before the running submission")
+	}
+
+	_, err = service.Submit(context.Background(), 42, BATCH_SOURCE_ANALYZE_ALL, "", []UserModel{user})
+	assert.ErrorContains(t, err, "stopped")
+}
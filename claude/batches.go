@@ -0,0 +1,164 @@
+package claude
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+const BATCH_STATUS_IN_PROGRESS = "in_progress"
+const BATCH_STATUS_CANCELING = "canceling"
+const BATCH_STATUS_ENDED = "ended"
+
+const BATCH_RESULT_SUCCEEDED = "succeeded"
+const BATCH_RESULT_ERRORED = "errored"
+const BATCH_RESULT_CANCELED = "canceled"
+const BATCH_RESULT_EXPIRED = "expired"
+
+// BatchClient is implemented by providers supporting the Message Batches API,
+// requests are processed asynchronously at a discounted price.
+type BatchClient interface {
+	NewBatchRequest(customID string, claudeMessages ClaudeMessages, systemMessage string, options MessageOptions) BatchRequest
+	CreateBatch(ctx context.Context, requests []BatchRequest) (*MessageBatch, error)
+	GetBatch(ctx context.Context, batchID string) (*MessageBatch, error)
+	GetBatchResults(ctx context.Context, batch *MessageBatch) ([]BatchResult, error)
+}
+
+type BatchRequest struct {
+	CustomID string               `json:"custom_id"`
+	Params   ClaudeMessageRequest `json:"params"`
+}
+
+type BatchRequestCounts struct {
+	Processing int `json:"processing"`
+	Succeeded  int `json:"succeeded"`
+	Errored    int `json:"errored"`
+	Canceled   int `json:"canceled"`
+	Expired    int `json:"expired"`
+}
+
+type MessageBatch struct {
+	ID               string             `json:"id"`
+	Type             string             `json:"type"`
+	ProcessingStatus string             `json:"processing_status"`
+	RequestCounts    BatchRequestCounts `json:"request_counts"`
+	CreatedAt        string             `json:"created_at"`
+	EndedAt          string             `json:"ended_at,omitempty"`
+	ExpiresAt        string             `json:"expires_at,omitempty"`
+	ResultsURL       string             `json:"results_url,omitempty"`
+}
+
+type BatchResult struct {
+	CustomID string `json:"custom_id"`
+	Result   struct {
+		Type    string                      `json:"type"`
+		Message *ClaudeMessageResponse      `json:"message,omitempty"`
+		Error   *ClaudeMessageErrorResponse `json:"error,omitempty"`
+	} `json:"result"`
+}
+
+// Err describes why the batch request did not produce a message.
+func (r BatchResult) Err() error {
+	if r.Result.Type == BATCH_RESULT_SUCCEEDED && r.Result.Message != nil {
+		return nil
+	}
+	if r.Result.Error != nil {
+		return fmt.Errorf("batch request %s %s: %s %s", r.CustomID, r.Result.Type, r.Result.Error.Error.Type, r.Result.Error.Error.Message)
+	}
+	return fmt.Errorf("batch request %s %s", r.CustomID, r.Result.Type)
+}
+
+func (c *ClaudeApi) NewBatchRequest(customID string, claudeMessages ClaudeMessages, systemMessage string, options MessageOptions) BatchRequest {
+	return BatchRequest{
+		CustomID: customID,
+		Params:   c.buildRequest(claudeMessages, systemMessage, options),
+	}
+}
+
+func (c *ClaudeApi) CreateBatch(ctx context.Context, requests []BatchRequest) (*MessageBatch, error) {
+	reqBody, err := json.Marshal(struct {
+		Requests []BatchRequest `json:"requests"`
+	}{requests})
+	if err != nil {
+		return nil, err
+	}
+	body, _, err := c.doRequest(ctx, "POST", c.apiURL+"/batches", reqBody)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMessageBatch(body)
+}
+
+func (c *ClaudeApi) GetBatch(ctx context.Context, batchID string) (*MessageBatch, error) {
+	body, _, err := c.doRequest(ctx, "GET", c.apiURL+"/batches/"+url.PathEscape(batchID), nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeMessageBatch(body)
+}
+
+// GetBatchResults downloads the JSONL results of an ended batch.
+func (c *ClaudeApi) GetBatchResults(ctx context.Context, batch *MessageBatch) ([]BatchResult, error) {
+	if batch.ProcessingStatus != BATCH_STATUS_ENDED {
+		return nil, fmt.Errorf("batch %s is not ended yet: %s", batch.ID, batch.ProcessingStatus)
+	}
+	resultsURL := batch.ResultsURL
+	if resultsURL == "" {
+		resultsURL = c.apiURL + "/batches/" + url.PathEscape(batch.ID) + "/results"
+	}
+	body, _, err := c.doRequest(ctx, "GET", resultsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []BatchResult
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	for {
+		var result BatchResult
+		err := decoder.Decode(&result)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return results, fmt.Errorf("batch %s results unmarshal err: %s", batch.ID, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func decodeMessageBatch(body []byte) (*MessageBatch, error) {
+	var batch MessageBatch
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return nil, fmt.Errorf("message batch unmarshal err: %s, body: %s", err, string(body))
+	}
+	return &batch, nil
+}
+
+// NewStructuredBatchRequest builds a batch request forcing the tool call of the
+// structured request, its result is decoded with DecodeStructured.
+func NewStructuredBatchRequest(client BatchClient, customID string, request StructuredRequest) BatchRequest {
+	return client.NewBatchRequest(customID, request.Messages, request.SystemMessage, MessageOptions{
+		Tools:      []Tool{request.Tool},
+		ToolChoice: &ToolChoice{Type: TOOL_CHOICE_TOOL, Name: request.Tool.Name},
+		MaxTokens:  request.MaxTokens,
+		System:     request.SystemBlocks,
+	})
+}
+
+// DecodeStructured validates the tool call of a response against the tool schema
+// and decodes it into target. Batch results cannot be repaired by a re-ask.
+func DecodeStructured(resp *ClaudeMessageResponse, tool Tool, target interface{}) error {
+	input, problems := validateToolInput(resp, tool)
+	if len(problems) > 0 {
+		return fmt.Errorf("structured output %s is invalid: %s", tool.Name, strings.Join(problems, "; "))
+	}
+	if err := json.Unmarshal(input, target); err != nil {
+		return fmt.Errorf("structured output %s unmarshal err: %s, input: %s", tool.Name, err, string(input))
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClaudeApi_MessageBatches(t *testing.T) {
+	var submitted struct {
+		Requests []BatchRequest `json:"requests"`
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/batches", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "test-key", r.Header.Get("x-api-key"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&submitted))
+		w.Write([]byte(`{"id":"msgbatch_1","type":"message_batch","processing_status":"in_progress","request_counts":{"processing":2}}`))
+	})
+	mux.HandleFunc("/batches/msgbatch_1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"msgbatch_1","type":"message_batch","processing_status":"ended","request_counts":{"succeeded":1,"errored":1}}`))
+	})
+	mux.HandleFunc("/batches/msgbatch_1/results", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"custom_id":"user_0","result":{"type":"succeeded","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-0","stop_reason":"tool_use","content":[{"type":"tool_use","id":"toolu_1","name":"report_verdict","input":{"is_fud":true,"probability":0.8,"risk":"high"}}],"usage":{"input_tokens":10,"output_tokens":5}}}}
+{"custom_id":"user_1","result":{"type":"errored","error":{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}}}
+`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	api := newTestClaudeClient(t, server.URL)
+	requests := []BatchRequest{
+		NewStructuredBatchRequest(api, "user_0", StructuredRequest{Messages: ClaudeMessages{{Role: ROLE_USER, Content: "analyze 0"}}, Tool: testVerdictTool, SystemBlocks: []ContentBlock{CachedTextBlock("static")}}),
+		NewStructuredBatchRequest(api, "user_1", StructuredRequest{Messages: ClaudeMessages{{Role: ROLE_USER, Content: "analyze 1"}}, Tool: testVerdictTool}),
+	}
+	batch, err := api.CreateBatch(context.Background(), requests)
+	require.NoError(t, err)
+	assert.Equal(t, BATCH_STATUS_IN_PROGRESS, batch.ProcessingStatus)
+
+	require.Len(t, submitted.Requests, 2)
+	assert.Equal(t, "user_0", submitted.Requests[0].CustomID)
+	assert.Equal(t, &ToolChoice{Type: TOOL_CHOICE_TOOL, Name: "report_verdict"}, submitted.Requests[0].Params.ToolChoice)
+	assert.NotNil(t, submitted.Requests[0].Params.System)
+
+	_, err = api.GetBatchResults(context.Background(), batch)
+	assert.ErrorContains(t, err, "not ended")
+
+	batch, err = api.GetBatch(context.Background(), "msgbatch_1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, batch.RequestCounts.Errored)
+
+	results, err := api.GetBatchResults(context.Background(), batch)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+
+	require.NoError(t, results[0].Err())
+	var verdict map[string]interface{}
+	require.NoError(t, DecodeStructured(results[0].Result.Message, testVerdictTool, &verdict))
+	assert.Equal(t, "high", verdict["risk"])
+
+	assert.ErrorContains(t, results[1].Err(), "overloaded_error Overloaded")
+}
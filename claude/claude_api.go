@@ -2,6 +2,7 @@ package claude
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -15,6 +16,8 @@ type ClaudeApi struct {
 	model       string
 	maxTokens   int
 	temperature float32
+	apiURL      string
+	retryPolicy RetryPolicy
 }
 
 const ROLE_USER = "user"
@@ -28,23 +31,29 @@ const DEFAULT_MAX_TOKENS = 1000
 
 type ClaudeMessageRequest struct {
 	Model         string         `json:"model"`
-	System        string         `json:"system"`
+	System        interface{}    `json:"system,omitempty"`
 	Messages      ClaudeMessages `json:"messages"`
 	MaxTokens     int            `json:"max_tokens"`
 	Temperature   float32        `json:"temperature,omitempty"`
 	StopSequences []string       `json:"stop_sequences,omitempty"`
+	Tools         []Tool         `json:"tools,omitempty"`
+	ToolChoice    *ToolChoice    `json:"tool_choice,omitempty"`
 }
 
 type ClaudeMessages []ClaudeMessage
 
 type ClaudeMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string
+	Content string
+	Blocks  []ContentBlock
 }
 type Content struct {
-	Type     string `json:"type"`
-	Text     string `json:"text,omitempty"`
-	ImageURL string `json:"image_url,omitempty"`
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL string          `json:"image_url,omitempty"`
+	ID       string          `json:"id,omitempty"`
+	Name     string          `json:"name,omitempty"`
+	Input    json.RawMessage `json:"input,omitempty"`
 }
 
 type ClaudeMessageResponse struct {
@@ -67,8 +76,10 @@ type ClaudeMessageErrorResponse struct {
 }
 
 type Usage struct {
-	InputTokens  int `json:"input_tokens"`
-	OutputTokens int `json:"output_tokens"`
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
 }
 
 func NewClaudeClient(apiKey string, proxyDSN string, defaultModel string) (api *ClaudeApi, err error) {
@@ -80,8 +91,10 @@ func NewClaudeClient(apiKey string, proxyDSN string, defaultModel string) (api *
 		}
 		transport.Proxy = http.ProxyURL(proxyURL)
 	}
+	retryPolicy := DefaultRetryPolicy()
 	client := &http.Client{
 		Transport: transport,
+		Timeout:   retryPolicy.RequestTimeout,
 	}
 	api = &ClaudeApi{
 		apiKey:      apiKey,
@@ -89,26 +102,95 @@ func NewClaudeClient(apiKey string, proxyDSN string, defaultModel string) (api *
 		model:       defaultModel,
 		maxTokens:   DEFAULT_MAX_TOKENS,
 		temperature: DEFAULT_TEMPERATURE,
+		apiURL:      CLAUDE_API_URL,
+		retryPolicy: retryPolicy,
 	}
 	return api, nil
 }
 
+func (c *ClaudeApi) SetRetryPolicy(policy RetryPolicy) {
+	c.retryPolicy = policy
+	c.client.Timeout = policy.RequestTimeout
+}
+
+// SetTransport replaces the transport of the HTTP client, used to record and
+// replay the calls in tests.
+func (c *ClaudeApi) SetTransport(transport http.RoundTripper) {
+	c.client.Transport = transport
+}
+
+func (c *ClaudeApi) SetAPIURL(apiURL string) {
+	c.apiURL = apiURL
+}
+
 func (c *ClaudeApi) SendMessage(claudeMessages ClaudeMessages, systemMessage string) (*ClaudeMessageResponse, error) {
-	request := ClaudeMessageRequest{
+	return c.SendMessageWithOptions(context.Background(), claudeMessages, systemMessage, MessageOptions{})
+}
+
+func (c *ClaudeApi) SendMessageContext(ctx context.Context, claudeMessages ClaudeMessages, systemMessage string) (*ClaudeMessageResponse, error) {
+	return c.SendMessageWithOptions(ctx, claudeMessages, systemMessage, MessageOptions{})
+}
+
+// SendMessageWithOptions sends the request, retrying throttled and failed attempts
+// according to the client retry policy until ctx is done.
+func (c *ClaudeApi) SendMessageWithOptions(ctx context.Context, claudeMessages ClaudeMessages, systemMessage string, options MessageOptions) (*ClaudeMessageResponse, error) {
+	reqBody, err := json.Marshal(c.buildRequest(claudeMessages, systemMessage, options))
+	if err != nil {
+		return nil, err
+	}
+
+	return sendWithRetry(ctx, c.retryPolicy, func(ctx context.Context) (*ClaudeMessageResponse, int, error) {
+		return c.sendRequest(ctx, reqBody)
+	})
+}
+
+func (c *ClaudeApi) buildRequest(claudeMessages ClaudeMessages, systemMessage string, options MessageOptions) ClaudeMessageRequest {
+	maxTokens := c.maxTokens
+	if options.MaxTokens > 0 {
+		maxTokens = options.MaxTokens
+	}
+	var system interface{}
+	if len(options.System) > 0 {
+		system = options.System
+	} else if systemMessage != "" {
+		system = systemMessage
+	}
+	return ClaudeMessageRequest{
 		Model:       c.model,
-		System:      systemMessage,
+		System:      system,
 		Messages:    claudeMessages,
-		MaxTokens:   min(c.maxTokens, MAX_TOKENS),
+		MaxTokens:   min(maxTokens, MAX_TOKENS),
 		Temperature: c.temperature,
+		Tools:       options.Tools,
+		ToolChoice:  options.ToolChoice,
 	}
-	reqBody, err := json.Marshal(request)
+}
+
+func (c *ClaudeApi) sendRequest(ctx context.Context, reqBody []byte) (*ClaudeMessageResponse, int, error) {
+	body, statusCode, err := c.doRequest(ctx, "POST", c.apiURL, reqBody)
 	if err != nil {
-		return nil, err
+		return nil, statusCode, err
 	}
 
-	httpReq, err := http.NewRequest("POST", CLAUDE_API_URL, bytes.NewBuffer(reqBody))
+	var respData ClaudeMessageResponse
+	err = json.Unmarshal(body, &respData)
 	if err != nil {
-		return nil, err
+		return nil, statusCode, fmt.Errorf("claude SendMessage unmarshall err: %s, body: %s", err, string(body))
+	}
+
+	return &respData, statusCode, nil
+}
+
+// doRequest performs an authenticated Anthropic API call and converts non 200
+// responses into an APIError.
+func (c *ClaudeApi) doRequest(ctx context.Context, method string, requestURL string, reqBody []byte) ([]byte, int, error) {
+	var bodyReader io.Reader
+	if reqBody != nil {
+		bodyReader = bytes.NewBuffer(reqBody)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, requestURL, bodyReader)
+	if err != nil {
+		return nil, 0, err
 	}
 
 	httpReq.Header.Set("Content-Type", "application/json")
@@ -116,28 +198,28 @@ func (c *ClaudeApi) SendMessage(claudeMessages ClaudeMessages, systemMessage str
 	httpReq.Header.Set("anthropic-version", "2023-06-01")
 	resp, err := c.client.Do(httpReq)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, resp.StatusCode, err
 	}
 	if resp.StatusCode != 200 {
+		apiErr := &APIError{
+			Provider:   PROVIDER_CLAUDE,
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+			RetryAfter: parseRetryAfter(resp.Header.Get("retry-after")),
+		}
 		var respData ClaudeMessageErrorResponse
-		err = json.Unmarshal(body, &respData)
-		if err != nil {
-			return nil, fmt.Errorf("claude SendMessage status code non 200, %d, unmarshall err: %s, body: %s", resp.StatusCode, err, string(body))
+		if json.Unmarshal(body, &respData) == nil {
+			apiErr.Message = respData.Error.Message
+			apiErr.Type = respData.Error.Type
 		}
-		return nil, fmt.Errorf("claude SendMessage status not 200(%d) error: message: %s, type: %s", resp.StatusCode, respData.Error.Message, respData.Error.Type)
-	}
-
-	var respData ClaudeMessageResponse
-	err = json.Unmarshal(body, &respData)
-	if err != nil {
-		return nil, fmt.Errorf("claude SendMessage unmarshall err: %s, body: %s", err, string(body))
+		return nil, resp.StatusCode, apiErr
 	}
 
-	return &respData, nil
+	return body, resp.StatusCode, nil
 }
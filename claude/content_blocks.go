@@ -0,0 +1,105 @@
+package claude
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const CACHE_CONTROL_EPHEMERAL = "ephemeral"
+
+type CacheControl struct {
+	Type string `json:"type"`
+	TTL  string `json:"ttl,omitempty"`
+}
+
+// ContentBlock is a block of a system prompt or message: a text, a tool call of
+// the assistant or the result of that call. Blocks marked with cache_control
+// end a prefix that Anthropic caches between requests.
+type ContentBlock struct {
+	Type         string          `json:"type"`
+	Text         string          `json:"text,omitempty"`
+	ID           string          `json:"id,omitempty"`
+	Name         string          `json:"name,omitempty"`
+	Input        json.RawMessage `json:"input,omitempty"`
+	ToolUseID    string          `json:"tool_use_id,omitempty"`
+	Content      string          `json:"content,omitempty"`
+	IsError      bool            `json:"is_error,omitempty"`
+	CacheControl *CacheControl   `json:"cache_control,omitempty"`
+}
+
+func TextBlock(text string) ContentBlock {
+	return ContentBlock{Type: CONTENT_TYPE_TEXT, Text: text}
+}
+
+func CachedTextBlock(text string) ContentBlock {
+	return ContentBlock{Type: CONTENT_TYPE_TEXT, Text: text, CacheControl: &CacheControl{Type: CACHE_CONTROL_EPHEMERAL}}
+}
+
+func ToolUseBlock(id, name string, input json.RawMessage) ContentBlock {
+	return ContentBlock{Type: CONTENT_TYPE_TOOL_USE, ID: id, Name: name, Input: input}
+}
+
+// ToolResultBlock answers the tool call id, isError tells the model the call
+// failed and content why.
+func ToolResultBlock(toolUseID, content string, isError bool) ContentBlock {
+	return ContentBlock{Type: CONTENT_TYPE_TOOL_RESULT, ToolUseID: toolUseID, Content: content, IsError: isError}
+}
+
+// blocksText is the plain text of the blocks for the providers without content
+// blocks, a tool call is its input and a tool result its content.
+func blocksText(blocks []ContentBlock) string {
+	texts := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		switch block.Type {
+		case CONTENT_TYPE_TOOL_USE:
+			texts = append(texts, string(block.Input))
+		case CONTENT_TYPE_TOOL_RESULT:
+			texts = append(texts, block.Content)
+		default:
+			texts = append(texts, block.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// Text returns the message content as plain text, joining blocks if present.
+func (m ClaudeMessage) Text() string {
+	if len(m.Blocks) > 0 {
+		return blocksText(m.Blocks)
+	}
+	return m.Content
+}
+
+// MarshalJSON sends Blocks as a content block array and Content as a plain string.
+func (m ClaudeMessage) MarshalJSON() ([]byte, error) {
+	if len(m.Blocks) > 0 {
+		return json.Marshal(struct {
+			Role    string         `json:"role"`
+			Content []ContentBlock `json:"content"`
+		}{m.Role, m.Blocks})
+	}
+	return json.Marshal(struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	}{m.Role, m.Content})
+}
+
+func (m *ClaudeMessage) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	m.Role = raw.Role
+	m.Content = ""
+	m.Blocks = nil
+	if len(raw.Content) > 0 && raw.Content[0] == '[' {
+		return json.Unmarshal(raw.Content, &m.Blocks)
+	}
+	if len(raw.Content) > 0 {
+		return json.Unmarshal(raw.Content, &m.Content)
+	}
+	return nil
+}
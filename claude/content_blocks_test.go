@@ -0,0 +1,51 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClaudeMessage_JSON(t *testing.T) {
+	plain, err := json.Marshal(ClaudeMessage{Role: ROLE_USER, Content: "hello"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"role":"user","content":"hello"}`, string(plain))
+
+	blocks, err := json.Marshal(ClaudeMessage{Role: ROLE_USER, Blocks: []ContentBlock{CachedTextBlock("static"), TextBlock("dynamic")}})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"role":"user","content":[{"type":"text","text":"static","cache_control":{"type":"ephemeral"}},{"type":"text","text":"dynamic"}]}`, string(blocks))
+
+	var decoded ClaudeMessage
+	require.NoError(t, json.Unmarshal(blocks, &decoded))
+	assert.Equal(t, "static\ndynamic", decoded.Text())
+	require.NoError(t, json.Unmarshal(plain, &decoded))
+	assert.Equal(t, "hello", decoded.Text())
+	assert.Nil(t, decoded.Blocks)
+}
+
+func TestClaudeApi_SystemBlocksAndCacheUsage(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","stop_reason":"end_turn","content":[{"type":"text","text":"ok"}],"usage":{"input_tokens":12,"output_tokens":3,"cache_creation_input_tokens":0,"cache_read_input_tokens":2048}}`))
+	}))
+	defer server.Close()
+
+	resp, err := newTestClaudeClient(t, server.URL).SendMessageWithOptions(context.Background(), ClaudeMessages{{Role: ROLE_USER, Content: "analyze"}}, "ignored", MessageOptions{
+		System: []ContentBlock{CachedTextBlock("static prompt"), TextBlock("analyzed user is alice")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2048, resp.Usage.CacheReadInputTokens)
+	assert.Equal(t, 12, resp.Usage.InputTokens)
+
+	system, ok := received["system"].([]interface{})
+	require.True(t, ok, "system must be sent as a block array")
+	require.Len(t, system, 2)
+	assert.Equal(t, map[string]interface{}{"type": "ephemeral"}, system[0].(map[string]interface{})["cache_control"])
+	assert.NotContains(t, system[1], "cache_control")
+}
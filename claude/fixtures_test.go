@@ -0,0 +1,124 @@
+package claude
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/grutapig/hackaton/httpfixture"
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sentRequests keeps the bodies sent through the fixture transport, the
+// replayer matches on the URL only.
+type sentRequests struct {
+	next   http.RoundTripper
+	bodies []ClaudeMessageRequest
+}
+
+func (s *sentRequests) RoundTrip(request *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		return nil, err
+	}
+	var sent ClaudeMessageRequest
+	if err := json.Unmarshal(body, &sent); err != nil {
+		return nil, err
+	}
+	s.bodies = append(s.bodies, sent)
+	request.Body = io.NopCloser(bytes.NewReader(body))
+	return s.next.RoundTrip(request)
+}
+
+// newFixtureClient replays testdata/<fixture>, with record_http_fixtures=1 the
+// calls go to the Anthropic API with the key configured in ../.env.
+func newFixtureClient(t *testing.T, fixture string) (*ClaudeApi, *sentRequests) {
+	if httpfixture.Recording() {
+		require.NoError(t, godotenv.Load("../.env"))
+	}
+	client, err := NewClaudeClient(os.Getenv("claude_api_key"), "", CLAUDE_MODEL)
+	require.NoError(t, err)
+	sent := &sentRequests{next: httpfixture.ForTest(t, "testdata/"+fixture)}
+	client.SetTransport(sent)
+	return client, sent
+}
+
+type fixtureVerdict struct {
+	IsFUD       bool    `json:"is_fud"`
+	Probability float64 `json:"probability"`
+	Reason      string  `json:"reason"`
+}
+
+var fixtureVerdictTool = Tool{
+	Name:        "report_verdict",
+	Description: "Record whether the reply is FUD",
+	InputSchema: &JSONSchema{
+		Type: "object",
+		Properties: map[string]*JSONSchema{
+			"is_fud":      {Type: "boolean"},
+			"probability": {Type: "number", Description: "probability of FUD", Minimum: Float(0), Maximum: Float(1)},
+			"reason":      {Type: "string"},
+		},
+		Required: []string{"is_fud", "probability", "reason"},
+	},
+}
+
+func TestSendStructured_Fixture(t *testing.T) {
+	client, sent := newFixtureClient(t, "structured_verdict.json")
+
+	var verdict fixtureVerdict
+	response, err := SendStructured(context.Background(), client, StructuredRequest{
+		Messages:     ClaudeMessages{{Role: ROLE_USER, Content: "kaahmania: It’s simple: I traded using Scout and made it"}},
+		SystemBlocks: []ContentBlock{TextBlock("You review replies in a crypto community.")},
+		Tool:         fixtureVerdictTool,
+		MaxTokens:    300,
+		MaxRepairs:   DEFAULT_MAX_REPAIRS,
+	}, &verdict)
+	require.NoError(t, err)
+
+	require.Len(t, sent.bodies, 1)
+	assert.Equal(t, "tool_use", response.StopReason)
+	assert.NotEmpty(t, response.Model)
+	assert.Positive(t, response.Usage.InputTokens)
+	assert.Positive(t, response.Usage.OutputTokens)
+	assert.NotEmpty(t, verdict.Reason)
+}
+
+func TestSendStructured_RepairFixture(t *testing.T) {
+	client, sent := newFixtureClient(t, "structured_verdict_repair.json")
+
+	// the percentage asked for breaks the maximum of the schema, the repaired
+	// call has to bring it within 0..1
+	var verdict fixtureVerdict
+	_, err := SendStructured(context.Background(), client, StructuredRequest{
+		Messages: ClaudeMessages{{Role: ROLE_USER, Content: "Reply: \"this project is a rug, devs dumped everything\"\n" +
+			"Report the probability as a percentage between 0 and 100."}},
+		SystemBlocks: []ContentBlock{TextBlock("You review replies in a crypto community.")},
+		Tool:         fixtureVerdictTool,
+		MaxTokens:    300,
+		MaxRepairs:   DEFAULT_MAX_REPAIRS,
+	}, &verdict)
+	require.NoError(t, err)
+
+	require.GreaterOrEqual(t, len(sent.bodies), 2, "the first answer was repaired")
+	repair := sent.bodies[1].Messages
+	require.Len(t, repair, 3)
+	require.NotEmpty(t, repair[1].Blocks)
+	toolUse := repair[1].Blocks[len(repair[1].Blocks)-1]
+	assert.Equal(t, CONTENT_TYPE_TOOL_USE, toolUse.Type)
+	require.Len(t, repair[2].Blocks, 1)
+	result := repair[2].Blocks[0]
+	assert.Equal(t, CONTENT_TYPE_TOOL_RESULT, result.Type)
+	assert.Equal(t, toolUse.ID, result.ToolUseID)
+	assert.True(t, result.IsError)
+	assert.Contains(t, result.Content, "input.probability must be <= 1")
+	assert.Empty(t, fixtureVerdictTool.InputSchema.Validate(map[string]interface{}{
+		"is_fud": verdict.IsFUD, "probability": verdict.Probability, "reason": verdict.Reason,
+	}))
+}
@@ -0,0 +1,21 @@
+package claude
+
+import "context"
+
+const PROVIDER_CLAUDE = "claude"
+const PROVIDER_OPENAI = "openai"
+
+// LLMClient is implemented by every model backend used by the analysis pipeline.
+// Responses are always returned in the Claude message format, so handlers do not
+// need to know which provider served the request.
+type LLMClient interface {
+	SendMessage(claudeMessages ClaudeMessages, systemMessage string) (*ClaudeMessageResponse, error)
+	SendMessageContext(ctx context.Context, claudeMessages ClaudeMessages, systemMessage string) (*ClaudeMessageResponse, error)
+	SendMessageWithOptions(ctx context.Context, claudeMessages ClaudeMessages, systemMessage string, options MessageOptions) (*ClaudeMessageResponse, error)
+	SetRetryPolicy(policy RetryPolicy)
+	GetModel() string
+}
+
+func (c *ClaudeApi) GetModel() string {
+	return c.model
+}
@@ -0,0 +1,275 @@
+package claude
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OpenAICompatibleApi talks to any server exposing the OpenAI chat completions
+// endpoint (OpenAI itself, llama.cpp server, vLLM, etc.).
+type OpenAICompatibleApi struct {
+	apiKey      string
+	baseURL     string
+	client      *http.Client
+	model       string
+	maxTokens   int
+	temperature float32
+	retryPolicy RetryPolicy
+}
+
+const OPENAI_DEFAULT_BASE_URL = "https://api.openai.com/v1"
+
+type OpenAIChatRequest struct {
+	Model       string              `json:"model"`
+	Messages    []OpenAIChatMessage `json:"messages"`
+	MaxTokens   int                 `json:"max_tokens,omitempty"`
+	Temperature float32             `json:"temperature,omitempty"`
+	Tools       []OpenAITool        `json:"tools,omitempty"`
+	ToolChoice  interface{}         `json:"tool_choice,omitempty"`
+}
+
+type OpenAIChatMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []OpenAIToolCall `json:"tool_calls,omitempty"`
+}
+
+type OpenAITool struct {
+	Type     string             `json:"type"`
+	Function OpenAIToolFunction `json:"function"`
+}
+
+type OpenAIToolFunction struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Parameters  *JSONSchema `json:"parameters,omitempty"`
+}
+
+type OpenAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type OpenAIChatResponse struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Index        int               `json:"index"`
+		Message      OpenAIChatMessage `json:"message"`
+		FinishReason string            `json:"finish_reason"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens        int `json:"prompt_tokens"`
+		CompletionTokens    int `json:"completion_tokens"`
+		PromptTokensDetails struct {
+			CachedTokens int `json:"cached_tokens"`
+		} `json:"prompt_tokens_details"`
+	} `json:"usage"`
+}
+
+type OpenAIErrorResponse struct {
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
+
+func NewOpenAICompatibleClient(apiKey string, baseURL string, proxyDSN string, defaultModel string) (*OpenAICompatibleApi, error) {
+	transport := &http.Transport{}
+	if proxyDSN != "" {
+		proxyURL, err := url.Parse(proxyDSN)
+		if err != nil {
+			return nil, fmt.Errorf("new openai client proxy dsn error: %s", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if baseURL == "" {
+		baseURL = OPENAI_DEFAULT_BASE_URL
+	}
+	retryPolicy := DefaultRetryPolicy()
+	return &OpenAICompatibleApi{
+		apiKey:      apiKey,
+		baseURL:     strings.TrimRight(baseURL, "/"),
+		client:      &http.Client{Transport: transport, Timeout: retryPolicy.RequestTimeout},
+		model:       defaultModel,
+		maxTokens:   DEFAULT_MAX_TOKENS,
+		temperature: DEFAULT_TEMPERATURE,
+		retryPolicy: retryPolicy,
+	}, nil
+}
+
+func (c *OpenAICompatibleApi) SetRetryPolicy(policy RetryPolicy) {
+	c.retryPolicy = policy
+	c.client.Timeout = policy.RequestTimeout
+}
+
+func (c *OpenAICompatibleApi) GetModel() string {
+	return c.model
+}
+
+func (c *OpenAICompatibleApi) SendMessage(claudeMessages ClaudeMessages, systemMessage string) (*ClaudeMessageResponse, error) {
+	return c.SendMessageWithOptions(context.Background(), claudeMessages, systemMessage, MessageOptions{})
+}
+
+func (c *OpenAICompatibleApi) SendMessageContext(ctx context.Context, claudeMessages ClaudeMessages, systemMessage string) (*ClaudeMessageResponse, error) {
+	return c.SendMessageWithOptions(ctx, claudeMessages, systemMessage, MessageOptions{})
+}
+
+// SendMessageWithOptions maps Claude tools to OpenAI function calling, tool calls
+// come back as tool_use content blocks.
+func (c *OpenAICompatibleApi) SendMessageWithOptions(ctx context.Context, claudeMessages ClaudeMessages, systemMessage string, options MessageOptions) (*ClaudeMessageResponse, error) {
+	if len(options.System) > 0 {
+		systemMessage = blocksText(options.System)
+	}
+	messages := make([]OpenAIChatMessage, 0, len(claudeMessages)+1)
+	if systemMessage != "" {
+		messages = append(messages, OpenAIChatMessage{Role: "system", Content: systemMessage})
+	}
+	for _, message := range claudeMessages {
+		messages = append(messages, OpenAIChatMessage{Role: message.Role, Content: message.Text()})
+	}
+
+	maxTokens := c.maxTokens
+	if options.MaxTokens > 0 {
+		maxTokens = options.MaxTokens
+	}
+	request := OpenAIChatRequest{
+		Model:       c.model,
+		Messages:    messages,
+		MaxTokens:   min(maxTokens, MAX_TOKENS),
+		Temperature: c.temperature,
+	}
+	for _, tool := range options.Tools {
+		request.Tools = append(request.Tools, OpenAITool{
+			Type:     "function",
+			Function: OpenAIToolFunction{Name: tool.Name, Description: tool.Description, Parameters: tool.InputSchema},
+		})
+	}
+	if options.ToolChoice != nil {
+		switch options.ToolChoice.Type {
+		case TOOL_CHOICE_TOOL:
+			request.ToolChoice = map[string]interface{}{"type": "function", "function": map[string]string{"name": options.ToolChoice.Name}}
+		case TOOL_CHOICE_ANY:
+			request.ToolChoice = "required"
+		default:
+			request.ToolChoice = "auto"
+		}
+	}
+	reqBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return sendWithRetry(ctx, c.retryPolicy, func(ctx context.Context) (*ClaudeMessageResponse, int, error) {
+		return c.sendRequest(ctx, reqBody, claudeMessages)
+	})
+}
+
+func (c *OpenAICompatibleApi) sendRequest(ctx context.Context, reqBody []byte, claudeMessages ClaudeMessages) (*ClaudeMessageResponse, int, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/chat/completions", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, 0, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	if resp.StatusCode != 200 {
+		apiErr := &APIError{
+			Provider:   PROVIDER_OPENAI,
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+			RetryAfter: parseRetryAfter(resp.Header.Get("retry-after")),
+		}
+		var respData OpenAIErrorResponse
+		if json.Unmarshal(body, &respData) == nil {
+			apiErr.Message = respData.Error.Message
+			apiErr.Type = respData.Error.Type
+		}
+		return nil, resp.StatusCode, apiErr
+	}
+
+	var respData OpenAIChatResponse
+	err = json.Unmarshal(body, &respData)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("openai SendMessage unmarshall err: %s, body: %s", err, string(body))
+	}
+	if len(respData.Choices) == 0 {
+		return nil, resp.StatusCode, fmt.Errorf("openai SendMessage empty choices, body: %s", string(body))
+	}
+
+	text := respData.Choices[0].Message.Content
+	// Claude continues an assistant prefill, OpenAI-compatible servers usually
+	// answer from scratch. Strip the repeated prefill so callers can keep
+	// concatenating it in front of the response.
+	if len(claudeMessages) > 0 {
+		last := claudeMessages[len(claudeMessages)-1]
+		if last.Role == ROLE_ASSISTANT && strings.HasPrefix(strings.TrimSpace(text), last.Text()) {
+			text = strings.TrimPrefix(strings.TrimSpace(text), last.Text())
+		}
+	}
+
+	content := []Content{{Type: CONTENT_TYPE_TEXT, Text: text}}
+	for _, toolCall := range respData.Choices[0].Message.ToolCalls {
+		input := json.RawMessage(toolCall.Function.Arguments)
+		if !json.Valid(input) {
+			// keep broken arguments as a JSON string so validation can report them back to the model
+			input, _ = json.Marshal(toolCall.Function.Arguments)
+		}
+		content = append(content, Content{
+			Type:  CONTENT_TYPE_TOOL_USE,
+			ID:    toolCall.ID,
+			Name:  toolCall.Function.Name,
+			Input: input,
+		})
+	}
+
+	return &ClaudeMessageResponse{
+		ID:         respData.ID,
+		Type:       "message",
+		Role:       ROLE_ASSISTANT,
+		Content:    content,
+		Model:      respData.Model,
+		StopReason: mapOpenAIFinishReason(respData.Choices[0].FinishReason),
+		// OpenAI counts cached tokens inside prompt_tokens, Claude reports them separately
+		Usage: Usage{
+			InputTokens:          respData.Usage.PromptTokens - respData.Usage.PromptTokensDetails.CachedTokens,
+			OutputTokens:         respData.Usage.CompletionTokens,
+			CacheReadInputTokens: respData.Usage.PromptTokensDetails.CachedTokens,
+		},
+	}, resp.StatusCode, nil
+}
+
+func mapOpenAIFinishReason(reason string) string {
+	switch reason {
+	case "length":
+		return "max_tokens"
+	case "stop":
+		return "end_turn"
+	case "tool_calls":
+		return "tool_use"
+	default:
+		return reason
+	}
+}
@@ -0,0 +1,55 @@
+package claude
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAICompatibleApi_SendMessage(t *testing.T) {
+	var received OpenAIChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/chat/completions", r.URL.Path)
+		assert.Equal(t, "Bearer test-key", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.Write([]byte(`{"id":"cmpl-1","model":"local","choices":[{"index":0,"message":{"role":"assistant","content":"{\"is_fud\": true}"},"finish_reason":"stop"}],"usage":{"prompt_tokens":12,"completion_tokens":5}}`))
+	}))
+	defer server.Close()
+
+	api, err := NewOpenAICompatibleClient("test-key", server.URL+"/v1/", "", "local")
+	require.NoError(t, err)
+
+	resp, err := api.SendMessage(ClaudeMessages{
+		{Role: ROLE_USER, Content: "analyze"},
+		{Role: ROLE_ASSISTANT, Content: "{"},
+	}, "system prompt")
+	require.NoError(t, err)
+
+	assert.Equal(t, "local", received.Model)
+	assert.Equal(t, "system", received.Messages[0].Role)
+	assert.Equal(t, "system prompt", received.Messages[0].Content)
+	assert.Len(t, received.Messages, 3)
+
+	assert.Equal(t, "\"is_fud\": true}", resp.Content[0].Text)
+	assert.Equal(t, "end_turn", resp.StopReason)
+	assert.Equal(t, 12, resp.Usage.InputTokens)
+	assert.Equal(t, 5, resp.Usage.OutputTokens)
+}
+
+func TestOpenAICompatibleApi_SendMessageError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"message":"bad model","type":"invalid_request_error"}}`))
+	}))
+	defer server.Close()
+
+	api, err := NewOpenAICompatibleClient("", server.URL, "", "local")
+	require.NoError(t, err)
+
+	_, err = api.SendMessage(ClaudeMessages{{Role: ROLE_USER, Content: "hi"}}, "")
+	assert.ErrorContains(t, err, "bad model")
+}
@@ -0,0 +1,75 @@
+package claude
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const CACHE_WRITE_PRICE_MULTIPLIER = 1.25
+const CACHE_READ_PRICE_MULTIPLIER = 0.1
+
+// ModelPrice is a price in USD per million tokens. Cache prices default to the
+// Anthropic multipliers of the input price when not set.
+type ModelPrice struct {
+	InputPerMTok      float64 `json:"input_per_mtok"`
+	OutputPerMTok     float64 `json:"output_per_mtok"`
+	CacheWritePerMTok float64 `json:"cache_write_per_mtok,omitempty"`
+	CacheReadPerMTok  float64 `json:"cache_read_per_mtok,omitempty"`
+}
+
+var defaultModelPrices = map[string]ModelPrice{
+	"claude-sonnet-4-0":         {InputPerMTok: 3, OutputPerMTok: 15},
+	"claude-sonnet-4-20250514":  {InputPerMTok: 3, OutputPerMTok: 15},
+	"claude-3-7-sonnet-latest":  {InputPerMTok: 3, OutputPerMTok: 15},
+	"claude-opus-4-0":           {InputPerMTok: 15, OutputPerMTok: 75},
+	"claude-opus-4-20250514":    {InputPerMTok: 15, OutputPerMTok: 75},
+	"claude-3-5-haiku-latest":   {InputPerMTok: 0.8, OutputPerMTok: 4},
+	"claude-3-5-haiku-20241022": {InputPerMTok: 0.8, OutputPerMTok: 4},
+	"gpt-4o":                    {InputPerMTok: 2.5, OutputPerMTok: 10},
+	"gpt-4o-mini":               {InputPerMTok: 0.15, OutputPerMTok: 0.6},
+	"gpt-4.1":                   {InputPerMTok: 2, OutputPerMTok: 8},
+	"gpt-4.1-mini":              {InputPerMTok: 0.4, OutputPerMTok: 1.6},
+}
+
+// LoadModelPrices returns the built-in price table merged with the JSON file
+// at path (model name -> {input_per_mtok, output_per_mtok}).
+func LoadModelPrices(path string) (map[string]ModelPrice, error) {
+	prices := make(map[string]ModelPrice, len(defaultModelPrices))
+	for model, price := range defaultModelPrices {
+		prices[model] = price
+	}
+	if path == "" {
+		return prices, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pricing file: %w", err)
+	}
+	var filePrices map[string]ModelPrice
+	if err := json.Unmarshal(data, &filePrices); err != nil {
+		return nil, fmt.Errorf("failed to parse pricing file: %w", err)
+	}
+	for model, price := range filePrices {
+		prices[model] = price
+	}
+	return prices, nil
+}
+
+// Cost returns the USD cost of the usage.
+func (p ModelPrice) Cost(usage Usage) float64 {
+	cacheWritePrice := p.CacheWritePerMTok
+	if cacheWritePrice == 0 {
+		cacheWritePrice = p.InputPerMTok * CACHE_WRITE_PRICE_MULTIPLIER
+	}
+	cacheReadPrice := p.CacheReadPerMTok
+	if cacheReadPrice == 0 {
+		cacheReadPrice = p.InputPerMTok * CACHE_READ_PRICE_MULTIPLIER
+	}
+
+	return (float64(usage.InputTokens)*p.InputPerMTok +
+		float64(usage.CacheCreationInputTokens)*cacheWritePrice +
+		float64(usage.CacheReadInputTokens)*cacheReadPrice +
+		float64(usage.OutputTokens)*p.OutputPerMTok) / 1_000_000
+}
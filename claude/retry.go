@@ -0,0 +1,173 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const DEFAULT_RETRY_MAX_ATTEMPTS = 4
+const DEFAULT_RETRY_BASE_DELAY = 2 * time.Second
+const DEFAULT_RETRY_MAX_DELAY = 60 * time.Second
+const DEFAULT_REQUEST_TIMEOUT = 180 * time.Second
+
+type RetryPolicy struct {
+	MaxAttempts    int
+	BaseDelay      time.Duration
+	MaxDelay       time.Duration
+	RequestTimeout time.Duration
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    DEFAULT_RETRY_MAX_ATTEMPTS,
+		BaseDelay:      DEFAULT_RETRY_BASE_DELAY,
+		MaxDelay:       DEFAULT_RETRY_MAX_DELAY,
+		RequestTimeout: DEFAULT_REQUEST_TIMEOUT,
+	}
+}
+
+// Attempt describes a single HTTP call made while sending one logical request.
+type Attempt struct {
+	Number     int
+	StatusCode int
+	Duration   time.Duration
+	RetryAfter time.Duration
+	Response   *ClaudeMessageResponse
+	Err        error
+}
+
+type AttemptRecorder func(attempt Attempt)
+
+type attemptRecorderKey struct{}
+
+// WithAttemptRecorder attaches a callback that is invoked after every attempt
+// made with the returned context, including the ones that will be retried.
+func WithAttemptRecorder(ctx context.Context, recorder AttemptRecorder) context.Context {
+	return context.WithValue(ctx, attemptRecorderKey{}, recorder)
+}
+
+func attemptRecorderFromContext(ctx context.Context) AttemptRecorder {
+	recorder, _ := ctx.Value(attemptRecorderKey{}).(AttemptRecorder)
+	return recorder
+}
+
+type APIError struct {
+	Provider   string
+	StatusCode int
+	Type       string
+	Message    string
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s SendMessage status code non 200, %d, body: %s", e.Provider, e.StatusCode, e.Body)
+	}
+	return fmt.Sprintf("%s SendMessage status not 200(%d) error: message: %s, type: %s", e.Provider, e.StatusCode, e.Message, e.Type)
+}
+
+// Retryable reports whether the request may succeed when sent again:
+// rate limiting (429), Anthropic overload (529), timeouts and server errors.
+func (e *APIError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests ||
+		e.StatusCode == http.StatusRequestTimeout ||
+		e.StatusCode == 529 ||
+		e.StatusCode >= 500
+}
+
+func isRetryableError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+	// transport level errors (connection reset, per-attempt timeout) are worth another try
+	return true
+}
+
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// backoffDelay returns exponential backoff with full jitter, never shorter
+// than the delay requested by the server.
+func backoffDelay(policy RetryPolicy, attempt int, retryAfter time.Duration) time.Duration {
+	delay := policy.BaseDelay << (attempt - 1)
+	if delay <= 0 || delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+	if delay > 0 {
+		delay = time.Duration(rand.Int63n(int64(delay) + 1))
+	}
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	return delay
+}
+
+func sendWithRetry(ctx context.Context, policy RetryPolicy, send func(ctx context.Context) (*ClaudeMessageResponse, int, error)) (*ClaudeMessageResponse, error) {
+	recorder := attemptRecorderFromContext(ctx)
+	maxAttempts := max(1, policy.MaxAttempts)
+
+	var lastErr error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		attemptCtx := ctx
+		cancel := func() {}
+		if policy.RequestTimeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, policy.RequestTimeout)
+		}
+		startTime := time.Now()
+		resp, statusCode, err := send(attemptCtx)
+		cancel()
+
+		var retryAfter time.Duration
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			retryAfter = apiErr.RetryAfter
+		}
+		if recorder != nil {
+			recorder(Attempt{
+				Number:     attempt,
+				StatusCode: statusCode,
+				Duration:   time.Since(startTime),
+				RetryAfter: retryAfter,
+				Response:   resp,
+				Err:        err,
+			})
+		}
+		if err == nil {
+			return resp, nil
+		}
+
+		lastErr = err
+		if attempt == maxAttempts || !isRetryableError(ctx, err) {
+			break
+		}
+
+		delay := backoffDelay(policy, attempt, retryAfter)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w (last error: %s)", ctx.Err(), lastErr)
+		case <-time.After(delay):
+		}
+	}
+
+	return nil, lastErr
+}
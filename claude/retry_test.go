@@ -0,0 +1,94 @@
+package claude
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClaudeClient(t *testing.T, serverURL string) *ClaudeApi {
+	api, err := NewClaudeClient("test-key", "", CLAUDE_MODEL)
+	require.NoError(t, err)
+	api.SetAPIURL(serverURL)
+	api.SetRetryPolicy(RetryPolicy{
+		MaxAttempts:    3,
+		BaseDelay:      time.Millisecond,
+		MaxDelay:       10 * time.Millisecond,
+		RequestTimeout: time.Second,
+	})
+	return api
+}
+
+func TestClaudeApi_RetriesOverloaded(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.Header().Set("retry-after", "0")
+			w.WriteHeader(529)
+			w.Write([]byte(`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`))
+			return
+		}
+		w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"text","text":"ok"}],"usage":{"input_tokens":3,"output_tokens":1}}`))
+	}))
+	defer server.Close()
+
+	var attempts []Attempt
+	ctx := WithAttemptRecorder(context.Background(), func(attempt Attempt) {
+		attempts = append(attempts, attempt)
+	})
+
+	resp, err := newTestClaudeClient(t, server.URL).SendMessageContext(ctx, ClaudeMessages{{Role: ROLE_USER, Content: "hi"}}, "")
+	require.NoError(t, err)
+	assert.Equal(t, "ok", resp.Content[0].Text)
+
+	require.Len(t, attempts, 3)
+	assert.Equal(t, 529, attempts[0].StatusCode)
+	assert.Error(t, attempts[0].Err)
+	assert.Equal(t, 3, attempts[2].Number)
+	assert.NoError(t, attempts[2].Err)
+}
+
+func TestClaudeApi_DoesNotRetryBadRequest(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"type":"error","error":{"type":"invalid_request_error","message":"bad prompt"}}`))
+	}))
+	defer server.Close()
+
+	_, err := newTestClaudeClient(t, server.URL).SendMessage(ClaudeMessages{{Role: ROLE_USER, Content: "hi"}}, "")
+	assert.ErrorContains(t, err, "bad prompt")
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+}
+
+func TestClaudeApi_GivesUpAfterMaxAttempts(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`))
+	}))
+	defer server.Close()
+
+	_, err := newTestClaudeClient(t, server.URL).SendMessage(ClaudeMessages{{Role: ROLE_USER, Content: "hi"}}, "")
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusTooManyRequests, apiErr.StatusCode)
+	assert.EqualValues(t, 3, atomic.LoadInt32(&calls))
+}
+
+func TestBackoffDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 4 * time.Second}
+	for attempt := 1; attempt <= 5; attempt++ {
+		assert.LessOrEqual(t, backoffDelay(policy, attempt, 0), policy.MaxDelay)
+	}
+	assert.Equal(t, 10*time.Second, backoffDelay(policy, 1, 10*time.Second))
+	assert.Equal(t, 30*time.Second, parseRetryAfter("30"))
+}
@@ -0,0 +1,239 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const CONTENT_TYPE_TEXT = "text"
+const CONTENT_TYPE_TOOL_USE = "tool_use"
+const CONTENT_TYPE_TOOL_RESULT = "tool_result"
+
+const TOOL_CHOICE_AUTO = "auto"
+const TOOL_CHOICE_ANY = "any"
+const TOOL_CHOICE_TOOL = "tool"
+
+const DEFAULT_MAX_REPAIRS = 2
+
+type Tool struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	InputSchema *JSONSchema `json:"input_schema"`
+}
+
+type ToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+type MessageOptions struct {
+	Tools      []Tool
+	ToolChoice *ToolChoice
+	MaxTokens  int
+	// System replaces the plain system message, use it to mark cacheable prefixes
+	System []ContentBlock
+}
+
+// JSONSchema is the subset of JSON schema used for tool input definitions.
+type JSONSchema struct {
+	Type        string                 `json:"type"`
+	Description string                 `json:"description,omitempty"`
+	Properties  map[string]*JSONSchema `json:"properties,omitempty"`
+	Required    []string               `json:"required,omitempty"`
+	Items       *JSONSchema            `json:"items,omitempty"`
+	Enum        []string               `json:"enum,omitempty"`
+	Minimum     *float64               `json:"minimum,omitempty"`
+	Maximum     *float64               `json:"maximum,omitempty"`
+}
+
+func Float(value float64) *float64 {
+	return &value
+}
+
+// Validate checks a decoded JSON value against the schema and returns
+// a human readable list of problems, suitable for sending back to the model.
+func (s *JSONSchema) Validate(value interface{}) []string {
+	return s.validate("input", value)
+}
+
+func (s *JSONSchema) validate(path string, value interface{}) []string {
+	var problems []string
+	switch s.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s must be an object", path)}
+		}
+		for _, name := range s.Required {
+			if _, exists := object[name]; !exists {
+				problems = append(problems, fmt.Sprintf("%s.%s is required", path, name))
+			}
+		}
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if propertyValue, exists := object[name]; exists {
+				problems = append(problems, s.Properties[name].validate(path+"."+name, propertyValue)...)
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s must be an array", path)}
+		}
+		if s.Items != nil {
+			for i, item := range items {
+				problems = append(problems, s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item)...)
+			}
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s must be a string", path)}
+		}
+		if len(s.Enum) > 0 && !containsString(s.Enum, text) {
+			problems = append(problems, fmt.Sprintf("%s must be one of [%s], got %q", path, strings.Join(s.Enum, ", "), text))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{fmt.Sprintf("%s must be a boolean", path)}
+		}
+	case "number", "integer":
+		number, ok := value.(float64)
+		if !ok {
+			return []string{fmt.Sprintf("%s must be a number", path)}
+		}
+		if s.Type == "integer" && number != float64(int64(number)) {
+			problems = append(problems, fmt.Sprintf("%s must be an integer", path))
+		}
+		if s.Minimum != nil && number < *s.Minimum {
+			problems = append(problems, fmt.Sprintf("%s must be >= %v", path, *s.Minimum))
+		}
+		if s.Maximum != nil && number > *s.Maximum {
+			problems = append(problems, fmt.Sprintf("%s must be <= %v", path, *s.Maximum))
+		}
+	}
+	return problems
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *ClaudeMessageResponse) ToolInput(toolName string) (json.RawMessage, bool) {
+	for _, content := range r.Content {
+		if content.Type == CONTENT_TYPE_TOOL_USE && content.Name == toolName {
+			return content.Input, true
+		}
+	}
+	return nil, false
+}
+
+type StructuredRequest struct {
+	Messages      ClaudeMessages
+	SystemMessage string
+	SystemBlocks  []ContentBlock
+	Tool          Tool
+	MaxTokens     int
+	MaxRepairs    int
+}
+
+// SendStructured forces the model to answer by calling the request tool and
+// decodes the validated tool input into target. Invalid or truncated answers are
+// answered with a tool_result error carrying the validation errors, up to
+// MaxRepairs times.
+// The last response is returned even on failure so callers can log it.
+func SendStructured(ctx context.Context, client LLMClient, request StructuredRequest, target interface{}) (*ClaudeMessageResponse, error) {
+	options := MessageOptions{
+		Tools:      []Tool{request.Tool},
+		ToolChoice: &ToolChoice{Type: TOOL_CHOICE_TOOL, Name: request.Tool.Name},
+		MaxTokens:  request.MaxTokens,
+		System:     request.SystemBlocks,
+	}
+	messages := append(ClaudeMessages{}, request.Messages...)
+
+	var resp *ClaudeMessageResponse
+	var problems []string
+	for repair := 0; repair <= request.MaxRepairs; repair++ {
+		var err error
+		resp, err = client.SendMessageWithOptions(ctx, messages, request.SystemMessage, options)
+		if err != nil {
+			return resp, err
+		}
+
+		var input json.RawMessage
+		input, problems = validateToolInput(resp, request.Tool)
+		if len(problems) == 0 {
+			if err := json.Unmarshal(input, target); err != nil {
+				return resp, fmt.Errorf("structured output %s unmarshal err: %s, input: %s", request.Tool.Name, err, string(input))
+			}
+			return resp, nil
+		}
+
+		messages = append(messages, repairMessages(resp, request.Tool, problems)...)
+	}
+
+	return resp, fmt.Errorf("structured output %s is invalid after %d repairs: %s", request.Tool.Name, request.MaxRepairs, strings.Join(problems, "; "))
+}
+
+// repairMessages are the assistant turn of a rejected answer followed by the
+// problems, as an error result of each tool call or as a plain message when the
+// answer made no call.
+func repairMessages(resp *ClaudeMessageResponse, tool Tool, problems []string) ClaudeMessages {
+	feedback := fmt.Sprintf("The call of the %s tool was rejected:\n- %s\nCall the %s tool again with input that fully matches its schema.",
+		tool.Name, strings.Join(problems, "\n- "), tool.Name)
+
+	assistant := ClaudeMessage{Role: ROLE_ASSISTANT}
+	var results []ContentBlock
+	for _, content := range resp.Content {
+		switch {
+		case content.Type == CONTENT_TYPE_TOOL_USE:
+			input := content.Input
+			if !json.Valid(input) {
+				input = json.RawMessage("{}")
+			}
+			assistant.Blocks = append(assistant.Blocks, ToolUseBlock(content.ID, content.Name, input))
+			results = append(results, ToolResultBlock(content.ID, feedback, true))
+		case content.Type == CONTENT_TYPE_TEXT && content.Text != "":
+			assistant.Blocks = append(assistant.Blocks, TextBlock(content.Text))
+		}
+	}
+
+	var repair ClaudeMessages
+	if len(assistant.Blocks) > 0 {
+		repair = append(repair, assistant)
+	}
+	if len(results) == 0 {
+		return append(repair, ClaudeMessage{Role: ROLE_USER, Content: feedback})
+	}
+	return append(repair, ClaudeMessage{Role: ROLE_USER, Blocks: results})
+}
+
+func validateToolInput(resp *ClaudeMessageResponse, tool Tool) (json.RawMessage, []string) {
+	input, ok := resp.ToolInput(tool.Name)
+	if !ok {
+		return nil, []string{fmt.Sprintf("the answer must be a call of the %s tool", tool.Name)}
+	}
+	if resp.StopReason == "max_tokens" {
+		return input, []string{"the answer was truncated by max_tokens, keep text fields shorter"}
+	}
+	var value interface{}
+	if err := json.Unmarshal(input, &value); err != nil {
+		return input, []string{fmt.Sprintf("input is not valid JSON: %s", err)}
+	}
+	if tool.InputSchema == nil {
+		return input, nil
+	}
+	return input, tool.InputSchema.Validate(value)
+}
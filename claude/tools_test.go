@@ -0,0 +1,134 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testVerdictTool = Tool{
+	Name: "report_verdict",
+	InputSchema: &JSONSchema{
+		Type: "object",
+		Properties: map[string]*JSONSchema{
+			"is_fud":      {Type: "boolean"},
+			"probability": {Type: "number", Minimum: Float(0), Maximum: Float(1)},
+			"risk":        {Type: "string", Enum: []string{"low", "high"}},
+		},
+		Required: []string{"is_fud", "probability", "risk"},
+	},
+}
+
+func TestJSONSchema_Validate(t *testing.T) {
+	var value interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{"is_fud":"yes","probability":1.5}`), &value))
+
+	problems := testVerdictTool.InputSchema.Validate(value)
+	assert.ElementsMatch(t, []string{
+		"input.risk is required",
+		"input.is_fud must be a boolean",
+		"input.probability must be <= 1",
+	}, problems)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"is_fud":true,"probability":0.4,"risk":"low"}`), &value))
+	assert.Empty(t, testVerdictTool.InputSchema.Validate(value))
+}
+
+func TestSendStructured_RepairsInvalidInput(t *testing.T) {
+	var requests []ClaudeMessageRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request ClaudeMessageRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		requests = append(requests, request)
+
+		input := `{"is_fud":true,"probability":0.9,"risk":"extreme"}`
+		if len(requests) > 1 {
+			input = `{"is_fud":true,"probability":0.9,"risk":"high"}`
+		}
+		w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","stop_reason":"tool_use","content":[{"type":"tool_use","id":"toolu_1","name":"report_verdict","input":` + input + `}]}`))
+	}))
+	defer server.Close()
+
+	var verdict struct {
+		IsFud       bool    `json:"is_fud"`
+		Probability float64 `json:"probability"`
+		Risk        string  `json:"risk"`
+	}
+	_, err := SendStructured(context.Background(), newTestClaudeClient(t, server.URL), StructuredRequest{
+		Messages:   ClaudeMessages{{Role: ROLE_USER, Content: "analyze"}},
+		Tool:       testVerdictTool,
+		MaxRepairs: DEFAULT_MAX_REPAIRS,
+	}, &verdict)
+	require.NoError(t, err)
+	assert.Equal(t, "high", verdict.Risk)
+
+	require.Len(t, requests, 2)
+	assert.Equal(t, &ToolChoice{Type: TOOL_CHOICE_TOOL, Name: "report_verdict"}, requests[0].ToolChoice)
+	require.Len(t, requests[1].Messages, 3)
+	assistant := requests[1].Messages[1]
+	assert.Equal(t, ROLE_ASSISTANT, assistant.Role)
+	require.Len(t, assistant.Blocks, 1)
+	assert.Equal(t, CONTENT_TYPE_TOOL_USE, assistant.Blocks[0].Type)
+	assert.Equal(t, "toolu_1", assistant.Blocks[0].ID)
+	assert.JSONEq(t, `{"is_fud":true,"probability":0.9,"risk":"extreme"}`, string(assistant.Blocks[0].Input))
+	result := requests[1].Messages[2]
+	assert.Equal(t, ROLE_USER, result.Role)
+	require.Len(t, result.Blocks, 1)
+	assert.Equal(t, CONTENT_TYPE_TOOL_RESULT, result.Blocks[0].Type)
+	assert.Equal(t, "toolu_1", result.Blocks[0].ToolUseID)
+	assert.True(t, result.Blocks[0].IsError)
+	assert.Contains(t, result.Blocks[0].Content, `input.risk must be one of [low, high], got "extreme"`)
+}
+
+func TestSendStructured_GivesUp(t *testing.T) {
+	var requests []ClaudeMessageRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request ClaudeMessageRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		requests = append(requests, request)
+		w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","stop_reason":"end_turn","content":[{"type":"text","text":"I think it is FUD"}]}`))
+	}))
+	defer server.Close()
+
+	var verdict map[string]interface{}
+	resp, err := SendStructured(context.Background(), newTestClaudeClient(t, server.URL), StructuredRequest{
+		Messages:   ClaudeMessages{{Role: ROLE_USER, Content: "analyze"}},
+		Tool:       testVerdictTool,
+		MaxRepairs: 1,
+	}, &verdict)
+	assert.ErrorContains(t, err, "must be a call of the report_verdict tool")
+	assert.NotNil(t, resp)
+
+	// an answer without a tool call has no result to carry the problems
+	require.Len(t, requests, 2)
+	require.Len(t, requests[1].Messages, 3)
+	assert.Equal(t, []ContentBlock{TextBlock("I think it is FUD")}, requests[1].Messages[1].Blocks)
+	assert.Contains(t, requests[1].Messages[2].Content, "must be a call of the report_verdict tool")
+}
+
+func TestOpenAICompatibleApi_ToolCalls(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.Write([]byte(`{"id":"cmpl-1","model":"local","choices":[{"index":0,"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function","function":{"name":"report_verdict","arguments":"{\"is_fud\":false,\"probability\":0.1,\"risk\":\"low\"}"}}]},"finish_reason":"tool_calls"}]}`))
+	}))
+	defer server.Close()
+
+	api, err := NewOpenAICompatibleClient("", server.URL, "", "local")
+	require.NoError(t, err)
+
+	var verdict map[string]interface{}
+	resp, err := SendStructured(context.Background(), api, StructuredRequest{
+		Messages: ClaudeMessages{{Role: ROLE_USER, Content: "analyze"}},
+		Tool:     testVerdictTool,
+	}, &verdict)
+	require.NoError(t, err)
+	assert.Equal(t, "tool_use", resp.StopReason)
+	assert.Equal(t, "low", verdict["risk"])
+	assert.Equal(t, map[string]interface{}{"type": "function", "function": map[string]interface{}{"name": "report_verdict"}}, received["tool_choice"])
+}
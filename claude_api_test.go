@@ -10,14 +10,16 @@ import (
 )
 
 func TestClaudeApi_SendMessage(t *testing.T) {
-	err := godotenv.Load()
-	assert.NoError(t, err)
+	godotenv.Load()
+	if os.Getenv(ENV_CLAUDE_API_KEY) == "" {
+		t.Skipf("%s is not set", ENV_CLAUDE_API_KEY)
+	}
 	claudeApi, err := claude.NewClaudeClient(os.Getenv(ENV_CLAUDE_API_KEY), os.Getenv(ENV_PROXY_DSN), claude.CLAUDE_MODEL)
 	assert.NoError(t, err)
 	response, err := claudeApi.SendMessage(
 		claude.ClaudeMessages{
-			{claude.ROLE_USER, "hi solve this: 54+99"},
-			{claude.ROLE_ASSISTANT, "{"},
+			{Role: claude.ROLE_USER, Content: "hi solve this: 54+99"},
+			{Role: claude.ROLE_ASSISTANT, Content: "{"},
 		},
 		"response JSON format {sum:365,param_first:1,param_second:2}")
 	assert.NoError(t, err)
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/grutapig/hackaton/analysis"
+	"github.com/grutapig/hackaton/claude"
+	"github.com/grutapig/hackaton/evaluation"
+	"github.com/joho/godotenv"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// the variables of the bot configuration used by the evaluation
+const (
+	ENV_DATABASE_NAME            = "database_name"
+	ENV_LOGGING_DATABASE_PATH    = "logging_database_path"
+	ENV_TWITTER_COMMUNITY_TICKER = "twitter_community_ticker"
+	ENV_CLAUDE_API_KEY           = "claude_api_key"
+	ENV_PROXY_CLAUDE_DSN         = "proxy_claude_dsn"
+	ENV_OPENAI_API_KEY           = "openai_api_key"
+	ENV_OPENAI_BASE_URL          = "openai_base_url"
+	ENV_OPENAI_MODEL             = "openai_model"
+	ENV_LLM_SECOND_STEP_PROVIDER = "llm_second_step_provider"
+	ENV_LLM_SECOND_STEP_MODEL    = "llm_second_step_model"
+	ENV_LLM_PRICING_FILE         = "llm_pricing_file"
+)
+
+const LABELS_FROM_REVIEWS = "reviews"
+const BASE_PROMPT_FILE = "data/txt/prompt2.txt"
+
+func main() {
+	configFile := flag.String("config", ".env", "configuration file of the bot, for the databases, keys and models")
+	labelsFrom := flag.String("labels", LABELS_FROM_REVIEWS, "labelled users: \"reviews\" for the moderated verdicts of the bot database, or a username,label CSV file")
+	communityID := flag.String("community", "", "community of the reviews and overrides, all by default")
+	promptFile := flag.String("prompt", "", "second step prompt version file, e.g. data/prompts/second_step/v2.txt, the builtin prompt by default")
+	baseFile := flag.String("base", BASE_PROMPT_FILE, "base prompt file rendered into the prompt")
+	ticker := flag.String("ticker", "", "community ticker, "+ENV_TWITTER_COMMUNITY_TICKER+" by default")
+	provider := flag.String("provider", "", "llm provider, claude or openai, "+ENV_LLM_SECOND_STEP_PROVIDER+" by default")
+	model := flag.String("model", "", "model name, "+ENV_LLM_SECOND_STEP_MODEL+" by default")
+	limit := flag.Int("limit", 0, "evaluate at most this many labelled users, all by default")
+	out := flag.String("out", "", "markdown report path, reports/evaluation_<time>.md by default")
+	flag.Parse()
+
+	if *configFile != "" {
+		if err := godotenv.Load(*configFile); err != nil {
+			log.Printf("Warning: Failed to load config file %s: %v", *configFile, err)
+		}
+	}
+	if *ticker == "" {
+		*ticker = os.Getenv(ENV_TWITTER_COMMUNITY_TICKER)
+	}
+	if *provider == "" {
+		*provider = getEnv(ENV_LLM_SECOND_STEP_PROVIDER, claude.PROVIDER_CLAUDE)
+	}
+	if *model == "" {
+		*model = os.Getenv(ENV_LLM_SECOND_STEP_MODEL)
+	}
+	if *out == "" {
+		*out = filepath.Join("reports", fmt.Sprintf("evaluation_%s.md", time.Now().Format("20060102_150405")))
+	}
+
+	labels, labelSource, err := loadLabels(*labelsFrom, *communityID)
+	panicErr(err)
+	if *limit > 0 && len(labels) > *limit {
+		labels = labels[:*limit]
+	}
+	log.Printf("Loaded %d labelled users from %s", len(labels), labelSource)
+
+	logsDB, err := openDatabase(getEnv(ENV_LOGGING_DATABASE_PATH, "logs.db"))
+	panicErr(err)
+	usernames := make([]string, 0, len(labels))
+	for _, label := range labels {
+		usernames = append(usernames, label.Username)
+	}
+	inputs, err := evaluation.LoadInputs(logsDB, usernames)
+	panicErr(err)
+	log.Printf("Found stored second step inputs for %d users", len(inputs))
+
+	prompt, err := loadPrompt(*promptFile, *baseFile)
+	panicErr(err)
+	client, err := newLLMClient(*provider, *model)
+	panicErr(err)
+	prices, err := claude.LoadModelPrices(os.Getenv(ENV_LLM_PRICING_FILE))
+	panicErr(err)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	evaluator := &evaluation.Evaluator{
+		Client:   client,
+		Provider: *provider,
+		Prompt:   prompt,
+		Ticker:   *ticker,
+		Prices:   prices,
+	}
+	report, err := evaluator.Run(ctx, labelSource, labels, inputs)
+	panicErr(err)
+	panicErr(report.WriteMarkdown(*out))
+
+	log.Printf("Precision %.3f, recall %.3f, F1 %.3f over %d users, cost $%.4f", report.Confusion.Precision(), report.Confusion.Recall(), report.Confusion.F1(), report.Confusion.Total(), report.CostUSD)
+	log.Printf("Report written to %s", *out)
+}
+
+func loadLabels(from, communityID string) ([]evaluation.Label, string, error) {
+	if from != LABELS_FROM_REVIEWS {
+		labels, err := evaluation.LoadLabelsCSV(from)
+		return labels, from, err
+	}
+	db, err := openDatabase(getEnv(ENV_DATABASE_NAME, "hackathon.db"))
+	if err != nil {
+		return nil, "", err
+	}
+	labels, err := evaluation.LabelsFromDatabase(db, communityID)
+	return labels, "moderated reviews and overrides", err
+}
+
+func loadPrompt(promptFile, baseFile string) (analysis.Prompt, error) {
+	prompt := analysis.DefaultPrompt(analysis.PROMPT_SECOND_STEP)
+	if promptFile != "" {
+		var err error
+		prompt, err = analysis.LoadPrompt(analysis.PROMPT_SECOND_STEP, promptFile)
+		if err != nil {
+			return analysis.Prompt{}, fmt.Errorf("load prompt %s: %w", promptFile, err)
+		}
+	}
+	base, err := analysis.LoadPrompt(analysis.PROMPT_SECOND_STEP_BASE, baseFile)
+	if err != nil {
+		return analysis.Prompt{}, fmt.Errorf("read base prompt: %w", err)
+	}
+	// the bot records the deployed base file as the builtin base version
+	if baseFile == BASE_PROMPT_FILE {
+		base.Version = analysis.PROMPT_VERSION_BUILTIN
+	}
+	return prompt.WithBasePrompt(base), nil
+}
+
+func newLLMClient(provider, model string) (claude.LLMClient, error) {
+	switch provider {
+	case claude.PROVIDER_CLAUDE:
+		if model == "" {
+			model = claude.CLAUDE_MODEL
+		}
+		return claude.NewClaudeClient(os.Getenv(ENV_CLAUDE_API_KEY), os.Getenv(ENV_PROXY_CLAUDE_DSN), model)
+	case claude.PROVIDER_OPENAI:
+		if model == "" {
+			model = os.Getenv(ENV_OPENAI_MODEL)
+		}
+		if model == "" {
+			return nil, fmt.Errorf("model should be set for openai provider: %s", ENV_OPENAI_MODEL)
+		}
+		return claude.NewOpenAICompatibleClient(os.Getenv(ENV_OPENAI_API_KEY), os.Getenv(ENV_OPENAI_BASE_URL), "", model)
+	}
+	return nil, fmt.Errorf("unknown llm provider: %s", provider)
+}
+
+func openDatabase(path string) (*gorm.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("database %s: %w", path, err)
+	}
+	return gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func panicErr(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
@@ -1,89 +1,51 @@
 package main
 
 import (
+	"context"
 	"github.com/grutapig/hackaton/twitterapi"
 	"log"
 )
 
 func getUserTweetsUnlimited(username string) bool {
-	var cursor string
 	pageCount := 0
 
-	for {
-		select {
-		case <-stopCurrentJob:
-			log.Printf("Stop signal received for @%s at page %d", username, pageCount)
-			return false
-		default:
-		}
-
-		resp, err := twitterApi.AdvancedSearch(twitterapi.AdvancedSearchRequest{
-			Query:     "from:" + username,
-			QueryType: twitterapi.LATEST,
-			Cursor:    cursor,
-		})
-
-		if err != nil || len(resp.Tweets) == 0 || resp.NextCursor == "" {
-			break
-		}
-
-		cursor = resp.NextCursor
-		pageCount++
-
-		newTweetsSaved := 0
-		for _, tweet := range resp.Tweets {
-			if saveTweetToDB(tweet) {
-				newTweetsSaved++
-			}
-		}
-
-		if newTweetsSaved == 0 {
-			log.Printf("No new tweets saved for @%s at page %d, breaking", username, pageCount)
+	for _, queryType := range []string{twitterapi.LATEST, twitterapi.TOP} {
+		if queryType == twitterapi.TOP && pageCount > 0 {
 			break
 		}
+		pager := twitterApi.AdvancedSearchPager(twitterapi.AdvancedSearchRequest{
+			Query:     "from:" + username,
+			QueryType: queryType,
+		}, twitterapi.PageOptions{})
 
-		if pageCount%10 == 0 {
-			log.Printf("Unlimited parsing for @%s: processed %d pages", username, pageCount)
-		}
-	}
-
-	cursor = ""
-	if pageCount == 0 {
-		for {
+		for !pager.Done() {
 			select {
 			case <-stopCurrentJob:
-				log.Printf("Stop signal received for @%s (TOP search) at page %d", username, pageCount)
+				log.Printf("Stop signal received for @%s (%s search) at page %d", username, queryType, pageCount)
 				return false
 			default:
 			}
 
-			resp, err := twitterApi.AdvancedSearch(twitterapi.AdvancedSearchRequest{
-				Query:     "from:" + username,
-				QueryType: twitterapi.TOP,
-				Cursor:    cursor,
-			})
-
-			if err != nil || len(resp.Tweets) == 0 || resp.NextCursor == "" {
+			tweets, err := pager.Next(context.Background())
+			if err != nil || len(tweets) == 0 {
 				break
 			}
-
-			cursor = resp.NextCursor
 			pageCount++
 
 			newTweetsSaved := 0
-			for _, tweet := range resp.Tweets {
+			for _, tweet := range tweets {
 				if saveTweetToDB(tweet) {
 					newTweetsSaved++
 				}
 			}
 
 			if newTweetsSaved == 0 {
-				log.Printf("No new tweets saved for @%s (TOP search) at page %d, breaking", username, pageCount)
+				log.Printf("No new tweets saved for @%s (%s search) at page %d, breaking", username, queryType, pageCount)
 				break
 			}
 
 			if pageCount%10 == 0 {
-				log.Printf("Unlimited parsing (TOP) for @%s: processed %d pages", username, pageCount)
+				log.Printf("Unlimited parsing (%s) for @%s: processed %d pages", queryType, username, pageCount)
 			}
 		}
 	}
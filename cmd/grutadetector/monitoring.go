@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/grutapig/hackaton/twitterapi_reverse"
 	"log"
@@ -48,7 +49,7 @@ func processNotification(tweet twitterapi_reverse.SimpleTweet) {
 		sendTelegramMessage(fmt.Sprintf("Reply on myself ignored: %s", tweet.TweetID))
 		return
 	}
-	lastTweets, err := twitterApi.GetTweetsByIds([]string{tweet.ReplyToID})
+	lastTweets, err := twitterApi.GetTweetsByIds(context.Background(), []string{tweet.ReplyToID})
 	if err != nil || len(lastTweets.Tweets) == 0 {
 		fmt.Println("cannot get reply tweet", err)
 		sendTelegramMessage(fmt.Sprintf("error on get GetTweetsByIds reply, or empty list returned: %s(%s), err: %s", tweet.TweetID, tweet.ReplyToID, err))
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"github.com/grutapig/hackaton/twitterapi"
 	"github.com/grutapig/hackaton/twitterapi_reverse"
 )
@@ -10,7 +11,7 @@ func postTweet(text string, replyId string) error {
 		text = text[:277] + "..."
 	}
 
-	_, err := twitterApi.PostTweet(twitterapi.PostTweetRequest{
+	_, err := twitterApi.PostTweet(context.Background(), twitterapi.PostTweetRequest{
 		AuthSession:      twitterAuth,
 		TweetText:        text,
 		QuoteTweetId:     "",
@@ -23,21 +24,17 @@ func postTweet(text string, replyId string) error {
 }
 func getUserTweets(username string) ([]twitterapi_reverse.SimpleTweet, error) {
 	var tweets []twitterapi_reverse.SimpleTweet
-	var cursor string
 
-	for i := 0; i < userSearchPages; i++ {
-		resp, err := twitterApi.AdvancedSearch(twitterapi.AdvancedSearchRequest{
-			Query:     "from:" + username,
-			QueryType: twitterapi.LATEST,
-			Cursor:    cursor,
-		})
-
-		if err != nil || len(resp.Tweets) == 0 || resp.NextCursor == "" {
+	for _, queryType := range []string{twitterapi.LATEST, twitterapi.TOP} {
+		if len(tweets) > 0 {
 			break
 		}
+		found, _ := twitterApi.AdvancedSearchPager(twitterapi.AdvancedSearchRequest{
+			Query:     "from:" + username,
+			QueryType: queryType,
+		}, twitterapi.PageOptions{MaxPages: userSearchPages}).All(context.Background())
 
-		cursor = resp.NextCursor
-		for _, tweet := range resp.Tweets {
+		for _, tweet := range found {
 			saveTweetToDB(tweet)
 			twitterTime, _ := twitterapi_reverse.ParseTwitterTime(tweet.CreatedAt)
 			tweets = append(tweets, twitterapi_reverse.SimpleTweet{
@@ -47,31 +44,6 @@ func getUserTweets(username string) ([]twitterapi_reverse.SimpleTweet, error) {
 			})
 		}
 	}
-	cursor = ""
-	if len(tweets) == 0 {
-		for i := 0; i < userSearchPages; i++ {
-			resp, err := twitterApi.AdvancedSearch(twitterapi.AdvancedSearchRequest{
-				Query:     "from:" + username,
-				QueryType: twitterapi.TOP,
-				Cursor:    cursor,
-			})
-
-			if err != nil || len(resp.Tweets) == 0 || resp.NextCursor == "" {
-				break
-			}
-
-			cursor = resp.NextCursor
-			for _, tweet := range resp.Tweets {
-				saveTweetToDB(tweet)
-				twitterTime, _ := twitterapi_reverse.ParseTwitterTime(tweet.CreatedAt)
-				tweets = append(tweets, twitterapi_reverse.SimpleTweet{
-					TweetID:   tweet.Id,
-					Text:      tweet.Text,
-					CreatedAt: twitterTime,
-				})
-			}
-		}
-	}
 
 	return tweets, nil
 }
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
 	"fmt"
 	"github.com/grutapig/hackaton/twitterapi"
 	"github.com/joho/godotenv"
+	"log"
 	"os"
 	"strings"
 	"time"
@@ -39,45 +41,40 @@ func main() {
 	writer.Flush()
 
 	communityID := os.Getenv(twitterapi.ENV_DEMO_COMMUNITY_ID)
-	cursor := ""
 	totalTweets := 0
 	totalReplies := 0
-	pageCount := 0
+	ctx := context.Background()
 
 	fmt.Printf("🚀 Starting community scraping ID: %s\n", communityID)
 	startTime := time.Now()
 
-	for {
-		pageCount++
+	pager := api.CommunityTweetsPager(communityID, twitterapi.PageOptions{})
+	for !pager.Done() {
 		pageStartTime := time.Now()
 
-		var communityResponse *twitterapi.CommunityTweetsResponse
-
+		var tweets []twitterapi.Tweet
 		err := retryRequest(func() error {
 			var requestErr error
-			communityResponse, requestErr = api.GetCommunityTweets(twitterapi.CommunityTweetsRequest{
-				CommunityID: communityID,
-				Cursor:      cursor,
-			})
+			tweets, requestErr = pager.Next(ctx)
 			return requestErr
-		}, fmt.Sprintf("getting page %d community tweets (cursor: %s)", pageCount, cursor))
+		}, fmt.Sprintf("getting page %d community tweets (cursor: %s)", pager.Pages()+1, pager.Cursor()))
 
 		panicErr(err)
 
 		pageDuration := time.Since(pageStartTime)
-		tweetsInPage := len(communityResponse.Tweets)
+		tweetsInPage := len(tweets)
 		totalTweets += tweetsInPage
 
-		fmt.Printf("📄 Page %d: got %d tweets in %v\n", pageCount, tweetsInPage, pageDuration)
+		log.Printf("📄 Page %d: got %d tweets in %v", pager.Pages(), tweetsInPage, pageDuration)
 
-		for i, tweet := range communityResponse.Tweets {
+		for i, tweet := range tweets {
 			tweetStartTime := time.Now()
 
 			err = writeTweetToCSV(writer, tweet, "")
 			panicErr(err)
 			writer.Flush()
 
-			repliesCount, err := scrapeRepliesRecursively(api, writer, tweet.Id, tweet.Id, 0)
+			repliesCount, err := scrapeRepliesRecursively(ctx, api, writer, tweet.Id, tweet.Id, 0)
 			panicErr(err)
 
 			totalReplies += repliesCount
@@ -90,18 +87,13 @@ func main() {
 		elapsed := time.Since(startTime)
 		fmt.Printf("📊 Intermediate statistics: %d tweets, %d replies, runtime: %v\n",
 			totalTweets, totalReplies, elapsed)
-
-		if communityResponse.NextCursor == "" {
-			fmt.Println("📋 Reached end of community tweets list")
-			break
-		}
-		cursor = communityResponse.NextCursor
 	}
+	fmt.Println("📋 Reached end of community tweets list")
 
 	totalDuration := time.Since(startTime)
 	fmt.Printf("🎉 Scraping completed!\n")
 	fmt.Printf("📈 Final statistics:\n")
-	fmt.Printf("   - Processed pages: %d\n", pageCount)
+	fmt.Printf("   - Processed pages: %d\n", pager.Pages())
 	fmt.Printf("   - Total tweets: %d\n", totalTweets)
 	fmt.Printf("   - Total replies: %d\n", totalReplies)
 	fmt.Printf("   - Total runtime: %v\n", totalDuration)
@@ -143,26 +135,20 @@ func panicErr(err error) {
 	}
 }
 
-func scrapeRepliesRecursively(api *twitterapi.TwitterAPIService, writer *csv.Writer, tweetID string, rootTweetID string, depth int) (int, error) {
-	cursor := ""
+func scrapeRepliesRecursively(ctx context.Context, api *twitterapi.TwitterAPIService, writer *csv.Writer, tweetID string, rootTweetID string, depth int) (int, error) {
 	totalReplies := 0
-	pageCount := 0
 	indent := strings.Repeat("  ", depth+1)
 
-	for {
-		pageCount++
+	pager := api.TweetRepliesPager(twitterapi.TweetRepliesRequest{TweetID: tweetID}, twitterapi.PageOptions{})
+	for !pager.Done() {
 		pageStartTime := time.Now()
 
-		var repliesResponse *twitterapi.TweetRepliesResponse
-
+		var replies []twitterapi.Tweet
 		err := retryRequest(func() error {
 			var requestErr error
-			repliesResponse, requestErr = api.GetTweetReplies(twitterapi.TweetRepliesRequest{
-				TweetID: tweetID,
-				Cursor:  cursor,
-			})
+			replies, requestErr = pager.Next(ctx)
 			return requestErr
-		}, fmt.Sprintf("getting replies for tweet %s (depth %d, page %d)", tweetID, depth, pageCount))
+		}, fmt.Sprintf("getting replies for tweet %s (depth %d, page %d)", tweetID, depth, pager.Pages()+1))
 
 		if err != nil {
 			fmt.Printf("%s❌ Error getting replies for tweet %s: %v\n", indent, tweetID, err)
@@ -170,14 +156,14 @@ func scrapeRepliesRecursively(api *twitterapi.TwitterAPIService, writer *csv.Wri
 		}
 
 		pageDuration := time.Since(pageStartTime)
-		repliesInPage := len(repliesResponse.Tweets)
+		repliesInPage := len(replies)
 
 		if repliesInPage > 0 {
 			fmt.Printf("%s🔍 Depth %d, page %d: found %d replies in %v\n",
-				indent, depth, pageCount, repliesInPage, pageDuration)
+				indent, depth, pager.Pages(), repliesInPage, pageDuration)
 		}
 
-		for _, reply := range repliesResponse.Tweets {
+		for _, reply := range replies {
 			err = writeTweetToCSV(writer, reply, tweetID)
 			if err != nil {
 				return totalReplies, err
@@ -186,21 +172,16 @@ func scrapeRepliesRecursively(api *twitterapi.TwitterAPIService, writer *csv.Wri
 			totalReplies++
 
 			if reply.ReplyCount > 0 {
-				nestedReplies, err := scrapeRepliesRecursively(api, writer, reply.Id, rootTweetID, depth+1)
+				nestedReplies, err := scrapeRepliesRecursively(ctx, api, writer, reply.Id, rootTweetID, depth+1)
 				if err != nil {
 					return totalReplies, err
 				}
 				totalReplies += nestedReplies
 			}
 		}
-
-		if !repliesResponse.HasNextPage || repliesResponse.NextCursor == "" {
-			if pageCount > 1 && repliesInPage > 0 {
-				fmt.Printf("%s✅ Processed %d reply pages for tweet %s\n", indent, pageCount, tweetID)
-			}
-			break
-		}
-		cursor = repliesResponse.NextCursor
+	}
+	if pager.Pages() > 1 {
+		log.Printf("%s✅ Processed %d reply pages for tweet %s", indent, pager.Pages(), tweetID)
 	}
 
 	return totalReplies, nil
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
 	"fmt"
 	"github.com/grutapig/hackaton/twitterapi"
@@ -103,7 +104,7 @@ func main() {
 				}
 
 				err := retryRequest(func() error {
-					followers, requestErr := api.GetUserFollowers(twitterapi.UserFollowersRequest{
+					followers, requestErr := api.GetUserFollowers(context.Background(), twitterapi.UserFollowersRequest{
 						UserName: user.Username,
 						PageSize: 200,
 					})
@@ -129,7 +130,7 @@ func main() {
 				}
 
 				err = retryRequest(func() error {
-					followings, requestErr := api.GetUserFollowings(twitterapi.UserFollowingsRequest{
+					followings, requestErr := api.GetUserFollowings(context.Background(), twitterapi.UserFollowingsRequest{
 						UserName: user.Username,
 						PageSize: 200,
 					})
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
 	"fmt"
 	"github.com/grutapig/hackaton/twitterapi"
@@ -55,7 +56,7 @@ func main() {
 		go func() {
 			defer wgParallel.Done()
 			for authorName := range authorsCh {
-				result, err := api.GetUserLastTweets(twitterapi.UserLastTweetsRequest{UserName: authorName, IncludeReplies: true})
+				result, err := api.GetUserLastTweets(context.Background(), twitterapi.UserLastTweetsRequest{UserName: authorName, IncludeReplies: true})
 				resultCh <- struct {
 					string
 					twitterapi.UserLastTweetsResponse
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
 	"fmt"
 	"github.com/grutapig/hackaton/twitterapi"
 	"github.com/joho/godotenv"
+	"log"
 	"os"
 	"strings"
 	"sync"
@@ -118,35 +120,22 @@ func searchWorker(api *twitterapi.TwitterAPIService, ticker string, userCh <-cha
 
 func searchUserTweets(api *twitterapi.TwitterAPIService, username, ticker string) ([]twitterapi.Tweet, error) {
 	var allTweets []twitterapi.Tweet
-	cursor := ""
-	maxPages := 3
-	pageCount := 0
-
-	for pageCount < maxPages {
-		searchQuery := fmt.Sprintf("%s from:%s", ticker, username)
-
-		response, err := api.AdvancedSearch(twitterapi.AdvancedSearchRequest{
-			Query:     searchQuery,
-			QueryType: twitterapi.LATEST,
-			Cursor:    cursor,
-		})
-
+	searchQuery := fmt.Sprintf("%s from:%s", ticker, username)
+	pager := api.AdvancedSearchPager(twitterapi.AdvancedSearchRequest{
+		Query:     searchQuery,
+		QueryType: twitterapi.LATEST,
+	}, twitterapi.PageOptions{MaxPages: 3})
+
+	for !pager.Done() {
+		tweets, err := pager.Next(context.Background())
 		if err != nil {
 			fmt.Printf("❌ Error searching tweets for %s: %v\n", username, err)
 			return allTweets, err
 		}
 
-		tweetsInPage := len(response.Tweets)
-		allTweets = append(allTweets, response.Tweets...)
+		allTweets = append(allTweets, tweets...)
 
-		fmt.Printf("  📄 Page %d: found %d tweets for %s\n", pageCount+1, tweetsInPage, username)
-
-		pageCount++
-
-		if !response.HasNextPage || response.NextCursor == "" {
-			break
-		}
-		cursor = response.NextCursor
+		log.Printf("  📄 Page %d: found %d tweets for %s", pager.Pages(), len(tweets), username)
 
 		time.Sleep(100 * time.Millisecond)
 	}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	COMMUNITY_FIELD_NAME          = "name"
+	COMMUNITY_FIELD_TICKER        = "ticker"
+	COMMUNITY_FIELD_CHATS         = "chats"
+	COMMUNITY_FIELD_THRESHOLD     = "threshold"
+	COMMUNITY_FIELD_FIRST_PROMPT  = "first_prompt"
+	COMMUNITY_FIELD_SECOND_PROMPT = "second_prompt"
+	COMMUNITY_FIELD_ENABLED       = "enabled"
+)
+
+// CommunityService resolves the settings of the monitored communities. The
+// default community of the database is created on the first start with the
+// twitter_community_ticker ticker. The prompt files of a community are read by
+// the PromptRegistry, which reloads them when they change.
+type CommunityService struct {
+	dbService     *DatabaseService
+	defaultTicker string
+}
+
+func NewCommunityService(dbService *DatabaseService, defaultTicker string) (*CommunityService, error) {
+	defaultCommunityID := dbService.CommunityID()
+	if defaultCommunityID != "" {
+		_, err := dbService.GetCommunity(defaultCommunityID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = dbService.SaveCommunity(CommunityModel{ID: defaultCommunityID, Ticker: defaultTicker, Enabled: true})
+			log.Printf("Default community %s (%s) created", defaultCommunityID, defaultTicker)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("default community: %w", err)
+		}
+	}
+	return &CommunityService{
+		dbService:     dbService,
+		defaultTicker: defaultTicker,
+	}, nil
+}
+
+// Get returns the community settings, an empty id is the default community.
+// Unknown communities get the default ticker and prompts.
+func (c *CommunityService) Get(communityID string) *CommunityModel {
+	if communityID == "" {
+		communityID = c.dbService.CommunityID()
+	}
+	community, err := c.dbService.GetCommunity(communityID)
+	if err != nil {
+		community = &CommunityModel{ID: communityID, Enabled: true}
+	}
+	if community.Ticker == "" {
+		community.Ticker = c.defaultTicker
+	}
+	return community
+}
+
+func (c *CommunityService) Enabled() ([]CommunityModel, error) {
+	return c.dbService.GetCommunities(true)
+}
+
+func (c *CommunityService) All() ([]CommunityModel, error) {
+	return c.dbService.GetCommunities(false)
+}
+
+func (c *CommunityService) Add(communityID, ticker, name string) (*CommunityModel, error) {
+	if _, err := c.dbService.GetCommunity(communityID); err == nil {
+		return nil, fmt.Errorf("community %s already exists", communityID)
+	}
+	if name == "" {
+		name = ticker
+	}
+	community := CommunityModel{ID: communityID, Name: name, Ticker: ticker, Enabled: true}
+	if err := c.dbService.SaveCommunity(community); err != nil {
+		return nil, err
+	}
+	return &community, nil
+}
+
+// Set changes one setting of the community, field is one of the COMMUNITY_FIELD_*
+// constants.
+func (c *CommunityService) Set(communityID, field, value string) (*CommunityModel, error) {
+	community, err := c.dbService.GetCommunity(communityID)
+	if err != nil {
+		return nil, fmt.Errorf("community %s not found", communityID)
+	}
+	switch field {
+	case COMMUNITY_FIELD_NAME:
+		community.Name = value
+	case COMMUNITY_FIELD_TICKER:
+		community.Ticker = value
+	case COMMUNITY_FIELD_CHATS:
+		if _, err := parseChatIDs(value); err != nil {
+			return nil, err
+		}
+		community.TelegramChatIDs = value
+	case COMMUNITY_FIELD_THRESHOLD:
+		threshold, err := strconv.ParseFloat(value, 64)
+		if err != nil || threshold < 0 || threshold > 1 {
+			return nil, fmt.Errorf("threshold should be a probability from 0 to 1")
+		}
+		community.AlertThreshold = threshold
+	case COMMUNITY_FIELD_FIRST_PROMPT, COMMUNITY_FIELD_SECOND_PROMPT:
+		if value != "" {
+			if _, err := os.Stat(value); err != nil {
+				return nil, fmt.Errorf("prompt file: %w", err)
+			}
+		}
+		if field == COMMUNITY_FIELD_FIRST_PROMPT {
+			community.FirstStepPromptFile = value
+		} else {
+			community.SecondStepPromptFile = value
+		}
+	case COMMUNITY_FIELD_ENABLED:
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("enabled should be true or false")
+		}
+		community.Enabled = enabled
+	default:
+		return nil, fmt.Errorf("unknown community setting %s", field)
+	}
+	if err := c.dbService.SaveCommunity(*community); err != nil {
+		return nil, err
+	}
+	return community, nil
+}
+
+// ChatIDs returns the Telegram chats of the community alerts, none means the
+// alerts are broadcast to every registered chat.
+func (m CommunityModel) ChatIDs() []int64 {
+	chatIDs, _ := parseChatIDs(m.TelegramChatIDs)
+	return chatIDs
+}
+
+func (m CommunityModel) DisplayName() string {
+	if m.Name != "" {
+		return m.Name
+	}
+	if m.Ticker != "" {
+		return m.Ticker
+	}
+	return m.ID
+}
+
+func parseChatIDs(value string) ([]int64, error) {
+	chatIDs := []int64{}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		chatID, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chat id %s", part)
+		}
+		chatIDs = append(chatIDs, chatID)
+	}
+	return chatIDs, nil
+}
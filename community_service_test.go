@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabaseService_SetDefaultCommunityMovesLegacyData(t *testing.T) {
+	db := setupTestDB(t)
+
+	// a database created before communities kept the statuses on the users table
+	for _, column := range []string{"status TEXT DEFAULT 'unknown'", "is_fud NUMERIC DEFAULT false", "fud_type TEXT", "fud_probability REAL DEFAULT 0", "is_detail_analyzed NUMERIC DEFAULT false", "last_analyzed_at DATETIME", "last_message_id TEXT", "analysis_count INTEGER DEFAULT 0", "fud_message_count INTEGER DEFAULT 0"} {
+		require.NoError(t, db.db.Exec("ALTER TABLE users ADD COLUMN "+column).Error)
+	}
+	require.NoError(t, db.SaveUser(UserModel{ID: "user_1", Username: "trader"}))
+	require.NoError(t, db.SaveUser(UserModel{ID: "user_2", Username: "holder"}))
+	require.NoError(t, db.db.Exec("UPDATE users SET status = ?, is_fud = 1, fud_type = 'emotional_escalation', fud_probability = 0.8, is_detail_analyzed = 1 WHERE id = 'user_1'", USER_STATUS_FUD_CONFIRMED).Error)
+	require.NoError(t, db.SaveFUDUser(FUDUserModel{UserID: "user_1", Username: "trader", DetectedAt: time.Now()}))
+	require.NoError(t, db.SaveTweet(TweetModel{ID: "tweet_1", UserID: "user_1"}))
+
+	require.NoError(t, db.SetDefaultCommunity("community_a"))
+	require.NoError(t, db.SetDefaultCommunity("community_a"))
+
+	status, err := db.GetUserCommunityStatus("user_1")
+	require.NoError(t, err)
+	assert.Equal(t, USER_STATUS_FUD_CONFIRMED, status.Status)
+	assert.Equal(t, 0.8, status.FUDProbability)
+	assert.True(t, status.IsDetailAnalyzed)
+	assert.True(t, db.IsFUDUser("user_1"))
+	assert.Equal(t, USER_STATUS_UNKNOWN, db.GetUserStatus("user_2"))
+
+	count, err := db.GetCommunityTweetCount()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestCommunityService_Settings(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.SetDefaultCommunity("community_a"))
+	communities, err := NewCommunityService(db, "$AAA")
+	require.NoError(t, err)
+
+	assert.Equal(t, "$AAA", communities.Get("").Ticker)
+	assert.Equal(t, "$AAA", communities.Get("unknown").Ticker, "unknown communities use the default settings")
+
+	_, err = communities.Add("community_b", "$BBB", "")
+	require.NoError(t, err)
+	_, err = communities.Add("community_b", "$BBB", "")
+	assert.Error(t, err)
+
+	_, err = communities.Set("community_b", COMMUNITY_FIELD_THRESHOLD, "80")
+	assert.Error(t, err)
+	_, err = communities.Set("community_b", COMMUNITY_FIELD_THRESHOLD, "0.8")
+	require.NoError(t, err)
+	_, err = communities.Set("community_b", COMMUNITY_FIELD_CHATS, "-100123, 42")
+	require.NoError(t, err)
+
+	community := communities.Get("community_b")
+	assert.Equal(t, "$BBB", community.DisplayName())
+	assert.Equal(t, 0.8, community.AlertThreshold)
+	assert.Equal(t, []int64{-100123, 42}, community.ChatIDs())
+
+	promptFile := filepath.Join(t.TempDir(), "prompt_b.txt")
+	require.NoError(t, os.WriteFile(promptFile, []byte("prompt of b"), 0644))
+	_, err = communities.Set("community_b", COMMUNITY_FIELD_SECOND_PROMPT, promptFile)
+	require.NoError(t, err)
+	_, err = communities.Set("community_b", COMMUNITY_FIELD_FIRST_PROMPT, "missing.txt")
+	assert.Error(t, err)
+
+	community = communities.Get("community_b")
+	registry, err := NewPromptRegistry("", nil, nil)
+	require.NoError(t, err)
+	text, err := registry.SelectWithBase(PROMPT_SECOND_STEP, "u1", community.SecondStepPromptFile).Render(PromptVars{})
+	require.NoError(t, err)
+	assert.Contains(t, text, "prompt of b")
+
+	// an edit of the file is used by the next analysis
+	modTime := time.Now().Add(time.Minute)
+	require.NoError(t, os.WriteFile(promptFile, []byte("prompt of b edited"), 0644))
+	require.NoError(t, os.Chtimes(promptFile, modTime, modTime))
+	text, err = registry.SelectWithBase(PROMPT_SECOND_STEP, "u1", community.SecondStepPromptFile).Render(PromptVars{})
+	require.NoError(t, err)
+	assert.Contains(t, text, "prompt of b edited")
+	assert.Empty(t, community.FirstStepPromptFile)
+
+	_, err = communities.Set("community_b", COMMUNITY_FIELD_ENABLED, "false")
+	require.NoError(t, err)
+	enabled, err := communities.Enabled()
+	require.NoError(t, err)
+	require.Len(t, enabled, 1)
+	assert.Equal(t, "community_a", enabled[0].ID)
+}
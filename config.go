@@ -4,6 +4,7 @@ const ENV_TWITTER_API_KEY = "twitter_api_key"
 const ENV_PROXY_DSN = "proxy_dsn"
 const ENV_PROXY_CLAUDE_DSN = "proxy_claude_dsn"
 const ENV_TWITTER_API_BASE_URL = "twitter_api_base_url"
+const ENV_TWITTER_API_RPS = "twitter_api_rps"
 const ENV_DEMO_COMMUNITY_ID = "demo_community_id"
 const ENV_TWITTER_COMMUNITY_TICKER = "twitter_community_ticker"
 const ENV_CLAUDE_API_KEY = "claude_api_key"
@@ -15,10 +16,35 @@ const ENV_CLEAR_ANALYSIS_ON_START = "clear_analysis_on_start"
 const ENV_SOLANA_RPC_URL = "solana_rpc"
 const ENV_LOGGING_DATABASE_PATH = "logging_database_path"
 
+const ENV_OPENAI_API_KEY = "openai_api_key"
+const ENV_OPENAI_BASE_URL = "openai_base_url"
+const ENV_OPENAI_MODEL = "openai_model"
+const ENV_LLM_FIRST_STEP_PROVIDER = "llm_first_step_provider"
+const ENV_LLM_FIRST_STEP_MODEL = "llm_first_step_model"
+const ENV_LLM_SECOND_STEP_PROVIDER = "llm_second_step_provider"
+const ENV_LLM_SECOND_STEP_MODEL = "llm_second_step_model"
+const ENV_LLM_TWITTER_BOT_PROVIDER = "llm_twitter_bot_provider"
+const ENV_LLM_TWITTER_BOT_MODEL = "llm_twitter_bot_model"
+const ENV_LLM_RETRY_MAX_ATTEMPTS = "llm_retry_max_attempts"
+const ENV_LLM_RETRY_BASE_DELAY_MS = "llm_retry_base_delay_ms"
+const ENV_LLM_RETRY_MAX_DELAY_MS = "llm_retry_max_delay_ms"
+const ENV_LLM_REQUEST_TIMEOUT_SECONDS = "llm_request_timeout_seconds"
+const ENV_LLM_PRICING_FILE = "llm_pricing_file"
+const ENV_PROMPTS_DIR = "prompts_dir"
+const ENV_LLM_DAILY_BUDGET_USD = "llm_daily_budget_usd"
+const ENV_LLM_MONTHLY_BUDGET_USD = "llm_monthly_budget_usd"
+const ENV_LLM_BATCH_ANALYSIS_DISABLED = "llm_batch_analysis_disabled"
+const ENV_SECOND_STEP_WORKERS = "second_step_workers"
+const ENV_SECOND_STEP_TWITTER_RPM = "second_step_twitter_rpm"
+const ENV_SECOND_STEP_LLM_RPM = "second_step_llm_rpm"
+const ENV_RELATIONS_MAX_PAGES = "relations_max_pages"
+const ENV_RELATIONS_REFRESH_DAYS = "relations_refresh_days"
+
 const ENV_TWITTER_REVERSE_AUTHORIZATION = "twitter_reverse_authorization"
 const ENV_TWITTER_REVERSE_CSRF_TOKEN = "twitter_reverse_csrf_token"
 const ENV_TWITTER_REVERSE_COOKIE = "twitter_reverse_cookie"
 const ENV_TWITTER_REVERSE_ENABLED = "twitter_reverse_enabled"
+const ENV_REVERSE_SESSION_KEY = "reverse_session_key"
 const ENV_TWITTER_AUTH = "twitter_auth"
 const ENV_TWITTER_BOT_TAG = "twitter_bot_tag"
 
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/grutapig/hackaton/claude"
 	"github.com/grutapig/hackaton/twitterapi_reverse"
+	"github.com/grutapig/hackaton/twittersource"
+	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/grutapig/hackaton/twitterapi"
 	"go.uber.org/dig"
@@ -15,6 +20,7 @@ type Config struct {
 	ProxyClaudeDSN       string
 	TwitterAPIKey        string
 	TwitterAPIBaseURL    string
+	TwitterAPIRPS        float64
 	ProxyDSN             string
 	TelegramAPIKey       string
 	TelegramAdminChatID  string
@@ -23,17 +29,44 @@ type Config struct {
 	TwitterBotTag        string
 	TwitterAuth          string
 	Ticker               string
+	CommunityID          string
 	ClearAnalysisOnStart bool
+
+	OpenAIAPIKey         string
+	OpenAIBaseURL        string
+	OpenAIModel          string
+	FirstStepProvider    string
+	FirstStepModel       string
+	SecondStepProvider   string
+	SecondStepModel      string
+	TwitterBotProvider   string
+	TwitterBotModel      string
+	LLMRetryPolicy       claude.RetryPolicy
+	LLMPricingFile       string
+	PromptsDir           string
+	DailyBudgetUSD       float64
+	MonthlyBudgetUSD     float64
+	BatchAnalysis        bool
+	SecondStepWorkers    int
+	SecondStepTwitterRPM int
+	SecondStepLLMRPM     int
+	Relations            RelationOptions
+
+	TwitterReverseEnabled bool
+	ReverseSessionKey     string
 }
 
 type Channels struct {
 	NewMessageCh   chan twitterapi.NewMessage
-	FirstStepCh    chan twitterapi.NewMessage
-	TwitterBotCh   chan twitterapi.NewMessage
-	FudCh          chan twitterapi.NewMessage
 	NotificationCh chan FUDAlertNotification
 }
 
+type LLMClients struct {
+	FirstStep  claude.LLMClient
+	SecondStep claude.LLMClient
+	TwitterBot claude.LLMClient
+}
+
 func ProvideConfig() (*Config, error) {
 	ticker := os.Getenv(ENV_TWITTER_COMMUNITY_TICKER)
 	if ticker == "" {
@@ -60,11 +93,28 @@ func ProvideConfig() (*Config, error) {
 		loggingDBPath = "logs.db"
 	}
 
+	promptsDir := os.Getenv(ENV_PROMPTS_DIR)
+	if promptsDir == "" {
+		promptsDir = PROMPTS_DIR
+	}
+
+	dailyBudget, _ := strconv.ParseFloat(os.Getenv(ENV_LLM_DAILY_BUDGET_USD), 64)
+	monthlyBudget, _ := strconv.ParseFloat(os.Getenv(ENV_LLM_MONTHLY_BUDGET_USD), 64)
+	secondStepWorkers, _ := strconv.Atoi(os.Getenv(ENV_SECOND_STEP_WORKERS))
+	secondStepTwitterRPM, _ := strconv.Atoi(os.Getenv(ENV_SECOND_STEP_TWITTER_RPM))
+	secondStepLLMRPM, _ := strconv.Atoi(os.Getenv(ENV_SECOND_STEP_LLM_RPM))
+	twitterReverseEnabled, _ := strconv.ParseBool(os.Getenv(ENV_TWITTER_REVERSE_ENABLED))
+	twitterAPIRPS := float64(twitterapi.TWITTER_API_DEFAULT_RPS)
+	if value, err := strconv.ParseFloat(os.Getenv(ENV_TWITTER_API_RPS), 64); err == nil && value >= 0 {
+		twitterAPIRPS = value
+	}
+
 	return &Config{
 		ClaudeAPIKey:         os.Getenv(ENV_CLAUDE_API_KEY),
 		ProxyClaudeDSN:       os.Getenv(ENV_PROXY_CLAUDE_DSN),
 		TwitterAPIKey:        os.Getenv(ENV_TWITTER_API_KEY),
 		TwitterAPIBaseURL:    os.Getenv(ENV_TWITTER_API_BASE_URL),
+		TwitterAPIRPS:        twitterAPIRPS,
 		ProxyDSN:             os.Getenv(ENV_PROXY_DSN),
 		TelegramAPIKey:       os.Getenv(ENV_TELEGRAM_API_KEY),
 		TelegramAdminChatID:  os.Getenv(ENV_TELEGRAM_ADMIN_CHAT_ID),
@@ -73,50 +123,234 @@ func ProvideConfig() (*Config, error) {
 		TwitterBotTag:        botTag,
 		TwitterAuth:          authSession,
 		Ticker:               ticker,
+		CommunityID:          os.Getenv(ENV_DEMO_COMMUNITY_ID),
 		ClearAnalysisOnStart: os.Getenv(ENV_CLEAR_ANALYSIS_ON_START) == "true",
+		OpenAIAPIKey:         os.Getenv(ENV_OPENAI_API_KEY),
+		OpenAIBaseURL:        os.Getenv(ENV_OPENAI_BASE_URL),
+		OpenAIModel:          os.Getenv(ENV_OPENAI_MODEL),
+		FirstStepProvider:    os.Getenv(ENV_LLM_FIRST_STEP_PROVIDER),
+		FirstStepModel:       os.Getenv(ENV_LLM_FIRST_STEP_MODEL),
+		SecondStepProvider:   os.Getenv(ENV_LLM_SECOND_STEP_PROVIDER),
+		SecondStepModel:      os.Getenv(ENV_LLM_SECOND_STEP_MODEL),
+		TwitterBotProvider:   os.Getenv(ENV_LLM_TWITTER_BOT_PROVIDER),
+		TwitterBotModel:      os.Getenv(ENV_LLM_TWITTER_BOT_MODEL),
+		LLMRetryPolicy:       loadRetryPolicy(),
+		LLMPricingFile:       os.Getenv(ENV_LLM_PRICING_FILE),
+		PromptsDir:           promptsDir,
+		DailyBudgetUSD:       dailyBudget,
+		MonthlyBudgetUSD:     monthlyBudget,
+		BatchAnalysis:        os.Getenv(ENV_LLM_BATCH_ANALYSIS_DISABLED) != "true",
+		SecondStepWorkers:    secondStepWorkers,
+		SecondStepTwitterRPM: secondStepTwitterRPM,
+		SecondStepLLMRPM:     secondStepLLMRPM,
+		Relations:            loadRelationOptions(),
+
+		TwitterReverseEnabled: twitterReverseEnabled,
+		ReverseSessionKey:     os.Getenv(ENV_REVERSE_SESSION_KEY),
 	}, nil
 }
 
+func loadRetryPolicy() claude.RetryPolicy {
+	policy := claude.DefaultRetryPolicy()
+	if value, err := strconv.Atoi(os.Getenv(ENV_LLM_RETRY_MAX_ATTEMPTS)); err == nil && value > 0 {
+		policy.MaxAttempts = value
+	}
+	if value, err := strconv.Atoi(os.Getenv(ENV_LLM_RETRY_BASE_DELAY_MS)); err == nil && value > 0 {
+		policy.BaseDelay = time.Duration(value) * time.Millisecond
+	}
+	if value, err := strconv.Atoi(os.Getenv(ENV_LLM_RETRY_MAX_DELAY_MS)); err == nil && value > 0 {
+		policy.MaxDelay = time.Duration(value) * time.Millisecond
+	}
+	if value, err := strconv.Atoi(os.Getenv(ENV_LLM_REQUEST_TIMEOUT_SECONDS)); err == nil && value > 0 {
+		policy.RequestTimeout = time.Duration(value) * time.Second
+	}
+	return policy
+}
+
+func loadRelationOptions() RelationOptions {
+	options := DefaultRelationOptions()
+	if value, err := strconv.Atoi(os.Getenv(ENV_RELATIONS_MAX_PAGES)); err == nil && value >= 0 {
+		options.MaxPages = value
+	}
+	if value, err := strconv.Atoi(os.Getenv(ENV_RELATIONS_REFRESH_DAYS)); err == nil && value >= 0 {
+		options.RefreshAfter = time.Duration(value) * 24 * time.Hour
+	}
+	return options
+}
+
 func ProvideChannels() *Channels {
 	return &Channels{
 		NewMessageCh:   make(chan twitterapi.NewMessage, 10),
-		FirstStepCh:    make(chan twitterapi.NewMessage, 10),
-		TwitterBotCh:   make(chan twitterapi.NewMessage, 10),
-		FudCh:          make(chan twitterapi.NewMessage, 30),
 		NotificationCh: make(chan FUDAlertNotification, 30),
 	}
 }
 
-func ProvideClaudeAPI(config *Config) (*claude.ClaudeApi, error) {
-	return claude.NewClaudeClient(config.ClaudeAPIKey, config.ProxyClaudeDSN, claude.CLAUDE_MODEL)
+func ProvideLLMClients(config *Config) (*LLMClients, error) {
+	firstStep, err := newLLMClient(config, config.FirstStepProvider, config.FirstStepModel)
+	if err != nil {
+		return nil, fmt.Errorf("first step llm client: %w", err)
+	}
+	secondStep, err := newLLMClient(config, config.SecondStepProvider, config.SecondStepModel)
+	if err != nil {
+		return nil, fmt.Errorf("second step llm client: %w", err)
+	}
+	twitterBot, err := newLLMClient(config, config.TwitterBotProvider, config.TwitterBotModel)
+	if err != nil {
+		return nil, fmt.Errorf("twitter bot llm client: %w", err)
+	}
+
+	log.Printf("LLM providers: first step %s, second step %s, twitter bot %s", firstStep.GetModel(), secondStep.GetModel(), twitterBot.GetModel())
+
+	return &LLMClients{
+		FirstStep:  firstStep,
+		SecondStep: secondStep,
+		TwitterBot: twitterBot,
+	}, nil
+}
+
+func newLLMClient(config *Config, provider string, model string) (claude.LLMClient, error) {
+	var client claude.LLMClient
+	var err error
+	switch provider {
+	case "", claude.PROVIDER_CLAUDE:
+		if model == "" {
+			model = claude.CLAUDE_MODEL
+		}
+		client, err = claude.NewClaudeClient(config.ClaudeAPIKey, config.ProxyClaudeDSN, model)
+	case claude.PROVIDER_OPENAI:
+		if model == "" {
+			model = config.OpenAIModel
+		}
+		if model == "" {
+			return nil, fmt.Errorf("model should be set for openai provider: %s", ENV_OPENAI_MODEL)
+		}
+		client, err = claude.NewOpenAICompatibleClient(config.OpenAIAPIKey, config.OpenAIBaseURL, "", model)
+	default:
+		return nil, fmt.Errorf("unknown llm provider: %s", provider)
+	}
+	if err != nil {
+		return nil, err
+	}
+	client.SetRetryPolicy(config.LLMRetryPolicy)
+	return client, nil
 }
 
-func ProvideTwitterAPI(config *Config) *twitterapi.TwitterAPIService {
-	return twitterapi.NewTwitterAPIService(config.TwitterAPIKey, config.TwitterAPIBaseURL, config.ProxyDSN)
+func ProvideTwitterAPI(config *Config, loggingService *LoggingService) *twitterapi.TwitterAPIService {
+	service := twitterapi.NewTwitterAPIService(config.TwitterAPIKey, config.TwitterAPIBaseURL, config.ProxyDSN)
+	service.SetRateLimit(config.TwitterAPIRPS, twitterapi.TWITTER_API_DEFAULT_BURST)
+	service.OnUsage(func(ctx context.Context, endpoint string, statusCode int, credits int) {
+		if err := loggingService.AddTwitterAPIUsage(time.Now(), endpoint, statusCode, credits); err != nil {
+			log.Printf("Failed to log twitterapi usage: %v", err)
+		}
+		if requestUUID := RequestUUIDFromContext(ctx); requestUUID != "" {
+			if err := loggingService.AddTwitterAPIRequestUsage(requestUUID, endpoint, statusCode, credits); err != nil {
+				log.Printf("Failed to log twitterapi usage of request %s: %v", requestUUID, err)
+			}
+		}
+	})
+	return service
 }
-func ProvideTwitterReverseAPI(config *Config) *twitterapi_reverse.TwitterReverseService {
+func ProvideTwitterReverseAPI(config *Config, sessions *ReverseSessionService) *twitterapi_reverse.TwitterReverseService {
 	auth := twitterapi_reverse.NewTwitterAuth(os.Getenv(ENV_TWITTER_REVERSE_AUTHORIZATION), os.Getenv(ENV_TWITTER_REVERSE_CSRF_TOKEN), os.Getenv(ENV_TWITTER_REVERSE_COOKIE))
 
-	return twitterapi_reverse.NewTwitterReverseApi(auth, config.ProxyDSN, false)
+	service := twitterapi_reverse.NewTwitterReverseApi(auth, config.ProxyDSN, false)
+	service.SetSessionPool(sessions.Pool())
+	return service
+}
+
+func ProvideReverseSessionService(config *Config, dbService *DatabaseService) (*ReverseSessionService, error) {
+	return NewReverseSessionService(dbService, config.ReverseSessionKey)
+}
+
+// ProvideTwitterSource chains the Twitter backends used for reading. The reverse
+// API goes first when twitter_reverse_enabled is set and a session is
+// configured, in the pool or in the twitter_reverse_* variables. twitterapi.io
+// serves everything it cannot.
+func ProvideTwitterSource(config *Config, twitterAPI *twitterapi.TwitterAPIService, twitterReverse *twitterapi_reverse.TwitterReverseService, sessions *ReverseSessionService) twittersource.Source {
+	var sources []twittersource.Source
+	if config.TwitterReverseEnabled {
+		envAuth := os.Getenv(ENV_TWITTER_REVERSE_AUTHORIZATION) != "" && os.Getenv(ENV_TWITTER_REVERSE_CSRF_TOKEN) != "" && os.Getenv(ENV_TWITTER_REVERSE_COOKIE) != ""
+		if !envAuth && sessions.Pool().Len() == 0 {
+			log.Println("Twitter Reverse API enabled but missing authentication data")
+		} else {
+			sources = append(sources, twittersource.NewReverseSource(twitterReverse))
+		}
+	}
+	sources = append(sources, twittersource.NewAPISource(twitterAPI))
+	source := twittersource.NewComposite(sources...)
+	log.Printf("Twitter sources: %s", source.Name())
+	return source
 }
 
 func ProvideDatabaseService(config *Config) (*DatabaseService, error) {
-	return NewDatabaseService(config.DatabaseName)
+	dbService, err := NewDatabaseService(config.DatabaseName)
+	if err != nil {
+		return nil, err
+	}
+	if err := dbService.SetDefaultCommunity(config.CommunityID); err != nil {
+		return nil, err
+	}
+	return dbService, nil
+}
+
+func ProvideCommunityService(config *Config, dbService *DatabaseService) (*CommunityService, error) {
+	return NewCommunityService(dbService, config.Ticker)
+}
+
+func ProvideJobQueue(dbService *DatabaseService) *JobQueue {
+	return NewJobQueue(dbService)
+}
+
+func ProvideSecondStepPool(config *Config, jobQueue *JobQueue, dbService *DatabaseService, channels *Channels) *SecondStepPool {
+	return NewSecondStepPool(jobQueue, dbService, channels.NotificationCh, config.SecondStepWorkers, config.SecondStepTwitterRPM, config.SecondStepLLMRPM)
 }
 
 func ProvideLoggingService(config *Config) (*LoggingService, error) {
 	return NewLoggingService(config.LoggingDBPath)
 }
-func ProvideTwitterBotService(twitterapiService *twitterapi.TwitterAPIService, dbService *DatabaseService, claudeApi *claude.ClaudeApi, twitterReverseService *twitterapi_reverse.TwitterReverseService) (*TwitterBotService, error) {
-	return NewTwitterBotService(twitterapiService, twitterReverseService, dbService, claudeApi), nil
+func ProvideAIBudgetService(config *Config, loggingService *LoggingService, jobQueue *JobQueue) (*AIBudgetService, error) {
+	prices, err := claude.LoadModelPrices(config.LLMPricingFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewAIBudgetService(loggingService, jobQueue, prices, config.DailyBudgetUSD, config.MonthlyBudgetUSD)
+}
+
+func ProvidePromptRegistry(config *Config, dbService *DatabaseService) (*PromptRegistry, error) {
+	return NewPromptRegistry(config.PromptsDir, map[string]string{
+		PROMPT_FIRST_STEP_BASE:  PROMPT_FILE_STEP1,
+		PROMPT_SECOND_STEP_BASE: PROMPT_FILE_STEP2,
+	}, dbService)
+}
+
+func ProvideBatchAnalysisService(config *Config, llmClients *LLMClients, source twittersource.Source, dbService *DatabaseService, loggingService *LoggingService, budget *AIBudgetService, prompts *PromptRegistry, communities *CommunityService, channels *Channels, jobQueue *JobQueue) (*BatchAnalysisService, error) {
+	var llmClient claude.LLMClient
+	if config.BatchAnalysis {
+		llmClient = llmClients.SecondStep
+	}
+	return NewBatchAnalysisService(llmClient, source, dbService, loggingService, budget, prompts, communities, config.Relations, channels.NotificationCh, jobQueue), nil
+}
+
+func ProvideTwitterBotService(twitterapiService *twitterapi.TwitterAPIService, source twittersource.Source, dbService *DatabaseService, llmClients *LLMClients, budget *AIBudgetService, prompts *PromptRegistry) (*TwitterBotService, error) {
+	return NewTwitterBotService(twitterapiService, source, dbService, llmClients.TwitterBot, budget, prompts), nil
 }
 
 func ProvideNotificationFormatter() *NotificationFormatter {
 	return NewNotificationFormatter()
 }
 
-func ProvideTelegramService(config *Config, formatter *NotificationFormatter, dbService *DatabaseService, channels *Channels) (*TelegramService, error) {
-	return NewTelegramService(config.TelegramAPIKey, config.ProxyDSN, config.TelegramAdminChatID, formatter, dbService, channels.FudCh)
+func ProvideTelegramService(config *Config, formatter *NotificationFormatter, dbService *DatabaseService, jobQueue *JobQueue, loggingService *LoggingService, budget *AIBudgetService, batchAnalysis *BatchAnalysisService, secondStepPool *SecondStepPool, communities *CommunityService, reverseSessions *ReverseSessionService, prompts *PromptRegistry) (*TelegramService, error) {
+	telegramService, err := NewTelegramService(config.TelegramAPIKey, config.ProxyDSN, config.TelegramAdminChatID, formatter, dbService, jobQueue)
+	if err != nil {
+		return nil, err
+	}
+	telegramService.SetBudgetService(budget, loggingService)
+	telegramService.SetBatchAnalysisService(batchAnalysis)
+	telegramService.SetSecondStepPool(secondStepPool)
+	telegramService.SetCommunityService(communities)
+	telegramService.SetReverseSessionService(reverseSessions)
+	telegramService.SetPromptRegistry(prompts)
+	return telegramService, nil
 }
 
 func ProvideCleanupScheduler(loggingService *LoggingService) *CleanupScheduler {
@@ -134,25 +368,56 @@ func BuildContainer() (*dig.Container, error) {
 		return nil, fmt.Errorf("failed to provide channels: %w", err)
 	}
 
-	if err := container.Provide(ProvideClaudeAPI); err != nil {
-		return nil, fmt.Errorf("failed to provide Claude API: %w", err)
+	if err := container.Provide(ProvideLLMClients); err != nil {
+		return nil, fmt.Errorf("failed to provide LLM clients: %w", err)
 	}
 
 	if err := container.Provide(ProvideTwitterAPI); err != nil {
 		return nil, fmt.Errorf("failed to provide Twitter API: %w", err)
 	}
+	if err := container.Provide(ProvideReverseSessionService); err != nil {
+		return nil, fmt.Errorf("failed to provide reverse sessions: %w", err)
+	}
 	if err := container.Provide(ProvideTwitterReverseAPI); err != nil {
 		return nil, fmt.Errorf("failed to provide Twitter API: %w", err)
 	}
 
+	if err := container.Provide(ProvideTwitterSource); err != nil {
+		return nil, fmt.Errorf("failed to provide Twitter source: %w", err)
+	}
+
 	if err := container.Provide(ProvideDatabaseService); err != nil {
 		return nil, fmt.Errorf("failed to provide database service: %w", err)
 	}
 
+	if err := container.Provide(ProvideCommunityService); err != nil {
+		return nil, fmt.Errorf("failed to provide community service: %w", err)
+	}
+
+	if err := container.Provide(ProvideJobQueue); err != nil {
+		return nil, fmt.Errorf("failed to provide job queue: %w", err)
+	}
+
+	if err := container.Provide(ProvideSecondStepPool); err != nil {
+		return nil, fmt.Errorf("failed to provide second step pool: %w", err)
+	}
+
 	if err := container.Provide(ProvideLoggingService); err != nil {
 		return nil, fmt.Errorf("failed to provide logging service: %w", err)
 	}
 
+	if err := container.Provide(ProvideAIBudgetService); err != nil {
+		return nil, fmt.Errorf("failed to provide AI budget service: %w", err)
+	}
+
+	if err := container.Provide(ProvidePromptRegistry); err != nil {
+		return nil, fmt.Errorf("failed to provide prompt registry: %w", err)
+	}
+
+	if err := container.Provide(ProvideBatchAnalysisService); err != nil {
+		return nil, fmt.Errorf("failed to provide batch analysis service: %w", err)
+	}
+
 	if err := container.Provide(ProvideTwitterBotService); err != nil {
 		return nil, fmt.Errorf("failed to provide twitterbot service: %w", err)
 	}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvideTwitterAPI_CountsCreditsPerRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-credits-used", "30")
+		w.Write([]byte(`{"tweets":[{"id":"1"}]}`))
+	}))
+	defer server.Close()
+
+	loggingService, err := NewLoggingService(t.TempDir() + "/test_logs.db")
+	require.NoError(t, err)
+	require.NoError(t, loggingService.StartRequestProcessing("r1", "u1", "alice", "t1", 5))
+	api := ProvideTwitterAPI(&Config{TwitterAPIKey: "key", TwitterAPIBaseURL: server.URL}, loggingService)
+
+	ctx := WithRequestUUID(context.Background(), "r1")
+	for i := 0; i < 2; i++ {
+		_, err = api.GetTweetsByIds(ctx, []string{"1"})
+		require.NoError(t, err)
+	}
+	_, err = api.GetTweetsByIds(context.Background(), []string{"1"})
+	require.NoError(t, err)
+
+	usage, err := loggingService.GetTwitterAPIUsageByUUID("r1")
+	require.NoError(t, err)
+	require.Len(t, usage, 1)
+	assert.Equal(t, "/twitter/tweets", usage[0].Endpoint)
+	assert.Equal(t, 2, usage[0].Requests)
+	assert.Equal(t, 60, usage[0].Credits)
+
+	today := time.Now()
+	requests, err := loggingService.GetTopTwitterAPIRequests(today, today, 5)
+	require.NoError(t, err)
+	assert.Equal(t, []TwitterAPIRequestCredits{{RequestUUID: "r1", Username: "alice", Requests: 2, Credits: 60}}, requests)
+
+	endpoints, err := loggingService.GetTwitterAPIUsageByEndpoint(today, today)
+	require.NoError(t, err)
+	require.Len(t, endpoints, 1)
+	assert.Equal(t, 90, endpoints[0].Credits, "the daily count has the calls outside a request too")
+}
@@ -18,6 +18,7 @@ type TweetModel struct {
 	SourceType    string    `gorm:"column:source_type;index" json:"source_type"`
 	TickerMention string    `gorm:"column:ticker_mention;index" json:"ticker_mention"`
 	SearchQuery   string    `gorm:"column:search_query" json:"search_query,omitempty"`
+	CommunityID   string    `gorm:"column:community_id;index" json:"community_id,omitempty"`
 }
 
 func (TweetModel) TableName() string {
@@ -26,9 +27,23 @@ func (TweetModel) TableName() string {
 
 type UserModel struct {
 	gorm.Model
-	ID               string     `gorm:"primaryKey;column:id" json:"id"`
-	Username         string     `gorm:"column:username;uniqueIndex" json:"username"`
-	Name             string     `gorm:"column:name" json:"name"`
+	ID        string    `gorm:"primaryKey;column:id" json:"id"`
+	Username  string    `gorm:"column:username;uniqueIndex" json:"username"`
+	Name      string    `gorm:"column:name" json:"name"`
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (UserModel) TableName() string {
+	return "users"
+}
+
+// UserCommunityStatusModel is the analysis status of a user in one community, a
+// user can be FUD in one community and clean in another.
+type UserCommunityStatusModel struct {
+	gorm.Model
+	UserID           string     `gorm:"column:user_id;uniqueIndex:idx_user_community_statuses_user_community" json:"user_id"`
+	CommunityID      string     `gorm:"column:community_id;uniqueIndex:idx_user_community_statuses_user_community" json:"community_id"`
 	IsFUD            bool       `gorm:"column:is_fud;default:false" json:"is_fud"`
 	FUDType          string     `gorm:"column:fud_type" json:"fud_type,omitempty"`
 	FUDProbability   float64    `gorm:"column:fud_probability;default:0" json:"fud_probability"`
@@ -38,18 +53,33 @@ type UserModel struct {
 	LastMessageID    string     `gorm:"column:last_message_id" json:"last_message_id,omitempty"`
 	AnalysisCount    int        `gorm:"column:analysis_count;default:0" json:"analysis_count"`
 	FUDMessageCount  int        `gorm:"column:fud_message_count;default:0" json:"fud_message_count"`
-	CreatedAt        time.Time  `gorm:"column:created_at" json:"created_at"`
-	UpdatedAt        time.Time  `gorm:"column:updated_at" json:"updated_at"`
 }
 
-func (UserModel) TableName() string {
-	return "users"
+func (UserCommunityStatusModel) TableName() string {
+	return "user_community_statuses"
+}
+
+type CommunityModel struct {
+	gorm.Model
+	ID                   string  `gorm:"primaryKey;column:id" json:"id"`
+	Name                 string  `gorm:"column:name" json:"name"`
+	Ticker               string  `gorm:"column:ticker" json:"ticker"`
+	FirstStepPromptFile  string  `gorm:"column:first_step_prompt_file" json:"first_step_prompt_file,omitempty"`
+	SecondStepPromptFile string  `gorm:"column:second_step_prompt_file" json:"second_step_prompt_file,omitempty"`
+	TelegramChatIDs      string  `gorm:"column:telegram_chat_ids" json:"telegram_chat_ids,omitempty"`
+	AlertThreshold       float64 `gorm:"column:alert_threshold;default:0" json:"alert_threshold"`
+	Enabled              bool    `gorm:"column:enabled;default:true" json:"enabled"`
+}
+
+func (CommunityModel) TableName() string {
+	return "communities"
 }
 
 type FUDUserModel struct {
 	gorm.Model
 	ID             uint      `gorm:"primaryKey;autoIncrement" json:"id"`
-	UserID         string    `gorm:"column:user_id;uniqueIndex" json:"user_id"`
+	UserID         string    `gorm:"column:user_id;uniqueIndex:idx_fud_users_user_community" json:"user_id"`
+	CommunityID    string    `gorm:"column:community_id;uniqueIndex:idx_fud_users_user_community" json:"community_id"`
 	Username       string    `gorm:"column:username" json:"username"`
 	FUDType        string    `gorm:"column:fud_type" json:"fud_type"`
 	FUDProbability float64   `gorm:"column:fud_probability" json:"fud_probability"`
@@ -64,19 +94,161 @@ func (FUDUserModel) TableName() string {
 	return "fud_users"
 }
 
+// FUDReviewModel is the human review of the FUD verdicts of the model for a
+// user. The user stays pending_review until a reviewer confirms or rejects it,
+// an escalated review is decided from an admin chat.
+type FUDReviewModel struct {
+	ID             uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID         string     `gorm:"column:user_id;index:idx_fud_reviews_user_community" json:"user_id"`
+	CommunityID    string     `gorm:"column:community_id;index:idx_fud_reviews_user_community" json:"community_id"`
+	Username       string     `gorm:"column:username" json:"username"`
+	TweetID        string     `gorm:"column:tweet_id" json:"tweet_id"`
+	FUDType        string     `gorm:"column:fud_type" json:"fud_type"`
+	FUDProbability float64    `gorm:"column:fud_probability" json:"fud_probability"`
+	Verdict        string     `gorm:"column:verdict;default:fud" json:"verdict"`
+	AlertCount     int        `gorm:"column:alert_count;default:1" json:"alert_count"`
+	Status         string     `gorm:"column:status;index" json:"status"`
+	ReviewerID     int64      `gorm:"column:reviewer_id" json:"reviewer_id,omitempty"`
+	ReviewerName   string     `gorm:"column:reviewer_name" json:"reviewer_name,omitempty"`
+	ReviewedAt     *time.Time `gorm:"column:reviewed_at" json:"reviewed_at,omitempty"`
+	EscalatedBy    string     `gorm:"column:escalated_by" json:"escalated_by,omitempty"`
+	EscalatedAt    *time.Time `gorm:"column:escalated_at" json:"escalated_at,omitempty"`
+	PromptVersion  string     `gorm:"column:prompt_version;index" json:"prompt_version,omitempty"`
+	CreatedAt      time.Time  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (FUDReviewModel) TableName() string {
+	return "fud_reviews"
+}
+
+// The verdict of the model a review approves or rejects. A clean verdict is
+// reviewed when the user was confirmed as FUD already.
+const (
+	REVIEW_VERDICT_FUD   = "fud"
+	REVIEW_VERDICT_CLEAN = "clean"
+)
+
+const (
+	REVIEW_STATUS_PENDING   = "pending_review"
+	REVIEW_STATUS_ESCALATED = "escalated"
+	REVIEW_STATUS_CONFIRMED = "confirmed"
+	REVIEW_STATUS_REJECTED  = "rejected"
+)
+
+// UserStatusHistoryModel is one transition of the status of a user in a
+// community, with what caused it. Rows are only appended.
+type UserStatusHistoryModel struct {
+	ID             uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID         string    `gorm:"column:user_id;index:idx_user_status_history_user_community" json:"user_id"`
+	CommunityID    string    `gorm:"column:community_id;index:idx_user_status_history_user_community" json:"community_id"`
+	Username       string    `gorm:"column:username" json:"username"`
+	OldStatus      string    `gorm:"column:old_status" json:"old_status"`
+	NewStatus      string    `gorm:"column:new_status" json:"new_status"`
+	IsFUD          bool      `gorm:"column:is_fud" json:"is_fud"`
+	FUDType        string    `gorm:"column:fud_type" json:"fud_type,omitempty"`
+	FUDProbability float64   `gorm:"column:fud_probability" json:"fud_probability"`
+	RiskLevel      string    `gorm:"column:risk_level" json:"risk_level,omitempty"`
+	Source         string    `gorm:"column:source;index" json:"source"`
+	TweetID        string    `gorm:"column:tweet_id" json:"tweet_id,omitempty"`
+	RequestUUID    string    `gorm:"column:request_uuid" json:"request_uuid,omitempty"`
+	Actor          string    `gorm:"column:actor" json:"actor,omitempty"`
+	Note           string    `gorm:"column:note" json:"note,omitempty"`
+	PromptVersion  string    `gorm:"column:prompt_version" json:"prompt_version,omitempty"`
+	CreatedAt      time.Time `gorm:"column:created_at;index" json:"created_at"`
+}
+
+func (UserStatusHistoryModel) TableName() string {
+	return "user_status_history"
+}
+
+const (
+	STATUS_SOURCE_FIRST_STEP  = "first_step"
+	STATUS_SOURCE_SECOND_STEP = "second_step"
+	STATUS_SOURCE_CACHE       = "cache"
+	STATUS_SOURCE_MANUAL      = "manual"
+	STATUS_SOURCE_BATCH       = "batch"
+	STATUS_SOURCE_REVIEW      = "review"
+	STATUS_SOURCE_OVERRIDE    = "override"
+	STATUS_SOURCE_ADMIN       = "admin"
+	STATUS_SOURCE_SYSTEM      = "system"
+)
+
+// UserOverrideModel is a verdict set by an admin for a user of a community,
+// consulted before any AI call. Allowlisted users are never analyzed nor
+// reported, denylisted users are reported on every message without AI, and a
+// pinned status is kept whatever the model decides.
+type UserOverrideModel struct {
+	ID           uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CommunityID  string    `gorm:"column:community_id;uniqueIndex:idx_user_overrides_community_username" json:"community_id"`
+	Username     string    `gorm:"column:username;uniqueIndex:idx_user_overrides_community_username" json:"username"`
+	UserID       string    `gorm:"column:user_id;index" json:"user_id"`
+	Kind         string    `gorm:"column:kind" json:"kind"`
+	PinnedStatus string    `gorm:"column:pinned_status" json:"pinned_status,omitempty"`
+	Reason       string    `gorm:"column:reason" json:"reason"`
+	AddedBy      string    `gorm:"column:added_by" json:"added_by"`
+	CreatedAt    time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt    time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (UserOverrideModel) TableName() string {
+	return "user_overrides"
+}
+
+const (
+	OVERRIDE_KIND_ALLOW = "allow"
+	OVERRIDE_KIND_DENY  = "deny"
+	OVERRIDE_KIND_PIN   = "pin"
+)
+
+// PromptSelectionModel is the version of a prompt used by the analyses, with an
+// optional A/B candidate getting CandidateShare percent of the users.
+type PromptSelectionModel struct {
+	Name             string    `gorm:"column:name;primaryKey" json:"name"`
+	ActiveVersion    string    `gorm:"column:active_version" json:"active_version"`
+	CandidateVersion string    `gorm:"column:candidate_version" json:"candidate_version,omitempty"`
+	CandidateShare   int       `gorm:"column:candidate_share" json:"candidate_share"`
+	UpdatedBy        string    `gorm:"column:updated_by" json:"updated_by"`
+	UpdatedAt        time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (PromptSelectionModel) TableName() string {
+	return "prompt_selections"
+}
+
+// UserRelationModel is one follower/following edge. Edges are kept as history:
+// a refresh moves LastSeenAt and an edge missing from a complete refresh gets
+// EndedAt, following again starts a new edge.
 type UserRelationModel struct {
 	gorm.Model
-	UserID        string    `gorm:"column:user_id;index" json:"user_id"`
-	RelatedUserID string    `gorm:"column:related_user_id;index" json:"related_user_id"`
-	RelationType  string    `gorm:"column:relation_type;index" json:"relation_type"`
-	CreatedAt     time.Time `gorm:"column:created_at" json:"created_at"`
-	UpdatedAt     time.Time `gorm:"column:updated_at" json:"updated_at"`
+	UserID        string     `gorm:"column:user_id;index" json:"user_id"`
+	RelatedUserID string     `gorm:"column:related_user_id;index" json:"related_user_id"`
+	RelationType  string     `gorm:"column:relation_type;index" json:"relation_type"`
+	FirstSeenAt   time.Time  `gorm:"column:first_seen_at" json:"first_seen_at"`
+	LastSeenAt    time.Time  `gorm:"column:last_seen_at" json:"last_seen_at"`
+	EndedAt       *time.Time `gorm:"column:ended_at;index" json:"ended_at,omitempty"`
+	CreatedAt     time.Time  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt     time.Time  `gorm:"column:updated_at" json:"updated_at"`
 }
 
 func (UserRelationModel) TableName() string {
 	return "user_relations"
 }
 
+// UserRelationSyncModel is the last refresh of the followers or followings of a
+// user. Complete is false when the refresh stopped at the page cap.
+type UserRelationSyncModel struct {
+	UserID       string    `gorm:"primaryKey;column:user_id" json:"user_id"`
+	RelationType string    `gorm:"primaryKey;column:relation_type" json:"relation_type"`
+	SyncedAt     time.Time `gorm:"column:synced_at" json:"synced_at"`
+	Count        int       `gorm:"column:count" json:"count"`
+	Complete     bool      `gorm:"column:complete" json:"complete"`
+}
+
+func (UserRelationSyncModel) TableName() string {
+	return "user_relation_syncs"
+}
+
 type UserCommunityActivity struct {
 	UserID       string        `json:"user_id"`
 	ThreadGroups []ThreadGroup `json:"thread_groups"`
@@ -144,9 +316,113 @@ const (
 	ANALYSIS_STEP_COMPLETED          = "completed"
 )
 
+type AnalysisBatchModel struct {
+	gorm.Model
+	ID             string     `gorm:"primaryKey;column:id" json:"id"`
+	Source         string     `gorm:"column:source" json:"source"`
+	Status         string     `gorm:"column:status;index" json:"status"`
+	TelegramChatID int64      `gorm:"column:telegram_chat_id" json:"telegram_chat_id"`
+	RequestCount   int        `gorm:"column:request_count" json:"request_count"`
+	Succeeded      int        `gorm:"column:succeeded" json:"succeeded"`
+	Errored        int        `gorm:"column:errored" json:"errored"`
+	Applied        int        `gorm:"column:applied" json:"applied"`
+	Requeued       int        `gorm:"column:requeued" json:"requeued"`
+	SubmittedAt    time.Time  `gorm:"column:submitted_at" json:"submitted_at"`
+	EndedAt        *time.Time `gorm:"column:ended_at" json:"ended_at,omitempty"`
+	CreatedAt      time.Time  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (AnalysisBatchModel) TableName() string {
+	return "analysis_batches"
+}
+
+type AnalysisBatchItemModel struct {
+	gorm.Model
+	BatchID  string `gorm:"column:batch_id;uniqueIndex:idx_analysis_batch_items_custom_id" json:"batch_id"`
+	CustomID string `gorm:"column:custom_id;uniqueIndex:idx_analysis_batch_items_custom_id" json:"custom_id"`
+	UserID   string `gorm:"column:user_id;index" json:"user_id"`
+	Username string `gorm:"column:username" json:"username"`
+	TaskID   string `gorm:"column:task_id" json:"task_id"`
+	TweetID  string `gorm:"column:tweet_id" json:"tweet_id"`
+	Text     string `gorm:"column:text" json:"text"`
+	Status   string `gorm:"column:status;index" json:"status"`
+	Error    string `gorm:"column:error" json:"error,omitempty"`
+	// the community the user was analyzed for, empty for the default community
+	CommunityID string `gorm:"column:community_id" json:"community_id,omitempty"`
+	// the second step prompt the request was submitted with
+	PromptVersion string `gorm:"column:prompt_version" json:"prompt_version,omitempty"`
+}
+
+func (AnalysisBatchItemModel) TableName() string {
+	return "analysis_batch_items"
+}
+
+const (
+	BATCH_STATUS_SUBMITTED = "submitted"
+	BATCH_STATUS_ENDED     = "ended"
+	BATCH_STATUS_APPLIED   = "applied"
+)
+
+const (
+	BATCH_ITEM_STATUS_PENDING  = "pending"
+	BATCH_ITEM_STATUS_APPLIED  = "applied"
+	BATCH_ITEM_STATUS_REQUEUED = "requeued"
+)
+
+type AnalysisJobModel struct {
+	gorm.Model
+	Stage       string     `gorm:"column:stage;index:idx_analysis_jobs_stage_status" json:"stage"`
+	Status      string     `gorm:"column:status;index:idx_analysis_jobs_stage_status" json:"status"`
+	UserID      string     `gorm:"column:user_id;index" json:"user_id"`
+	Username    string     `gorm:"column:username" json:"username"`
+	TweetID     string     `gorm:"column:tweet_id" json:"tweet_id"`
+	Payload     string     `gorm:"column:payload;type:text" json:"payload"`
+	Attempts    int        `gorm:"column:attempts" json:"attempts"`
+	AvailableAt time.Time  `gorm:"column:available_at;index" json:"available_at"`
+	LeaseUntil  *time.Time `gorm:"column:lease_until" json:"lease_until,omitempty"`
+	LastError   string     `gorm:"column:last_error" json:"last_error,omitempty"`
+}
+
+func (AnalysisJobModel) TableName() string {
+	return "analysis_jobs"
+}
+
+const (
+	JOB_STAGE_FIRST_STEP  = "first_step"
+	JOB_STAGE_SECOND_STEP = "second_step"
+)
+
+const (
+	JOB_STATUS_PENDING = "pending"
+	JOB_STATUS_LEASED  = "leased"
+	JOB_STATUS_DONE    = "done"
+	JOB_STATUS_DEAD    = "dead"
+	// held back by the AI budget until ReleaseDeferred
+	JOB_STATUS_DEFERRED = "deferred"
+)
+
+// ReverseSessionModel is one x.com session of the reverse API pool. The
+// credentials are encrypted with reverse_session_key, see ReverseSessionService.
+type ReverseSessionModel struct {
+	gorm.Model
+	ID              string     `gorm:"primaryKey;column:id" json:"id"`
+	Label           string     `gorm:"column:label;uniqueIndex" json:"label"`
+	AuthCiphertext  string     `gorm:"column:auth_ciphertext;type:text" json:"-"`
+	Status          string     `gorm:"column:status" json:"status"`
+	CoolingUntil    *time.Time `gorm:"column:cooling_until" json:"cooling_until,omitempty"`
+	LastThrottledAt *time.Time `gorm:"column:last_throttled_at" json:"last_throttled_at,omitempty"`
+	LastError       string     `gorm:"column:last_error" json:"last_error,omitempty"`
+}
+
+func (ReverseSessionModel) TableName() string {
+	return "reverse_sessions"
+}
+
 type CachedAnalysisModel struct {
 	gorm.Model
-	UserID         string    `gorm:"column:user_id;uniqueIndex" json:"user_id"`
+	UserID         string    `gorm:"column:user_id;uniqueIndex:idx_cached_analysis_user_community" json:"user_id"`
+	CommunityID    string    `gorm:"column:community_id;uniqueIndex:idx_cached_analysis_user_community" json:"community_id"`
 	Username       string    `gorm:"column:username;index" json:"username"`
 	IsFUDUser      bool      `gorm:"column:is_fud_user" json:"is_fud_user"`
 	FUDType        string    `gorm:"column:fud_type" json:"fud_type"`
@@ -191,4 +467,6 @@ const (
 	USER_STATUS_CLEAN         = "clean"
 	USER_STATUS_FUD_CONFIRMED = "fud_confirmed"
 	USER_STATUS_ANALYZING     = "analyzing"
+	// the model flagged the user, a reviewer has not confirmed it yet
+	USER_STATUS_PENDING_REVIEW = "pending_review"
 )
@@ -9,11 +9,30 @@ import (
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
+// DatabaseService stores the bot data. Tweets, FUD users, cached analyses and
+// user statuses are scoped by community, ForCommunity returns a view of the same
+// database for another community.
 type DatabaseService struct {
-	db *gorm.DB
+	db          *gorm.DB
+	communityID string
+	change      StatusChange
+}
+
+// StatusChange is what causes the status updates made through a service, it is
+// stored with every transition in the status history.
+type StatusChange struct {
+	Source      string
+	TweetID     string
+	RequestUUID string
+	RiskLevel   string
+	Actor       string
+	Note        string
+	// the prompt which produced the verdict, see Prompt.Label
+	PromptVersion string
 }
 
 func NewDatabaseService(dbPath string) (*DatabaseService, error) {
@@ -36,11 +55,105 @@ func NewDatabaseService(dbPath string) (*DatabaseService, error) {
 }
 
 func (s *DatabaseService) runMigrations() error {
-	return s.db.AutoMigrate(&TweetModel{}, &UserModel{}, &FUDUserModel{}, &UserRelationModel{}, &AnalysisTaskModel{}, &CachedAnalysisModel{}, &UserTickerOpinionModel{})
+	// FUD users and cached analyses were unique per user before communities
+	for _, index := range []struct {
+		model interface{}
+		name  string
+	}{{&FUDUserModel{}, "idx_fud_users_user_id"}, {&CachedAnalysisModel{}, "idx_cached_analysis_user_id"}} {
+		if s.db.Migrator().HasIndex(index.model, index.name) {
+			if err := s.db.Migrator().DropIndex(index.model, index.name); err != nil {
+				return err
+			}
+		}
+	}
+	err := s.db.AutoMigrate(&TweetModel{}, &UserModel{}, &UserCommunityStatusModel{}, &CommunityModel{}, &FUDUserModel{}, &UserRelationModel{}, &UserRelationSyncModel{}, &AnalysisTaskModel{}, &CachedAnalysisModel{}, &UserTickerOpinionModel{}, &AnalysisBatchModel{}, &AnalysisBatchItemModel{}, &AnalysisJobModel{}, &ReverseSessionModel{}, &FUDReviewModel{}, &UserOverrideModel{}, &UserStatusHistoryModel{}, &PromptSelectionModel{})
+	if err != nil {
+		return err
+	}
+	// relations were replaced on every save before they had a history
+	return s.db.Exec("UPDATE user_relations SET first_seen_at = created_at, last_seen_at = updated_at WHERE first_seen_at IS NULL").Error
+}
+
+// ForCommunity returns a view of the database scoped to the community, an empty
+// id keeps the default community. Views share the connection, only the root
+// service should be closed.
+func (s *DatabaseService) ForCommunity(communityID string) *DatabaseService {
+	if communityID == "" || communityID == s.communityID {
+		return s
+	}
+	return &DatabaseService{db: s.db, communityID: communityID, change: s.change}
+}
+
+// WithStatusChange returns the service recording change as the cause of its
+// status updates.
+func (s *DatabaseService) WithStatusChange(change StatusChange) *DatabaseService {
+	return &DatabaseService{db: s.db, communityID: s.communityID, change: change}
+}
+
+func (s *DatabaseService) CommunityID() string {
+	return s.communityID
+}
+
+// SetDefaultCommunity scopes the service to the default community and moves the
+// data stored before communities existed into it.
+func (s *DatabaseService) SetDefaultCommunity(communityID string) error {
+	s.communityID = communityID
+	if communityID == "" {
+		return nil
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for _, table := range []string{"tweets", "fud_users", "cached_analysis"} {
+			if err := tx.Exec("UPDATE "+table+" SET community_id = ? WHERE community_id = '' OR community_id IS NULL", communityID).Error; err != nil {
+				return fmt.Errorf("move %s to community %s: %w", table, communityID, err)
+			}
+		}
+		if !tx.Migrator().HasColumn(&UserModel{}, "status") {
+			return nil
+		}
+		// statuses were stored on the users table before communities
+		err := tx.Exec(`
+			INSERT INTO user_community_statuses (user_id, community_id, is_fud, fud_type, fud_probability, is_detail_analyzed, status, last_analyzed_at, last_message_id, analysis_count, fud_message_count, created_at, updated_at)
+			SELECT u.id, ?, u.is_fud, u.fud_type, u.fud_probability, u.is_detail_analyzed, u.status, u.last_analyzed_at, u.last_message_id, u.analysis_count, u.fud_message_count, u.created_at, ?
+			FROM users u
+			WHERE (u.status != ? OR u.is_detail_analyzed OR u.is_fud)
+			AND NOT EXISTS (SELECT 1 FROM user_community_statuses s WHERE s.user_id = u.id AND s.community_id = ?)`,
+			communityID, time.Now(), USER_STATUS_UNKNOWN, communityID).Error
+		if err != nil {
+			return fmt.Errorf("move user statuses to community %s: %w", communityID, err)
+		}
+		return tx.Exec("UPDATE users SET status = ?, is_fud = 0, is_detail_analyzed = 0", USER_STATUS_UNKNOWN).Error
+	})
+}
+
+func (s *DatabaseService) SaveCommunity(community CommunityModel) error {
+	community.UpdatedAt = time.Now()
+	return s.db.Save(&community).Error
+}
+
+func (s *DatabaseService) GetCommunity(id string) (*CommunityModel, error) {
+	var community CommunityModel
+	err := s.db.Where("id = ?", id).First(&community).Error
+	if err != nil {
+		return nil, err
+	}
+	return &community, nil
+}
+
+func (s *DatabaseService) GetCommunities(onlyEnabled bool) ([]CommunityModel, error) {
+	var communities []CommunityModel
+	query := s.db.Order("created_at ASC")
+	if onlyEnabled {
+		query = query.Where("enabled = ?", true)
+	}
+	err := query.Find(&communities).Error
+	return communities, err
 }
 
 func (s *DatabaseService) SaveTweet(tweet TweetModel) error {
 	tweet.UpdatedAt = time.Now()
+	if tweet.CommunityID == "" {
+		tweet.CommunityID = s.communityID
+	}
 	return s.db.Save(&tweet).Error
 }
 
@@ -81,6 +194,23 @@ func (s *DatabaseService) UpdateTweetReplyCount(id string, replyCount int) error
 	return s.db.Model(&TweetModel{}).Where("id = ?", id).Update("reply_count", replyCount).Error
 }
 
+// GetMonitoringBaseline returns the reply count of every community tweet already
+// seen by the monitoring of the community, keyed by tweet ID.
+func (s *DatabaseService) GetMonitoringBaseline() (map[string]int, error) {
+	var tweets []TweetModel
+	err := s.db.Select("id", "reply_count").
+		Where("community_id = ? AND source_type = ?", s.communityID, TWEET_SOURCE_COMMUNITY).
+		Find(&tweets).Error
+	if err != nil {
+		return nil, err
+	}
+	baseline := make(map[string]int, len(tweets))
+	for _, tweet := range tweets {
+		baseline[tweet.ID] = tweet.ReplyCount
+	}
+	return baseline, nil
+}
+
 func (s *DatabaseService) GetTweetsByUser(userID string) ([]TweetModel, error) {
 	var tweets []TweetModel
 	err := s.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&tweets).Error
@@ -138,12 +268,15 @@ func (s *DatabaseService) DeleteUser(id string) error {
 
 func (s *DatabaseService) SaveFUDUser(fudUser FUDUserModel) error {
 	fudUser.UpdatedAt = time.Now()
+	if fudUser.CommunityID == "" {
+		fudUser.CommunityID = s.communityID
+	}
 	return s.db.Save(&fudUser).Error
 }
 
 func (s *DatabaseService) GetFUDUser(userID string) (*FUDUserModel, error) {
 	var fudUser FUDUserModel
-	err := s.db.Where("user_id = ?", userID).First(&fudUser).Error
+	err := s.db.Where("user_id = ? AND community_id = ?", userID, s.communityID).First(&fudUser).Error
 	if err != nil {
 		return nil, err
 	}
@@ -152,18 +285,18 @@ func (s *DatabaseService) GetFUDUser(userID string) (*FUDUserModel, error) {
 
 func (s *DatabaseService) IsFUDUser(userID string) bool {
 	var count int64
-	s.db.Model(&FUDUserModel{}).Where("user_id = ?", userID).Count(&count)
+	s.db.Model(&FUDUserModel{}).Where("user_id = ? AND community_id = ?", userID, s.communityID).Count(&count)
 	return count > 0
 }
 
 func (s *DatabaseService) GetAllFUDUsers() ([]FUDUserModel, error) {
 	var fudUsers []FUDUserModel
-	err := s.db.Order("detected_at DESC").Find(&fudUsers).Error
+	err := s.db.Where("community_id = ?", s.communityID).Order("detected_at DESC").Find(&fudUsers).Error
 	return fudUsers, err
 }
 
 func (s *DatabaseService) IncrementFUDUserMessageCount(userID string, messageID string) error {
-	return s.db.Model(&FUDUserModel{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+	return s.db.Model(&FUDUserModel{}).Where("user_id = ? AND community_id = ?", userID, s.communityID).Updates(map[string]interface{}{
 		"message_count":   gorm.Expr("message_count + 1"),
 		"last_message_id": messageID,
 		"updated_at":      time.Now(),
@@ -171,15 +304,350 @@ func (s *DatabaseService) IncrementFUDUserMessageCount(userID string, messageID
 }
 
 func (s *DatabaseService) DeleteFUDUser(userID string) error {
-	return s.db.Delete(&FUDUserModel{}, "user_id = ?", userID).Error
+	// removed for good so the user can be listed again, the status history
+	// keeps the record
+	result := s.db.Unscoped().Delete(&FUDUserModel{}, "user_id = ? AND community_id = ?", userID, s.communityID)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+	// a removal the status does not show is recorded on its own
+	status, err := s.GetUserCommunityStatus(userID)
+	if err != nil || status.Status != USER_STATUS_FUD_CONFIRMED {
+		return nil
+	}
+	return s.recordStatusTransition(*status, *status, "removed from the FUD list")
 }
 
 func (s *DatabaseService) UpdateUserFUDStatus(userID string, isFUD bool, fudType string) error {
-	return s.db.Model(&UserModel{}).Where("id = ?", userID).Updates(map[string]interface{}{
-		"is_fud":     isFUD,
-		"fud_type":   fudType,
-		"updated_at": time.Now(),
-	}).Error
+	return s.updateUserStatus(userID, map[string]interface{}{
+		"is_fud":   isFUD,
+		"fud_type": fudType,
+	})
+}
+
+var openReviewStatuses = []string{REVIEW_STATUS_PENDING, REVIEW_STATUS_ESCALATED}
+
+func (r *FUDReviewModel) IsOpen() bool {
+	return r.Status == REVIEW_STATUS_PENDING || r.Status == REVIEW_STATUS_ESCALATED
+}
+
+// OpenFUDReview records a FUD verdict of the model for review. A user has one
+// open review of each verdict per community, a new verdict updates it.
+func (s *DatabaseService) OpenFUDReview(userID, username, tweetID, fudType string, probability float64) (*FUDReviewModel, error) {
+	return s.openReview(REVIEW_VERDICT_FUD, userID, username, tweetID, fudType, probability)
+}
+
+// OpenCleanReview records a clean verdict of the model on a confirmed FUD
+// user, the user stays on the FUD list unless a reviewer approves it.
+func (s *DatabaseService) OpenCleanReview(userID, username, tweetID string, probability float64) (*FUDReviewModel, error) {
+	return s.openReview(REVIEW_VERDICT_CLEAN, userID, username, tweetID, "", probability)
+}
+
+func (s *DatabaseService) openReview(verdict, userID, username, tweetID, fudType string, probability float64) (*FUDReviewModel, error) {
+	var review FUDReviewModel
+	err := s.db.Where("user_id = ? AND community_id = ? AND verdict = ? AND status IN ?", userID, s.communityID, verdict, openReviewStatuses).Order("id DESC").First(&review).Error
+	if err == gorm.ErrRecordNotFound {
+		review = FUDReviewModel{
+			UserID:         userID,
+			CommunityID:    s.communityID,
+			Username:       username,
+			TweetID:        tweetID,
+			FUDType:        fudType,
+			FUDProbability: probability,
+			Verdict:        verdict,
+			AlertCount:     1,
+			Status:         REVIEW_STATUS_PENDING,
+			PromptVersion:  s.change.PromptVersion,
+		}
+		return &review, s.db.Create(&review).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+	review.Username = username
+	review.TweetID = tweetID
+	review.FUDType = fudType
+	review.FUDProbability = probability
+	if s.change.PromptVersion != "" {
+		review.PromptVersion = s.change.PromptVersion
+	}
+	review.AlertCount++
+	return &review, s.db.Save(&review).Error
+}
+
+func (s *DatabaseService) GetFUDReview(id uint) (*FUDReviewModel, error) {
+	var review FUDReviewModel
+	err := s.db.Where("id = ?", id).First(&review).Error
+	if err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+// GetOpenFUDReviews returns the reviews waiting for a decision, oldest first.
+func (s *DatabaseService) GetOpenFUDReviews(limit int) ([]FUDReviewModel, error) {
+	var reviews []FUDReviewModel
+	err := s.db.Where("community_id = ? AND status IN ?", s.communityID, openReviewStatuses).Order("id ASC").Limit(limit).Find(&reviews).Error
+	return reviews, err
+}
+
+// ResolveFUDReview stores the decision of a reviewer. Confirming a FUD verdict
+// or rejecting a clean one makes the user a known FUD user of the community of
+// the review, the other decisions clear the user. Escalating leaves the review
+// open for the admins.
+func (s *DatabaseService) ResolveFUDReview(id uint, status string, reviewerID int64, reviewerName string) (*FUDReviewModel, error) {
+	review, err := s.GetFUDReview(id)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		updates := map[string]interface{}{"status": status, "updated_at": now}
+		if status == REVIEW_STATUS_ESCALATED {
+			updates["escalated_by"] = reviewerName
+			updates["escalated_at"] = &now
+		} else {
+			updates["reviewer_id"] = reviewerID
+			updates["reviewer_name"] = reviewerName
+			updates["reviewed_at"] = &now
+		}
+		// two reviewers can press a button of the same alert at once
+		result := tx.Model(&FUDReviewModel{}).Where("id = ? AND status IN ?", id, openReviewStatuses).Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("review #%d is already closed", id)
+		}
+
+		scoped := &DatabaseService{db: tx, communityID: review.CommunityID, change: StatusChange{
+			Source:  STATUS_SOURCE_REVIEW,
+			TweetID: review.TweetID,
+			Actor:   reviewerName,
+			Note:    fmt.Sprintf("review #%d %s", review.ID, status),
+		}}
+		if status != REVIEW_STATUS_ESCALATED && status != REVIEW_STATUS_CONFIRMED && status != REVIEW_STATUS_REJECTED {
+			return fmt.Errorf("unknown review status %s", status)
+		}
+		isFUD := (status == REVIEW_STATUS_CONFIRMED) == (review.Verdict != REVIEW_VERDICT_CLEAN)
+		switch {
+		case status == REVIEW_STATUS_ESCALATED:
+			return nil
+		case isFUD && review.Verdict == REVIEW_VERDICT_CLEAN:
+			// the user stays on the FUD list with the type confirmed before
+			return scoped.updateUserStatus(review.UserID, map[string]interface{}{
+				"status": USER_STATUS_FUD_CONFIRMED,
+				"is_fud": true,
+			})
+		case isFUD:
+			err := scoped.updateUserStatus(review.UserID, map[string]interface{}{
+				"status":          USER_STATUS_FUD_CONFIRMED,
+				"is_fud":          true,
+				"fud_type":        review.FUDType,
+				"fud_probability": review.FUDProbability,
+			})
+			if err != nil || scoped.IsFUDUser(review.UserID) {
+				return err
+			}
+			return scoped.SaveFUDUser(FUDUserModel{
+				UserID:         review.UserID,
+				Username:       review.Username,
+				FUDType:        review.FUDType,
+				FUDProbability: review.FUDProbability,
+				DetectedAt:     review.CreatedAt,
+				MessageCount:   review.AlertCount,
+				LastMessageID:  review.TweetID,
+			})
+		default:
+			err := scoped.updateUserStatus(review.UserID, map[string]interface{}{
+				"status":          USER_STATUS_CLEAN,
+				"is_fud":          false,
+				"fud_type":        "",
+				"fud_probability": 0,
+			})
+			if err != nil {
+				return err
+			}
+			return scoped.DeleteFUDUser(review.UserID)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetFUDReview(id)
+}
+
+// IsFUD reports whether the override keeps the user a confirmed FUD user.
+func (o *UserOverrideModel) IsFUD() bool {
+	return o.Kind == OVERRIDE_KIND_DENY || (o.Kind == OVERRIDE_KIND_PIN && o.PinnedStatus == USER_STATUS_FUD_CONFIRMED)
+}
+
+func (o *UserOverrideModel) Label() string {
+	switch {
+	case o.Kind == OVERRIDE_KIND_ALLOW:
+		return "Allowlisted"
+	case o.Kind == OVERRIDE_KIND_DENY:
+		return "Denylisted"
+	case o.IsFUD():
+		return "Pinned as FUD"
+	default:
+		return "Pinned as clean"
+	}
+}
+
+func (o *UserOverrideModel) FUDType() string {
+	if o.Kind == OVERRIDE_KIND_DENY {
+		return "denylisted"
+	}
+	return "pinned_fud"
+}
+
+// Decision returns the verdict of the override in place of the one of the
+// model.
+func (o *UserOverrideModel) Decision() SecondStepClaudeResponse {
+	reason := fmt.Sprintf("%s by %s: %s", o.Label(), o.AddedBy, o.Reason)
+	if !o.IsFUD() {
+		return SecondStepClaudeResponse{UserRiskLevel: "low", DecisionReason: reason, UserSummary: reason}
+	}
+	return SecondStepClaudeResponse{
+		IsFUDUser:      true,
+		FUDProbability: 1,
+		FUDType:        o.FUDType(),
+		UserRiskLevel:  "high",
+		KeyEvidence:    []string{reason},
+		DecisionReason: reason,
+		UserSummary:    reason,
+	}
+}
+
+// SetUserOverride stores the override of a username, replacing the previous
+// one, and applies it to the user when the user is known already.
+func (s *DatabaseService) SetUserOverride(override UserOverrideModel) (*UserOverrideModel, error) {
+	override.CommunityID = s.communityID
+	override.Username = strings.ToLower(strings.TrimPrefix(override.Username, "@"))
+	if override.UserID == "" {
+		if user, err := s.GetUserByUsername(override.Username); err == nil {
+			override.UserID = user.ID
+		}
+	}
+
+	var existing UserOverrideModel
+	err := s.db.Where("community_id = ? AND username = ?", override.CommunityID, override.Username).First(&existing).Error
+	if err == nil {
+		override.ID = existing.ID
+		override.CreatedAt = existing.CreatedAt
+	} else if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	if err := s.db.Save(&override).Error; err != nil {
+		return nil, err
+	}
+	return &override, s.ApplyUserOverride(&override)
+}
+
+// GetUserOverride returns the override of the user matched by id or by
+// username, nil when there is none.
+func (s *DatabaseService) GetUserOverride(userID, username string) *UserOverrideModel {
+	query := s.db.Where("community_id = ?", s.communityID)
+	if userID != "" {
+		query = query.Where("username = ? OR user_id = ?", strings.ToLower(username), userID)
+	} else {
+		query = query.Where("username = ?", strings.ToLower(username))
+	}
+	var override UserOverrideModel
+	if err := query.First(&override).Error; err != nil {
+		return nil
+	}
+	// overrides added before the user was seen learn the id on the first message
+	if override.UserID == "" && userID != "" {
+		override.UserID = userID
+		s.db.Model(&override).Update("user_id", userID)
+	}
+	return &override
+}
+
+func (s *DatabaseService) GetUserOverrides() ([]UserOverrideModel, error) {
+	var overrides []UserOverrideModel
+	err := s.db.Where("community_id = ?", s.communityID).Order("kind ASC, username ASC").Find(&overrides).Error
+	return overrides, err
+}
+
+// DeleteUserOverride removes the override of the username, the user keeps the
+// current status until the next analysis.
+func (s *DatabaseService) DeleteUserOverride(username string) (bool, error) {
+	result := s.db.Where("community_id = ? AND username = ?", s.communityID, strings.ToLower(strings.TrimPrefix(username, "@"))).Delete(&UserOverrideModel{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// ApplyUserOverride sets the status of the user to the verdict of the
+// override, the FUD list follows it and the open reviews of the user are
+// closed with it.
+func (s *DatabaseService) ApplyUserOverride(override *UserOverrideModel) error {
+	if override.UserID == "" {
+		return nil
+	}
+	now := time.Now()
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		change := s.change
+		change.Source = STATUS_SOURCE_OVERRIDE
+		change.Actor = override.AddedBy
+		change.Note = override.Label() + ": " + override.Reason
+		scoped := &DatabaseService{db: tx, communityID: s.communityID, change: change}
+		reviewStatus, cleanReviewStatus := REVIEW_STATUS_REJECTED, REVIEW_STATUS_CONFIRMED
+		if override.IsFUD() {
+			reviewStatus, cleanReviewStatus = REVIEW_STATUS_CONFIRMED, REVIEW_STATUS_REJECTED
+			err := scoped.updateUserStatus(override.UserID, map[string]interface{}{
+				"status":          USER_STATUS_FUD_CONFIRMED,
+				"is_fud":          true,
+				"fud_type":        override.FUDType(),
+				"fud_probability": 1,
+			})
+			if err != nil {
+				return err
+			}
+			if !scoped.IsFUDUser(override.UserID) {
+				err = scoped.SaveFUDUser(FUDUserModel{
+					UserID:         override.UserID,
+					Username:       override.Username,
+					FUDType:        override.FUDType(),
+					FUDProbability: 1,
+					DetectedAt:     now,
+				})
+				if err != nil {
+					return err
+				}
+			}
+		} else {
+			err := scoped.updateUserStatus(override.UserID, map[string]interface{}{
+				"status":          USER_STATUS_CLEAN,
+				"is_fud":          false,
+				"fud_type":        "",
+				"fud_probability": 0,
+			})
+			if err != nil {
+				return err
+			}
+			if err := scoped.DeleteFUDUser(override.UserID); err != nil {
+				return err
+			}
+		}
+		// an allowlist approves the clean verdicts and rejects the FUD ones, a
+		// FUD override the other way round
+		for verdict, status := range map[string]string{REVIEW_VERDICT_FUD: reviewStatus, REVIEW_VERDICT_CLEAN: cleanReviewStatus} {
+			err := tx.Model(&FUDReviewModel{}).
+				Where("user_id = ? AND community_id = ? AND verdict = ? AND status IN ?", override.UserID, s.communityID, verdict, openReviewStatuses).
+				Updates(map[string]interface{}{
+					"status":        status,
+					"reviewer_name": "override by " + override.AddedBy,
+					"reviewed_at":   &now,
+					"updated_at":    now,
+				}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 func (s *DatabaseService) SearchTweets(query string, limit int) ([]TweetModel, error) {
@@ -200,6 +668,12 @@ func (s *DatabaseService) GetTweetCount() (int64, error) {
 	return count, err
 }
 
+func (s *DatabaseService) GetCommunityTweetCount() (int64, error) {
+	var count int64
+	err := s.db.Model(&TweetModel{}).Where("community_id = ?", s.communityID).Count(&count).Error
+	return count, err
+}
+
 func (s *DatabaseService) GetUserCount() (int64, error) {
 	var count int64
 	err := s.db.Model(&UserModel{}).Count(&count).Error
@@ -208,43 +682,138 @@ func (s *DatabaseService) GetUserCount() (int64, error) {
 
 func (s *DatabaseService) GetFUDUserCount() (int64, error) {
 	var count int64
-	err := s.db.Model(&FUDUserModel{}).Count(&count).Error
+	err := s.db.Model(&FUDUserModel{}).Where("community_id = ?", s.communityID).Count(&count).Error
 	return count, err
 }
 
-func (s *DatabaseService) SaveUserRelations(userID string, relatedUsers []string, relationType string) error {
-	tx := s.db.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
+// SaveUserRelations records a refresh of the followers or followings of the
+// user. Seen edges are kept or started, the open edges missing from the list are
+// ended only when the list is complete.
+func (s *DatabaseService) SaveUserRelations(userID string, relatedUsers []string, relationType string, complete bool) (int, int, error) {
+	now := time.Now()
+	started, ended := 0, 0
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var open []UserRelationModel
+		if err := tx.Where("user_id = ? AND relation_type = ? AND ended_at IS NULL", userID, relationType).Find(&open).Error; err != nil {
+			return err
+		}
+		openByID := make(map[string]UserRelationModel, len(open))
+		for _, relation := range open {
+			openByID[relation.RelatedUserID] = relation
 		}
-	}()
-
-	if err := tx.Where("user_id = ? AND relation_type = ?", userID, relationType).Delete(&UserRelationModel{}).Error; err != nil {
-		tx.Rollback()
-		return err
-	}
 
-	for _, relatedUserID := range relatedUsers {
-		relation := UserRelationModel{
-			UserID:        userID,
-			RelatedUserID: relatedUserID,
-			RelationType:  relationType,
-			CreatedAt:     time.Now(),
-			UpdatedAt:     time.Now(),
+		seen := make(map[string]bool, len(relatedUsers))
+		var seenIDs []uint
+		for _, relatedUserID := range relatedUsers {
+			if seen[relatedUserID] {
+				continue
+			}
+			seen[relatedUserID] = true
+			if relation, ok := openByID[relatedUserID]; ok {
+				seenIDs = append(seenIDs, relation.ID)
+				continue
+			}
+			relation := UserRelationModel{
+				UserID:        userID,
+				RelatedUserID: relatedUserID,
+				RelationType:  relationType,
+				FirstSeenAt:   now,
+				LastSeenAt:    now,
+				CreatedAt:     now,
+				UpdatedAt:     now,
+			}
+			if err := tx.Create(&relation).Error; err != nil {
+				return err
+			}
+			started++
 		}
-		if err := tx.Create(&relation).Error; err != nil {
-			tx.Rollback()
-			return err
+		if len(seenIDs) > 0 {
+			if err := tx.Model(&UserRelationModel{}).Where("id IN ?", seenIDs).Updates(map[string]interface{}{"last_seen_at": now, "updated_at": now}).Error; err != nil {
+				return err
+			}
 		}
-	}
 
-	return tx.Commit().Error
+		if complete {
+			var endedIDs []uint
+			for _, relation := range open {
+				if !seen[relation.RelatedUserID] {
+					endedIDs = append(endedIDs, relation.ID)
+				}
+			}
+			if len(endedIDs) > 0 {
+				if err := tx.Model(&UserRelationModel{}).Where("id IN ?", endedIDs).Updates(map[string]interface{}{"ended_at": now, "updated_at": now}).Error; err != nil {
+					return err
+				}
+			}
+			ended = len(endedIDs)
+		}
+
+		return tx.Save(&UserRelationSyncModel{
+			UserID:       userID,
+			RelationType: relationType,
+			SyncedAt:     now,
+			Count:        len(seen),
+			Complete:     complete,
+		}).Error
+	})
+	return started, ended, err
 }
 
+// GetRelationSync returns the last refresh of the relations, nil when they were
+// never fetched.
+func (s *DatabaseService) GetRelationSync(userID, relationType string) (*UserRelationSyncModel, error) {
+	var relationSync UserRelationSyncModel
+	err := s.db.Where("user_id = ? AND relation_type = ?", userID, relationType).First(&relationSync).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &relationSync, nil
+}
+
+// GetUserRelations returns the current (not ended) relations.
 func (s *DatabaseService) GetUserRelations(userID, relationType string) ([]UserRelationModel, error) {
 	var relations []UserRelationModel
-	err := s.db.Where("user_id = ? AND relation_type = ?", userID, relationType).Find(&relations).Error
+	err := s.db.Where("user_id = ? AND relation_type = ? AND ended_at IS NULL", userID, relationType).Find(&relations).Error
+	return relations, err
+}
+
+// GetRelatedUsernames returns the usernames of the current relations.
+func (s *DatabaseService) GetRelatedUsernames(userID, relationType string) ([]string, error) {
+	var usernames []string
+	err := s.db.Table("user_relations r").
+		Select("u.username").
+		Joins("JOIN users u ON u.id = r.related_user_id").
+		Where("r.user_id = ? AND r.relation_type = ? AND r.ended_at IS NULL AND r.deleted_at IS NULL", userID, relationType).
+		Order("u.username").
+		Pluck("u.username", &usernames).Error
+	return usernames, err
+}
+
+// FUDRelation is an edge, current or ended, between a user and a confirmed FUD
+// user of the community.
+type FUDRelation struct {
+	Username     string     `json:"username"`
+	RelationType string     `json:"relation_type"`
+	FUDType      string     `json:"fud_type,omitempty"`
+	FirstSeenAt  time.Time  `json:"first_seen_at"`
+	LastSeenAt   time.Time  `json:"last_seen_at"`
+	EndedAt      *time.Time `json:"ended_at,omitempty"`
+}
+
+// GetFUDRelationHistory returns the relations of the user with confirmed FUD
+// users, oldest first, to tell when the user joined a FUD cluster.
+func (s *DatabaseService) GetFUDRelationHistory(userID string) ([]FUDRelation, error) {
+	var relations []FUDRelation
+	err := s.db.Table("user_relations r").
+		Select("u.username, r.relation_type, s.fud_type, r.first_seen_at, r.last_seen_at, r.ended_at").
+		Joins("JOIN users u ON u.id = r.related_user_id").
+		Joins("JOIN user_community_statuses s ON s.user_id = r.related_user_id AND s.community_id = ?", s.communityID).
+		Where("r.user_id = ? AND s.status = ? AND r.deleted_at IS NULL", userID, USER_STATUS_FUD_CONFIRMED).
+		Order("r.first_seen_at, u.username").
+		Scan(&relations).Error
 	return relations, err
 }
 
@@ -277,7 +846,7 @@ func (s *DatabaseService) GetUserTickerMentions(userID, ticker string, limit int
 func (s *DatabaseService) GetUserCommunityActivity(userID string) (*UserCommunityActivity, error) {
 
 	var userTweets []TweetModel
-	err := s.db.Where("user_id = ? AND source_type = ?", userID, TWEET_SOURCE_COMMUNITY).
+	err := s.db.Where("user_id = ? AND source_type = ? AND community_id = ?", userID, TWEET_SOURCE_COMMUNITY, s.communityID).
 		Order("created_at DESC").Find(&userTweets).Error
 	if err != nil {
 		return nil, err
@@ -374,13 +943,12 @@ func (s *DatabaseService) findRootPostID(tweetID string) string {
 }
 
 func (s *DatabaseService) IsUserDetailAnalyzed(userID string) bool {
-	var user UserModel
-	err := s.db.Where("id = ?", userID).First(&user).Error
-	return err == nil && user.IsDetailAnalyzed
+	status, err := s.GetUserCommunityStatus(userID)
+	return err == nil && status.IsDetailAnalyzed
 }
 
 func (s *DatabaseService) MarkUserAsDetailAnalyzed(userID string) error {
-	return s.db.Model(&UserModel{}).Where("id = ?", userID).Update("is_detail_analyzed", true).Error
+	return s.updateUserStatus(userID, map[string]interface{}{"is_detail_analyzed": true})
 }
 
 func (s *DatabaseService) GetUserMessagesWithContext(userID string, limit int) ([]TweetModel, error) {
@@ -435,19 +1003,19 @@ func (s *DatabaseService) GetTopActiveUsers(limit int) ([]UserModel, error) {
 	query := `
 		SELECT u.*, COUNT(t.id) as tweet_count 
 		FROM users u 
-		LEFT JOIN tweets t ON u.id = t.user_id 
+		LEFT JOIN tweets t ON u.id = t.user_id AND t.community_id = ?
 		GROUP BY u.id 
 		HAVING tweet_count > 0
 		ORDER BY tweet_count DESC, u.username ASC`
 
 	if limit > 0 {
 		query += " LIMIT ?"
-		err := s.db.Raw(query, limit).Scan(&users).Error
+		err := s.db.Raw(query, s.communityID, limit).Scan(&users).Error
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		err := s.db.Raw(query).Scan(&users).Error
+		err := s.db.Raw(query, s.communityID).Scan(&users).Error
 		if err != nil {
 			return nil, err
 		}
@@ -554,12 +1122,23 @@ func (s *DatabaseService) ClearAllAnalysisFlags() error {
 		}
 	}()
 
+	// the users losing their FUD flag, the statuses are left as they are
+	err := tx.Exec(`INSERT INTO user_status_history (user_id, community_id, username, old_status, new_status, is_fud, fud_type, fud_probability, source, note, created_at)
+		SELECT s.user_id, s.community_id, COALESCE((SELECT u.username FROM users u WHERE u.id = s.user_id), ''), s.status, s.status, false, '', s.fud_probability, ?, ?, ?
+		FROM user_community_statuses s
+		WHERE s.deleted_at IS NULL AND (s.is_fud = true OR EXISTS (SELECT 1 FROM fud_users f WHERE f.user_id = s.user_id AND f.community_id = s.community_id AND f.deleted_at IS NULL))`,
+		STATUS_SOURCE_ADMIN, "analysis flags cleared on startup", time.Now()).Error
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to record cleared flags: %w", err)
+	}
+
 	if err := tx.Exec("DELETE FROM fud_users").Error; err != nil {
 		tx.Rollback()
 		return fmt.Errorf("failed to clear FUD users: %w", err)
 	}
 
-	if err := tx.Model(&UserModel{}).Updates(map[string]interface{}{
+	if err := tx.Model(&UserCommunityStatusModel{}).Where("1 = 1").Updates(map[string]interface{}{
 		"is_fud":             false,
 		"fud_type":           "",
 		"is_detail_analyzed": false,
@@ -585,11 +1164,11 @@ func (s *DatabaseService) GetAnalysisStats() (map[string]interface{}, error) {
 	stats["total_users"] = totalUsers
 
 	var analyzedUsers int64
-	s.db.Model(&UserModel{}).Where("is_detail_analyzed = ?", true).Count(&analyzedUsers)
+	s.db.Model(&UserCommunityStatusModel{}).Where("community_id = ? AND is_detail_analyzed = ?", s.communityID, true).Count(&analyzedUsers)
 	stats["analyzed_users"] = analyzedUsers
 
 	var fudUsers int64
-	s.db.Model(&FUDUserModel{}).Count(&fudUsers)
+	s.db.Model(&FUDUserModel{}).Where("community_id = ?", s.communityID).Count(&fudUsers)
 	stats["fud_users"] = fudUsers
 
 	var runningTasks int64
@@ -599,6 +1178,173 @@ func (s *DatabaseService) GetAnalysisStats() (map[string]interface{}, error) {
 	return stats, nil
 }
 
+func (s *DatabaseService) SaveAnalysisBatch(batch *AnalysisBatchModel, items []AnalysisBatchItemModel) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(batch).Error; err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(items, 100).Error
+	})
+}
+
+func (s *DatabaseService) UpdateAnalysisBatch(batch *AnalysisBatchModel) error {
+	batch.UpdatedAt = time.Now()
+	return s.db.Save(batch).Error
+}
+
+func (s *DatabaseService) GetUnappliedAnalysisBatches() ([]AnalysisBatchModel, error) {
+	var batches []AnalysisBatchModel
+	err := s.db.Where("status != ?", BATCH_STATUS_APPLIED).Order("submitted_at ASC").Find(&batches).Error
+	return batches, err
+}
+
+func (s *DatabaseService) GetRecentAnalysisBatches(limit int) ([]AnalysisBatchModel, error) {
+	var batches []AnalysisBatchModel
+	err := s.db.Order("submitted_at DESC").Limit(limit).Find(&batches).Error
+	return batches, err
+}
+
+func (s *DatabaseService) GetAnalysisBatchItems(batchID string) ([]AnalysisBatchItemModel, error) {
+	var items []AnalysisBatchItemModel
+	err := s.db.Where("batch_id = ?", batchID).Find(&items).Error
+	return items, err
+}
+
+func (s *DatabaseService) UpdateAnalysisBatchItemStatus(batchID, customID, status, errorMessage string) error {
+	return s.db.Model(&AnalysisBatchItemModel{}).
+		Where("batch_id = ? AND custom_id = ?", batchID, customID).
+		Updates(map[string]interface{}{"status": status, "error": errorMessage}).Error
+}
+
+func (s *DatabaseService) CreateAnalysisJob(job *AnalysisJobModel) error {
+	return s.db.Create(job).Error
+}
+
+// LeaseAnalysisJob marks the oldest available job of the stage as leased until
+// leaseUntil. Jobs whose lease expired are leased again. It returns nil when no
+// job is available.
+// LeaseAnalysisJob leases the next pending job of the stage or a job whose lease
+// ran out, except the jobs of skip.
+func (s *DatabaseService) LeaseAnalysisJob(stage string, leaseUntil time.Time, skip ...uint) (*AnalysisJobModel, error) {
+	var job AnalysisJobModel
+	now := time.Now()
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("stage = ? AND ((status = ? AND available_at <= ?) OR (status = ? AND lease_until < ?))", stage, JOB_STATUS_PENDING, now, JOB_STATUS_LEASED, now)
+		if len(skip) > 0 {
+			query = query.Where("id NOT IN ?", skip)
+		}
+		err := query.Order("id ASC").First(&job).Error
+		if err != nil {
+			return err
+		}
+		job.Status = JOB_STATUS_LEASED
+		job.LeaseUntil = &leaseUntil
+		job.Attempts++
+		return tx.Save(&job).Error
+	})
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// RenewAnalysisJobLease extends the lease of a job which is still leased.
+func (s *DatabaseService) RenewAnalysisJobLease(id uint, leaseUntil time.Time) error {
+	return s.db.Model(&AnalysisJobModel{}).
+		Where("id = ? AND status = ?", id, JOB_STATUS_LEASED).
+		Update("lease_until", leaseUntil).Error
+}
+
+func (s *DatabaseService) UpdateAnalysisJob(job *AnalysisJobModel) error {
+	return s.db.Save(job).Error
+}
+
+// RecoverLeasedAnalysisJobs returns jobs leased by a previous run to the queue,
+// jobs which already used all attempts are moved to the dead letter.
+func (s *DatabaseService) RecoverLeasedAnalysisJobs(maxAttempts int) (int64, int64, error) {
+	dead := s.db.Model(&AnalysisJobModel{}).
+		Where("status = ? AND attempts >= ?", JOB_STATUS_LEASED, maxAttempts).
+		Updates(map[string]interface{}{"status": JOB_STATUS_DEAD, "lease_until": nil, "last_error": "interrupted on every attempt"})
+	if dead.Error != nil {
+		return 0, 0, dead.Error
+	}
+	recovered := s.db.Model(&AnalysisJobModel{}).
+		Where("status = ?", JOB_STATUS_LEASED).
+		Updates(map[string]interface{}{"status": JOB_STATUS_PENDING, "lease_until": nil, "available_at": time.Now()})
+	return recovered.RowsAffected, dead.RowsAffected, recovered.Error
+}
+
+func (s *DatabaseService) CountAnalysisJobs(stage, status string) (int64, error) {
+	var count int64
+	err := s.db.Model(&AnalysisJobModel{}).Where("stage = ? AND status = ?", stage, status).Count(&count).Error
+	return count, err
+}
+
+func (s *DatabaseService) GetOldestPendingAnalysisJob(stage string) (*AnalysisJobModel, error) {
+	var job AnalysisJobModel
+	err := s.db.Where("stage = ? AND status = ?", stage, JOB_STATUS_PENDING).Order("id ASC").First(&job).Error
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (s *DatabaseService) GetDeadAnalysisJobs(limit int) ([]AnalysisJobModel, error) {
+	var jobs []AnalysisJobModel
+	err := s.db.Where("status = ?", JOB_STATUS_DEAD).Order("updated_at DESC").Limit(limit).Find(&jobs).Error
+	return jobs, err
+}
+
+func (s *DatabaseService) RetryDeadAnalysisJobs() (int64, error) {
+	result := s.db.Model(&AnalysisJobModel{}).
+		Where("status = ?", JOB_STATUS_DEAD).
+		Updates(map[string]interface{}{"status": JOB_STATUS_PENDING, "attempts": 0, "available_at": time.Now()})
+	return result.RowsAffected, result.Error
+}
+
+// HasDeferredAnalysisJob reports whether the tweet already waits in the
+// deferred jobs of the stage.
+func (s *DatabaseService) HasDeferredAnalysisJob(stage, tweetID string) (bool, error) {
+	var count int64
+	err := s.db.Model(&AnalysisJobModel{}).Where("stage = ? AND status = ? AND tweet_id = ?", stage, JOB_STATUS_DEFERRED, tweetID).Count(&count).Error
+	return count > 0, err
+}
+
+// ReleaseDeferredAnalysisJobs makes the deferred jobs of the stage available.
+func (s *DatabaseService) ReleaseDeferredAnalysisJobs(stage string) (int64, error) {
+	result := s.db.Model(&AnalysisJobModel{}).
+		Where("stage = ? AND status = ?", stage, JOB_STATUS_DEFERRED).
+		Updates(map[string]interface{}{"status": JOB_STATUS_PENDING, "available_at": time.Now()})
+	return result.RowsAffected, result.Error
+}
+
+func (s *DatabaseService) DeleteDoneAnalysisJobs(before time.Time) (int64, error) {
+	result := s.db.Unscoped().Where("status = ? AND updated_at < ?", JOB_STATUS_DONE, before).Delete(&AnalysisJobModel{})
+	return result.RowsAffected, result.Error
+}
+
+func (s *DatabaseService) SaveReverseSession(session ReverseSessionModel) error {
+	session.UpdatedAt = time.Now()
+	return s.db.Save(&session).Error
+}
+
+func (s *DatabaseService) GetReverseSessions() ([]ReverseSessionModel, error) {
+	var sessions []ReverseSessionModel
+	err := s.db.Order("created_at ASC").Find(&sessions).Error
+	return sessions, err
+}
+
+func (s *DatabaseService) UpdateReverseSessionStatus(id, status string, coolingUntil, lastThrottledAt *time.Time, lastError string) error {
+	return s.db.Model(&ReverseSessionModel{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"status": status, "cooling_until": coolingUntil, "last_throttled_at": lastThrottledAt, "last_error": lastError, "updated_at": time.Now()}).Error
+}
+
 func (s *DatabaseService) SaveCachedAnalysis(userID, username string, analysis SecondStepClaudeResponse) error {
 
 	keyEvidenceJSON := ""
@@ -609,7 +1355,7 @@ func (s *DatabaseService) SaveCachedAnalysis(userID, username string, analysis S
 	}
 
 	var existing CachedAnalysisModel
-	err := s.db.Where("user_id = ?", userID).First(&existing).Error
+	err := s.db.Where("user_id = ? AND community_id = ?", userID, s.communityID).First(&existing).Error
 
 	if err == nil {
 
@@ -631,6 +1377,7 @@ func (s *DatabaseService) SaveCachedAnalysis(userID, username string, analysis S
 
 		cached := CachedAnalysisModel{
 			UserID:         userID,
+			CommunityID:    s.communityID,
 			Username:       username,
 			IsFUDUser:      analysis.IsFUDUser,
 			FUDType:        analysis.FUDType,
@@ -650,7 +1397,7 @@ func (s *DatabaseService) SaveCachedAnalysis(userID, username string, analysis S
 
 func (s *DatabaseService) GetCachedAnalysis(userID string) (*SecondStepClaudeResponse, error) {
 	var cached CachedAnalysisModel
-	err := s.db.Where("user_id = ?", userID).First(&cached).Error
+	err := s.db.Where("user_id = ? AND community_id = ?", userID, s.communityID).First(&cached).Error
 	if err != nil {
 		return nil, err
 	}
@@ -675,7 +1422,7 @@ func (s *DatabaseService) GetCachedAnalysis(userID string) (*SecondStepClaudeRes
 
 func (s *DatabaseService) HasValidCachedAnalysis(userID string) bool {
 	var count int64
-	s.db.Model(&CachedAnalysisModel{}).Where("user_id = ?", userID).Count(&count)
+	s.db.Model(&CachedAnalysisModel{}).Where("user_id = ? AND community_id = ?", userID, s.communityID).Count(&count)
 	return count > 0
 }
 
@@ -683,7 +1430,7 @@ func (s *DatabaseService) GetAllFUDUsersFromCache() ([]map[string]interface{}, e
 	var results []map[string]interface{}
 
 	var fudUsers []FUDUserModel
-	err := s.db.Order("detected_at DESC").Find(&fudUsers).Error
+	err := s.db.Where("community_id = ?", s.communityID).Order("detected_at DESC").Find(&fudUsers).Error
 	if err != nil {
 		return nil, err
 	}
@@ -694,7 +1441,7 @@ func (s *DatabaseService) GetAllFUDUsersFromCache() ([]map[string]interface{}, e
 		lastMessageDate := time.Time{}
 		isAlive := false
 
-		err = s.db.Where("user_id = ?", user.UserID).Order("created_at DESC").First(&lastTweet).Error
+		err = s.db.Where("user_id = ? AND community_id = ?", user.UserID, s.communityID).Order("created_at DESC").First(&lastTweet).Error
 		if err == nil {
 			lastMessageDate = lastTweet.CreatedAt
 
@@ -717,7 +1464,7 @@ func (s *DatabaseService) GetAllFUDUsersFromCache() ([]map[string]interface{}, e
 	}
 
 	var cachedFUD []CachedAnalysisModel
-	err = s.db.Where("is_fud_user = ?", true).
+	err = s.db.Where("is_fud_user = ? AND community_id = ?", true, s.communityID).
 		Order("analyzed_at DESC").Find(&cachedFUD).Error
 	if err != nil {
 		return nil, err
@@ -735,7 +1482,7 @@ func (s *DatabaseService) GetAllFUDUsersFromCache() ([]map[string]interface{}, e
 			lastMessageDate := time.Time{}
 			isAlive := false
 
-			err = s.db.Where("user_id = ?", cached.UserID).Order("created_at DESC").First(&lastTweet).Error
+			err = s.db.Where("user_id = ? AND community_id = ?", cached.UserID, s.communityID).Order("created_at DESC").First(&lastTweet).Error
 			if err == nil {
 				lastMessageDate = lastTweet.CreatedAt
 
@@ -779,13 +1526,13 @@ func (s *DatabaseService) GetAllGoodUsersFromCache() ([]map[string]interface{},
 				ELSE 0
 			END as is_alive
 		FROM cached_analysis ca
-		LEFT JOIN tweets t ON ca.user_id = t.user_id
-		WHERE ca.is_fud_user = 0
+		LEFT JOIN tweets t ON ca.user_id = t.user_id AND t.community_id = ca.community_id
+		WHERE ca.is_fud_user = 0 AND ca.community_id = ?
 		GROUP BY ca.user_id, ca.username, ca.user_risk_level, ca.fud_probability, ca.analyzed_at, ca.user_summary
 		ORDER BY message_count DESC, ca.analyzed_at DESC
 	`
 
-	rows, err := s.db.Raw(query, time.Now()).Rows()
+	rows, err := s.db.Raw(query, s.communityID).Rows()
 	if err != nil {
 		return nil, err
 	}
@@ -830,7 +1577,7 @@ func (s *DatabaseService) GetActiveFUDUsersSortedByLastMessage() ([]map[string]i
 
 	var cachedFUD []CachedAnalysisModel
 	log.Printf("🔍 DB: Querying cached_analysis table for FUD users...")
-	err := s.db.Where("is_fud_user = ?", true).
+	err := s.db.Where("is_fud_user = ? AND community_id = ?", true, s.communityID).
 		Order("analyzed_at DESC").Find(&cachedFUD).Error
 	if err != nil {
 		log.Printf("❌ DB: Error querying cached_analysis: %v", err)
@@ -847,7 +1594,7 @@ func (s *DatabaseService) GetActiveFUDUsersSortedByLastMessage() ([]map[string]i
 		lastMessageDate := time.Time{}
 		isAlive := false
 
-		err = s.db.Where("user_id = ?", cached.UserID).Order("created_at DESC").First(&lastTweet).Error
+		err = s.db.Where("user_id = ? AND community_id = ?", cached.UserID, s.communityID).Order("created_at DESC").First(&lastTweet).Error
 		if err == nil {
 			lastMessageDate = lastTweet.CreatedAt
 
@@ -945,60 +1692,221 @@ func (s *DatabaseService) GetUserTickerOpinionCount(userID, ticker string) (int6
 	return count, err
 }
 
-func (s *DatabaseService) GetUserStatus(userID string) string {
+func (s *DatabaseService) GetUserCommunityStatus(userID string) (*UserCommunityStatusModel, error) {
+	var status UserCommunityStatusModel
+	err := s.db.Where("user_id = ? AND community_id = ?", userID, s.communityID).First(&status).Error
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// updateUserStatus applies updates to the status of the user in the community,
+// creating it on the first analysis.
+func (s *DatabaseService) updateUserStatus(userID string, updates map[string]interface{}) error {
+	status := UserCommunityStatusModel{UserID: userID, CommunityID: s.communityID, Status: USER_STATUS_UNKNOWN}
+	err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&status).Error
+	if err != nil {
+		return err
+	}
+
+	_, statusChanged := updates["status"]
+	_, fudChanged := updates["is_fud"]
+	var previous UserCommunityStatusModel
+	if statusChanged || fudChanged {
+		s.db.Where("user_id = ? AND community_id = ?", userID, s.communityID).First(&previous)
+	}
+
+	updates["updated_at"] = time.Now()
+	err = s.db.Model(&UserCommunityStatusModel{}).Where("user_id = ? AND community_id = ?", userID, s.communityID).Updates(updates).Error
+	if err != nil || !(statusChanged || fudChanged) {
+		return err
+	}
+
+	var current UserCommunityStatusModel
+	if err := s.db.Where("user_id = ? AND community_id = ?", userID, s.communityID).First(&current).Error; err != nil {
+		return err
+	}
+	return s.recordStatusTransition(previous, current, "")
+}
+
+// recordStatusTransition appends the transition between the two states of the
+// user to the status history. The analyzing state is not recorded, leaving it
+// is a transition from the last recorded status.
+func (s *DatabaseService) recordStatusTransition(previous, current UserCommunityStatusModel, note string) error {
+	if current.Status == USER_STATUS_ANALYZING {
+		return nil
+	}
+	oldStatus := previous.Status
+	if oldStatus == USER_STATUS_ANALYZING || oldStatus == "" {
+		oldStatus = s.lastRecordedStatus(current.UserID)
+	}
+	if note == "" && oldStatus == current.Status && previous.IsFUD == current.IsFUD {
+		return nil
+	}
+
+	source := s.change.Source
+	if source == "" {
+		source = STATUS_SOURCE_SYSTEM
+	}
+	if note == "" {
+		note = s.change.Note
+	}
+	username := ""
 	var user UserModel
-	err := s.db.Select("status").Where("id = ?", userID).First(&user).Error
+	if err := s.db.Select("username").Where("id = ?", current.UserID).First(&user).Error; err == nil {
+		username = user.Username
+	}
+	return s.db.Create(&UserStatusHistoryModel{
+		UserID:         current.UserID,
+		CommunityID:    s.communityID,
+		Username:       username,
+		OldStatus:      oldStatus,
+		NewStatus:      current.Status,
+		IsFUD:          current.IsFUD,
+		FUDType:        current.FUDType,
+		FUDProbability: current.FUDProbability,
+		RiskLevel:      s.change.RiskLevel,
+		Source:         source,
+		TweetID:        s.change.TweetID,
+		RequestUUID:    s.change.RequestUUID,
+		Actor:          s.change.Actor,
+		Note:           note,
+		PromptVersion:  s.change.PromptVersion,
+		CreatedAt:      time.Now(),
+	}).Error
+}
+
+func (s *DatabaseService) lastRecordedStatus(userID string) string {
+	var last UserStatusHistoryModel
+	err := s.db.Where("user_id = ? AND community_id = ?", userID, s.communityID).Order("id DESC").First(&last).Error
+	if err != nil {
+		return USER_STATUS_UNKNOWN
+	}
+	return last.NewStatus
+}
+
+// GetUserStatusHistory returns the status transitions of the user, newest
+// first.
+func (s *DatabaseService) GetUserStatusHistory(userID string, limit int) ([]UserStatusHistoryModel, error) {
+	var history []UserStatusHistoryModel
+	err := s.db.Where("user_id = ? AND community_id = ?", userID, s.communityID).Order("id DESC").Limit(limit).Find(&history).Error
+	return history, err
+}
+
+func (s *DatabaseService) GetPromptSelections() ([]PromptSelectionModel, error) {
+	var selections []PromptSelectionModel
+	err := s.db.Order("name ASC").Find(&selections).Error
+	return selections, err
+}
+
+func (s *DatabaseService) SavePromptSelection(selection PromptSelectionModel) error {
+	return s.db.Save(&selection).Error
+}
+
+// PromptReviewStats are the reviews opened by the verdicts of one prompt
+// version and how the reviewers closed them, confirmed agrees with the model.
+// The reviews of a prompt rendered over a base prompt count for both.
+type PromptReviewStats struct {
+	PromptVersion string `gorm:"column:prompt_version"`
+	Opened        int64  `gorm:"column:opened"`
+	Confirmed     int64  `gorm:"column:confirmed"`
+	Rejected      int64  `gorm:"column:rejected"`
+}
+
+// GetPromptReviewStats returns the review outcomes of every prompt version, in
+// all communities since the prompts are shared.
+func (s *DatabaseService) GetPromptReviewStats() (map[string]PromptReviewStats, error) {
+	var rows []PromptReviewStats
+	err := s.db.Model(&FUDReviewModel{}).
+		Select("prompt_version, COUNT(*) AS opened, SUM(CASE WHEN status = ? THEN 1 ELSE 0 END) AS confirmed, SUM(CASE WHEN status = ? THEN 1 ELSE 0 END) AS rejected", REVIEW_STATUS_CONFIRMED, REVIEW_STATUS_REJECTED).
+		Where("prompt_version <> ''").
+		Group("prompt_version").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string]PromptReviewStats, len(rows))
+	for _, row := range rows {
+		for _, label := range SplitPromptLabel(row.PromptVersion) {
+			total := stats[label]
+			total.PromptVersion = label
+			total.Opened += row.Opened
+			total.Confirmed += row.Confirmed
+			total.Rejected += row.Rejected
+			stats[label] = total
+		}
+	}
+	return stats, nil
+}
+
+func (s *DatabaseService) GetUserStatus(userID string) string {
+	status, err := s.GetUserCommunityStatus(userID)
 	if err != nil {
 		return USER_STATUS_UNKNOWN
 	}
-	return user.Status
+	return status.Status
 }
 
 func (s *DatabaseService) SetUserAnalyzing(userID, username string) error {
 	now := time.Now()
-	return s.db.Model(&UserModel{}).Where("id = ?", userID).Updates(map[string]interface{}{
+	return s.updateUserStatus(userID, map[string]interface{}{
 		"status":           USER_STATUS_ANALYZING,
 		"last_analyzed_at": &now,
 		"analysis_count":   gorm.Expr("analysis_count + 1"),
-		"updated_at":       now,
-	}).Error
+	})
 }
 
 func (s *DatabaseService) UpdateUserAfterAnalysis(userID, username string, aiDecision SecondStepClaudeResponse, messageID string) error {
 	now := time.Now()
 
+	// a FUD verdict of the model waits for a reviewer, users on the FUD list
+	// were confirmed already and change through a review or an override only.
+	// The condition matches the one opening the review.
+	confirmed := s.IsFUDUser(userID)
 	status := USER_STATUS_CLEAN
-	if aiDecision.IsFUDUser || aiDecision.IsFUDAttack {
+	switch {
+	case confirmed:
 		status = USER_STATUS_FUD_CONFIRMED
+	case aiDecision.IsFUDUser:
+		status = USER_STATUS_PENDING_REVIEW
 	}
 
 	updates := map[string]interface{}{
-		"username":         username,
 		"status":           status,
 		"last_analyzed_at": &now,
 		"last_message_id":  messageID,
 		"analysis_count":   gorm.Expr("analysis_count + 1"),
-		"updated_at":       now,
 	}
 
-	if status == USER_STATUS_FUD_CONFIRMED {
-		updates["is_fud"] = true
+	if aiDecision.IsFUDUser {
+		updates["is_fud"] = confirmed
 		updates["fud_type"] = aiDecision.FUDType
 		updates["fud_probability"] = aiDecision.FUDProbability
 		updates["fud_message_count"] = gorm.Expr("fud_message_count + 1")
-	} else {
+	} else if !confirmed {
 		updates["is_fud"] = false
 		updates["fud_type"] = ""
 		updates["fud_probability"] = 0
 	}
 
-	return s.db.Model(&UserModel{}).Where("id = ?", userID).Updates(updates).Error
+	change := s.change
+	change.RiskLevel = aiDecision.UserRiskLevel
+	if change.TweetID == "" {
+		change.TweetID = messageID
+	}
+	s.updateUsername(userID, username)
+	return s.WithStatusChange(change).updateUserStatus(userID, updates)
 }
 
 func (s *DatabaseService) MarkUserAsFUD(userID, username, messageID string, fudType string, probability float64) error {
 	now := time.Now()
-	return s.db.Model(&UserModel{}).Where("id = ?", userID).Updates(map[string]interface{}{
-		"username":          username,
+	change := s.change
+	if change.TweetID == "" {
+		change.TweetID = messageID
+	}
+	s.updateUsername(userID, username)
+	return s.WithStatusChange(change).updateUserStatus(userID, map[string]interface{}{
 		"status":            USER_STATUS_FUD_CONFIRMED,
 		"is_fud":            true,
 		"fud_type":          fudType,
@@ -1006,8 +1914,17 @@ func (s *DatabaseService) MarkUserAsFUD(userID, username, messageID string, fudT
 		"last_message_id":   messageID,
 		"last_analyzed_at":  &now,
 		"fud_message_count": gorm.Expr("fud_message_count + 1"),
-		"updated_at":        now,
-	}).Error
+	})
+}
+
+func (s *DatabaseService) updateUsername(userID, username string) {
+	if username == "" {
+		return
+	}
+	s.db.Model(&UserModel{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"username":   username,
+		"updated_at": time.Now(),
+	})
 }
 
 func (s *DatabaseService) IsFUDUserByStatus(userID string) bool {
@@ -1032,8 +1949,16 @@ func (s *DatabaseService) GetFUDFriendsAnalysis(usernames []string) (int, int, [
 		lowerUsernames[i] = strings.ToLower(username)
 	}
 
-	var fudUsers []UserModel
-	err := s.db.Where("LOWER(username) IN ? AND status = ?", lowerUsernames, USER_STATUS_FUD_CONFIRMED).Find(&fudUsers).Error
+	var fudUsers []struct {
+		Username       string
+		FUDType        string
+		FUDProbability float64
+	}
+	err := s.db.Table("user_community_statuses s").
+		Select("u.username, s.fud_type, s.fud_probability").
+		Joins("JOIN users u ON u.id = s.user_id").
+		Where("LOWER(u.username) IN ? AND s.community_id = ? AND s.status = ?", lowerUsernames, s.communityID, USER_STATUS_FUD_CONFIRMED).
+		Scan(&fudUsers).Error
 	if err != nil {
 		log.Printf("Error getting FUD friends analysis: %v", err)
 		return totalFriends, 0, []string{}
@@ -1050,11 +1975,12 @@ func (s *DatabaseService) GetFUDFriendsAnalysis(usernames []string) (int, int, [
 
 func (s *DatabaseService) GetUserStats() (map[string]int, error) {
 	stats := map[string]int{
-		"total_users":   0,
-		"fud_confirmed": 0,
-		"clean_users":   0,
-		"analyzing":     0,
-		"unknown":       0,
+		"total_users":    0,
+		"fud_confirmed":  0,
+		"clean_users":    0,
+		"analyzing":      0,
+		"pending_review": 0,
+		"unknown":        0,
 	}
 
 	var totalCount int64
@@ -1069,14 +1995,17 @@ func (s *DatabaseService) GetUserStats() (map[string]int, error) {
 		Count  int64
 	}
 
-	err = s.db.Model(&UserModel{}).
+	err = s.db.Model(&UserCommunityStatusModel{}).
 		Select("status, COUNT(*) as count").
+		Where("community_id = ?", s.communityID).
 		Group("status").
 		Find(&statusCounts).Error
 	if err != nil {
 		return stats, err
 	}
 
+	// users never analyzed in the community have no status
+	known := 0
 	for _, statusCount := range statusCounts {
 		switch statusCount.Status {
 		case USER_STATUS_FUD_CONFIRMED:
@@ -1085,9 +2014,15 @@ func (s *DatabaseService) GetUserStats() (map[string]int, error) {
 			stats["clean_users"] = int(statusCount.Count)
 		case USER_STATUS_ANALYZING:
 			stats["analyzing"] = int(statusCount.Count)
-		case USER_STATUS_UNKNOWN:
-			stats["unknown"] = int(statusCount.Count)
+		case USER_STATUS_PENDING_REVIEW:
+			stats["pending_review"] = int(statusCount.Count)
+		default:
+			continue
 		}
+		known += int(statusCount.Count)
+	}
+	if unknown := stats["total_users"] - known; unknown > 0 {
+		stats["unknown"] = unknown
 	}
 
 	return stats, nil
@@ -1104,7 +2039,7 @@ func (s *DatabaseService) GetUserInfo(userID string) (*UserModel, error) {
 
 func (s *DatabaseService) GetCachedAnalysisByUsername(username string) (*CachedAnalysisModel, error) {
 	var cached CachedAnalysisModel
-	err := s.db.Where("LOWER(username) = ?", strings.ToLower(username)).First(&cached).Error
+	err := s.db.Where("LOWER(username) = ? AND community_id = ?", strings.ToLower(username), s.communityID).First(&cached).Error
 	if err != nil {
 		return nil, err
 	}
@@ -91,7 +91,6 @@ func TestDatabaseService_UserOperations(t *testing.T) {
 		ID:       "user_123",
 		Username: "testuser",
 		Name:     "Test User",
-		IsFUD:    false,
 	}
 
 	t.Run("SaveUser", func(t *testing.T) {
@@ -121,7 +120,7 @@ func TestDatabaseService_UserOperations(t *testing.T) {
 		assert.Equal(t, user.ID, retrievedUser.ID)
 		assert.Equal(t, user.Username, retrievedUser.Username)
 		assert.Equal(t, user.Name, retrievedUser.Name)
-		assert.Equal(t, user.IsFUD, retrievedUser.IsFUD)
+		assert.False(t, db.IsFUDUserByStatus(user.ID))
 	})
 
 	t.Run("GetUserByUsername", func(t *testing.T) {
@@ -402,3 +401,227 @@ func TestDatabaseService_ComplexScenario(t *testing.T) {
 	assert.Len(t, fudTweets, 1)
 	assert.Equal(t, "complex_tweet_2", fudTweets[0].ID)
 }
+
+func TestDatabaseService_CommunityScope(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.SetDefaultCommunity("community_a"))
+	communityB := db.ForCommunity("community_b")
+	assert.Same(t, db, db.ForCommunity(""))
+
+	require.NoError(t, db.SaveUser(UserModel{ID: "user_1", Username: "trader"}))
+	require.NoError(t, db.SaveTweet(TweetModel{ID: "tweet_a", Text: "in a", UserID: "user_1", SourceType: TWEET_SOURCE_COMMUNITY}))
+	require.NoError(t, communityB.SaveTweet(TweetModel{ID: "tweet_b", Text: "in b", UserID: "user_1", SourceType: TWEET_SOURCE_COMMUNITY}))
+
+	fud := SecondStepClaudeResponse{IsFUDUser: true, FUDType: "emotional_escalation", FUDProbability: 0.9}
+	require.NoError(t, db.UpdateUserAfterAnalysis("user_1", "trader", fud, "tweet_a"))
+	review, err := db.OpenFUDReview("user_1", "trader", "tweet_a", fud.FUDType, fud.FUDProbability)
+	require.NoError(t, err)
+	_, err = db.ResolveFUDReview(review.ID, REVIEW_STATUS_CONFIRMED, 7, "reviewer")
+	require.NoError(t, err)
+	require.NoError(t, db.SaveCachedAnalysis("user_1", "trader", fud))
+	require.NoError(t, communityB.UpdateUserAfterAnalysis("user_1", "trader", SecondStepClaudeResponse{}, "tweet_b"))
+	require.NoError(t, communityB.SaveCachedAnalysis("user_1", "trader", SecondStepClaudeResponse{UserSummary: "clean"}))
+
+	assert.True(t, db.IsFUDUserByStatus("user_1"))
+	assert.True(t, db.IsFUDUser("user_1"))
+	assert.Equal(t, USER_STATUS_CLEAN, communityB.GetUserStatus("user_1"))
+	assert.False(t, communityB.IsFUDUser("user_1"))
+	assert.Equal(t, USER_STATUS_UNKNOWN, db.ForCommunity("community_c").GetUserStatus("user_1"))
+
+	cachedB, err := communityB.GetCachedAnalysis("user_1")
+	require.NoError(t, err)
+	assert.False(t, cachedB.IsFUDUser)
+	assert.Equal(t, "clean", cachedB.UserSummary)
+
+	activity, err := communityB.GetUserCommunityActivity("user_1")
+	require.NoError(t, err)
+	require.Len(t, activity.ThreadGroups, 1)
+	assert.Equal(t, "tweet_b", activity.ThreadGroups[0].MainPost.ID)
+
+	_, fudFriends, _ := db.GetFUDFriendsAnalysis([]string{"Trader"})
+	assert.Equal(t, 1, fudFriends)
+	_, fudFriends, _ = communityB.GetFUDFriendsAnalysis([]string{"Trader"})
+	assert.Equal(t, 0, fudFriends)
+
+	stats, err := communityB.GetUserStats()
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats["clean_users"])
+	assert.Equal(t, 0, stats["fud_confirmed"])
+}
+
+func TestDatabaseService_FUDReview(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.SaveUser(UserModel{ID: "user_1", Username: "trader"}))
+
+	fud := SecondStepClaudeResponse{IsFUDUser: true, FUDType: "direct_attack", FUDProbability: 0.8}
+	require.NoError(t, db.UpdateUserAfterAnalysis("user_1", "trader", fud, "tweet_1"))
+	assert.Equal(t, USER_STATUS_PENDING_REVIEW, db.GetUserStatus("user_1"))
+	assert.False(t, db.IsFUDUserByStatus("user_1"))
+
+	review, err := db.OpenFUDReview("user_1", "trader", "tweet_1", fud.FUDType, fud.FUDProbability)
+	require.NoError(t, err)
+	again, err := db.OpenFUDReview("user_1", "trader", "tweet_2", fud.FUDType, 0.95)
+	require.NoError(t, err)
+	assert.Equal(t, review.ID, again.ID, "one open review per user")
+	assert.Equal(t, 2, again.AlertCount)
+	assert.Equal(t, "tweet_2", again.TweetID)
+
+	escalated, err := db.ResolveFUDReview(review.ID, REVIEW_STATUS_ESCALATED, 1, "moderator")
+	require.NoError(t, err)
+	assert.True(t, escalated.IsOpen())
+	assert.Equal(t, "moderator", escalated.EscalatedBy)
+	assert.Nil(t, escalated.ReviewedAt)
+
+	rejected, err := db.ResolveFUDReview(review.ID, REVIEW_STATUS_REJECTED, 2, "admin")
+	require.NoError(t, err)
+	assert.Equal(t, REVIEW_STATUS_REJECTED, rejected.Status)
+	assert.Equal(t, int64(2), rejected.ReviewerID)
+	assert.NotNil(t, rejected.ReviewedAt)
+	assert.Equal(t, USER_STATUS_CLEAN, db.GetUserStatus("user_1"))
+	assert.False(t, db.IsFUDUser("user_1"))
+
+	_, err = db.ResolveFUDReview(review.ID, REVIEW_STATUS_CONFIRMED, 3, "late")
+	assert.ErrorContains(t, err, "already closed")
+	assert.Equal(t, USER_STATUS_CLEAN, db.GetUserStatus("user_1"))
+
+	reviews, err := db.GetOpenFUDReviews(REVIEW_LIST_LIMIT)
+	require.NoError(t, err)
+	assert.Empty(t, reviews)
+
+	// a FUD attack of a user who is not a FUD user opens no review
+	attack := SecondStepClaudeResponse{IsFUDAttack: true, FUDType: "casual", FUDProbability: 0.4}
+	require.NoError(t, db.UpdateUserAfterAnalysis("user_1", "trader", attack, "tweet_4"))
+	assert.Equal(t, USER_STATUS_CLEAN, db.GetUserStatus("user_1"))
+
+	// a user on the FUD list stays confirmed on a new verdict
+	require.NoError(t, db.SaveFUDUser(FUDUserModel{UserID: "user_2", Username: "known", DetectedAt: time.Now()}))
+	require.NoError(t, db.UpdateUserAfterAnalysis("user_2", "known", fud, "tweet_3"))
+	assert.Equal(t, USER_STATUS_FUD_CONFIRMED, db.GetUserStatus("user_2"))
+
+	// a clean verdict on a confirmed user changes nothing until it is approved
+	clean := SecondStepClaudeResponse{FUDProbability: 0.1}
+	require.NoError(t, db.UpdateUserAfterAnalysis("user_2", "known", clean, "tweet_5"))
+	assert.Equal(t, USER_STATUS_FUD_CONFIRMED, db.GetUserStatus("user_2"))
+	assert.True(t, db.IsFUDUser("user_2"))
+	cleanReview, err := db.OpenCleanReview("user_2", "known", "tweet_5", clean.FUDProbability)
+	require.NoError(t, err)
+	assert.Equal(t, REVIEW_VERDICT_CLEAN, cleanReview.Verdict)
+	fudReview, err := db.OpenFUDReview("user_2", "known", "tweet_3", fud.FUDType, fud.FUDProbability)
+	require.NoError(t, err)
+	assert.NotEqual(t, cleanReview.ID, fudReview.ID, "one open review per verdict")
+
+	_, err = db.ResolveFUDReview(cleanReview.ID, REVIEW_STATUS_REJECTED, 2, "admin")
+	require.NoError(t, err)
+	assert.Equal(t, USER_STATUS_FUD_CONFIRMED, db.GetUserStatus("user_2"))
+	assert.True(t, db.IsFUDUser("user_2"))
+
+	cleanReview, err = db.OpenCleanReview("user_2", "known", "tweet_6", clean.FUDProbability)
+	require.NoError(t, err)
+	_, err = db.ResolveFUDReview(cleanReview.ID, REVIEW_STATUS_CONFIRMED, 2, "admin")
+	require.NoError(t, err)
+	assert.Equal(t, USER_STATUS_CLEAN, db.GetUserStatus("user_2"))
+	assert.False(t, db.IsFUDUser("user_2"))
+}
+
+func TestDatabaseService_UserOverrides(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.SaveUser(UserModel{ID: "user_1", Username: "Trader"}))
+
+	fud := SecondStepClaudeResponse{IsFUDUser: true, FUDType: "direct_attack", FUDProbability: 0.8}
+	require.NoError(t, db.UpdateUserAfterAnalysis("user_1", "Trader", fud, "tweet_1"))
+	review, err := db.OpenFUDReview("user_1", "Trader", "tweet_1", fud.FUDType, fud.FUDProbability)
+	require.NoError(t, err)
+
+	allowed, err := db.SetUserOverride(UserOverrideModel{Username: "@Trader", Kind: OVERRIDE_KIND_ALLOW, Reason: "team member", AddedBy: "admin"})
+	require.NoError(t, err)
+	assert.Equal(t, "trader", allowed.Username)
+	assert.Equal(t, "user_1", allowed.UserID, "the id of a known user is looked up")
+	assert.Equal(t, USER_STATUS_CLEAN, db.GetUserStatus("user_1"))
+	closed, err := db.GetFUDReview(review.ID)
+	require.NoError(t, err)
+	assert.Equal(t, REVIEW_STATUS_REJECTED, closed.Status)
+	assert.Equal(t, "override by admin", closed.ReviewerName)
+	assert.False(t, allowed.Decision().IsFUDUser)
+
+	pinned, err := db.SetUserOverride(UserOverrideModel{Username: "trader", Kind: OVERRIDE_KIND_PIN, PinnedStatus: USER_STATUS_FUD_CONFIRMED, Reason: "known campaign", AddedBy: "admin"})
+	require.NoError(t, err)
+	assert.Equal(t, allowed.ID, pinned.ID, "one override per user")
+	assert.Equal(t, USER_STATUS_FUD_CONFIRMED, db.GetUserStatus("user_1"))
+	assert.True(t, db.IsFUDUser("user_1"))
+	assert.Equal(t, "Pinned as FUD by admin: known campaign", pinned.Decision().DecisionReason)
+
+	// an override of a user not seen yet learns the id from the first message
+	_, err = db.SetUserOverride(UserOverrideModel{Username: "newcomer", Kind: OVERRIDE_KIND_DENY, Reason: "paid", AddedBy: "admin"})
+	require.NoError(t, err)
+	assert.Nil(t, db.GetUserOverride("user_2", "someone"))
+	override := db.GetUserOverride("user_3", "NewComer")
+	require.NotNil(t, override)
+	assert.Equal(t, "user_3", override.UserID)
+	assert.NotNil(t, db.GetUserOverride("user_3", "renamed"))
+	assert.Nil(t, db.ForCommunity("community_b").GetUserOverride("user_3", "newcomer"))
+
+	overrides, err := db.GetUserOverrides()
+	require.NoError(t, err)
+	assert.Len(t, overrides, 2)
+	removed, err := db.DeleteUserOverride("@NEWCOMER")
+	require.NoError(t, err)
+	assert.True(t, removed)
+	assert.Nil(t, db.GetUserOverride("user_3", "newcomer"))
+}
+
+func TestDatabaseService_StatusHistory(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.SaveUser(UserModel{ID: "user_1", Username: "trader"}))
+
+	secondStep := db.WithStatusChange(StatusChange{Source: STATUS_SOURCE_SECOND_STEP, TweetID: "tweet_1", RequestUUID: "request_1"})
+	require.NoError(t, secondStep.SetUserAnalyzing("user_1", "trader"))
+	fud := SecondStepClaudeResponse{IsFUDUser: true, FUDType: "direct_attack", FUDProbability: 0.8, UserRiskLevel: "high"}
+	require.NoError(t, secondStep.UpdateUserAfterAnalysis("user_1", "trader", fud, "tweet_1"))
+	require.NoError(t, secondStep.UpdateUserAfterAnalysis("user_1", "trader", fud, "tweet_1"))
+
+	review, err := db.OpenFUDReview("user_1", "trader", "tweet_1", fud.FUDType, fud.FUDProbability)
+	require.NoError(t, err)
+	_, err = db.ResolveFUDReview(review.ID, REVIEW_STATUS_CONFIRMED, 7, "moderator")
+	require.NoError(t, err)
+
+	history, err := db.GetUserStatusHistory("user_1", TIMELINE_LIMIT)
+	require.NoError(t, err)
+	require.Len(t, history, 2, "analyzing and unchanged statuses are not recorded")
+	assert.Equal(t, USER_STATUS_UNKNOWN, history[1].OldStatus)
+	assert.Equal(t, USER_STATUS_PENDING_REVIEW, history[1].NewStatus)
+	assert.Equal(t, "trader", history[1].Username)
+	assert.Equal(t, 0.8, history[1].FUDProbability)
+	assert.Equal(t, "high", history[1].RiskLevel)
+	assert.Equal(t, STATUS_SOURCE_SECOND_STEP, history[1].Source)
+	assert.Equal(t, "tweet_1", history[1].TweetID)
+	assert.Equal(t, "request_1", history[1].RequestUUID)
+	assert.Equal(t, USER_STATUS_FUD_CONFIRMED, history[0].NewStatus)
+	assert.True(t, history[0].IsFUD)
+	assert.Equal(t, STATUS_SOURCE_REVIEW, history[0].Source)
+	assert.Equal(t, "moderator", history[0].Actor)
+
+	// removed from the FUD list while the status stays confirmed
+	require.NoError(t, db.DeleteFUDUser("user_1"))
+	require.NoError(t, db.SaveFUDUser(FUDUserModel{UserID: "user_1", Username: "trader", DetectedAt: time.Now()}))
+	require.NoError(t, db.ClearAllAnalysisFlags())
+
+	history, err = db.GetUserStatusHistory("user_1", TIMELINE_LIMIT)
+	require.NoError(t, err)
+	require.Len(t, history, 4)
+	assert.Equal(t, "removed from the FUD list", history[1].Note)
+	assert.Equal(t, STATUS_SOURCE_SYSTEM, history[1].Source)
+	assert.Equal(t, STATUS_SOURCE_ADMIN, history[0].Source)
+	assert.False(t, history[0].IsFUD)
+	assert.Equal(t, "trader", history[0].Username)
+
+	// the cached clean verdict of a later message
+	cache := db.WithStatusChange(StatusChange{Source: STATUS_SOURCE_CACHE})
+	require.NoError(t, cache.UpdateUserAfterAnalysis("user_1", "trader", SecondStepClaudeResponse{UserRiskLevel: "low"}, "tweet_2"))
+	history, err = db.GetUserStatusHistory("user_1", 1)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, USER_STATUS_FUD_CONFIRMED, history[0].OldStatus)
+	assert.Equal(t, USER_STATUS_CLEAN, history[0].NewStatus)
+	assert.Equal(t, STATUS_SOURCE_CACHE, history[0].Source)
+	assert.Equal(t, "tweet_2", history[0].TweetID)
+}
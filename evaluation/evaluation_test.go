@@ -0,0 +1,141 @@
+package evaluation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/grutapig/hackaton/analysis"
+	"github.com/grutapig/hackaton/claude"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadLabelsCSV(t *testing.T) {
+	labels, err := ReadLabelsCSV(strings.NewReader("username,label\n@Alice,fud\nbob, clean\ncarol,1\nalice,0\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []Label{
+		{Username: "alice", FUD: false, Source: LABEL_SOURCE_CSV},
+		{Username: "bob", FUD: false, Source: LABEL_SOURCE_CSV},
+		{Username: "carol", FUD: true, Source: LABEL_SOURCE_CSV},
+	}, labels, "the last label of a user wins")
+
+	_, err = ReadLabelsCSV(strings.NewReader("alice,fud\nbob,maybe\n"))
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestConfusion(t *testing.T) {
+	var confusion Confusion
+	assert.Zero(t, confusion.Precision())
+	assert.Zero(t, confusion.F1())
+
+	for _, pair := range [][2]bool{{true, true}, {true, true}, {true, false}, {false, true}, {false, false}, {false, false}} {
+		confusion.Add(pair[0], pair[1])
+	}
+	assert.Equal(t, Confusion{TruePositive: 2, FalsePositive: 1, FalseNegative: 1, TrueNegative: 2}, confusion)
+	assert.InDelta(t, 2.0/3, confusion.Precision(), 1e-9)
+	assert.InDelta(t, 2.0/3, confusion.Recall(), 1e-9)
+	assert.InDelta(t, 2.0/3, confusion.F1(), 1e-9)
+	assert.InDelta(t, 4.0/6, confusion.Accuracy(), 1e-9)
+}
+
+type messageRequest struct {
+	System   []claude.ContentBlock `json:"system"`
+	Messages claude.ClaudeMessages `json:"messages"`
+}
+
+// decisionServer answers the second step as FUD for the users in fud, read from
+// the system prompt, and fails for the users in failing.
+func decisionServer(t *testing.T, fud map[string]bool, failing map[string]bool) (*httptest.Server, *[]messageRequest) {
+	var mu sync.Mutex
+	var requests []messageRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request messageRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		mu.Lock()
+		requests = append(requests, request)
+		mu.Unlock()
+
+		username := strings.ToLower(strings.TrimPrefix(request.System[len(request.System)-1].Text, "analyzed user is "))
+		if failing[username] {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long"}}`))
+			return
+		}
+		isFUD, _ := json.Marshal(fud[username])
+		w.Write([]byte(`{"id":"msg_1","type":"message","role":"assistant","model":"test-model","stop_reason":"tool_use","content":[{"type":"tool_use","id":"toolu_1","name":"` + analysis.SecondStepTool.Name + `","input":{"is_fud_attack":` + string(isFUD) + `,"is_fud_user":` + string(isFUD) + `,"fud_probability":0.8,"fud_type":"direct_attack","user_risk_level":"high","key_evidence":["scam | rug"],"decision_reason":"calls it a\nscam","user_summary":"x"}}],"usage":{"input_tokens":1000,"output_tokens":100,"cache_read_input_tokens":2000}}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestEvaluator_Run(t *testing.T) {
+	server, requests := decisionServer(t, map[string]bool{"alice": true, "carol": true}, map[string]bool{"erin": true})
+	client, err := claude.NewClaudeClient("test-key", "", "test-model")
+	require.NoError(t, err)
+	client.SetAPIURL(server.URL)
+	client.SetRetryPolicy(claude.RetryPolicy{MaxAttempts: 1})
+
+	labels := []Label{
+		{Username: "alice", FUD: true},
+		{Username: "bob", FUD: true},
+		{Username: "carol", FUD: false},
+		{Username: "dave", FUD: false},
+		{Username: "erin", FUD: true},
+		{Username: "frank", FUD: false},
+	}
+	stored := func(username string, fud bool) Input {
+		return Input{
+			Username:        username,
+			Messages:        claude.ClaudeMessages{{Role: claude.ROLE_USER, Content: "thread of " + username}},
+			Baseline:        &analysis.SecondStepResponse{IsFUDUser: fud},
+			BaselineCostUSD: 0.01,
+		}
+	}
+	inputs := map[string]Input{
+		"alice": stored("Alice", true),
+		"bob":   stored("bob", true),
+		"carol": stored("carol", false),
+		"dave":  stored("dave", true),
+		"erin":  stored("erin", true),
+	}
+
+	evaluator := &Evaluator{
+		Client:   client,
+		Provider: claude.PROVIDER_CLAUDE,
+		Prompt:   analysis.DefaultPrompt(analysis.PROMPT_SECOND_STEP).WithBase([]byte("base prompt")),
+		Ticker:   "$TEST",
+		Prices:   map[string]claude.ModelPrice{"test-model": {InputPerMTok: 10, OutputPerMTok: 100}},
+	}
+	report, err := evaluator.Run(context.Background(), "test labels", labels, inputs)
+	require.NoError(t, err)
+
+	require.Len(t, *requests, 5)
+	first := (*requests)[0]
+	assert.Equal(t, "base prompt\nthe system ticker is:$TEST, it cannot be used for any criteria or flag about decision FUD or not", first.System[0].Text)
+	assert.Equal(t, "analyzed user is Alice", first.System[1].Text)
+	assert.Equal(t, "thread of Alice", first.Messages[0].Content)
+
+	assert.Equal(t, Confusion{TruePositive: 1, FalsePositive: 1, FalseNegative: 1, TrueNegative: 1}, report.Confusion)
+	assert.Equal(t, Confusion{TruePositive: 2, FalsePositive: 1, TrueNegative: 1}, report.Baseline, "the stored decisions of the decided users")
+	assert.Equal(t, 1, report.Errors)
+	assert.Equal(t, []Label{{Username: "frank", FUD: false}}, report.Missing)
+	assert.Equal(t, claude.Usage{InputTokens: 4000, OutputTokens: 400, CacheReadInputTokens: 8000}, report.Usage)
+	// 1000*10 + 100*100 + 2000*10*0.1 per request
+	assert.InDelta(t, 4*0.022, report.CostUSD, 1e-9)
+	assert.InDelta(t, 0.05, report.BaselineCostUSD, 1e-9)
+	assert.Empty(t, report.UnpricedModels)
+
+	markdown := report.Markdown()
+	assert.Contains(t, markdown, "| **Precision** | 50.0% | 66.7% |")
+	assert.Contains(t, markdown, "| **Labelled FUD** | 1 | 1 |")
+	assert.Contains(t, markdown, "| @bob | FUD | clean | 0.80 | direct_attack | FUD | calls it a scam |")
+	assert.Contains(t, markdown, "| @carol | clean | FUD |")
+	assert.Contains(t, markdown, "| @erin | ")
+	assert.Contains(t, markdown, "prompt is too long")
+	assert.Contains(t, markdown, "## 📭 Users Without Stored Input\n\n@frank\n")
+}
@@ -0,0 +1,206 @@
+package evaluation
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grutapig/hackaton/analysis"
+	"github.com/grutapig/hackaton/claude"
+)
+
+// Confusion counts the decisions against the labels, FUD is the positive class.
+type Confusion struct {
+	TruePositive  int
+	FalsePositive int
+	FalseNegative int
+	TrueNegative  int
+}
+
+func (c *Confusion) Add(expected, predicted bool) {
+	switch {
+	case expected && predicted:
+		c.TruePositive++
+	case !expected && predicted:
+		c.FalsePositive++
+	case expected && !predicted:
+		c.FalseNegative++
+	default:
+		c.TrueNegative++
+	}
+}
+
+func (c Confusion) Total() int {
+	return c.TruePositive + c.FalsePositive + c.FalseNegative + c.TrueNegative
+}
+
+// Precision is the share of the FUD decisions which are labelled FUD, 0 without
+// FUD decisions.
+func (c Confusion) Precision() float64 {
+	return ratio(c.TruePositive, c.TruePositive+c.FalsePositive)
+}
+
+// Recall is the share of the FUD users which are detected, 0 without FUD users.
+func (c Confusion) Recall() float64 {
+	return ratio(c.TruePositive, c.TruePositive+c.FalseNegative)
+}
+
+func (c Confusion) F1() float64 {
+	precision, recall := c.Precision(), c.Recall()
+	if precision+recall == 0 {
+		return 0
+	}
+	return 2 * precision * recall / (precision + recall)
+}
+
+func (c Confusion) Accuracy() float64 {
+	return ratio(c.TruePositive+c.TrueNegative, c.Total())
+}
+
+func ratio(numerator, denominator int) float64 {
+	if denominator == 0 {
+		return 0
+	}
+	return float64(numerator) / float64(denominator)
+}
+
+// Result is the evaluation of one labelled user.
+type Result struct {
+	Label    Label
+	Input    Input
+	Decision *analysis.SecondStepResponse
+	Err      string
+	Usage    claude.Usage
+	CostUSD  float64
+}
+
+// Report is the outcome of an evaluation run. Baseline counts the decisions
+// stored with the inputs of the users the run decided, to compare on the same
+// users.
+type Report struct {
+	Prompt          string
+	Provider        string
+	Model           string
+	LabelSource     string
+	StartedAt       time.Time
+	FinishedAt      time.Time
+	Labels          int
+	Results         []Result
+	Missing         []Label
+	Confusion       Confusion
+	Baseline        Confusion
+	Errors          int
+	Usage           claude.Usage
+	CostUSD         float64
+	BaselineCostUSD float64
+	UnpricedModels  []string
+}
+
+// Evaluator replays the second step inputs through Prompt, already rendered
+// over its base prompt, and Client.
+type Evaluator struct {
+	Client   claude.LLMClient
+	Provider string
+	Prompt   analysis.Prompt
+	Ticker   string
+	Prices   map[string]claude.ModelPrice
+}
+
+// Run evaluates every label with a stored input, the labels without one are
+// reported as missing. A failed replay is reported and the run goes on.
+func (e *Evaluator) Run(ctx context.Context, labelSource string, labels []Label, inputs map[string]Input) (*Report, error) {
+	report := &Report{
+		Prompt:      e.Prompt.Label(),
+		Provider:    e.Provider,
+		Model:       e.Client.GetModel(),
+		LabelSource: labelSource,
+		StartedAt:   time.Now(),
+		Labels:      len(labels),
+	}
+	unpriced := make(map[string]bool)
+
+	for i, label := range labels {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		input, ok := inputs[strings.ToLower(label.Username)]
+		if !ok {
+			report.Missing = append(report.Missing, label)
+			continue
+		}
+
+		result := Result{Label: label, Input: input}
+		decision, err := e.replay(ctx, input, &result, unpriced)
+		if err != nil {
+			result.Err = err.Error()
+			report.Errors++
+			log.Printf("Evaluation %d/%d %s failed: %v", i+1, len(labels), label.Username, err)
+		} else {
+			result.Decision = decision
+			report.Confusion.Add(label.FUD, decision.IsFUDUser)
+			log.Printf("Evaluation %d/%d %s: labelled fud=%t, decided fud=%t (%.2f)", i+1, len(labels), label.Username, label.FUD, decision.IsFUDUser, decision.FUDProbability)
+			if input.Baseline != nil {
+				report.Baseline.Add(label.FUD, input.Baseline.IsFUDUser)
+			}
+		}
+		report.BaselineCostUSD += input.BaselineCostUSD
+		report.Usage = addUsage(report.Usage, result.Usage)
+		report.CostUSD += result.CostUSD
+		report.Results = append(report.Results, result)
+	}
+
+	for model := range unpriced {
+		report.UnpricedModels = append(report.UnpricedModels, model)
+	}
+	sort.Strings(report.UnpricedModels)
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+func (e *Evaluator) replay(ctx context.Context, input Input, result *Result, unpriced map[string]bool) (*analysis.SecondStepResponse, error) {
+	systemBlocks, err := e.Prompt.RenderBlocks(analysis.PromptVars{Ticker: e.Ticker, Username: input.Username})
+	if err != nil {
+		return nil, fmt.Errorf("render prompt %s: %w", e.Prompt.Label(), err)
+	}
+
+	// every attempt is billed, the retried and repaired ones included
+	ctx = claude.WithAttemptRecorder(ctx, func(attempt claude.Attempt) {
+		if attempt.Response == nil {
+			return
+		}
+		model := attempt.Response.Model
+		if model == "" {
+			model = e.Client.GetModel()
+		}
+		price, ok := e.Prices[model]
+		if !ok {
+			unpriced[model] = true
+		}
+		result.Usage = addUsage(result.Usage, attempt.Response.Usage)
+		result.CostUSD += price.Cost(attempt.Response.Usage)
+	})
+
+	decision := &analysis.SecondStepResponse{}
+	_, err = claude.SendStructured(ctx, e.Client, claude.StructuredRequest{
+		Messages:     input.Messages,
+		SystemBlocks: systemBlocks,
+		Tool:         analysis.SecondStepTool,
+		MaxTokens:    analysis.SECOND_STEP_MAX_TOKENS,
+		MaxRepairs:   claude.DEFAULT_MAX_REPAIRS,
+	}, decision)
+	if err != nil {
+		return nil, err
+	}
+	return decision, nil
+}
+
+func addUsage(total, usage claude.Usage) claude.Usage {
+	total.InputTokens += usage.InputTokens
+	total.OutputTokens += usage.OutputTokens
+	total.CacheCreationInputTokens += usage.CacheCreationInputTokens
+	total.CacheReadInputTokens += usage.CacheReadInputTokens
+	return total
+}
@@ -0,0 +1,117 @@
+package evaluation
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/grutapig/hackaton/analysis"
+	"github.com/grutapig/hackaton/claude"
+	"gorm.io/gorm"
+)
+
+// the request_type of the second step rows of ai_request_logs
+const REQUEST_TYPE_SECOND_STEP = "second_step"
+
+// Input is the last second step request of a user stored in the AI request
+// log, with the decision and the cost it had.
+type Input struct {
+	RequestUUID   string
+	UserID        string
+	Username      string
+	PromptVersion string
+	Model         string
+	Messages      claude.ClaudeMessages
+	// nil when no attempt of the request returned a valid decision
+	Baseline        *analysis.SecondStepResponse
+	BaselineCostUSD float64
+}
+
+type requestLogRow struct {
+	RequestUUID   string
+	UserID        string
+	Username      string
+	PromptVersion string
+	Model         string
+	RequestData   string
+	ResponseData  string
+	CostUSD       float64
+	IsSuccess     bool
+}
+
+// LoadInputs returns the last decodable second step request of each of the
+// usernames, keyed by the lowercased username, from the logging database.
+// Usernames without a stored request are missing from the result.
+func LoadInputs(logsDB *gorm.DB, usernames []string) (map[string]Input, error) {
+	wanted := make([]string, 0, len(usernames))
+	for _, username := range usernames {
+		wanted = append(wanted, strings.ToLower(username))
+	}
+
+	var rows []requestLogRow
+	err := logsDB.Table("ai_request_logs").
+		Select("request_uuid, user_id, username, prompt_version, model, request_data, response_data, cost_usd, is_success").
+		Where("request_type = ? AND LOWER(username) IN ?", REQUEST_TYPE_SECOND_STEP, wanted).
+		Order("id DESC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("load second step requests: %w", err)
+	}
+
+	requests := make(map[string]*Input)
+	var order []string
+	for _, row := range rows {
+		input, ok := requests[row.RequestUUID]
+		if !ok {
+			var messages claude.ClaudeMessages
+			if err := json.Unmarshal([]byte(row.RequestData), &messages); err != nil || len(messages) == 0 {
+				continue
+			}
+			input = &Input{
+				RequestUUID:   row.RequestUUID,
+				UserID:        row.UserID,
+				Username:      row.Username,
+				PromptVersion: row.PromptVersion,
+				Model:         row.Model,
+				Messages:      messages,
+			}
+			requests[row.RequestUUID] = input
+			order = append(order, row.RequestUUID)
+		}
+		input.BaselineCostUSD += row.CostUSD
+		// the rows are newest first, the first valid answer is the decision
+		if input.Baseline == nil && row.IsSuccess {
+			input.Baseline = decodeDecision(row.ResponseData)
+		}
+	}
+
+	inputs := make(map[string]Input)
+	for _, requestUUID := range order {
+		input := requests[requestUUID]
+		key := strings.ToLower(input.Username)
+		if _, ok := inputs[key]; !ok {
+			inputs[key] = *input
+		}
+	}
+	return inputs, nil
+}
+
+func decodeDecision(responseData string) *analysis.SecondStepResponse {
+	var resp claude.ClaudeMessageResponse
+	if err := json.Unmarshal([]byte(responseData), &resp); err != nil {
+		return nil
+	}
+	input, ok := resp.ToolInput(analysis.SecondStepTool.Name)
+	if !ok || resp.StopReason == "max_tokens" {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(input, &value); err != nil || len(analysis.SecondStepTool.InputSchema.Validate(value)) > 0 {
+		return nil
+	}
+	var decision analysis.SecondStepResponse
+	if err := json.Unmarshal(input, &decision); err != nil {
+		return nil
+	}
+	return &decision
+}
@@ -0,0 +1,171 @@
+// Package evaluation measures the second step detection against labelled
+// users: the stored second step inputs are replayed through a prompt and a
+// model and the decisions are compared with the labels.
+package evaluation
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	LABEL_SOURCE_REVIEW   = "review"
+	LABEL_SOURCE_OVERRIDE = "override"
+	LABEL_SOURCE_CSV      = "csv"
+)
+
+// Label is the expected verdict of a user.
+type Label struct {
+	Username string
+	UserID   string
+	FUD      bool
+	Source   string
+}
+
+// the columns of the fud_reviews and user_overrides tables of the bot database,
+// the status values are the REVIEW_STATUS_*, OVERRIDE_KIND_* and USER_STATUS_*
+// constants of the bot
+type reviewRow struct {
+	UserID    string
+	Username  string
+	Status    string
+	Verdict   string
+	UpdatedAt time.Time
+}
+
+type overrideRow struct {
+	UserID       string
+	Username     string
+	Kind         string
+	PinnedStatus string
+	UpdatedAt    time.Time
+}
+
+// LabelsFromDatabase builds the labels from the moderated verdicts of the bot
+// database: a confirmed FUD verdict is FUD and a rejected one is clean, the
+// other way round for a clean verdict on a confirmed user. The admin
+// overrides win over the reviews, the allowlist is clean and the denylist is
+// FUD. An empty communityID takes every community.
+func LabelsFromDatabase(db *gorm.DB, communityID string) ([]Label, error) {
+	labels := make(map[string]Label)
+
+	// databases older than the clean verdicts have FUD reviews only
+	columns := "user_id, username, status, 'fud' AS verdict, updated_at"
+	if db.Migrator().HasColumn("fud_reviews", "verdict") {
+		columns = "user_id, username, status, verdict, updated_at"
+	}
+	var reviews []reviewRow
+	query := db.Table("fud_reviews").
+		Select(columns).
+		Where("status IN ?", []string{"confirmed", "rejected"})
+	if communityID != "" {
+		query = query.Where("community_id = ?", communityID)
+	}
+	if err := query.Order("updated_at, id").Scan(&reviews).Error; err != nil {
+		return nil, fmt.Errorf("load reviews: %w", err)
+	}
+	for _, review := range reviews {
+		addLabel(labels, Label{Username: review.Username, UserID: review.UserID, FUD: (review.Status == "confirmed") != (review.Verdict == "clean"), Source: LABEL_SOURCE_REVIEW})
+	}
+
+	var overrides []overrideRow
+	query = db.Table("user_overrides").Select("user_id, username, kind, pinned_status, updated_at")
+	if communityID != "" {
+		query = query.Where("community_id = ?", communityID)
+	}
+	if err := query.Order("updated_at, id").Scan(&overrides).Error; err != nil {
+		return nil, fmt.Errorf("load overrides: %w", err)
+	}
+	for _, override := range overrides {
+		label := Label{Username: override.Username, UserID: override.UserID, Source: LABEL_SOURCE_OVERRIDE}
+		switch {
+		case override.Kind == "allow" || override.Kind == "pin" && override.PinnedStatus == "clean":
+			label.FUD = false
+		case override.Kind == "deny" || override.Kind == "pin" && override.PinnedStatus == "fud_confirmed":
+			label.FUD = true
+		default:
+			continue
+		}
+		addLabel(labels, label)
+	}
+
+	return sortedLabels(labels), nil
+}
+
+// LoadLabelsCSV reads username,label lines, the label is fud or clean (also
+// 1/0 and true/false). A header line is skipped.
+func LoadLabelsCSV(path string) ([]Label, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadLabelsCSV(file)
+}
+
+func ReadLabelsCSV(r io.Reader) ([]Label, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	labels := make(map[string]Label)
+	for i, record := range records {
+		if len(record) < 2 {
+			return nil, fmt.Errorf("line %d: expected username,label", i+1)
+		}
+		fud, err := parseLabel(record[1])
+		if err != nil {
+			if i == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		addLabel(labels, Label{Username: record[0], FUD: fud, Source: LABEL_SOURCE_CSV})
+	}
+	return sortedLabels(labels), nil
+}
+
+func parseLabel(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "fud", "1", "true":
+		return true, nil
+	case "clean", "0", "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("unknown label %q, expected fud or clean", value)
+}
+
+// addLabel keeps the last label of a username
+func addLabel(labels map[string]Label, label Label) {
+	label.Username = strings.TrimPrefix(strings.TrimSpace(label.Username), "@")
+	if label.Username == "" {
+		return
+	}
+	key := strings.ToLower(label.Username)
+	if label.UserID == "" {
+		label.UserID = labels[key].UserID
+	}
+	labels[key] = label
+}
+
+func sortedLabels(labels map[string]Label) []Label {
+	result := make([]Label, 0, len(labels))
+	for _, label := range labels {
+		result = append(result, label)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return strings.ToLower(result[i].Username) < strings.ToLower(result[j].Username)
+	})
+	return result
+}
@@ -0,0 +1,134 @@
+package evaluation
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Markdown renders the report in the layout of the reports directory.
+func (r *Report) Markdown() string {
+	var b strings.Builder
+	decided := r.Confusion.Total()
+
+	fmt.Fprintf(&b, "# 🧪 Detection Evaluation Report: %s\n\n", r.Prompt)
+	b.WriteString("## 📊 Evaluation Overview\n\n")
+	fmt.Fprintf(&b, "**Prompt:** `%s`  \n", r.Prompt)
+	fmt.Fprintf(&b, "**Model:** %s (%s)  \n", r.Model, r.Provider)
+	fmt.Fprintf(&b, "**Labels:** %d users from %s  \n", r.Labels, r.LabelSource)
+	fmt.Fprintf(&b, "**Evaluated:** %d users, %d without a stored second step input, %d failed  \n", decided, len(r.Missing), r.Errors)
+	fmt.Fprintf(&b, "**Run:** %s, %s\n\n", r.StartedAt.Format("2006-01-02 15:04"), r.FinishedAt.Sub(r.StartedAt).Round(time.Second))
+	b.WriteString("---\n\n")
+
+	b.WriteString("## 🎯 Detection Quality\n\n")
+	fmt.Fprintf(&b, "Stored decisions are the verdicts logged with the replayed inputs, for the %d evaluated users that have one.\n\n", r.Baseline.Total())
+	b.WriteString("| Metric | Evaluated | Stored decisions |\n")
+	b.WriteString("|--------|--|--|\n")
+	fmt.Fprintf(&b, "| **Users** | %d | %d |\n", decided, r.Baseline.Total())
+	fmt.Fprintf(&b, "| **Precision** | %s | %s |\n", percent(r.Confusion.Precision(), r.Confusion.TruePositive+r.Confusion.FalsePositive), percent(r.Baseline.Precision(), r.Baseline.TruePositive+r.Baseline.FalsePositive))
+	fmt.Fprintf(&b, "| **Recall** | %s | %s |\n", percent(r.Confusion.Recall(), r.Confusion.TruePositive+r.Confusion.FalseNegative), percent(r.Baseline.Recall(), r.Baseline.TruePositive+r.Baseline.FalseNegative))
+	fmt.Fprintf(&b, "| **F1** | %s | %s |\n", percent(r.Confusion.F1(), decided), percent(r.Baseline.F1(), r.Baseline.Total()))
+	fmt.Fprintf(&b, "| **Accuracy** | %s | %s |\n\n", percent(r.Confusion.Accuracy(), decided), percent(r.Baseline.Accuracy(), r.Baseline.Total()))
+
+	b.WriteString("### Confusion Matrix\n\n")
+	b.WriteString("| | Decided FUD | Decided clean |\n")
+	b.WriteString("|--|--|--|\n")
+	fmt.Fprintf(&b, "| **Labelled FUD** | %d | %d |\n", r.Confusion.TruePositive, r.Confusion.FalseNegative)
+	fmt.Fprintf(&b, "| **Labelled clean** | %d | %d |\n\n", r.Confusion.FalsePositive, r.Confusion.TrueNegative)
+	b.WriteString("---\n\n")
+
+	b.WriteString("## 💰 Cost\n\n")
+	b.WriteString("| Metric | Value |\n")
+	b.WriteString("|--------|--|\n")
+	fmt.Fprintf(&b, "| **Input Tokens** | %d |\n", r.Usage.InputTokens)
+	fmt.Fprintf(&b, "| **Cache Write Tokens** | %d |\n", r.Usage.CacheCreationInputTokens)
+	fmt.Fprintf(&b, "| **Cache Read Tokens** | %d |\n", r.Usage.CacheReadInputTokens)
+	fmt.Fprintf(&b, "| **Output Tokens** | %d |\n", r.Usage.OutputTokens)
+	fmt.Fprintf(&b, "| **Evaluation Cost** | $%.4f |\n", r.CostUSD)
+	if replayed := len(r.Results); replayed > 0 {
+		fmt.Fprintf(&b, "| **Cost per User** | $%.4f |\n", r.CostUSD/float64(replayed))
+		fmt.Fprintf(&b, "| **Stored Cost per User** | $%.4f |\n", r.BaselineCostUSD/float64(replayed))
+	}
+	b.WriteString("\n")
+	if len(r.UnpricedModels) > 0 {
+		fmt.Fprintf(&b, "⚠️ No price configured for %s, its usage is counted as free.\n\n", strings.Join(r.UnpricedModels, ", "))
+	}
+	b.WriteString("---\n\n")
+
+	b.WriteString("## 🔍 Misclassified Users\n\n")
+	misclassified := 0
+	for _, result := range r.Results {
+		if result.Decision == nil || result.Decision.IsFUDUser == result.Label.FUD {
+			continue
+		}
+		if misclassified == 0 {
+			b.WriteString("| User | Label | Decision | Probability | FUD Type | Stored Decision | Reason |\n")
+			b.WriteString("|------|--|--|--|--|--|--|\n")
+		}
+		misclassified++
+		stored := "-"
+		if result.Input.Baseline != nil {
+			stored = verdict(result.Input.Baseline.IsFUDUser)
+		}
+		fmt.Fprintf(&b, "| @%s | %s | %s | %.2f | %s | %s | %s |\n",
+			cell(result.Label.Username), verdict(result.Label.FUD), verdict(result.Decision.IsFUDUser),
+			result.Decision.FUDProbability, cell(result.Decision.FUDType), stored, cell(result.Decision.DecisionReason))
+	}
+	if misclassified == 0 {
+		b.WriteString("No misclassified users.\n")
+	}
+	b.WriteString("\n")
+
+	if r.Errors > 0 {
+		b.WriteString("## ⚠️ Errors\n\n")
+		b.WriteString("| User | Error |\n")
+		b.WriteString("|------|--|\n")
+		for _, result := range r.Results {
+			if result.Err != "" {
+				fmt.Fprintf(&b, "| @%s | %s |\n", cell(result.Label.Username), cell(result.Err))
+			}
+		}
+		b.WriteString("\n")
+	}
+
+	if len(r.Missing) > 0 {
+		b.WriteString("## 📭 Users Without Stored Input\n\n")
+		usernames := make([]string, 0, len(r.Missing))
+		for _, label := range r.Missing {
+			usernames = append(usernames, "@"+label.Username)
+		}
+		b.WriteString(strings.Join(usernames, ", ") + "\n")
+	}
+
+	return b.String()
+}
+
+// WriteMarkdown writes the report to path, creating its directory.
+func (r *Report) WriteMarkdown(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(r.Markdown()), 0644)
+}
+
+// percent formats a ratio, n/a when its denominator is empty.
+func percent(value float64, denominator int) string {
+	if denominator == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.1f%%", value*100)
+}
+
+func verdict(fud bool) string {
+	if fud {
+		return "FUD"
+	}
+	return "clean"
+}
+
+func cell(text string) string {
+	text = strings.ReplaceAll(text, "|", "\\|")
+	return strings.Join(strings.Fields(text), " ")
+}
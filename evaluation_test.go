@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/grutapig/hackaton/claude"
+	"github.com/grutapig/hackaton/evaluation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The evaluation harness reads the bot databases without the bot models, this
+// checks it against what the bot writes.
+func TestEvaluation_ReadsBotDatabases(t *testing.T) {
+	db := setupTestDB(t)
+	for _, username := range []string{"Alice", "Bob", "Carol"} {
+		review, err := db.OpenFUDReview("id_"+username, username, "t_"+username, "direct_attack", 0.9)
+		require.NoError(t, err)
+		status := REVIEW_STATUS_CONFIRMED
+		if username == "Bob" {
+			status = REVIEW_STATUS_REJECTED
+		}
+		_, err = db.ResolveFUDReview(review.ID, status, 1, "mod")
+		require.NoError(t, err)
+	}
+	_, err := db.OpenFUDReview("id_Dave", "Dave", "t_Dave", "casual", 0.7)
+	require.NoError(t, err)
+	// an approved clean verdict on a confirmed user
+	require.NoError(t, db.SaveFUDUser(FUDUserModel{UserID: "id_Frank", Username: "Frank", DetectedAt: time.Now()}))
+	cleanReview, err := db.OpenCleanReview("id_Frank", "Frank", "t_Frank", 0.1)
+	require.NoError(t, err)
+	_, err = db.ResolveFUDReview(cleanReview.ID, REVIEW_STATUS_CONFIRMED, 1, "mod")
+	require.NoError(t, err)
+	_, err = db.SetUserOverride(UserOverrideModel{Username: "carol", Kind: OVERRIDE_KIND_ALLOW, AddedBy: "admin"})
+	require.NoError(t, err)
+	_, err = db.SetUserOverride(UserOverrideModel{Username: "erin", Kind: OVERRIDE_KIND_PIN, PinnedStatus: USER_STATUS_FUD_CONFIRMED, AddedBy: "admin"})
+	require.NoError(t, err)
+
+	labels, err := evaluation.LabelsFromDatabase(db.db, "")
+	require.NoError(t, err)
+	assert.Equal(t, []evaluation.Label{
+		{Username: "Alice", UserID: "id_Alice", FUD: true, Source: evaluation.LABEL_SOURCE_REVIEW},
+		{Username: "Bob", UserID: "id_Bob", FUD: false, Source: evaluation.LABEL_SOURCE_REVIEW},
+		{Username: "carol", UserID: "id_Carol", FUD: false, Source: evaluation.LABEL_SOURCE_OVERRIDE},
+		{Username: "erin", FUD: true, Source: evaluation.LABEL_SOURCE_OVERRIDE},
+		{Username: "Frank", UserID: "id_Frank", FUD: false, Source: evaluation.LABEL_SOURCE_REVIEW},
+	}, labels, "pending reviews are not labels, the overrides win")
+
+	loggingService, err := NewLoggingService(t.TempDir() + "/test_logs.db")
+	require.NoError(t, err)
+	messages := claude.ClaudeMessages{{Role: claude.ROLE_USER, Content: "<thread>alice replies</thread>"}}
+	decision := func(isFUD bool) *claude.ClaudeMessageResponse {
+		input, _ := json.Marshal(SecondStepClaudeResponse{IsFUDUser: isFUD, FUDProbability: 0.9, FUDType: "direct_attack", UserRiskLevel: "high", KeyEvidence: []string{}, DecisionReason: "r", UserSummary: "s"})
+		return &claude.ClaudeMessageResponse{Model: "test-model", StopReason: "tool_use", Content: []claude.Content{{Type: claude.CONTENT_TYPE_TOOL_USE, Name: SecondStepTool.Name, Input: input}}}
+	}
+	logRequest := func(requestUUID, username string, attempt int, resp *claude.ClaudeMessageResponse, cost float64, isSuccess bool) {
+		require.NoError(t, loggingService.LogAIRequest(requestUUID, "id_"+username, username, "t1", REQUEST_TYPE_SECOND_STEP, "second_step@builtin", 2, attempt, messages, resp, AIUsage{Model: "test-model", CostUSD: cost}, 10, isSuccess, ""))
+	}
+	logRequest("r1", "Alice", 1, decision(false), 0.01, true)
+	// a later request of the same user, retried once
+	logRequest("r2", "alice", 1, nil, 0, false)
+	logRequest("r2", "alice", 2, decision(true), 0.02, true)
+	// a batch result has no replayable input
+	require.NoError(t, loggingService.LogAIRequest("r3", "id_Bob", "Bob", "t1", REQUEST_TYPE_BATCH, "second_step@builtin", 2, 1, map[string]string{"batch_id": "b1"}, decision(false), AIUsage{}, 0, true, ""))
+
+	inputs, err := evaluation.LoadInputs(loggingService.db, []string{"Alice", "Bob"})
+	require.NoError(t, err)
+	require.Len(t, inputs, 1)
+	input := inputs["alice"]
+	assert.Equal(t, "r2", input.RequestUUID)
+	assert.Equal(t, "second_step@builtin", input.PromptVersion)
+	assert.Equal(t, messages, input.Messages)
+	require.NotNil(t, input.Baseline)
+	assert.True(t, input.Baseline.IsFUDUser)
+	assert.InDelta(t, 0.02, input.BaselineCostUSD, 1e-9)
+}
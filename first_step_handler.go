@@ -1,196 +1,222 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/grutapig/hackaton/claude"
 	"github.com/grutapig/hackaton/twitterapi"
 	"log"
-	"os"
 	"time"
 )
 
 const FUD_TYPE = "known_fud_user_activity"
 
-func FirstStepHandler(newMessageCh chan twitterapi.NewMessage, fudChannel chan twitterapi.NewMessage, claudeApi *claude.ClaudeApi, systemPromptFirstStep []byte, dbService *DatabaseService, loggingService *LoggingService, notificationCh chan FUDAlertNotification) {
-	defer close(fudChannel)
-
-	for newMessage := range newMessageCh {
-		log.Println("Got a new message:", newMessage.Author.UserName, " - ", newMessage.Text, "parent to:", newMessage.ParentTweet.Text, " grandparent:", newMessage.GrandParentTweet.Text)
+// FirstStepHandler analyzes one community message with the prompt selected for
+// the user and queues the second step for new or flagged users. An error is
+// returned when the job should be retried.
+func FirstStepHandler(ctx context.Context, newMessage twitterapi.NewMessage, jobQueue *JobQueue, claudeApi claude.LLMClient, prompt Prompt, ticker string, dbService *DatabaseService, loggingService *LoggingService, budget *AIBudgetService, notificationCh chan FUDAlertNotification) error {
+	dbService = dbService.ForCommunity(newMessage.CommunityID).WithStatusChange(StatusChange{Source: STATUS_SOURCE_FIRST_STEP, TweetID: newMessage.TweetID, PromptVersion: prompt.Label()})
+	log.Println("Got a new message:", newMessage.Author.UserName, " - ", newMessage.Text, "parent to:", newMessage.ParentTweet.Text, " grandparent:", newMessage.GrandParentTweet.Text)
+
+	isNewUser := !dbService.UserExists(newMessage.Author.ID)
+	activityType := ACTIVITY_TYPE_EXISTING_USER
+	if isNewUser {
+		activityType = ACTIVITY_TYPE_NEW_USER
+	}
 
-		isNewUser := !dbService.UserExists(newMessage.Author.ID)
-		activityType := ACTIVITY_TYPE_EXISTING_USER
-		if isNewUser {
-			activityType = ACTIVITY_TYPE_NEW_USER
+	if loggingService != nil {
+		err := loggingService.LogUserActivity(newMessage.Author.ID, newMessage.Author.UserName, activityType, newMessage.TweetID, TWEET_SOURCE_COMMUNITY)
+		if err != nil {
+			log.Printf("Error logging user activity: %v", err)
 		}
+	}
 
-		if loggingService != nil {
-			err := loggingService.LogUserActivity(newMessage.Author.ID, newMessage.Author.UserName, activityType, newMessage.TweetID, TWEET_SOURCE_COMMUNITY)
-			if err != nil {
-				log.Printf("Error logging user activity: %v", err)
+	if override := applyUserOverride(newMessage, dbService); override != nil {
+		switch {
+		case override.Kind == OVERRIDE_KIND_DENY:
+			log.Printf("Denylisted user %s - reporting without analysis", newMessage.Author.UserName)
+			if err := dbService.IncrementFUDUserMessageCount(newMessage.Author.ID, newMessage.TweetID); err != nil {
+				log.Printf("Failed to increment FUD user message count: %v", err)
 			}
+			notificationCh <- denylistAlert(newMessage, override)
+			return nil
+		case !override.IsFUD():
+			log.Printf("%s user %s - skipping analysis", override.Label(), newMessage.Author.UserName)
+			return nil
 		}
+		// a user pinned as FUD goes through the quick analysis of known FUD users
+	}
 
-		isDetailAnalyzed := dbService.IsUserDetailAnalyzed(newMessage.Author.ID)
+	isDetailAnalyzed := dbService.IsUserDetailAnalyzed(newMessage.Author.ID)
 
-		isKnownFUDUser := dbService.IsFUDUserByStatus(newMessage.Author.ID)
+	userStatus, err := dbService.GetUserCommunityStatus(newMessage.Author.ID)
+	isKnownFUDUser := err == nil && userStatus.Status == USER_STATUS_FUD_CONFIRMED
 
-		if isKnownFUDUser {
+	if isKnownFUDUser {
 
-			log.Printf("Known FUD user %s - performing quick analysis before notification", newMessage.Author.UserName)
+		if deferred, err := budget.DeferFirstStep(newMessage); deferred || err != nil {
+			return err
+		}
+		log.Printf("Known FUD user %s - performing quick analysis before notification", newMessage.Author.UserName)
 
-			requestUUID := uuid.New().String()
+		requestUUID := uuid.New().String()
 
-			messages := claude.ClaudeMessages{}
+		messages := claude.ClaudeMessages{}
 
-			if newMessage.GrandParentTweet.ID != "" {
-				messages = append(messages, claude.ClaudeMessage{claude.ROLE_USER, "the main post is: " + newMessage.GrandParentTweet.Author + ":" + newMessage.GrandParentTweet.Text})
-				messages = append(messages, claude.ClaudeMessage{claude.ROLE_USER, "reply in thread: " + newMessage.ParentTweet.Author + ":" + newMessage.ParentTweet.Text})
-			} else {
-				messages = append(messages, claude.ClaudeMessage{claude.ROLE_USER, "the main post is: " + newMessage.ParentTweet.Author + ":" + newMessage.ParentTweet.Text})
-			}
+		if newMessage.GrandParentTweet.ID != "" {
+			messages = append(messages, claude.ClaudeMessage{Role: claude.ROLE_USER, Content: "the main post is: " + newMessage.GrandParentTweet.Author + ":" + newMessage.GrandParentTweet.Text})
+			messages = append(messages, claude.ClaudeMessage{Role: claude.ROLE_USER, Content: "reply in thread: " + newMessage.ParentTweet.Author + ":" + newMessage.ParentTweet.Text})
+		} else {
+			messages = append(messages, claude.ClaudeMessage{Role: claude.ROLE_USER, Content: "the main post is: " + newMessage.ParentTweet.Author + ":" + newMessage.ParentTweet.Text})
+		}
 
-			messages = append(messages, claude.ClaudeMessage{claude.ROLE_USER, "user reply being analyzed: " + newMessage.Author.UserName + ":" + newMessage.Text})
-			messages = append(messages, claude.ClaudeMessage{claude.ROLE_ASSISTANT, "{"})
-			systemTicker := os.Getenv(ENV_TWITTER_COMMUNITY_TICKER)
-
-			startTime := time.Now()
-			resp, err := claudeApi.SendMessage(messages, fmt.Sprintf("%s\n<instruction>you must analyze %s user messages in the context of the full thread</instruction> \n this is a FUD user. be more attention for his message and his answers."+"\nthe system ticker is:"+systemTicker+", it cannot be used for any criteria or flag about decision FUD or not", string(systemPromptFirstStep), newMessage.Author.UserName))
-			processingTime := int(time.Since(startTime).Milliseconds())
-
-			if loggingService != nil {
-				loggingService.LogAIRequest(requestUUID, newMessage.Author.ID, newMessage.Author.UserName, newMessage.TweetID, REQUEST_TYPE_FIRST_STEP, 1, messages, resp, 0, processingTime, err == nil, func() string {
-					if err != nil {
-						return err.Error()
-					}
-					return ""
-				}())
-			}
+		messages = append(messages, claude.ClaudeMessage{Role: claude.ROLE_USER, Content: "user reply being analyzed: " + newMessage.Author.UserName + ":" + newMessage.Text})
+		systemMessage, err := prompt.Render(PromptVars{Ticker: ticker, Username: newMessage.Author.UserName, KnownFUD: true})
+		if err != nil {
+			return fmt.Errorf("render prompt %s: %w", prompt.Label(), err)
+		}
+		aiDecision := FirstStepClaudeResponse{}
+		_, err = sendStructuredAIRequest(ctx, claudeApi, loggingService, budget, AIRequestMeta{
+			RequestUUID:   requestUUID,
+			UserID:        newMessage.Author.ID,
+			Username:      newMessage.Author.UserName,
+			TweetID:       newMessage.TweetID,
+			RequestType:   REQUEST_TYPE_FIRST_STEP,
+			StepNumber:    1,
+			PromptVersion: prompt.Label(),
+		}, claude.StructuredRequest{
+			Messages:      messages,
+			SystemMessage: systemMessage,
+			Tool:          FirstStepTool,
+			MaxTokens:     FIRST_STEP_MAX_TOKENS,
+		}, &aiDecision)
 
-			if err != nil {
-				log.Printf("error claude quick analysis: %s", err)
-				continue
-			}
+		if err != nil {
+			log.Printf("error claude quick analysis: %s", err)
+			return err
+		}
+
+		if aiDecision.IsFud {
+
+			originalPostText := ""
+			originalPostAuthor := ""
+			parentPostText := ""
+			parentPostAuthor := ""
+			grandParentPostText := ""
+			grandParentPostAuthor := ""
+			hasThreadContext := false
 
-			aiDecision := FirstStepClaudeResponse{}
-			err = json.Unmarshal([]byte("{"+resp.Content[0].Text), &aiDecision)
-			if err != nil {
-				log.Printf("error unmarshaling claude response: %s", err)
-				continue
+			if newMessage.GrandParentTweet.ID != "" {
+				grandParentPostText = newMessage.GrandParentTweet.Text
+				grandParentPostAuthor = newMessage.GrandParentTweet.Author
+				parentPostText = newMessage.ParentTweet.Text
+				parentPostAuthor = newMessage.ParentTweet.Author
+				originalPostText = newMessage.GrandParentTweet.Text
+				originalPostAuthor = newMessage.GrandParentTweet.Author
+				hasThreadContext = true
+			} else if newMessage.ParentTweet.ID != "" {
+				parentPostText = newMessage.ParentTweet.Text
+				parentPostAuthor = newMessage.ParentTweet.Author
+				originalPostText = newMessage.ParentTweet.Text
+				originalPostAuthor = newMessage.ParentTweet.Author
+				hasThreadContext = true
 			}
 
-			if aiDecision.IsFud {
-
-				originalPostText := ""
-				originalPostAuthor := ""
-				parentPostText := ""
-				parentPostAuthor := ""
-				grandParentPostText := ""
-				grandParentPostAuthor := ""
-				hasThreadContext := false
-
-				if newMessage.GrandParentTweet.ID != "" {
-					grandParentPostText = newMessage.GrandParentTweet.Text
-					grandParentPostAuthor = newMessage.GrandParentTweet.Author
-					parentPostText = newMessage.ParentTweet.Text
-					parentPostAuthor = newMessage.ParentTweet.Author
-					originalPostText = newMessage.GrandParentTweet.Text
-					originalPostAuthor = newMessage.GrandParentTweet.Author
-					hasThreadContext = true
-				} else if newMessage.ParentTweet.ID != "" {
-					parentPostText = newMessage.ParentTweet.Text
-					parentPostAuthor = newMessage.ParentTweet.Author
-					originalPostText = newMessage.ParentTweet.Text
-					originalPostAuthor = newMessage.ParentTweet.Author
-					hasThreadContext = true
-				}
-
-				alert := FUDAlertNotification{
-					FUDMessageID:          newMessage.TweetID,
-					FUDUserID:             newMessage.Author.ID,
-					FUDUsername:           newMessage.Author.UserName,
-					ThreadID:              newMessage.ReplyTweetID,
-					DetectedAt:            time.Now().Format(time.RFC3339),
-					AlertSeverity:         "medium",
-					FUDType:               FUD_TYPE,
-					FUDProbability:        0,
-					MessagePreview:        newMessage.Text,
-					RecommendedAction:     "MONITOR_ACTIVITY",
-					KeyEvidence:           []string{"Known FUD user"},
-					DecisionReason:        "Quick analysis of known FUD user activity",
-					OriginalPostText:      originalPostText,
-					OriginalPostAuthor:    originalPostAuthor,
-					ParentPostText:        parentPostText,
-					ParentPostAuthor:      parentPostAuthor,
-					GrandParentPostText:   grandParentPostText,
-					GrandParentPostAuthor: grandParentPostAuthor,
-					HasThreadContext:      hasThreadContext,
-				}
-				log.Printf("Sending quick notification for known FUD user %s", newMessage.Author.UserName)
-				notificationCh <- alert
-			} else {
-				log.Printf("Known FUD user %s - message not FUD, ignoring", newMessage.Author.UserName)
+			alert := FUDAlertNotification{
+				FUDMessageID:          newMessage.TweetID,
+				FUDUserID:             newMessage.Author.ID,
+				FUDUsername:           newMessage.Author.UserName,
+				ThreadID:              newMessage.ReplyTweetID,
+				DetectedAt:            time.Now().Format(time.RFC3339),
+				AlertSeverity:         "medium",
+				FUDType:               FUD_TYPE,
+				FUDProbability:        userStatus.FUDProbability,
+				MessagePreview:        newMessage.Text,
+				RecommendedAction:     "MONITOR_ACTIVITY",
+				KeyEvidence:           []string{"Known FUD user"},
+				DecisionReason:        "Quick analysis of known FUD user activity",
+				OriginalPostText:      originalPostText,
+				OriginalPostAuthor:    originalPostAuthor,
+				ParentPostText:        parentPostText,
+				ParentPostAuthor:      parentPostAuthor,
+				GrandParentPostText:   grandParentPostText,
+				GrandParentPostAuthor: grandParentPostAuthor,
+				HasThreadContext:      hasThreadContext,
+				CommunityID:           newMessage.CommunityID,
+				Backfill:              newMessage.Backfill,
 			}
-			continue
+			log.Printf("Sending quick notification for known FUD user %s", newMessage.Author.UserName)
+			notificationCh <- alert
+		} else {
+			log.Printf("Known FUD user %s - message not FUD, ignoring", newMessage.Author.UserName)
 		}
+		return nil
+	}
 
-		if !isDetailAnalyzed {
+	if !isDetailAnalyzed {
 
-			log.Printf("New user %s - sending directly to detailed analysis", newMessage.Author.UserName)
-			dbService.SetUserAnalyzing(newMessage.Author.ID, newMessage.Author.UserName)
-			fudChannel <- newMessage
-			continue
+		if deferred, err := budget.DeferSecondStep(newMessage); deferred || err != nil {
+			return err
 		}
+		log.Printf("New user %s - sending directly to detailed analysis", newMessage.Author.UserName)
+		dbService.SetUserAnalyzing(newMessage.Author.ID, newMessage.Author.UserName)
+		return jobQueue.Enqueue(JOB_STAGE_SECOND_STEP, newMessage)
+	}
 
-		log.Printf("Existing user %s - performing first step analysis", newMessage.Author.UserName)
-
-		requestUUID := uuid.New().String()
-
-		messages := claude.ClaudeMessages{}
-
-		if newMessage.GrandParentTweet.ID != "" {
-			messages = append(messages, claude.ClaudeMessage{claude.ROLE_USER, "the main post is: " + newMessage.GrandParentTweet.Author + ":" + newMessage.GrandParentTweet.Text})
-			messages = append(messages, claude.ClaudeMessage{claude.ROLE_USER, "reply in thread: " + newMessage.ParentTweet.Author + ":" + newMessage.ParentTweet.Text})
-		} else {
-			messages = append(messages, claude.ClaudeMessage{claude.ROLE_USER, "the main post is: " + newMessage.ParentTweet.Author + ":" + newMessage.ParentTweet.Text})
-		}
+	if deferred, err := budget.DeferFirstStep(newMessage); deferred || err != nil {
+		return err
+	}
+	log.Printf("Existing user %s - performing first step analysis", newMessage.Author.UserName)
 
-		messages = append(messages, claude.ClaudeMessage{claude.ROLE_USER, "user reply being analyzed: " + newMessage.Author.UserName + ":" + newMessage.Text})
-		messages = append(messages, claude.ClaudeMessage{claude.ROLE_ASSISTANT, "{"})
+	requestUUID := uuid.New().String()
 
-		startTime := time.Now()
-		resp, err := claudeApi.SendMessage(messages, fmt.Sprintf("%s\n<instruction>you must analyze %s user messages in the context of the full thread</instruction>", string(systemPromptFirstStep), newMessage.Author.UserName))
-		processingTime := int(time.Since(startTime).Milliseconds())
+	messages := claude.ClaudeMessages{}
 
-		if loggingService != nil {
-			loggingService.LogAIRequest(requestUUID, newMessage.Author.ID, newMessage.Author.UserName, newMessage.TweetID, REQUEST_TYPE_FIRST_STEP, 1, messages, resp, 0, processingTime, err == nil, func() string {
-				if err != nil {
-					return err.Error()
-				}
-				return ""
-			}())
-		}
+	if newMessage.GrandParentTweet.ID != "" {
+		messages = append(messages, claude.ClaudeMessage{Role: claude.ROLE_USER, Content: "the main post is: " + newMessage.GrandParentTweet.Author + ":" + newMessage.GrandParentTweet.Text})
+		messages = append(messages, claude.ClaudeMessage{Role: claude.ROLE_USER, Content: "reply in thread: " + newMessage.ParentTweet.Author + ":" + newMessage.ParentTweet.Text})
+	} else {
+		messages = append(messages, claude.ClaudeMessage{Role: claude.ROLE_USER, Content: "the main post is: " + newMessage.ParentTweet.Author + ":" + newMessage.ParentTweet.Text})
+	}
 
-		if err != nil {
-			log.Printf("error claude: %s", err)
-			continue
-		}
+	messages = append(messages, claude.ClaudeMessage{Role: claude.ROLE_USER, Content: "user reply being analyzed: " + newMessage.Author.UserName + ":" + newMessage.Text})
 
-		aiDecision := FirstStepClaudeResponse{}
-		err = json.Unmarshal([]byte("{"+resp.Content[0].Text), &aiDecision)
-		if err != nil {
-			log.Printf("error unmarshaling claude response: %s; raw: %s", err, "{"+resp.Content[0].Text)
-			continue
-		}
+	systemMessage, err := prompt.Render(PromptVars{Ticker: ticker, Username: newMessage.Author.UserName})
+	if err != nil {
+		return fmt.Errorf("render prompt %s: %w", prompt.Label(), err)
+	}
+	aiDecision := FirstStepClaudeResponse{}
+	_, err = sendStructuredAIRequest(ctx, claudeApi, loggingService, budget, AIRequestMeta{
+		RequestUUID:   requestUUID,
+		UserID:        newMessage.Author.ID,
+		Username:      newMessage.Author.UserName,
+		TweetID:       newMessage.TweetID,
+		RequestType:   REQUEST_TYPE_FIRST_STEP,
+		StepNumber:    1,
+		PromptVersion: prompt.Label(),
+	}, claude.StructuredRequest{
+		Messages:      messages,
+		SystemMessage: systemMessage,
+		Tool:          FirstStepTool,
+		MaxTokens:     FIRST_STEP_MAX_TOKENS,
+	}, &aiDecision)
+
+	if err != nil {
+		log.Printf("error claude: %s", err)
+		return err
+	}
 
-		if aiDecision.IsFud {
+	if aiDecision.IsFud {
 
-			log.Printf("First step flagged user %s as FUD - sending to detailed analysis", newMessage.Author.UserName)
-			dbService.SetUserAnalyzing(newMessage.Author.ID, newMessage.Author.UserName)
-			fudChannel <- newMessage
-		} else {
-			log.Printf("First step - user %s message not FUD, ignoring", newMessage.Author.UserName)
+		if deferred, err := budget.DeferSecondStep(newMessage); deferred || err != nil {
+			return err
 		}
+		log.Printf("First step flagged user %s as FUD - sending to detailed analysis", newMessage.Author.UserName)
+		dbService.SetUserAnalyzing(newMessage.Author.ID, newMessage.Author.UserName)
+		return jobQueue.Enqueue(JOB_STAGE_SECOND_STEP, newMessage)
+	} else {
+		log.Printf("First step - user %s message not FUD, ignoring", newMessage.Author.UserName)
 	}
+	return nil
 }
@@ -0,0 +1,135 @@
+package httpfixture
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const REDACTED = "REDACTED"
+
+// the values of these headers and query parameters never reach a fixture
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "X-Csrf-Token"}
+var sensitiveParams = []string{"api_key", "key", "token", "access_token"}
+
+// dropped from the recorded responses, the replayed body is not the one
+// received byte for byte
+var transportHeaders = []string{"Content-Length", "Content-Encoding", "Transfer-Encoding"}
+
+// Cassette is the content of a fixture file, the exchanges of one test in the
+// order they were made.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+type Request struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   Body        `json:"body,omitempty"`
+}
+
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       Body        `json:"body,omitempty"`
+}
+
+// Body keeps a JSON object or array as it is so the fixtures stay readable,
+// any other payload is stored as a string.
+type Body []byte
+
+func (b Body) MarshalJSON() ([]byte, error) {
+	trimmed := strings.TrimSpace(string(b))
+	if (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) && json.Valid(b) {
+		return []byte(trimmed), nil
+	}
+	return json.Marshal(string(b))
+}
+
+func (b *Body) UnmarshalJSON(data []byte) error {
+	if strings.HasPrefix(string(data), `"`) {
+		var text string
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+		*b = Body(text)
+		return nil
+	}
+	if string(data) == "null" {
+		*b = nil
+		return nil
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cassette := &Cassette{}
+	if err := json.Unmarshal(data, cassette); err != nil {
+		return nil, err
+	}
+	return cassette, nil
+}
+
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func sanitizeHeader(header http.Header) http.Header {
+	if len(header) == 0 {
+		return nil
+	}
+	sanitized := header.Clone()
+	for _, name := range sensitiveHeaders {
+		if sanitized.Get(name) != "" {
+			sanitized.Set(name, REDACTED)
+		}
+	}
+	return sanitized
+}
+
+func sanitizeURL(requestURL *url.URL) string {
+	sanitized := *requestURL
+	sanitized.User = nil
+	query := sanitized.Query()
+	for _, name := range sensitiveParams {
+		if query.Get(name) != "" {
+			query.Set(name, REDACTED)
+		}
+	}
+	sanitized.RawQuery = query.Encode()
+	return sanitized.String()
+}
+
+// requestKey identifies the recorded request of a call: the method, path and
+// query, the host is left out so the fixtures can be replayed against any base
+// URL.
+func requestKey(method string, requestURL string) string {
+	parsed, err := url.Parse(requestURL)
+	if err != nil {
+		return method + " " + requestURL
+	}
+	parsed.Scheme = ""
+	parsed.Host = ""
+	return method + " " + sanitizeURL(parsed)
+}
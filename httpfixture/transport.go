@@ -0,0 +1,181 @@
+package httpfixture
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+)
+
+// ENV_RECORD makes ForTest call the real services and record the fixtures
+// instead of replaying them.
+const ENV_RECORD = "record_http_fixtures"
+
+// Recorder passes the requests to the next transport and keeps every exchange,
+// sanitized, until Save writes them as a cassette.
+type Recorder struct {
+	next     http.RoundTripper
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+func NewRecorder(next http.RoundTripper) *Recorder {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Recorder{next: next}
+}
+
+func (r *Recorder) RoundTrip(request *http.Request) (*http.Response, error) {
+	var requestBody []byte
+	outgoing := request
+	if request.Body != nil {
+		var err error
+		requestBody, err = io.ReadAll(request.Body)
+		request.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		outgoing = request.Clone(request.Context())
+		outgoing.Body = io.NopCloser(bytes.NewReader(requestBody))
+	}
+
+	response, err := r.next.RoundTrip(outgoing)
+	if err != nil {
+		return nil, err
+	}
+	responseBody, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	response.Body = io.NopCloser(bytes.NewReader(responseBody))
+
+	responseHeader := sanitizeHeader(response.Header)
+	for _, name := range transportHeaders {
+		responseHeader.Del(name)
+	}
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Request: Request{
+			Method: request.Method,
+			URL:    sanitizeURL(request.URL),
+			Header: sanitizeHeader(request.Header),
+			Body:   requestBody,
+		},
+		Response: Response{
+			StatusCode: response.StatusCode,
+			Header:     responseHeader,
+			Body:       responseBody,
+		},
+	})
+	r.mu.Unlock()
+	return response, nil
+}
+
+func (r *Recorder) Save(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cassette.Save(path)
+}
+
+// Replayer answers the requests with the recorded responses, without network.
+// Identical requests get their responses in the recorded order, the last one
+// is repeated once they are used up. A request that was not recorded fails.
+type Replayer struct {
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+func NewReplayer(cassette Cassette) *Replayer {
+	return &Replayer{
+		interactions: cassette.Interactions,
+		used:         make([]bool, len(cassette.Interactions)),
+	}
+}
+
+func LoadReplayer(path string) (*Replayer, error) {
+	cassette, err := LoadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayer(*cassette), nil
+}
+
+func (r *Replayer) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.Body != nil {
+		request.Body.Close()
+	}
+	key := requestKey(request.Method, request.URL.String())
+
+	r.mu.Lock()
+	match := -1
+	for i, interaction := range r.interactions {
+		if requestKey(interaction.Request.Method, interaction.Request.URL) != key {
+			continue
+		}
+		match = i
+		if !r.used[i] {
+			break
+		}
+	}
+	if match >= 0 {
+		r.used[match] = true
+	}
+	r.mu.Unlock()
+
+	if match < 0 {
+		return nil, fmt.Errorf("httpfixture: no recorded response for %s", key)
+	}
+	recorded := r.interactions[match].Response
+	header := recorded.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+		StatusCode:    recorded.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(recorded.Body)),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       request,
+	}, nil
+}
+
+// Recording reports whether the fixture tests record instead of replaying.
+func Recording() bool {
+	return os.Getenv(ENV_RECORD) != ""
+}
+
+// ForTest returns the transport of a fixture test: the cassette at path is
+// replayed, or while Recording the real services are called and the cassette
+// is written at the end of the test. A test whose cassette was never recorded
+// is skipped.
+func ForTest(t testing.TB, path string) http.RoundTripper {
+	t.Helper()
+	if Recording() {
+		recorder := NewRecorder(nil)
+		t.Cleanup(func() {
+			if err := recorder.Save(path); err != nil {
+				t.Errorf("save fixture %s: %v", path, err)
+			}
+		})
+		return recorder
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		t.Skipf("fixture %s is not recorded, run the test with %s=1 to record it", path, ENV_RECORD)
+	}
+	replayer, err := LoadReplayer(path)
+	if err != nil {
+		t.Fatalf("load fixture %s: %v, run the test with %s=1 to record it", path, err, ENV_RECORD)
+	}
+	return replayer
+}
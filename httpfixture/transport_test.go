@@ -0,0 +1,92 @@
+package httpfixture
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func get(t *testing.T, client *http.Client, url string) (int, string) {
+	request, err := http.NewRequest("GET", url, nil)
+	require.NoError(t, err)
+	request.Header.Set("Authorization", "Bearer secret")
+	response, err := client.Do(request)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	return response.StatusCode, string(body)
+}
+
+func TestRecorder_SanitizesAndReplays(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+		fmt.Fprintf(w, `{"page":%d,"path":"%s"}`, calls, r.URL.Path)
+	}))
+	defer server.Close()
+
+	recorder := NewRecorder(nil)
+	client := &http.Client{Transport: recorder}
+	get(t, client, server.URL+"/tweets?id=1&api_key=secret")
+	get(t, client, server.URL+"/tweets?id=1&api_key=secret")
+	get(t, client, server.URL+"/users")
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	require.NoError(t, recorder.Save(path))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+	assert.Contains(t, string(data), `"page": 1`, "JSON bodies are stored as JSON")
+
+	replayer, err := LoadReplayer(path)
+	require.NoError(t, err)
+	client = &http.Client{Transport: replayer}
+	// the host and the order of the query are not part of the match
+	_, body := get(t, client, "https://other.host/tweets?api_key=another&id=1")
+	assert.JSONEq(t, `{"page":1,"path":"/tweets"}`, body)
+	_, body = get(t, client, "https://other.host/tweets?id=1&api_key=another")
+	assert.JSONEq(t, `{"page":2,"path":"/tweets"}`, body)
+	_, body = get(t, client, "https://other.host/tweets?id=1&api_key=another")
+	assert.JSONEq(t, `{"page":2,"path":"/tweets"}`, body, "the last response is repeated")
+	status, body := get(t, client, "https://other.host/users")
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"page":3,"path":"/users"}`, body)
+	assert.Equal(t, 3, calls)
+
+	_, err = client.Get("https://other.host/tweets?id=2")
+	require.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "no recorded response for GET /tweets?id=2"), err.Error())
+}
+
+func TestBody_KeepsTextPayloads(t *testing.T) {
+	cassette := Cassette{Interactions: []Interaction{{
+		Request:  Request{Method: "POST", URL: "https://host/form", Body: Body("a=1&b=2")},
+		Response: Response{StatusCode: http.StatusTooManyRequests, Body: Body("rate limited\n")},
+	}}}
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	require.NoError(t, cassette.Save(path))
+
+	loaded, err := LoadCassette(path)
+	require.NoError(t, err)
+	assert.Equal(t, cassette, *loaded)
+}
+
+func TestForTest_SkipsUnrecordedFixture(t *testing.T) {
+	t.Setenv(ENV_RECORD, "")
+	skipped := false
+	t.Run("unrecorded", func(t *testing.T) {
+		defer func() { skipped = t.Skipped() }()
+		ForTest(t, filepath.Join(t.TempDir(), "missing.json"))
+	})
+	assert.True(t, skipped)
+}
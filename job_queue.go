@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/grutapig/hackaton/twitterapi"
+)
+
+const JOB_MAX_ATTEMPTS = 3
+const JOB_LEASE_DURATION = 15 * time.Minute
+const JOB_LEASE_HEARTBEAT = JOB_LEASE_DURATION / 3
+const JOB_RETRY_DELAY = 30 * time.Second
+const JOB_POLL_INTERVAL = 5 * time.Second
+const JOB_DONE_RETENTION = 7 * 24 * time.Hour
+const JOB_DEAD_LIST_LIMIT = 5
+
+// JobQueue is the persistent queue of first and second step analyses. Jobs are
+// leased by the stage worker, failed jobs are retried with a growing delay and
+// moved to the dead letter after JOB_MAX_ATTEMPTS. The lease of a job is renewed
+// until it is finished, so an analysis running longer than JOB_LEASE_DURATION is
+// not leased a second time.
+type JobQueue struct {
+	dbService *DatabaseService
+	wake      map[string]chan struct{}
+	purgeMu   sync.Mutex
+	lastPurge time.Time
+
+	heldMu    sync.Mutex
+	held      map[uint]chan struct{}
+	heartbeat time.Duration
+}
+
+type JobStageStats struct {
+	Stage         string
+	Pending       int64
+	Leased        int64
+	Dead          int64
+	Deferred      int64
+	OldestPending *time.Time
+}
+
+func NewJobQueue(dbService *DatabaseService) *JobQueue {
+	return &JobQueue{
+		dbService: dbService,
+		wake: map[string]chan struct{}{
+			JOB_STAGE_FIRST_STEP:  make(chan struct{}, 1),
+			JOB_STAGE_SECOND_STEP: make(chan struct{}, 1),
+		},
+		held:      make(map[uint]chan struct{}),
+		heartbeat: JOB_LEASE_HEARTBEAT,
+	}
+}
+
+func (q *JobQueue) Enqueue(stage string, message twitterapi.NewMessage) error {
+	if err := q.create(stage, JOB_STATUS_PENDING, message); err != nil {
+		return err
+	}
+	q.wakeStage(stage)
+	return nil
+}
+
+// Defer stores the message as a job of the stage which is not worked until
+// ReleaseDeferred. A tweet already deferred for the stage is not added again.
+func (q *JobQueue) Defer(stage string, message twitterapi.NewMessage) error {
+	deferred, err := q.dbService.HasDeferredAnalysisJob(stage, message.TweetID)
+	if err != nil {
+		return fmt.Errorf("defer %s job: %w", stage, err)
+	}
+	if deferred {
+		return nil
+	}
+	return q.create(stage, JOB_STATUS_DEFERRED, message)
+}
+
+// ReleaseDeferred returns the deferred jobs of the stage to the queue.
+func (q *JobQueue) ReleaseDeferred(stage string) (int64, error) {
+	released, err := q.dbService.ReleaseDeferredAnalysisJobs(stage)
+	if err == nil && released > 0 {
+		q.wakeStage(stage)
+	}
+	return released, err
+}
+
+func (q *JobQueue) create(stage, status string, message twitterapi.NewMessage) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("encode %s job: %w", stage, err)
+	}
+	job := &AnalysisJobModel{
+		Stage:       stage,
+		Status:      status,
+		UserID:      message.Author.ID,
+		Username:    message.Author.UserName,
+		TweetID:     message.TweetID,
+		Payload:     string(payload),
+		AvailableAt: time.Now(),
+	}
+	if err := q.dbService.CreateAnalysisJob(job); err != nil {
+		return fmt.Errorf("enqueue %s job: %w", stage, err)
+	}
+	return nil
+}
+
+func (q *JobQueue) wakeStage(stage string) {
+	select {
+	case q.wake[stage] <- struct{}{}:
+	default:
+	}
+}
+
+// Recover returns the jobs interrupted by a crash or restart to the queue and
+// purges old finished jobs.
+func (q *JobQueue) Recover() error {
+	recovered, dead, err := q.dbService.RecoverLeasedAnalysisJobs(JOB_MAX_ATTEMPTS)
+	if err != nil {
+		return err
+	}
+	if recovered > 0 || dead > 0 {
+		log.Printf("Job queue: resumed %d interrupted jobs, %d moved to dead letter", recovered, dead)
+	}
+	q.purge()
+	return nil
+}
+
+// Work leases and handles jobs of the stage until ctx is done. The job being
+// handled is finished first, pending jobs stay in the queue for the next start.
+func (q *JobQueue) Work(ctx context.Context, stage string, handle func(message twitterapi.NewMessage) error) {
+	for ctx.Err() == nil {
+		job, message, err := q.Lease(stage)
+		if err != nil {
+			log.Printf("Job queue: error leasing %s job: %v", stage, err)
+		}
+		if job == nil {
+			q.WaitForJob(ctx, stage)
+			continue
+		}
+		q.Finish(job, runJob(func() error { return handle(message) }))
+	}
+}
+
+// Lease returns the next available job of the stage with its message, or nil
+// when there is none. The jobs this queue still holds are skipped even when
+// their lease ran out. Jobs with an unreadable payload are moved to the dead
+// letter.
+func (q *JobQueue) Lease(stage string) (*AnalysisJobModel, twitterapi.NewMessage, error) {
+	for {
+		var message twitterapi.NewMessage
+		job, err := q.dbService.LeaseAnalysisJob(stage, time.Now().Add(JOB_LEASE_DURATION), q.heldJobs()...)
+		if err != nil || job == nil {
+			return nil, message, err
+		}
+		q.hold(job.ID)
+		if err := json.Unmarshal([]byte(job.Payload), &message); err != nil {
+			job.Attempts = JOB_MAX_ATTEMPTS
+			q.Finish(job, fmt.Errorf("decode payload: %w", err))
+			continue
+		}
+		return job, message, nil
+	}
+}
+
+func (q *JobQueue) heldJobs() []uint {
+	q.heldMu.Lock()
+	defer q.heldMu.Unlock()
+	ids := make([]uint, 0, len(q.held))
+	for id := range q.held {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// hold renews the lease of the job every heartbeat until it is released.
+func (q *JobQueue) hold(id uint) {
+	stop := make(chan struct{})
+	q.heldMu.Lock()
+	q.held[id] = stop
+	q.heldMu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(q.heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			// renewed under the lock so Finish never sees a renewal after release
+			q.heldMu.Lock()
+			if _, held := q.held[id]; held {
+				if err := q.dbService.RenewAnalysisJobLease(id, time.Now().Add(JOB_LEASE_DURATION)); err != nil {
+					log.Printf("Job queue: error renewing the lease of job %d: %v", id, err)
+				}
+			}
+			q.heldMu.Unlock()
+		}
+	}()
+}
+
+func (q *JobQueue) release(id uint) {
+	q.heldMu.Lock()
+	defer q.heldMu.Unlock()
+	if stop, held := q.held[id]; held {
+		close(stop)
+		delete(q.held, id)
+	}
+}
+
+// WaitForJob blocks until a job of the stage is enqueued, the poll interval
+// passes or ctx is done.
+func (q *JobQueue) WaitForJob(ctx context.Context, stage string) {
+	select {
+	case <-ctx.Done():
+	case <-q.wake[stage]:
+	case <-time.After(JOB_POLL_INTERVAL):
+		q.purge()
+	}
+}
+
+// runJob turns a panic of the handler into a job error.
+func runJob(handle func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handle()
+}
+
+func (q *JobQueue) Finish(job *AnalysisJobModel, err error) {
+	q.release(job.ID)
+	job.LeaseUntil = nil
+	switch {
+	case err == nil:
+		job.Status = JOB_STATUS_DONE
+		job.LastError = ""
+	case errors.Is(err, context.Canceled):
+		// interrupted by shutdown, the attempt is not counted
+		job.Status = JOB_STATUS_PENDING
+		job.Attempts--
+		job.AvailableAt = time.Now()
+		log.Printf("Job queue: %s job %d for %s interrupted, kept for the next start", job.Stage, job.ID, job.Username)
+	case job.Attempts >= JOB_MAX_ATTEMPTS:
+		job.Status = JOB_STATUS_DEAD
+		job.LastError = err.Error()
+		log.Printf("Job queue: %s job %d for %s moved to dead letter after %d attempts: %v", job.Stage, job.ID, job.Username, job.Attempts, err)
+	default:
+		job.Status = JOB_STATUS_PENDING
+		job.LastError = err.Error()
+		job.AvailableAt = time.Now().Add(JOB_RETRY_DELAY * time.Duration(job.Attempts))
+		log.Printf("Job queue: %s job %d for %s failed (attempt %d/%d), retrying at %s: %v", job.Stage, job.ID, job.Username, job.Attempts, JOB_MAX_ATTEMPTS, job.AvailableAt.Format("15:04:05"), err)
+	}
+	if err := q.dbService.UpdateAnalysisJob(job); err != nil {
+		log.Printf("Job queue: error saving %s job %d: %v", job.Stage, job.ID, err)
+	}
+}
+
+func (q *JobQueue) purge() {
+	q.purgeMu.Lock()
+	defer q.purgeMu.Unlock()
+	if time.Since(q.lastPurge) < time.Hour {
+		return
+	}
+	q.lastPurge = time.Now()
+	deleted, err := q.dbService.DeleteDoneAnalysisJobs(time.Now().Add(-JOB_DONE_RETENTION))
+	if err != nil {
+		log.Printf("Job queue: error purging done jobs: %v", err)
+	} else if deleted > 0 {
+		log.Printf("Job queue: purged %d done jobs", deleted)
+	}
+}
+
+func (q *JobQueue) Stats() ([]JobStageStats, error) {
+	stats := []JobStageStats{}
+	for _, stage := range []string{JOB_STAGE_FIRST_STEP, JOB_STAGE_SECOND_STEP} {
+		stageStats := JobStageStats{Stage: stage}
+		counts := map[string]*int64{
+			JOB_STATUS_PENDING:  &stageStats.Pending,
+			JOB_STATUS_LEASED:   &stageStats.Leased,
+			JOB_STATUS_DEAD:     &stageStats.Dead,
+			JOB_STATUS_DEFERRED: &stageStats.Deferred,
+		}
+		for status, count := range counts {
+			value, err := q.dbService.CountAnalysisJobs(stage, status)
+			if err != nil {
+				return nil, err
+			}
+			*count = value
+		}
+		if stageStats.Pending > 0 {
+			if oldest, err := q.dbService.GetOldestPendingAnalysisJob(stage); err == nil {
+				stageStats.OldestPending = &oldest.CreatedAt
+			}
+		}
+		stats = append(stats, stageStats)
+	}
+	return stats, nil
+}
+
+func (q *JobQueue) DeadJobs(limit int) ([]AnalysisJobModel, error) {
+	return q.dbService.GetDeadAnalysisJobs(limit)
+}
+
+func (q *JobQueue) RetryDead() (int64, error) {
+	retried, err := q.dbService.RetryDeadAnalysisJobs()
+	if err == nil && retried > 0 {
+		for stage := range q.wake {
+			q.wakeStage(stage)
+		}
+	}
+	return retried, err
+}
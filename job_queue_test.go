@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/grutapig/hackaton/twitterapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newJobMessage(tweetID, username string) twitterapi.NewMessage {
+	message := twitterapi.NewMessage{TweetID: tweetID, Text: "text of " + tweetID}
+	message.Author.ID = "id_" + username
+	message.Author.UserName = username
+	return message
+}
+
+func TestJobQueue_RecoverAndWork(t *testing.T) {
+	dbService := setupTestDB(t)
+	jobQueue := NewJobQueue(dbService)
+
+	require.NoError(t, jobQueue.Enqueue(JOB_STAGE_FIRST_STEP, newJobMessage("1", "alice")))
+	require.NoError(t, jobQueue.Enqueue(JOB_STAGE_FIRST_STEP, newJobMessage("2", "bob")))
+
+	// a job leased by a crashed run is resumed on startup
+	crashed, err := dbService.LeaseAnalysisJob(JOB_STAGE_FIRST_STEP, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.NotNil(t, crashed)
+	require.NoError(t, jobQueue.Recover())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handled := []string{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		jobQueue.Work(ctx, JOB_STAGE_FIRST_STEP, func(message twitterapi.NewMessage) error {
+			handled = append(handled, message.TweetID)
+			if len(handled) == 2 {
+				cancel()
+			}
+			return nil
+		})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		cancel()
+		t.Fatal("worker did not handle the queued jobs")
+	}
+	assert.Equal(t, []string{"1", "2"}, handled)
+
+	stats, err := jobQueue.Stats()
+	require.NoError(t, err)
+	require.Len(t, stats, 2)
+	assert.Equal(t, JobStageStats{Stage: JOB_STAGE_FIRST_STEP}, stats[0])
+}
+
+func TestJobQueue_RetryAndDeadLetter(t *testing.T) {
+	dbService := setupTestDB(t)
+	jobQueue := NewJobQueue(dbService)
+	require.NoError(t, jobQueue.Enqueue(JOB_STAGE_SECOND_STEP, newJobMessage("1", "alice")))
+
+	lease := func() *AnalysisJobModel {
+		job, err := dbService.LeaseAnalysisJob(JOB_STAGE_SECOND_STEP, time.Now().Add(time.Minute))
+		require.NoError(t, err)
+		return job
+	}
+
+	// interrupted by shutdown, the attempt is not counted
+	job := lease()
+	require.NotNil(t, job)
+	jobQueue.Finish(job, fmt.Errorf("request: %w", context.Canceled))
+	assert.Equal(t, JOB_STATUS_PENDING, job.Status)
+	assert.Equal(t, 0, job.Attempts)
+
+	for attempt := 1; attempt <= JOB_MAX_ATTEMPTS; attempt++ {
+		job = lease()
+		require.NotNil(t, job, "attempt %d", attempt)
+		jobQueue.Finish(job, errors.New("overloaded"))
+		if attempt < JOB_MAX_ATTEMPTS {
+			assert.Equal(t, JOB_STATUS_PENDING, job.Status)
+			assert.Nil(t, lease(), "retry must wait for the delay")
+			job.AvailableAt = time.Now()
+			require.NoError(t, dbService.UpdateAnalysisJob(job))
+		}
+	}
+	assert.Equal(t, JOB_STATUS_DEAD, job.Status)
+	assert.Nil(t, lease())
+
+	stats, err := jobQueue.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats[1].Dead)
+	dead, err := jobQueue.DeadJobs(JOB_DEAD_LIST_LIMIT)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, "overloaded", dead[0].LastError)
+
+	retried, err := jobQueue.RetryDead()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), retried)
+	job = lease()
+	require.NotNil(t, job)
+	assert.Equal(t, 1, job.Attempts)
+	assert.Equal(t, "alice", job.Username)
+}
+
+func TestJobQueue_RenewsLeaseWhileHeld(t *testing.T) {
+	dbService := setupTestDB(t)
+	jobQueue := NewJobQueue(dbService)
+	jobQueue.heartbeat = 10 * time.Millisecond
+	require.NoError(t, jobQueue.Enqueue(JOB_STAGE_SECOND_STEP, newJobMessage("1", "alice")))
+
+	job, _, err := jobQueue.Lease(JOB_STAGE_SECOND_STEP)
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	leaseUntil := func() *time.Time {
+		var stored AnalysisJobModel
+		require.NoError(t, dbService.db.First(&stored, job.ID).Error)
+		return stored.LeaseUntil
+	}
+
+	// a run longer than the lease keeps the job
+	require.NoError(t, dbService.db.Model(&AnalysisJobModel{}).Where("id = ?", job.ID).Update("lease_until", time.Now().Add(-time.Minute)).Error)
+	again, _, err := jobQueue.Lease(JOB_STAGE_SECOND_STEP)
+	require.NoError(t, err)
+	assert.Nil(t, again, "a job held by this queue is not leased twice")
+	require.Eventually(t, func() bool {
+		return leaseUntil().After(time.Now().Add(JOB_LEASE_DURATION / 2))
+	}, time.Second, 5*time.Millisecond)
+	other, err := dbService.LeaseAnalysisJob(JOB_STAGE_SECOND_STEP, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Nil(t, other, "the renewed lease keeps other workers off")
+
+	jobQueue.Finish(job, nil)
+	time.Sleep(30 * time.Millisecond)
+	assert.Nil(t, leaseUntil(), "a finished job is not renewed")
+	assert.Empty(t, jobQueue.heldJobs())
+}
+
+func TestJobQueue_DeferAndRelease(t *testing.T) {
+	dbService := setupTestDB(t)
+	jobQueue := NewJobQueue(dbService)
+	require.NoError(t, jobQueue.Defer(JOB_STAGE_SECOND_STEP, newJobMessage("1", "alice")))
+	require.NoError(t, jobQueue.Defer(JOB_STAGE_SECOND_STEP, newJobMessage("1", "alice")))
+	require.NoError(t, jobQueue.Defer(JOB_STAGE_FIRST_STEP, newJobMessage("2", "bob")))
+
+	stats, err := jobQueue.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats[0].Deferred)
+	assert.Equal(t, int64(1), stats[1].Deferred, "a tweet is deferred once per stage")
+	assert.Zero(t, stats[1].Pending)
+
+	job, err := dbService.LeaseAnalysisJob(JOB_STAGE_SECOND_STEP, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Nil(t, job, "deferred jobs are not worked")
+
+	// deferred jobs survive a restart
+	require.NoError(t, NewJobQueue(dbService).Recover())
+	released, err := jobQueue.ReleaseDeferred(JOB_STAGE_SECOND_STEP)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), released)
+
+	job, err = dbService.LeaseAnalysisJob(JOB_STAGE_SECOND_STEP, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	assert.Equal(t, "alice", job.Username)
+	stats, err = jobQueue.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats[0].Deferred, "the other stage stays deferred")
+}
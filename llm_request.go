@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/grutapig/hackaton/claude"
+)
+
+type AIRequestMeta struct {
+	RequestUUID string
+	UserID      string
+	Username    string
+	TweetID     string
+	RequestType string
+	StepNumber  int
+	// see Prompt.Label
+	PromptVersion string
+}
+
+type requestUUIDKey struct{}
+
+// WithRequestUUID tags the calls made with ctx with the analysis request, the
+// twitterapi.io credits are stored per request with it.
+func WithRequestUUID(ctx context.Context, requestUUID string) context.Context {
+	return context.WithValue(ctx, requestUUIDKey{}, requestUUID)
+}
+
+// RequestUUIDFromContext returns the analysis request of ctx, empty for the
+// calls made outside an analysis.
+func RequestUUIDFromContext(ctx context.Context) string {
+	requestUUID, _ := ctx.Value(requestUUIDKey{}).(string)
+	return requestUUID
+}
+
+// sendStructuredAIRequest asks the llm client for a validated tool call decoded into
+// target. Every attempt (throttled, retried and repair re-asks) is priced by the
+// budget and stored in the ai request log.
+func sendStructuredAIRequest(ctx context.Context, llmClient claude.LLMClient, loggingService *LoggingService, budget *AIBudgetService, meta AIRequestMeta, request claude.StructuredRequest, target interface{}) (*claude.ClaudeMessageResponse, error) {
+	ctx = withAIRequestAccounting(ctx, llmClient, loggingService, budget, meta, request.Messages)
+	if request.MaxRepairs == 0 {
+		request.MaxRepairs = claude.DEFAULT_MAX_REPAIRS
+	}
+	return claude.SendStructured(ctx, llmClient, request, target)
+}
+
+func withAIRequestAccounting(ctx context.Context, llmClient claude.LLMClient, loggingService *LoggingService, budget *AIBudgetService, meta AIRequestMeta, requestData interface{}) context.Context {
+	if loggingService == nil && budget == nil {
+		return ctx
+	}
+
+	attemptNumber := 0
+	return claude.WithAttemptRecorder(ctx, func(attempt claude.Attempt) {
+		attemptNumber++
+		errorMessage := ""
+		if attempt.Err != nil {
+			errorMessage = attempt.Err.Error()
+			log.Printf("AI request %s attempt %d failed (status %d): %s", meta.RequestUUID, attemptNumber, attempt.StatusCode, attempt.Err)
+		}
+
+		usage := AIUsage{Model: llmClient.GetModel()}
+		if attempt.Response != nil {
+			if attempt.Response.Model != "" {
+				usage.Model = attempt.Response.Model
+			}
+			usage.InputTokens = attempt.Response.Usage.InputTokens
+			usage.OutputTokens = attempt.Response.Usage.OutputTokens
+			usage.CacheWrite = attempt.Response.Usage.CacheCreationInputTokens
+			usage.CacheRead = attempt.Response.Usage.CacheReadInputTokens
+			if budget != nil {
+				usage = budget.RecordUsage(meta.RequestType, meta.UserID, meta.Username, usage.Model, attempt.Response.Usage)
+			}
+		}
+
+		if loggingService == nil || meta.RequestUUID == "" {
+			return
+		}
+		err := loggingService.LogAIRequest(meta.RequestUUID, meta.UserID, meta.Username, meta.TweetID, meta.RequestType, meta.PromptVersion, meta.StepNumber, attemptNumber, requestData, attempt.Response, usage, int(attempt.Duration.Milliseconds()), attempt.Err == nil, errorMessage)
+		if err != nil {
+			log.Printf("Error logging AI request: %v", err)
+		}
+	})
+}
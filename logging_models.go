@@ -44,15 +44,23 @@ func (UserActivityLogModel) TableName() string {
 type AIRequestLogModel struct {
 	gorm.Model
 	ID             uint      `gorm:"primaryKey;autoIncrement" json:"id"`
-	RequestUUID    string    `gorm:"column:request_uuid;uniqueIndex" json:"request_uuid"`
+	RequestUUID    string    `gorm:"column:request_uuid;uniqueIndex:idx_ai_request_logs_uuid_attempt" json:"request_uuid"`
+	Attempt        int       `gorm:"column:attempt;default:1;uniqueIndex:idx_ai_request_logs_uuid_attempt" json:"attempt"`
 	StepNumber     int       `gorm:"column:step_number;index" json:"step_number"`
 	RequestType    string    `gorm:"column:request_type;index" json:"request_type"`
+	PromptVersion  string    `gorm:"column:prompt_version;index" json:"prompt_version,omitempty"`
 	UserID         string    `gorm:"column:user_id;index" json:"user_id"`
 	Username       string    `gorm:"column:username;index" json:"username"`
 	TweetID        string    `gorm:"column:tweet_id;index" json:"tweet_id"`
 	RequestData    string    `gorm:"column:request_data" json:"request_data"`
 	ResponseData   string    `gorm:"column:response_data" json:"response_data"`
+	ModelName      string    `gorm:"column:model;index" json:"model"`
+	InputTokens    int       `gorm:"column:input_tokens" json:"input_tokens"`
+	OutputTokens   int       `gorm:"column:output_tokens" json:"output_tokens"`
+	CacheWrite     int       `gorm:"column:cache_creation_tokens" json:"cache_creation_tokens"`
+	CacheRead      int       `gorm:"column:cache_read_tokens" json:"cache_read_tokens"`
 	TokensUsed     int       `gorm:"column:tokens_used" json:"tokens_used"`
+	CostUSD        float64   `gorm:"column:cost_usd" json:"cost_usd"`
 	ProcessingTime int       `gorm:"column:processing_time" json:"processing_time"`
 	IsSuccess      bool      `gorm:"column:is_success" json:"is_success"`
 	ErrorMessage   string    `gorm:"column:error_message" json:"error_message,omitempty"`
@@ -64,6 +72,90 @@ func (AIRequestLogModel) TableName() string {
 	return "ai_request_logs"
 }
 
+type AIUsageDailyModel struct {
+	gorm.Model
+	ID           uint    `gorm:"primaryKey;autoIncrement" json:"id"`
+	Day          string  `gorm:"column:day;uniqueIndex:idx_ai_usage_daily_key" json:"day"`
+	Stage        string  `gorm:"column:stage;uniqueIndex:idx_ai_usage_daily_key" json:"stage"`
+	UserID       string  `gorm:"column:user_id;uniqueIndex:idx_ai_usage_daily_key" json:"user_id"`
+	ModelName    string  `gorm:"column:model;uniqueIndex:idx_ai_usage_daily_key" json:"model"`
+	Username     string  `gorm:"column:username" json:"username"`
+	Requests     int     `gorm:"column:requests" json:"requests"`
+	InputTokens  int     `gorm:"column:input_tokens" json:"input_tokens"`
+	OutputTokens int     `gorm:"column:output_tokens" json:"output_tokens"`
+	CacheWrite   int     `gorm:"column:cache_creation_tokens" json:"cache_creation_tokens"`
+	CacheRead    int     `gorm:"column:cache_read_tokens" json:"cache_read_tokens"`
+	CostUSD      float64 `gorm:"column:cost_usd" json:"cost_usd"`
+}
+
+func (AIUsageDailyModel) TableName() string {
+	return "ai_usage_daily"
+}
+
+// AIUsage is the token usage and price of a single AI call.
+type AIUsage struct {
+	Model        string
+	InputTokens  int
+	OutputTokens int
+	CacheWrite   int
+	CacheRead    int
+	CostUSD      float64
+}
+
+type AIUsageSummary struct {
+	Stage        string  `json:"stage"`
+	Requests     int     `json:"requests"`
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	CacheWrite   int     `json:"cache_creation_tokens"`
+	CacheRead    int     `json:"cache_read_tokens"`
+	CostUSD      float64 `json:"cost_usd"`
+}
+
+// TwitterAPIUsageDailyModel counts the twitterapi.io calls and credits per
+// day and endpoint.
+type TwitterAPIUsageDailyModel struct {
+	gorm.Model
+	ID          uint   `gorm:"primaryKey;autoIncrement" json:"id"`
+	Day         string `gorm:"column:day;uniqueIndex:idx_twitter_api_usage_daily_key" json:"day"`
+	Endpoint    string `gorm:"column:endpoint;uniqueIndex:idx_twitter_api_usage_daily_key" json:"endpoint"`
+	Requests    int    `gorm:"column:requests" json:"requests"`
+	Credits     int    `gorm:"column:credits" json:"credits"`
+	RateLimited int    `gorm:"column:rate_limited" json:"rate_limited"`
+	Errors      int    `gorm:"column:errors" json:"errors"`
+}
+
+func (TwitterAPIUsageDailyModel) TableName() string {
+	return "twitter_api_usage_daily"
+}
+
+// TwitterAPIRequestUsageModel counts the twitterapi.io calls and credits of one
+// analysis request per endpoint, the request UUID is the one of the AI request
+// and data collection logs.
+type TwitterAPIRequestUsageModel struct {
+	gorm.Model
+	ID          uint   `gorm:"primaryKey;autoIncrement" json:"id"`
+	RequestUUID string `gorm:"column:request_uuid;uniqueIndex:idx_twitter_api_request_usage_key" json:"request_uuid"`
+	Endpoint    string `gorm:"column:endpoint;uniqueIndex:idx_twitter_api_request_usage_key" json:"endpoint"`
+	Requests    int    `gorm:"column:requests" json:"requests"`
+	Credits     int    `gorm:"column:credits" json:"credits"`
+	RateLimited int    `gorm:"column:rate_limited" json:"rate_limited"`
+	Errors      int    `gorm:"column:errors" json:"errors"`
+}
+
+func (TwitterAPIRequestUsageModel) TableName() string {
+	return "twitter_api_request_usage"
+}
+
+// TwitterAPIRequestCredits are the twitterapi.io calls and credits of one
+// analysis request with the user it analysed.
+type TwitterAPIRequestCredits struct {
+	RequestUUID string `json:"request_uuid"`
+	Username    string `json:"username"`
+	Requests    int    `json:"requests"`
+	Credits     int    `json:"credits"`
+}
+
 type DataCollectionLogModel struct {
 	gorm.Model
 	ID             uint      `gorm:"primaryKey;autoIncrement" json:"id"`
@@ -113,6 +205,8 @@ const (
 const (
 	REQUEST_TYPE_FIRST_STEP  = "first_step"
 	REQUEST_TYPE_SECOND_STEP = "second_step"
+	REQUEST_TYPE_BATCH       = "second_step_batch"
+	REQUEST_TYPE_TWITTER_BOT = "twitter_bot"
 )
 
 const (
@@ -4,10 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
@@ -35,10 +37,20 @@ func NewLoggingService(dbPath string) (*LoggingService, error) {
 }
 
 func (s *LoggingService) runMigrations() error {
+	// request_uuid used to be unique on its own, retried requests log one row per attempt
+	if s.db.Migrator().HasIndex(&AIRequestLogModel{}, "idx_ai_request_logs_request_uuid") {
+		if err := s.db.Migrator().DropIndex(&AIRequestLogModel{}, "idx_ai_request_logs_request_uuid"); err != nil {
+			return fmt.Errorf("failed to drop ai request uuid index: %w", err)
+		}
+	}
+
 	return s.db.AutoMigrate(
 		&MessageLogModel{},
 		&UserActivityLogModel{},
 		&AIRequestLogModel{},
+		&AIUsageDailyModel{},
+		&TwitterAPIUsageDailyModel{},
+		&TwitterAPIRequestUsageModel{},
 		&DataCollectionLogModel{},
 		&RequestProcessingLogModel{},
 	)
@@ -199,20 +211,28 @@ func (s *LoggingService) GetDailyUserActivityStats() ([]map[string]interface{},
 	return results, nil
 }
 
-func (s *LoggingService) LogAIRequest(requestUUID, userID, username, tweetID, requestType string, stepNumber int, requestData, responseData interface{}, tokensUsed, processingTime int, isSuccess bool, errorMessage string) error {
+func (s *LoggingService) LogAIRequest(requestUUID, userID, username, tweetID, requestType, promptVersion string, stepNumber, attempt int, requestData, responseData interface{}, usage AIUsage, processingTime int, isSuccess bool, errorMessage string) error {
 	requestJSON, _ := json.Marshal(requestData)
 	responseJSON, _ := json.Marshal(responseData)
 
 	aiLog := AIRequestLogModel{
 		RequestUUID:    requestUUID,
+		Attempt:        attempt,
 		StepNumber:     stepNumber,
 		RequestType:    requestType,
+		PromptVersion:  promptVersion,
 		UserID:         userID,
 		Username:       username,
 		TweetID:        tweetID,
 		RequestData:    string(requestJSON),
 		ResponseData:   string(responseJSON),
-		TokensUsed:     tokensUsed,
+		ModelName:      usage.Model,
+		InputTokens:    usage.InputTokens,
+		OutputTokens:   usage.OutputTokens,
+		CacheWrite:     usage.CacheWrite,
+		CacheRead:      usage.CacheRead,
+		TokensUsed:     usage.InputTokens + usage.CacheWrite + usage.CacheRead + usage.OutputTokens,
+		CostUSD:        usage.CostUSD,
 		ProcessingTime: processingTime,
 		IsSuccess:      isSuccess,
 		ErrorMessage:   errorMessage,
@@ -222,9 +242,188 @@ func (s *LoggingService) LogAIRequest(requestUUID, userID, username, tweetID, re
 	return s.db.Create(&aiLog).Error
 }
 
+// CountAIRequestsByPromptVersion returns the number of AI requests, retries
+// counted once, made with each prompt version. A request rendered over a base
+// prompt counts for the template and for the base.
+func (s *LoggingService) CountAIRequestsByPromptVersion() (map[string]int64, error) {
+	var rows []struct {
+		PromptVersion string
+		Requests      int64
+	}
+	err := s.db.Model(&AIRequestLogModel{}).
+		Select("prompt_version, COUNT(DISTINCT request_uuid) AS requests").
+		Where("prompt_version <> ''").
+		Group("prompt_version").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		for _, label := range SplitPromptLabel(row.PromptVersion) {
+			counts[label] += row.Requests
+		}
+	}
+	return counts, nil
+}
+
+func (s *LoggingService) AddAIUsage(day time.Time, stage, userID, username string, usage AIUsage) error {
+	record := AIUsageDailyModel{
+		Day:          day.Format("2006-01-02"),
+		Stage:        stage,
+		UserID:       userID,
+		ModelName:    usage.Model,
+		Username:     username,
+		Requests:     1,
+		InputTokens:  usage.InputTokens,
+		OutputTokens: usage.OutputTokens,
+		CacheWrite:   usage.CacheWrite,
+		CacheRead:    usage.CacheRead,
+		CostUSD:      usage.CostUSD,
+	}
+
+	return s.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "day"}, {Name: "stage"}, {Name: "user_id"}, {Name: "model"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"requests":              gorm.Expr("requests + 1"),
+			"input_tokens":          gorm.Expr("input_tokens + ?", usage.InputTokens),
+			"output_tokens":         gorm.Expr("output_tokens + ?", usage.OutputTokens),
+			"cache_creation_tokens": gorm.Expr("cache_creation_tokens + ?", usage.CacheWrite),
+			"cache_read_tokens":     gorm.Expr("cache_read_tokens + ?", usage.CacheRead),
+			"cost_usd":              gorm.Expr("cost_usd + ?", usage.CostUSD),
+			"username":              username,
+			"updated_at":            time.Now(),
+		}),
+	}).Create(&record).Error
+}
+
+// GetAICostBetween returns the summed cost of days in [fromDay, toDay].
+func (s *LoggingService) GetAICostBetween(fromDay, toDay time.Time) (float64, error) {
+	var cost float64
+	err := s.db.Model(&AIUsageDailyModel{}).
+		Where("day >= ? AND day <= ?", fromDay.Format("2006-01-02"), toDay.Format("2006-01-02")).
+		Select("COALESCE(SUM(cost_usd), 0)").
+		Scan(&cost).Error
+	return cost, err
+}
+
+func (s *LoggingService) GetAIUsageByStage(fromDay, toDay time.Time) ([]AIUsageSummary, error) {
+	var summaries []AIUsageSummary
+	err := s.db.Model(&AIUsageDailyModel{}).
+		Where("day >= ? AND day <= ?", fromDay.Format("2006-01-02"), toDay.Format("2006-01-02")).
+		Select("stage, SUM(requests) AS requests, SUM(input_tokens) AS input_tokens, SUM(output_tokens) AS output_tokens, SUM(cache_creation_tokens) AS cache_creation_tokens, SUM(cache_read_tokens) AS cache_read_tokens, SUM(cost_usd) AS cost_usd").
+		Group("stage").
+		Order("cost_usd DESC").
+		Scan(&summaries).Error
+	return summaries, err
+}
+
+func (s *LoggingService) GetTopAIUsers(fromDay, toDay time.Time, limit int) ([]AIUsageDailyModel, error) {
+	var users []AIUsageDailyModel
+	err := s.db.Model(&AIUsageDailyModel{}).
+		Where("day >= ? AND day <= ? AND user_id != ''", fromDay.Format("2006-01-02"), toDay.Format("2006-01-02")).
+		Select("user_id, MAX(username) AS username, SUM(requests) AS requests, SUM(input_tokens) AS input_tokens, SUM(output_tokens) AS output_tokens, SUM(cost_usd) AS cost_usd").
+		Group("user_id").
+		Order("cost_usd DESC").
+		Limit(limit).
+		Scan(&users).Error
+	return users, err
+}
+
+// AddTwitterAPIUsage counts one twitterapi.io call, it is set as the usage
+// function of the TwitterAPIService.
+func (s *LoggingService) AddTwitterAPIUsage(day time.Time, endpoint string, statusCode int, credits int) error {
+	rateLimited, errors := twitterAPICallOutcome(statusCode)
+	record := TwitterAPIUsageDailyModel{
+		Day:         day.Format("2006-01-02"),
+		Endpoint:    endpoint,
+		Requests:    1,
+		Credits:     credits,
+		RateLimited: rateLimited,
+		Errors:      errors,
+	}
+	return s.db.Clauses(twitterAPIUsageUpsert([]string{"day", "endpoint"}, credits, rateLimited, errors)).Create(&record).Error
+}
+
+// AddTwitterAPIRequestUsage counts one twitterapi.io call made for the
+// analysis request.
+func (s *LoggingService) AddTwitterAPIRequestUsage(requestUUID, endpoint string, statusCode int, credits int) error {
+	rateLimited, errors := twitterAPICallOutcome(statusCode)
+	record := TwitterAPIRequestUsageModel{
+		RequestUUID: requestUUID,
+		Endpoint:    endpoint,
+		Requests:    1,
+		Credits:     credits,
+		RateLimited: rateLimited,
+		Errors:      errors,
+	}
+	return s.db.Clauses(twitterAPIUsageUpsert([]string{"request_uuid", "endpoint"}, credits, rateLimited, errors)).Create(&record).Error
+}
+
+func twitterAPICallOutcome(statusCode int) (rateLimited int, errors int) {
+	if statusCode == http.StatusTooManyRequests {
+		return 1, 0
+	}
+	if statusCode != http.StatusOK {
+		return 0, 1
+	}
+	return 0, 0
+}
+
+func twitterAPIUsageUpsert(key []string, credits, rateLimited, errors int) clause.OnConflict {
+	columns := make([]clause.Column, 0, len(key))
+	for _, name := range key {
+		columns = append(columns, clause.Column{Name: name})
+	}
+	return clause.OnConflict{
+		Columns: columns,
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"requests":     gorm.Expr("requests + 1"),
+			"credits":      gorm.Expr("credits + ?", credits),
+			"rate_limited": gorm.Expr("rate_limited + ?", rateLimited),
+			"errors":       gorm.Expr("errors + ?", errors),
+			"updated_at":   time.Now(),
+		}),
+	}
+}
+
+func (s *LoggingService) GetTwitterAPIUsageByUUID(requestUUID string) ([]TwitterAPIRequestUsageModel, error) {
+	var usage []TwitterAPIRequestUsageModel
+	err := s.db.Where("request_uuid = ?", requestUUID).Order("endpoint ASC").Find(&usage).Error
+	return usage, err
+}
+
+// GetTopTwitterAPIRequests returns the analysis requests which spent the most
+// twitterapi.io credits between the two days.
+func (s *LoggingService) GetTopTwitterAPIRequests(fromDay, toDay time.Time, limit int) ([]TwitterAPIRequestCredits, error) {
+	from := time.Date(fromDay.Year(), fromDay.Month(), fromDay.Day(), 0, 0, 0, 0, fromDay.Location())
+	to := time.Date(toDay.Year(), toDay.Month(), toDay.Day()+1, 0, 0, 0, 0, toDay.Location())
+	var requests []TwitterAPIRequestCredits
+	err := s.db.Table("twitter_api_request_usage AS u").
+		Joins("LEFT JOIN request_processing_logs AS p ON p.request_uuid = u.request_uuid").
+		Where("u.created_at >= ? AND u.created_at < ?", from, to).
+		Select("u.request_uuid, MAX(p.username) AS username, SUM(u.requests) AS requests, SUM(u.credits) AS credits").
+		Group("u.request_uuid").
+		Order("credits DESC").
+		Limit(limit).
+		Scan(&requests).Error
+	return requests, err
+}
+
+func (s *LoggingService) GetTwitterAPIUsageByEndpoint(fromDay, toDay time.Time) ([]TwitterAPIUsageDailyModel, error) {
+	var usage []TwitterAPIUsageDailyModel
+	err := s.db.Model(&TwitterAPIUsageDailyModel{}).
+		Where("day >= ? AND day <= ?", fromDay.Format("2006-01-02"), toDay.Format("2006-01-02")).
+		Select("endpoint, SUM(requests) AS requests, SUM(credits) AS credits, SUM(rate_limited) AS rate_limited, SUM(errors) AS errors").
+		Group("endpoint").
+		Order("credits DESC").
+		Scan(&usage).Error
+	return usage, err
+}
+
 func (s *LoggingService) GetAIRequestsByUUID(requestUUID string) ([]AIRequestLogModel, error) {
 	var requests []AIRequestLogModel
-	err := s.db.Where("request_uuid = ?", requestUUID).Order("step_number ASC").Find(&requests).Error
+	err := s.db.Where("request_uuid = ?", requestUUID).Order("step_number ASC, attempt ASC").Find(&requests).Error
 	return requests, err
 }
 
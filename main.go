@@ -1,22 +1,25 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/grutapig/hackaton/claude"
 	"github.com/grutapig/hackaton/twitterapi"
-	"github.com/grutapig/hackaton/twitterapi_reverse"
+	"github.com/grutapig/hackaton/twittersource"
 	"github.com/joho/godotenv"
 	"log"
 	"os"
-	"time"
+	"os/signal"
+	"syscall"
 )
 
 const ENV_PROD_CONFIG = ".env"
 const ENV_DEV_CONFIG = ".dev.env"
 const PROMPT_FILE_STEP1 = "data/txt/prompt_simple.txt"
 const PROMPT_FILE_STEP2 = "data/txt/prompt2.txt"
+const PROMPTS_DIR = "data/prompts"
 
 func main() {
 
@@ -66,9 +69,18 @@ func main() {
 		panic(fmt.Sprintf("Failed to build container: %v", err))
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		// a second signal kills the process without waiting for the drain
+		stop()
+	}()
+
+	restart := false
 	err = container.Invoke(func(app *Application) {
 
-		if err := app.Initialize(); err != nil {
+		if err := app.Initialize(ctx); err != nil {
 			panic(fmt.Sprintf("Failed to initialize application: %v", err))
 		}
 
@@ -77,12 +89,17 @@ func main() {
 		if err := app.Run(); err != nil {
 			panic(fmt.Sprintf("Failed to run application: %v", err))
 		}
+		restart = app.RestartRequested()
 	})
 	if err != nil {
 		panic(fmt.Sprintf("Failed to invoke application: %v", err))
 	}
+	if restart {
+		log.Printf("Exiting with code %d for restart", EXIT_CODE_RESTART)
+		os.Exit(EXIT_CODE_RESTART)
+	}
 }
-func initializeData(dbService *DatabaseService, twitterApi *twitterapi.TwitterAPIService) {
+func initializeData(ctx context.Context, dbService *DatabaseService, source twittersource.Source, communities *CommunityService) {
 
 	csvPath := os.Getenv(ENV_IMPORT_CSV_PATH)
 	if csvPath != "" {
@@ -99,21 +116,39 @@ func initializeData(dbService *DatabaseService, twitterApi *twitterapi.TwitterAP
 		}
 	}
 
-	tweetCount, err := dbService.GetTweetCount()
+	enabled, err := communities.Enabled()
 	if err != nil {
-		log.Printf("Error getting tweet count: %v", err)
-		tweetCount = 0
+		log.Printf("Error getting communities: %v", err)
+		return
 	}
+	for _, community := range enabled {
+		communityDB := dbService.ForCommunity(community.ID)
+		tweetCount, err := communityDB.GetCommunityTweetCount()
+		if err != nil {
+			log.Printf("Error getting tweet count of community %s: %v", community.ID, err)
+			tweetCount = 0
+		}
 
-	if tweetCount < 10 {
-		log.Printf("Tweet count (%d) is less than 10, performing full community load...", tweetCount)
-		FullCommunityLoad(twitterApi, dbService)
-	} else {
-		log.Printf("Tweet count (%d) is >= 10, skipping full database initialization", tweetCount)
+		if tweetCount < 10 {
+			log.Printf("Community %s tweet count (%d) is less than 10, performing full community load...", community.DisplayName(), tweetCount)
+			FullCommunityLoad(ctx, source, communityDB, community.ID)
+		} else {
+			log.Printf("Community %s tweet count (%d) is >= 10, skipping full database initialization", community.DisplayName(), tweetCount)
+		}
 	}
 }
 
-func PrepareClaudeSecondStepRequest(userTickerData *UserTickerMentionsData, followers *twitterapi.UserFollowersResponse, followings *twitterapi.UserFollowingsResponse, dbService *DatabaseService, communityActivity *UserCommunityActivity) claude.ClaudeMessages {
+// PrepareClaudeSecondStepSystem renders the second step prompt into a static
+// cacheable prefix shared by every analysis and a short per-user suffix.
+func PrepareClaudeSecondStepSystem(prompt Prompt, systemTicker string, username string, isManualAnalysis bool) ([]claude.ContentBlock, error) {
+	return prompt.RenderBlocks(PromptVars{
+		Ticker:   systemTicker,
+		Username: username,
+		Manual:   isManualAnalysis,
+	})
+}
+
+func PrepareClaudeSecondStepRequest(userTickerData *UserTickerMentionsData, relations *UserRelationsData, dbService *DatabaseService, communityActivity *UserCommunityActivity) claude.ClaudeMessages {
 	claudeMessages := claude.ClaudeMessages{}
 
 	if userTickerData != nil {
@@ -130,15 +165,9 @@ func PrepareClaudeSecondStepRequest(userTickerData *UserTickerMentionsData, foll
 	}
 
 	allFriends := make([]string, 0)
-	if followers != nil {
-		for _, follower := range followers.Followers {
-			allFriends = append(allFriends, follower.UserName)
-		}
-	}
-	if followings != nil {
-		for _, following := range followings.Followings {
-			allFriends = append(allFriends, following.UserName)
-		}
+	if relations != nil {
+		allFriends = append(allFriends, relations.Followers...)
+		allFriends = append(allFriends, relations.Followings...)
 	}
 
 	if len(allFriends) > 0 {
@@ -150,6 +179,9 @@ func PrepareClaudeSecondStepRequest(userTickerData *UserTickerMentionsData, foll
 			"fud_percentage":      float64(fudFriends) / float64(totalFriends) * 100,
 			"fud_friends_details": fudFriendsList,
 		}
+		if len(relations.FUDRelations) > 0 {
+			friendsAnalysis["fud_relations_history"] = relations.FUDRelations
+		}
 
 		friendsJSON, _ := json.Marshal(friendsAnalysis)
 		claudeMessages = append(claudeMessages, claude.ClaudeMessage{
@@ -179,22 +211,6 @@ func PrepareClaudeSecondStepRequest(userTickerData *UserTickerMentionsData, foll
 	return claudeMessages
 }
 
-type FirstStepClaudeResponse struct {
-	IsFud bool `json:"is_fud"`
-	//FudProbability float64 `json:"fud_probability"`
-	//Reason         string  `json:"reason"`
-}
-type SecondStepClaudeResponse struct {
-	IsFUDAttack    bool     `json:"is_fud_attack"`
-	IsFUDUser      bool     `json:"is_fud_user"`
-	FUDProbability float64  `json:"fud_probability"`
-	FUDType        string   `json:"fud_type"`
-	UserRiskLevel  string   `json:"user_risk_level"`
-	KeyEvidence    []string `json:"key_evidence"`
-	DecisionReason string   `json:"decision_reason"`
-	UserSummary    string   `json:"user_summary"`
-}
-
 type UserTickerMentionsData struct {
 	UserMessages    []UserMessageWithReplies `json:"user_messages"`
 	TotalMessages   int                      `json:"total_messages"`
@@ -217,87 +233,69 @@ type ReplyTweet struct {
 	Author    string `json:"author"`
 }
 
-func getUserTickerMentions(twitterApi *twitterapi.TwitterAPIService, username string, ticker string, dbService *DatabaseService) *UserTickerMentionsData {
+func getUserTickerMentions(ctx context.Context, source twittersource.Source, username string, ticker string, dbService *DatabaseService) *UserTickerMentionsData {
 	const MAX_PAGES = 3
 	const TOKEN_LIMIT = 50000
 
 	userMessages := []UserMessageWithReplies{}
-	cursor := ""
-	totalPages := 0
 	replyTweetIDs := []string{}
 
-	for totalPages < MAX_PAGES {
-		searchResponse, err := twitterApi.AdvancedSearch(twitterapi.AdvancedSearchRequest{
-			Query:     fmt.Sprintf("%s from:%s", ticker, username),
-			QueryType: twitterapi.LATEST,
-			Cursor:    cursor,
-		})
+	searchQuery := fmt.Sprintf("%s from:%s", ticker, username)
+	tweets, err := twittersource.SearchPages(source, searchQuery, twittersource.PageOptions{MaxPages: MAX_PAGES}).All(ctx)
+	if err != nil {
+		log.Printf("Error fetching user ticker mentions: %v", err)
+	}
 
-		if err != nil {
-			log.Printf("Error fetching user ticker mentions: %v", err)
-			break
-		}
+	for _, tweet := range tweets {
 
-		for _, tweet := range searchResponse.Tweets {
-
-			searchQuery := fmt.Sprintf("%s from:%s", ticker, username)
-			storeTweetAndUserWithSource(dbService, tweet, TWEET_SOURCE_TICKER_SEARCH, ticker, searchQuery)
-
-			if !dbService.TickerOpinionExists(tweet.Id) {
-				tweetCreatedAt, _ := time.Parse(time.RFC3339, tweet.CreatedAt)
-				opinion := UserTickerOpinionModel{
-					UserID:         tweet.Author.Id,
-					Username:       tweet.Author.UserName,
-					Ticker:         ticker,
-					TweetID:        tweet.Id,
-					Text:           tweet.Text,
-					TweetCreatedAt: tweetCreatedAt,
-					InReplyToID:    tweet.InReplyToId,
-					SearchQuery:    searchQuery,
-				}
+		storeTweetAndUserWithSource(dbService, tweet, TWEET_SOURCE_TICKER_SEARCH, ticker, searchQuery)
 
-				err := dbService.SaveUserTickerOpinion(opinion)
-				if err != nil {
-					log.Printf("Failed to save ticker opinion for tweet %s: %v", tweet.Id, err)
-				}
-			}
-
-			userMessage := UserMessageWithReplies{
-				TweetID:     tweet.Id,
-				CreatedAt:   tweet.CreatedAt,
-				Text:        tweet.Text,
-				InReplyToID: tweet.InReplyToId,
+		if !dbService.TickerOpinionExists(tweet.ID) {
+			opinion := UserTickerOpinionModel{
+				UserID:         tweet.Author.ID,
+				Username:       tweet.Author.Username,
+				Ticker:         ticker,
+				TweetID:        tweet.ID,
+				Text:           tweet.Text,
+				TweetCreatedAt: tweet.CreatedAt,
+				InReplyToID:    tweet.InReplyToID,
+				SearchQuery:    searchQuery,
 			}
 
-			if tweet.InReplyToId != "" {
-				replyTweetIDs = append(replyTweetIDs, tweet.InReplyToId)
+			err := dbService.SaveUserTickerOpinion(opinion)
+			if err != nil {
+				log.Printf("Failed to save ticker opinion for tweet %s: %v", tweet.ID, err)
 			}
-
-			userMessages = append(userMessages, userMessage)
 		}
 
-		totalPages++
+		userMessage := UserMessageWithReplies{
+			TweetID:     tweet.ID,
+			CreatedAt:   tweet.CreatedAt.Format(twittersource.TWITTER_TIME_LAYOUT),
+			Text:        tweet.Text,
+			InReplyToID: tweet.InReplyToID,
+		}
 
-		if !searchResponse.HasNextPage || searchResponse.NextCursor == "" {
-			break
+		if tweet.InReplyToID != "" {
+			replyTweetIDs = append(replyTweetIDs, tweet.InReplyToID)
 		}
-		cursor = searchResponse.NextCursor
+
+		userMessages = append(userMessages, userMessage)
 	}
 
 	if len(replyTweetIDs) > 0 {
-		repliedTweets, err := twitterApi.GetTweetsByIds(replyTweetIDs)
+		repliedTweets, err := source.TweetsByIDs(ctx, replyTweetIDs)
 		if err == nil {
 
 			replyMap := make(map[string]ReplyTweet)
-			for _, tweet := range repliedTweets.Tweets {
+			for _, tweet := range repliedTweets {
 
 				storeTweetAndUserWithSource(dbService, tweet, TWEET_SOURCE_CONTEXT, "", "context for "+username)
 
-				replyMap[tweet.Id] = ReplyTweet{
-					TweetID:   tweet.Id,
-					CreatedAt: tweet.CreatedAt,
+				replyMap[tweet.ID] = ReplyTweet{
+					TweetID:   tweet.ID,
+					CreatedAt: tweet.CreatedAt.Format(twittersource.TWITTER_TIME_LAYOUT),
 					Text:      tweet.Text,
-					Author:    tweet.Author.UserName,
+					Author:    tweet.Author.Username,
 				}
 			}
 
@@ -341,37 +339,38 @@ func getUserTickerMentions(twitterApi *twitterapi.TwitterAPIService, username st
 	return result
 }
 
-func SendIfNotExistsTweetToChannel(tweet twitterapi.Tweet, newMessageCh chan twitterapi.NewMessage, tweetsExistsStorage map[string]int, parentTweet twitterapi.Tweet, grandParentTweet twitterapi.Tweet, loggingService *LoggingService) {
-	if _, ok := tweetsExistsStorage[tweet.Id]; !ok {
+func SendIfNotExistsTweetToChannel(tweet twittersource.Tweet, newMessageCh chan twitterapi.NewMessage, tweetsExistsStorage map[string]int, parentTweet twittersource.Tweet, grandParentTweet twittersource.Tweet, loggingService *LoggingService, communityID string, backfill bool) {
+	if _, ok := tweetsExistsStorage[tweet.ID]; !ok {
 		newMessage := twitterapi.NewMessage{
-			TweetID:      tweet.Id,
-			ReplyTweetID: tweet.InReplyToId,
+			TweetID:      tweet.ID,
+			ReplyTweetID: tweet.InReplyToID,
 			Author: struct {
 				UserName string
 				Name     string
 				ID       string
-			}{tweet.Author.UserName, tweet.Author.Name, tweet.Author.Id},
+			}{tweet.Author.Username, tweet.Author.Name, tweet.Author.ID},
 			ParentTweet: struct {
 				ID     string
 				Author string
 				Text   string
-			}{ID: parentTweet.Id, Author: parentTweet.Author.UserName, Text: parentTweet.Text},
+			}{ID: parentTweet.ID, Author: parentTweet.Author.Username, Text: parentTweet.Text},
 			GrandParentTweet: struct {
 				ID     string
 				Author string
 				Text   string
-			}{ID: grandParentTweet.Id, Author: grandParentTweet.Author.UserName, Text: grandParentTweet.Text},
-			Text:         tweet.Text,
-			CreatedAt:    tweet.CreatedAt,
-			ReplyCount:   tweet.ReplyCount,
-			LikeCount:    tweet.LikeCount,
-			RetweetCount: tweet.RetweetCount,
+			}{ID: grandParentTweet.ID, Author: grandParentTweet.Author.Username, Text: grandParentTweet.Text},
+			Text:            tweet.Text,
+			CreatedAt:       tweet.CreatedAt.Format(twittersource.TWITTER_TIME_LAYOUT),
+			CreatedAtParsed: tweet.CreatedAt,
+			ReplyCount:      tweet.ReplyCount,
+			LikeCount:       tweet.LikeCount,
+			RetweetCount:    tweet.RetweetCount,
+			CommunityID:     communityID,
+			Backfill:        backfill,
 		}
-		tweet.CreatedAtParsed, _ = twitterapi_reverse.ParseTwitterTime(tweet.CreatedAt)
-		newMessage.CreatedAtParsed = tweet.CreatedAtParsed
 
 		if loggingService != nil {
-			err := loggingService.LogMessage(tweet.Id, tweet.Author.Id, tweet.Author.UserName, tweet.Text, TWEET_SOURCE_COMMUNITY, tweet.CreatedAtParsed)
+			err := loggingService.LogMessage(tweet.ID, tweet.Author.ID, tweet.Author.Username, tweet.Text, TWEET_SOURCE_COMMUNITY, tweet.CreatedAt)
 			if err != nil {
 				log.Printf("Error logging message: %v", err)
 			}
@@ -1,446 +1,393 @@
 package main
 
 import (
+	"context"
 	"github.com/grutapig/hackaton/twitterapi"
-	"github.com/grutapig/hackaton/twitterapi_reverse"
+	"github.com/grutapig/hackaton/twittersource"
 	"log"
-	"os"
-	"strconv"
+	"sync"
 	"time"
 )
 
-func MonitoringHandler(twitterApi *twitterapi.TwitterAPIService, newMessageCh chan twitterapi.NewMessage, dbService *DatabaseService, loggingService *LoggingService) {
+const MONITORING_COMMUNITY_REFRESH = time.Minute
+const MONITORING_INTERVAL = 30 * time.Second
+const MONITORING_BACKFILL_PAGES = 3
+const MONITORING_REPLY_PAGES = 5
+
+// MonitoringHandler runs a monitoring loop for every enabled community. The list
+// is refreshed every MONITORING_COMMUNITY_REFRESH, so communities added or
+// disabled from Telegram are picked up without a restart. newMessageCh is closed
+// when all loops have stopped.
+func MonitoringHandler(ctx context.Context, source twittersource.Source, newMessageCh chan twitterapi.NewMessage, dbService *DatabaseService, loggingService *LoggingService, communities *CommunityService) {
 	defer close(newMessageCh)
 
-	var reverseService *twitterapi_reverse.TwitterReverseService
-	if enabled, _ := strconv.ParseBool(os.Getenv(ENV_TWITTER_REVERSE_ENABLED)); enabled {
-		auth := &twitterapi_reverse.TwitterAuth{
-			Authorization: os.Getenv(ENV_TWITTER_REVERSE_AUTHORIZATION),
-			XCSRFToken:    os.Getenv(ENV_TWITTER_REVERSE_CSRF_TOKEN),
-			Cookie:        os.Getenv(ENV_TWITTER_REVERSE_COOKIE),
-		}
+	loops := map[string]context.CancelFunc{}
+	running := sync.WaitGroup{}
+	defer running.Wait()
 
-		if auth.Authorization != "" && auth.XCSRFToken != "" && auth.Cookie != "" {
-			reverseService = twitterapi_reverse.NewTwitterReverseApi(auth, os.Getenv(ENV_PROXY_DSN), false)
-			log.Println("Twitter Reverse API service initialized")
+	for ctx.Err() == nil {
+		enabled, err := communities.Enabled()
+		if err != nil {
+			log.Println("Error getting monitored communities:", err)
 		} else {
-			log.Println("Twitter Reverse API enabled but missing authentication data")
+			wanted := map[string]bool{}
+			for _, community := range enabled {
+				wanted[community.ID] = true
+				if loops[community.ID] != nil {
+					continue
+				}
+				loopCtx, cancel := context.WithCancel(ctx)
+				loops[community.ID] = cancel
+				log.Printf("Monitoring community %s (%s)", community.ID, community.DisplayName())
+
+				running.Add(1)
+				go func(communityID string) {
+					defer running.Done()
+					MonitoringIncremental(loopCtx, source, newMessageCh, dbService.ForCommunity(communityID), loggingService, communityID)
+				}(community.ID)
+			}
+			for communityID, cancel := range loops {
+				if !wanted[communityID] {
+					log.Printf("Community %s disabled, stopping its monitoring", communityID)
+					cancel()
+					delete(loops, communityID)
+				}
+			}
 		}
-	}
 
-	MonitoringIncremental(twitterApi, newMessageCh, dbService, loggingService, reverseService)
+		select {
+		case <-ctx.Done():
+		case <-time.After(MONITORING_COMMUNITY_REFRESH):
+		}
+	}
 }
 
-func MonitoringIncremental(twitterApi *twitterapi.TwitterAPIService, newMessageCh chan twitterapi.NewMessage, dbService *DatabaseService, loggingService *LoggingService, reverseService *twitterapi_reverse.TwitterReverseService) {
+// MonitoringIncremental polls the community and sends new posts and replies to
+// the pipeline. The reply counts already seen are kept in the tweets table, so
+// after a restart a catch-up pass sends what was posted in the meantime with
+// the Backfill flag instead of rebuilding the baseline from scratch.
+func MonitoringIncremental(ctx context.Context, source twittersource.Source, newMessageCh chan twitterapi.NewMessage, dbService *DatabaseService, loggingService *LoggingService, communityID string) {
 
-	tweetsExistsStorage := map[string]int{}
+	tweetsExistsStorage, err := dbService.GetMonitoringBaseline()
+	if err != nil {
+		log.Printf("Error loading monitoring baseline of community %s: %v", communityID, err)
+		tweetsExistsStorage = map[string]int{}
+	}
+	if len(tweetsExistsStorage) > 0 {
+		log.Printf("Loaded monitoring baseline of community %s with %d tweets, catching up...", communityID, len(tweetsExistsStorage))
+		BackfillCommunity(ctx, source, newMessageCh, dbService, loggingService, tweetsExistsStorage, communityID)
+	}
 
 	for {
-		time.Sleep(30 * time.Second)
-
-		tweets, err := getCommunityTweetsWithFallback(reverseService, twitterApi, os.Getenv(ENV_DEMO_COMMUNITY_ID))
-		if err != nil {
-			log.Println("Error getting community tweets:", err)
-			continue
+		select {
+		case <-ctx.Done():
+			log.Printf("Monitoring of community %s stopped", communityID)
+			return
+		case <-time.After(MONITORING_INTERVAL):
 		}
 
-		tweetsResponse := &twitterapi.CommunityTweetsResponse{
-			Tweets: tweets,
-		}
-
-		if len(tweetsExistsStorage) == 0 {
-			log.Println("First time monitoring initialization...")
-
-			log.Println("Initializing monitoring mapping from 3 pages...")
-			InitializeMonitoringMapping(twitterApi, tweetsExistsStorage, reverseService)
-
-			log.Printf("Monitoring initialization completed with %d tweets in storage", len(tweetsExistsStorage))
-			continue
-		}
+		MonitoringPass(ctx, source, newMessageCh, dbService, loggingService, tweetsExistsStorage, communityID)
+	}
+}
 
-		for _, tweet := range tweetsResponse.Tweets {
+// MonitoringPass polls the first page of the community once. The first pass
+// over an empty baseline only records what is already there.
+func MonitoringPass(ctx context.Context, source twittersource.Source, newMessageCh chan twitterapi.NewMessage, dbService *DatabaseService, loggingService *LoggingService, tweetsExistsStorage map[string]int, communityID string) {
+	if len(tweetsExistsStorage) == 0 {
+		log.Println("First time monitoring initialization...")
 
-			storeTweetAndUser(dbService, tweet)
+		InitializeMonitoringMapping(ctx, source, dbService, tweetsExistsStorage, communityID)
 
-			SendIfNotExistsTweetToChannel(tweet, newMessageCh, tweetsExistsStorage, twitterapi.Tweet{}, twitterapi.Tweet{}, loggingService)
-			if tweet.ReplyCount > tweetsExistsStorage[tweet.Id] {
-				tweetsExistsStorage[tweet.Id] = tweet.ReplyCount
+		log.Printf("Monitoring initialization completed with %d tweets in storage", len(tweetsExistsStorage))
+		return
+	}
 
-				tweetRepliesResponse, err := twitterApi.GetTweetReplies(twitterapi.TweetRepliesRequest{
-					TweetID: tweet.Id,
-				})
-				if err != nil {
+	page, err := source.CommunityTweets(ctx, communityID, "")
+	if err != nil {
+		log.Printf("Error getting tweets of community %s: %v", communityID, err)
+		return
+	}
 
-					log.Printf("error on gettings replies for tweet, ERR: %s, TWEET ID: %s, TEXT: %s, AUTHOR: %s", err, tweet.Id, tweet.Text, tweet.Author.Name)
-					continue
-				}
+	for _, tweet := range page.Items {
+		processMonitoredTweet(ctx, source, newMessageCh, dbService, loggingService, tweetsExistsStorage, tweet, communityID, false)
+	}
+}
 
-				for _, tweetReply := range tweetRepliesResponse.Tweets {
+// BackfillCommunity compares the first MONITORING_BACKFILL_PAGES pages of the
+// community with the stored baseline and sends the posts and replies that
+// appeared while the monitoring was not running.
+func BackfillCommunity(ctx context.Context, source twittersource.Source, newMessageCh chan twitterapi.NewMessage, dbService *DatabaseService, loggingService *LoggingService, tweetsExistsStorage map[string]int, communityID string) {
+	changed := 0
 
-					storeTweetAndUser(dbService, tweetReply)
+	pager := twittersource.CommunityTweetPages(source, communityID, twittersource.PageOptions{MaxPages: MONITORING_BACKFILL_PAGES})
+	for !pager.Done() && ctx.Err() == nil {
+		tweets, err := pager.Next(ctx)
+		if err != nil {
+			log.Printf("Error fetching backfill page %d of community %s: %v", pager.Pages()+1, communityID, err)
+			break
+		}
 
-					var parentTweet, grandParentTweet twitterapi.Tweet
-					if tweetReply.InReplyToId != tweet.Id {
+		for _, tweet := range tweets {
+			if ctx.Err() != nil {
+				break
+			}
+			if replyCount, ok := tweetsExistsStorage[tweet.ID]; ok && replyCount >= tweet.ReplyCount {
+				continue
+			}
+			changed++
+			processMonitoredTweet(ctx, source, newMessageCh, dbService, loggingService, tweetsExistsStorage, tweet, communityID, true)
+		}
+	}
 
-						log.Printf("Reply %s is responding to another reply %s, not main post %s", tweetReply.Id, tweetReply.InReplyToId, tweet.Id)
+	log.Printf("Backfill of community %s completed, %d posts changed while monitoring was down", communityID, changed)
+}
 
-						if dbTweet, err := dbService.GetTweet(tweetReply.InReplyToId); err == nil {
-							if dbUser, err := dbService.GetUser(dbTweet.UserID); err == nil {
-								parentTweet = twitterapi.Tweet{
-									Id:   dbTweet.ID,
-									Text: dbTweet.Text,
-									Author: twitterapi.Author{
-										Id:       dbUser.ID,
-										UserName: dbUser.Username,
-										Name:     dbUser.Name,
-									},
-								}
-								log.Printf("'%s', Found parent reply in database: %s by %s", tweetReply.Text, parentTweet.Text, parentTweet.Author.UserName)
+// processMonitoredTweet sends the post and its new replies to the pipeline and
+// stores them as seen. The stored reply count only advances when the replies
+// were fetched, so replies missed on an API error are picked up on the next pass.
+func processMonitoredTweet(ctx context.Context, source twittersource.Source, newMessageCh chan twitterapi.NewMessage, dbService *DatabaseService, loggingService *LoggingService, tweetsExistsStorage map[string]int, tweet twittersource.Tweet, communityID string, backfill bool) {
 
-								grandParentTweet = tweet
-							}
-						} else {
-							log.Printf("Parent reply %s not found in database", tweetReply.InReplyToId)
+	SendIfNotExistsTweetToChannel(tweet, newMessageCh, tweetsExistsStorage, twittersource.Tweet{}, twittersource.Tweet{}, loggingService, communityID, backfill)
 
-							parentTweet = tweet
-						}
-					} else {
-						log.Printf("Reply %s is responding to main post %s", tweetReply.Id, tweet.Id)
+	seenReplyCount := tweetsExistsStorage[tweet.ID]
+	if tweet.ReplyCount > seenReplyCount {
+		tweetReplies, err := getTweetReplies(ctx, source, tweet.ID)
+		if err != nil {
+			log.Printf("error on gettings replies for tweet, ERR: %s, TWEET ID: %s, TEXT: %s, AUTHOR: %s", err, tweet.ID, tweet.Text, tweet.Author.Name)
+			tweet.ReplyCount = seenReplyCount
+		} else {
+			for _, tweetReply := range tweetReplies {
 
-						parentTweet = tweet
-					}
+				parentTweet, grandParentTweet := resolveReplyParents(dbService, tweet, tweetReply)
 
-					SendIfNotExistsTweetToChannel(tweetReply, newMessageCh, tweetsExistsStorage, parentTweet, grandParentTweet, loggingService)
-					tweetsExistsStorage[tweetReply.Id] = tweetReply.ReplyCount
-				}
+				SendIfNotExistsTweetToChannel(tweetReply, newMessageCh, tweetsExistsStorage, parentTweet, grandParentTweet, loggingService, communityID, backfill)
+				storeTweetAndUser(dbService, tweetReply)
+				tweetsExistsStorage[tweetReply.ID] = tweetReply.ReplyCount
 			}
-			tweetsExistsStorage[tweet.Id] = tweet.ReplyCount
 		}
 	}
-}
-
-func storeTweetAndUser(dbService *DatabaseService, tweet twitterapi.Tweet) {
 
-	createdAt, err := time.Parse(time.RFC1123, tweet.CreatedAt)
-	if err != nil {
+	storeTweetAndUser(dbService, tweet)
+	tweetsExistsStorage[tweet.ID] = tweet.ReplyCount
+}
 
-		createdAt, err = time.Parse("Mon Jan 02 15:04:05 -0700 2006", tweet.CreatedAt)
-		if err != nil {
-			log.Printf("Failed to parse tweet created_at: %v", err)
-			createdAt = time.Now()
-		}
+func resolveReplyParents(dbService *DatabaseService, tweet twittersource.Tweet, tweetReply twittersource.Tweet) (twittersource.Tweet, twittersource.Tweet) {
+	if tweetReply.InReplyToID == tweet.ID {
+		log.Printf("Reply %s is responding to main post %s", tweetReply.ID, tweet.ID)
+		return tweet, twittersource.Tweet{}
 	}
 
-	user := UserModel{
-		ID:       tweet.Author.Id,
-		Username: tweet.Author.UserName,
-		Name:     tweet.Author.Name,
-	}
+	log.Printf("Reply %s is responding to another reply %s, not main post %s", tweetReply.ID, tweetReply.InReplyToID, tweet.ID)
 
-	if !dbService.UserExists(tweet.Author.Id) {
-		err = dbService.SaveUser(user)
-		if err != nil {
-			log.Printf("Failed to save user %s: %v", tweet.Author.UserName, err)
-		}
+	dbTweet, err := dbService.GetTweet(tweetReply.InReplyToID)
+	if err != nil {
+		log.Printf("Parent reply %s not found in database", tweetReply.InReplyToID)
+		return tweet, twittersource.Tweet{}
 	}
-
-	tweetModel := TweetModel{
-		ID:            tweet.Id,
-		Text:          tweet.Text,
-		CreatedAt:     createdAt,
-		ReplyCount:    tweet.ReplyCount,
-		UserID:        tweet.Author.Id,
-		Username:      tweet.Author.UserName,
-		InReplyToID:   tweet.InReplyToId,
-		SourceType:    TWEET_SOURCE_COMMUNITY,
-		TickerMention: "",
-		SearchQuery:   "",
+	dbUser, err := dbService.GetUser(dbTweet.UserID)
+	if err != nil {
+		return twittersource.Tweet{}, twittersource.Tweet{}
+	}
+	parentTweet := twittersource.Tweet{
+		ID:   dbTweet.ID,
+		Text: dbTweet.Text,
+		Author: twittersource.User{
+			ID:       dbUser.ID,
+			Username: dbUser.Username,
+			Name:     dbUser.Name,
+		},
 	}
+	log.Printf("'%s', Found parent reply in database: %s by %s", tweetReply.Text, parentTweet.Text, parentTweet.Author.Username)
+	return parentTweet, tweet
+}
 
-	err = dbService.SaveTweet(tweetModel)
+// getTweetReplies returns the replies below the tweet, up to
+// MONITORING_REPLY_PAGES pages.
+func getTweetReplies(ctx context.Context, source twittersource.Source, tweetID string) ([]twittersource.Tweet, error) {
+	pager := twittersource.ReplyPages(source, tweetID, twittersource.PageOptions{MaxPages: MONITORING_REPLY_PAGES})
+	replies, err := pager.All(ctx)
 	if err != nil {
-		log.Printf("Failed to save tweet %s: %v", tweet.Id, err)
+		if pager.Pages() == 0 {
+			return nil, err
+		}
+		log.Printf("Error getting replies page %d of tweet %s: %v", pager.Pages()+1, tweetID, err)
 	}
+	return replies, nil
 }
 
-func storeTweetAndUserWithSource(dbService *DatabaseService, tweet twitterapi.Tweet, sourceType, tickerMention, searchQuery string) {
+func storeTweetAndUser(dbService *DatabaseService, tweet twittersource.Tweet) {
+	storeTweetAndUserWithSource(dbService, tweet, TWEET_SOURCE_COMMUNITY, "", "")
+}
 
-	createdAt, err := time.Parse(time.RFC1123, tweet.CreatedAt)
-	if err != nil {
+func storeTweetAndUserWithSource(dbService *DatabaseService, tweet twittersource.Tweet, sourceType, tickerMention, searchQuery string) {
 
-		createdAt, err = time.Parse("Mon Jan 02 15:04:05 -0700 2006", tweet.CreatedAt)
-		if err != nil {
-			log.Printf("Failed to parse tweet created_at: %v", err)
-			createdAt = time.Now()
-		}
+	createdAt := tweet.CreatedAt
+	if createdAt.IsZero() {
+		log.Printf("Tweet %s has no created_at, using the current time", tweet.ID)
+		createdAt = time.Now()
 	}
 
 	user := UserModel{
-		ID:       tweet.Author.Id,
-		Username: tweet.Author.UserName,
+		ID:       tweet.Author.ID,
+		Username: tweet.Author.Username,
 		Name:     tweet.Author.Name,
 	}
 
-	if !dbService.UserExists(tweet.Author.Id) {
-		err = dbService.SaveUser(user)
+	if !dbService.UserExists(tweet.Author.ID) {
+		err := dbService.SaveUser(user)
 		if err != nil {
-			log.Printf("Failed to save user %s: %v", tweet.Author.UserName, err)
+			log.Printf("Failed to save user %s: %v", tweet.Author.Username, err)
 		}
 	}
 
 	tweetModel := TweetModel{
-		ID:            tweet.Id,
+		ID:            tweet.ID,
 		Text:          tweet.Text,
 		CreatedAt:     createdAt,
 		ReplyCount:    tweet.ReplyCount,
-		UserID:        tweet.Author.Id,
-		InReplyToID:   tweet.InReplyToId,
+		UserID:        tweet.Author.ID,
+		Username:      tweet.Author.Username,
+		InReplyToID:   tweet.InReplyToID,
 		SourceType:    sourceType,
 		TickerMention: tickerMention,
 		SearchQuery:   searchQuery,
 	}
 
-	err = dbService.SaveTweet(tweetModel)
+	err := dbService.SaveTweet(tweetModel)
 	if err != nil {
-		log.Printf("Failed to save tweet %s: %v", tweet.Id, err)
+		log.Printf("Failed to save tweet %s: %v", tweet.ID, err)
 	}
 }
 
-func InitialCommunityLoad(twitterApi *twitterapi.TwitterAPIService, dbService *DatabaseService) {
+func InitialCommunityLoad(ctx context.Context, source twittersource.Source, dbService *DatabaseService, communityID string) {
 	const MAX_PAGES = 3
-	cursor := ""
 	totalPosts := 0
 	totalReplies := 0
 
 	log.Printf("Starting initial community load - fetching %d pages...", MAX_PAGES)
 
-	for page := 0; page < MAX_PAGES; page++ {
-		var tweetsResponse *twitterapi.CommunityTweetsResponse
-		var err error
-
-		if cursor == "" {
-			tweetsResponse, err = twitterApi.GetCommunityTweets(twitterapi.CommunityTweetsRequest{
-				CommunityID: os.Getenv(ENV_DEMO_COMMUNITY_ID),
-			})
-		} else {
-			tweetsResponse, err = twitterApi.GetCommunityTweets(twitterapi.CommunityTweetsRequest{
-				CommunityID: os.Getenv(ENV_DEMO_COMMUNITY_ID),
-				Cursor:      cursor,
-			})
-		}
-
+	pager := twittersource.CommunityTweetPages(source, communityID, twittersource.PageOptions{MaxPages: MAX_PAGES})
+	for !pager.Done() {
+		tweets, err := pager.Next(ctx)
 		if err != nil {
-			log.Printf("Error fetching community tweets page %d: %v", page+1, err)
-			break
-		}
-
-		if len(tweetsResponse.Tweets) == 0 {
-			log.Printf("No more tweets found on page %d", page+1)
+			log.Printf("Error fetching community tweets page %d: %v", pager.Pages()+1, err)
 			break
 		}
 
-		log.Printf("Processing page %d with %d posts...", page+1, len(tweetsResponse.Tweets))
+		log.Printf("Processing page %d with %d posts...", pager.Pages(), len(tweets))
 
-		for _, mainTweet := range tweetsResponse.Tweets {
+		for _, mainTweet := range tweets {
 
 			storeTweetAndUserWithSource(dbService, mainTweet, TWEET_SOURCE_COMMUNITY, "", "")
 			totalPosts++
 
-			repliesCount := LoadAllRepliesRecursive(twitterApi, dbService, mainTweet.Id, 0)
+			repliesCount := LoadAllRepliesRecursive(ctx, source, dbService, mainTweet.ID, 0)
 			totalReplies += repliesCount
 
-			log.Printf("Loaded post %s with %d replies", mainTweet.Id, repliesCount)
+			log.Printf("Loaded post %s with %d replies", mainTweet.ID, repliesCount)
 		}
-
-		if tweetsResponse.NextCursor == "" {
-			log.Printf("No more pages available after page %d", page+1)
-			break
-		}
-		cursor = tweetsResponse.NextCursor
 	}
 
 	log.Printf("Initial community load completed: %d posts, %d replies loaded", totalPosts, totalReplies)
 }
 
-func LoadAllRepliesRecursive(twitterApi *twitterapi.TwitterAPIService, dbService *DatabaseService, tweetID string, depth int) int {
+// LoadAllRepliesRecursive stores the conversation below the tweet. The reverse
+// API already returns replies to replies, so only the replies whose own replies
+// are missing from the conversation are loaded separately.
+func LoadAllRepliesRecursive(ctx context.Context, source twittersource.Source, dbService *DatabaseService, tweetID string, depth int) int {
 	if depth > 10 {
 		log.Printf("Max depth reached for tweet %s", tweetID)
 		return 0
 	}
 
-	repliesResponse, err := twitterApi.GetTweetReplies(twitterapi.TweetRepliesRequest{
-		TweetID: tweetID,
-	})
+	replies, err := getTweetReplies(ctx, source, tweetID)
 	if err != nil {
 		log.Printf("Error getting replies for tweet %s: %v", tweetID, err)
 		return 0
 	}
 
-	totalReplies := len(repliesResponse.Tweets)
+	totalReplies := len(replies)
+	loadedReplies := map[string]int{}
+	for _, reply := range replies {
+		loadedReplies[reply.InReplyToID]++
+	}
 
-	for _, reply := range repliesResponse.Tweets {
+	for _, reply := range replies {
 
 		storeTweetAndUserWithSource(dbService, reply, TWEET_SOURCE_COMMUNITY, "", "")
 
-		nestedReplies := LoadAllRepliesRecursive(twitterApi, dbService, reply.Id, depth+1)
-		totalReplies += nestedReplies
+		if reply.ReplyCount > loadedReplies[reply.ID] {
+			nestedReplies := LoadAllRepliesRecursive(ctx, source, dbService, reply.ID, depth+1)
+			totalReplies += nestedReplies
+		}
 	}
 
 	return totalReplies
 }
 
-func FullCommunityLoad(twitterApi *twitterapi.TwitterAPIService, dbService *DatabaseService) {
-	cursor := ""
+// FullCommunityLoad stores the first FULL_LOAD_PAGES pages of the community
+// with their conversations.
+func FullCommunityLoad(ctx context.Context, source twittersource.Source, dbService *DatabaseService, communityID string) {
+	const FULL_LOAD_PAGES = 5
 	totalPosts := 0
 	totalReplies := 0
-	pageCount := 0
 
 	log.Printf("Starting FULL community load - fetching ALL pages...")
 
-	for {
-		pageCount++
-		if pageCount > 5 {
-			break
-		}
-		var tweetsResponse *twitterapi.CommunityTweetsResponse
-		var err error
-
-		if cursor == "" {
-			tweetsResponse, err = twitterApi.GetCommunityTweets(twitterapi.CommunityTweetsRequest{
-				CommunityID: os.Getenv(ENV_DEMO_COMMUNITY_ID),
-			})
-		} else {
-			tweetsResponse, err = twitterApi.GetCommunityTweets(twitterapi.CommunityTweetsRequest{
-				CommunityID: os.Getenv(ENV_DEMO_COMMUNITY_ID),
-				Cursor:      cursor,
-			})
-		}
-
+	pager := twittersource.CommunityTweetPages(source, communityID, twittersource.PageOptions{MaxPages: FULL_LOAD_PAGES})
+	for !pager.Done() && ctx.Err() == nil {
+		tweets, err := pager.Next(ctx)
 		if err != nil {
-			log.Printf("Error fetching community tweets page %d: %v", pageCount, err)
-			break
-		}
-
-		if len(tweetsResponse.Tweets) == 0 {
-			log.Printf("No more tweets found on page %d", pageCount)
+			log.Printf("Error fetching community tweets page %d: %v", pager.Pages()+1, err)
 			break
 		}
 
-		log.Printf("Processing FULL load page %d with %d posts...", pageCount, len(tweetsResponse.Tweets))
+		log.Printf("Processing FULL load page %d with %d posts...", pager.Pages(), len(tweets))
 
-		for _, mainTweet := range tweetsResponse.Tweets {
+		for _, mainTweet := range tweets {
 
 			storeTweetAndUserWithSource(dbService, mainTweet, TWEET_SOURCE_COMMUNITY, "", "")
 			totalPosts++
 
-			repliesCount := LoadAllRepliesRecursive(twitterApi, dbService, mainTweet.Id, 0)
+			repliesCount := LoadAllRepliesRecursive(ctx, source, dbService, mainTweet.ID, 0)
 			totalReplies += repliesCount
 
-			log.Printf("FULL load: saved post %s with %d replies", mainTweet.Id, repliesCount)
+			log.Printf("FULL load: saved post %s with %d replies", mainTweet.ID, repliesCount)
 		}
-
-		if tweetsResponse.NextCursor == "" {
-			log.Printf("Reached end of community pages after page %d", pageCount)
-			break
-		}
-		cursor = tweetsResponse.NextCursor
 	}
 
-	log.Printf("FULL community load completed: %d posts, %d replies loaded across %d pages", totalPosts, totalReplies, pageCount)
+	log.Printf("FULL community load completed: %d posts, %d replies loaded across %d pages", totalPosts, totalReplies, pager.Pages())
 }
 
-func InitializeMonitoringMapping(twitterApi *twitterapi.TwitterAPIService, tweetsExistsStorage map[string]int, reverseService *twitterapi_reverse.TwitterReverseService) {
-	pageCount := 0
-	maxPages := 3
-
-	for pageCount < maxPages {
+func InitializeMonitoringMapping(ctx context.Context, source twittersource.Source, dbService *DatabaseService, tweetsExistsStorage map[string]int, communityID string) {
+	const MAX_PAGES = 3
 
-		tweets, err := getCommunityTweetsWithFallback(reverseService, twitterApi, os.Getenv(ENV_DEMO_COMMUNITY_ID))
+	pager := twittersource.CommunityTweetPages(source, communityID, twittersource.PageOptions{MaxPages: MAX_PAGES})
+	for !pager.Done() {
+		tweets, err := pager.Next(ctx)
 		if err != nil {
-			log.Printf("Error fetching monitoring mapping page %d: %v", pageCount+1, err)
-			break
-		}
-
-		if len(tweets) == 0 {
-			log.Printf("No tweets found on monitoring mapping page %d", pageCount+1)
+			log.Printf("Error fetching monitoring mapping page %d: %v", pager.Pages()+1, err)
 			break
 		}
 
-		log.Printf("Processing monitoring mapping page %d with %d posts...", pageCount+1, len(tweets))
+		log.Printf("Processing monitoring mapping page %d with %d posts...", pager.Pages(), len(tweets))
 
 		for _, tweet := range tweets {
-			tweetsExistsStorage[tweet.Id] = tweet.ReplyCount
+			storeTweetAndUser(dbService, tweet)
+			tweetsExistsStorage[tweet.ID] = tweet.ReplyCount
 
-			tweetRepliesResponse, err := twitterApi.GetTweetReplies(twitterapi.TweetRepliesRequest{
-				TweetID: tweet.Id,
-			})
+			tweetReplies, err := getTweetReplies(ctx, source, tweet.ID)
 			if err != nil {
-				log.Printf("Error getting replies for monitoring mapping, tweet %s: %v", tweet.Id, err)
+				log.Printf("Error getting replies for monitoring mapping, tweet %s: %v", tweet.ID, err)
 				continue
 			}
 
-			for _, tweetReply := range tweetRepliesResponse.Tweets {
-				tweetsExistsStorage[tweetReply.Id] = tweetReply.ReplyCount
+			for _, tweetReply := range tweetReplies {
+				storeTweetAndUser(dbService, tweetReply)
+				tweetsExistsStorage[tweetReply.ID] = tweetReply.ReplyCount
 			}
 		}
-
-		pageCount++
-
-		if reverseService != nil {
-			log.Println("Reverse API doesn't support pagination yet, stopping after first page")
-			break
-		}
-
-		break
-	}
-
-	log.Printf("Monitoring mapping initialized with %d tweets from %d pages", len(tweetsExistsStorage), pageCount)
-}
-
-func getCommunityTweetsWithFallback(reverseService *twitterapi_reverse.TwitterReverseService, twitterApi *twitterapi.TwitterAPIService, communityID string) ([]twitterapi.Tweet, error) {
-
-	if reverseService != nil {
-		log.Println("Trying reverse API for community tweets...")
-		simpleTweets, err := reverseService.GetCommunityTweets(communityID, 20)
-		if err != nil {
-			log.Printf("Reverse API failed: %v, falling back to original API", err)
-		} else {
-			log.Printf("Reverse API success: got %d tweets", len(simpleTweets))
-			return convertSimpleTweetsToTweets(simpleTweets), nil
-		}
-	}
-
-	log.Println("Using original API for community tweets...")
-	tweetsResponse, err := twitterApi.GetCommunityTweets(twitterapi.CommunityTweetsRequest{
-		CommunityID: communityID,
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	return tweetsResponse.Tweets, nil
-}
-
-func convertSimpleTweetsToTweets(simpleTweets []twitterapi_reverse.SimpleTweet) []twitterapi.Tweet {
-	var tweets []twitterapi.Tweet
-
-	for _, simpleTweet := range simpleTweets {
-		tweet := twitterapi.Tweet{
-			Id:              simpleTweet.TweetID,
-			Text:            simpleTweet.Text,
-			CreatedAt:       simpleTweet.CreatedAt.Format("Mon Jan 02 15:04:05 -0700 2006"),
-			CreatedAtParsed: simpleTweet.CreatedAt,
-			ReplyCount:      simpleTweet.RepliesCount,
-			InReplyToId:     simpleTweet.ReplyToID,
-			Author: twitterapi.Author{
-				Id:       simpleTweet.Author.ID,
-				UserName: simpleTweet.Author.Username,
-				Name:     simpleTweet.Author.Name,
-			},
-		}
-		//fmt.Println("tweet:", tweet.Id, tweet.Text, tweet.Author.Id, tweet.Author.UserName, tweet.Author.Name, "reply", tweet.ReplyCount)
-		tweets = append(tweets, tweet)
 	}
 
-	return tweets
+	log.Printf("Monitoring mapping initialized with %d tweets from %d pages", len(tweetsExistsStorage), pager.Pages())
 }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grutapig/hackaton/twitterapi"
+	"github.com/grutapig/hackaton/twittersource"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// communityStandIn serves one page of community posts and the replies of each
+// post, posts listed in failReplies answer the replies request with an error.
+type communityStandIn struct {
+	posts       []twitterapi.Tweet
+	replies     map[string][]twitterapi.Tweet
+	failReplies map[string]bool
+}
+
+func (s *communityStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/twitter/community/tweets":
+		json.NewEncoder(w).Encode(twitterapi.CommunityTweetsResponse{Tweets: s.posts})
+	case "/twitter/tweet/replies":
+		tweetID := r.URL.Query().Get("tweetId")
+		if s.failReplies[tweetID] {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(twitterapi.TweetRepliesResponse{Tweets: s.replies[tweetID]})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func monitoredTweet(id, inReplyToID, author string, replyCount int) twitterapi.Tweet {
+	return twitterapi.Tweet{
+		Id:          id,
+		Text:        "text of " + id,
+		CreatedAt:   "Mon Jan 02 15:04:05 +0000 2006",
+		ReplyCount:  replyCount,
+		InReplyToId: inReplyToID,
+		Author:      twitterapi.Author{Id: author, UserName: author},
+	}
+}
+
+func TestBackfillCommunity_SendsWhatChangedWhileDown(t *testing.T) {
+	dbService := setupTestDB(t)
+	require.NoError(t, dbService.SetDefaultCommunity("community_a"))
+
+	// state stored by the previous run
+	storeTweetAndUser(dbService, twittersource.FromAPITweet(monitoredTweet("post_1", "", "alice", 1)))
+	storeTweetAndUser(dbService, twittersource.FromAPITweet(monitoredTweet("reply_1", "post_1", "bob", 0)))
+	storeTweetAndUser(dbService, twittersource.FromAPITweet(monitoredTweet("post_3", "", "alice", 0)))
+	require.NoError(t, dbService.SaveTweet(TweetModel{ID: "reply_2", UserID: "carol", SourceType: TWEET_SOURCE_CONTEXT}))
+
+	standIn := &communityStandIn{
+		posts: []twitterapi.Tweet{
+			monitoredTweet("post_1", "", "alice", 2),
+			monitoredTweet("post_2", "", "dave", 0),
+			monitoredTweet("post_3", "", "alice", 0),
+		},
+		replies: map[string][]twitterapi.Tweet{
+			"post_1": {monitoredTweet("reply_1", "post_1", "bob", 0), monitoredTweet("reply_2", "post_1", "carol", 0)},
+		},
+	}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+	source := twittersource.NewAPISource(twitterapi.NewTwitterAPIService("test-key", server.URL, ""))
+
+	baseline, err := dbService.GetMonitoringBaseline()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"post_1": 1, "reply_1": 0, "post_3": 0}, baseline)
+
+	newMessageCh := make(chan twitterapi.NewMessage, 10)
+	BackfillCommunity(context.Background(), source, newMessageCh, dbService, nil, baseline, "community_a")
+	close(newMessageCh)
+
+	sent := map[string]twitterapi.NewMessage{}
+	for message := range newMessageCh {
+		assert.True(t, message.Backfill)
+		assert.Equal(t, "community_a", message.CommunityID)
+		sent[message.TweetID] = message
+	}
+	assert.Len(t, sent, 2)
+	assert.Contains(t, sent, "post_2")
+	assert.Equal(t, "post_1", sent["reply_2"].ParentTweet.ID)
+
+	baseline, err = dbService.GetMonitoringBaseline()
+	require.NoError(t, err)
+	assert.Equal(t, 2, baseline["post_1"])
+	assert.Contains(t, baseline, "post_2")
+	assert.Contains(t, baseline, "reply_2")
+}
+
+func TestProcessMonitoredTweet_KeepsReplyCountWhenRepliesFail(t *testing.T) {
+	dbService := setupTestDB(t)
+	require.NoError(t, dbService.SetDefaultCommunity("community_a"))
+	storeTweetAndUser(dbService, twittersource.FromAPITweet(monitoredTweet("post_1", "", "alice", 1)))
+
+	standIn := &communityStandIn{failReplies: map[string]bool{"post_1": true}}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+	source := twittersource.NewAPISource(twitterapi.NewTwitterAPIService("test-key", server.URL, ""))
+
+	baseline, err := dbService.GetMonitoringBaseline()
+	require.NoError(t, err)
+
+	newMessageCh := make(chan twitterapi.NewMessage, 10)
+	processMonitoredTweet(context.Background(), source, newMessageCh, dbService, nil, baseline, twittersource.FromAPITweet(monitoredTweet("post_1", "", "alice", 3)), "community_a", false)
+	assert.Empty(t, newMessageCh)
+
+	replyCount, err := dbService.GetTweetReplyCount("post_1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, replyCount)
+	assert.Equal(t, 1, baseline["post_1"])
+}
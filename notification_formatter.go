@@ -31,7 +31,12 @@ type FUDAlertNotification struct {
 	GrandParentPostAuthor string `json:"grandparent_post_author"`
 	HasThreadContext      bool   `json:"has_thread_context"`
 
-	TargetChatID int64 `json:"target_chat_id,omitempty"`
+	TargetChatID  int64  `json:"target_chat_id,omitempty"`
+	CommunityID   string `json:"community_id,omitempty"`
+	CommunityName string `json:"community_name,omitempty"`
+	Backfill      bool   `json:"backfill,omitempty"`
+	// ReviewID is the open review of the verdict, the alert gets the review buttons
+	ReviewID uint `json:"review_id,omitempty"`
 }
 
 func NewNotificationFormatter() *NotificationFormatter {
@@ -172,6 +177,15 @@ func (nf *NotificationFormatter) FormatForTelegramWithDetail(alert FUDAlertNotif
 			nf.truncateText(alert.MessagePreview, 2000),
 			alert.FUDUsername)
 	}
+	if alert.ReviewID != 0 {
+		message += fmt.Sprintf("\n\n🧑‍⚖️ <b>Pending review #%d</b> - not a known FUD user until approved", alert.ReviewID)
+	}
+	if alert.Backfill {
+		message = "⏮ <i>Posted while monitoring was offline</i>\n" + message
+	}
+	if alert.CommunityName != "" {
+		message = fmt.Sprintf("🏘 <b>%s</b>\n", alert.CommunityName) + message
+	}
 	return message
 }
 
@@ -4,25 +4,42 @@ import (
 	"log"
 )
 
-func NotificationHandler(notificationCh chan FUDAlertNotification, telegramService *TelegramService) {
+func NotificationHandler(notificationCh chan FUDAlertNotification, telegramService *TelegramService, communities *CommunityService) {
 	for alert := range notificationCh {
 		log.Printf("FUD Alert: %s (@%s) - %s", alert.FUDType, alert.FUDUsername, alert.AlertSeverity)
 
 		if alert.TargetChatID != 0 {
 
 			telegramMessage := telegramService.formatter.FormatForTelegramWithDetail(alert, "")
-			err := telegramService.SendMessage(alert.TargetChatID, telegramMessage)
+			err := telegramService.SendMessageWithKeyboard(alert.TargetChatID, telegramMessage, reviewKeyboard(alert.ReviewID, true))
 			if err != nil {
 				log.Printf("Failed to send targeted Telegram notification to chat %d: %v", alert.TargetChatID, err)
 			} else {
 				log.Printf("Sent targeted notification for @%s to chat %d", alert.FUDUsername, alert.TargetChatID)
 			}
-		} else {
+			continue
+		}
 
-			err := telegramService.StoreAndBroadcastNotification(alert)
-			if err != nil {
-				log.Printf("Failed to send Telegram notification: %v", err)
-			}
+		if override := telegramService.dbService.ForCommunity(alert.CommunityID).GetUserOverride(alert.FUDUserID, alert.FUDUsername); override != nil && override.Kind == OVERRIDE_KIND_ALLOW {
+			log.Printf("Alert for allowlisted @%s not sent", alert.FUDUsername)
+			continue
+		}
+
+		community := communities.Get(alert.CommunityID)
+		if alert.FUDProbability < community.AlertThreshold {
+			log.Printf("Alert for @%s below the %.0f%% threshold of community %s, not sent", alert.FUDUsername, community.AlertThreshold*100, community.DisplayName())
+			continue
+		}
+		alert.CommunityName = community.Name
+
+		var err error
+		if chatIDs := community.ChatIDs(); len(chatIDs) > 0 {
+			err = telegramService.StoreAndSendNotification(alert, chatIDs)
+		} else {
+			err = telegramService.StoreAndBroadcastNotification(alert)
+		}
+		if err != nil {
+			log.Printf("Failed to send Telegram notification: %v", err)
 		}
 	}
 }
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grutapig/hackaton/analysis"
+)
+
+const (
+	PROMPT_FIRST_STEP  = analysis.PROMPT_FIRST_STEP
+	PROMPT_SECOND_STEP = analysis.PROMPT_SECOND_STEP
+	PROMPT_TWITTER_BOT = analysis.PROMPT_TWITTER_BOT
+
+	PROMPT_FIRST_STEP_BASE  = analysis.PROMPT_FIRST_STEP_BASE
+	PROMPT_SECOND_STEP_BASE = analysis.PROMPT_SECOND_STEP_BASE
+)
+
+const PROMPT_VERSION_BUILTIN = analysis.PROMPT_VERSION_BUILTIN
+const PROMPT_FILE_EXT = analysis.PROMPT_FILE_EXT
+const PROMPT_RELOAD_INTERVAL = 30 * time.Second
+
+var promptNames = analysis.PromptNames
+
+type PromptVars = analysis.PromptVars
+type Prompt = analysis.Prompt
+
+// DefaultPrompt returns the builtin version of the prompt.
+func DefaultPrompt(name string) Prompt {
+	return analysis.DefaultPrompt(name)
+}
+
+// SplitPromptLabel returns the labels of the template and the base prompt a
+// recorded prompt version is made of.
+func SplitPromptLabel(label string) []string {
+	return analysis.SplitPromptLabel(label)
+}
+
+type promptFile struct {
+	modTime time.Time
+	size    int64
+	prompt  *Prompt
+}
+
+// PromptRegistry keeps the versions of the prompts, read from
+// <dir>/<name>/<version>.txt and reloaded when the files change, and picks the
+// version of each request. The builtin version of a base prompt is read from
+// its file in baseFiles the same way. The active version and the A/B candidate
+// are stored in the database.
+type PromptRegistry struct {
+	dir        string
+	baseFiles  map[string]string
+	dbService  *DatabaseService
+	mu         sync.RWMutex
+	files      map[string]promptFile
+	baseCache  map[string]promptFile
+	prompts    map[string]map[string]Prompt
+	selections map[string]PromptSelectionModel
+}
+
+func NewPromptRegistry(dir string, baseFiles map[string]string, dbService *DatabaseService) (*PromptRegistry, error) {
+	r := &PromptRegistry{
+		dir:        dir,
+		baseFiles:  baseFiles,
+		dbService:  dbService,
+		files:      make(map[string]promptFile),
+		baseCache:  make(map[string]promptFile),
+		selections: make(map[string]PromptSelectionModel),
+	}
+	if dbService != nil {
+		selections, err := dbService.GetPromptSelections()
+		if err != nil {
+			return nil, fmt.Errorf("load prompt selections: %w", err)
+		}
+		for _, selection := range selections {
+			r.selections[selection.Name] = selection
+		}
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the new and changed prompt files. A file which does not parse
+// is reported and its previous version stays in use, a base file which cannot
+// be read the first time is an error.
+func (r *PromptRegistry) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	files := make(map[string]promptFile)
+	prompts := make(map[string]map[string]Prompt)
+	for _, name := range promptNames {
+		prompts[name] = map[string]Prompt{PROMPT_VERSION_BUILTIN: DefaultPrompt(name)}
+		if path := r.baseFiles[name]; path != "" {
+			file, err := r.reloadFile(r.files, path, name, PROMPT_VERSION_BUILTIN)
+			if file.prompt == nil {
+				if err == nil {
+					err = fmt.Errorf("cannot load %s", path)
+				}
+				return fmt.Errorf("base prompt %s: %w", name, err)
+			}
+			if err != nil {
+				log.Printf("Cannot read base prompt %s, keeping the previous version: %v", path, err)
+			}
+			files[path] = file
+			prompts[name][PROMPT_VERSION_BUILTIN] = *file.prompt
+		}
+		if r.dir == "" {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(r.dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("read prompts %s: %w", name, err)
+		}
+		for _, entry := range entries {
+			version := strings.TrimSuffix(entry.Name(), PROMPT_FILE_EXT)
+			if entry.IsDir() || filepath.Ext(entry.Name()) != PROMPT_FILE_EXT || version == PROMPT_VERSION_BUILTIN {
+				continue
+			}
+			path := filepath.Join(r.dir, name, entry.Name())
+			file, err := r.reloadFile(r.files, path, name, version)
+			if err != nil {
+				continue
+			}
+			files[path] = file
+			if file.prompt != nil {
+				prompts[name][version] = *file.prompt
+			}
+		}
+	}
+
+	for path, file := range r.files {
+		if _, ok := files[path]; !ok && file.prompt != nil {
+			log.Printf("Prompt %s removed", file.prompt.Label())
+		}
+	}
+	for name, selection := range r.selections {
+		for _, version := range []string{selection.ActiveVersion, selection.CandidateVersion} {
+			_, available := r.prompts[name][version]
+			if _, ok := prompts[name][version]; available && !ok {
+				log.Printf("Selected prompt %s@%s is not available, the %s version is used instead", name, version, PROMPT_VERSION_BUILTIN)
+			}
+		}
+	}
+	r.files = files
+	r.prompts = prompts
+	return nil
+}
+
+// reloadFile reads the file again when its modification time or size changed,
+// the error is the one of a file which cannot be found.
+func (r *PromptRegistry) reloadFile(loaded map[string]promptFile, path, name, version string) (promptFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return loaded[path], err
+	}
+	file, known := loaded[path]
+	if !known || !file.modTime.Equal(info.ModTime()) || file.size != info.Size() {
+		file = r.loadFile(path, name, version, file.prompt)
+		file.modTime = info.ModTime()
+		file.size = info.Size()
+	}
+	return file, nil
+}
+
+func (r *PromptRegistry) loadFile(path, name, version string, previous *Prompt) promptFile {
+	content, err := os.ReadFile(path)
+	if err == nil {
+		var prompt Prompt
+		if analysis.IsBasePrompt(name) {
+			prompt = analysis.TextPrompt(name, version, string(content))
+		} else {
+			prompt, err = analysis.ParsePrompt(name, version, string(content))
+		}
+		if err == nil {
+			log.Printf("Loaded prompt %s from %s", prompt.Label(), path)
+			return promptFile{prompt: &prompt}
+		}
+	}
+	log.Printf("Cannot load prompt %s, keeping the previous version: %v", path, err)
+	return promptFile{prompt: previous}
+}
+
+// Run reloads the prompt files every PROMPT_RELOAD_INTERVAL until ctx is done.
+func (r *PromptRegistry) Run(ctx context.Context) {
+	if r.dir == "" {
+		return
+	}
+	ticker := time.NewTicker(PROMPT_RELOAD_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Reload(); err != nil {
+				log.Printf("Error reloading prompts: %v", err)
+			}
+		}
+	}
+}
+
+// Select returns the version of the prompt used for subject, a user ID or
+// username. With an A/B candidate the same subject always gets the same
+// version. A nil registry uses the builtin prompts.
+func (r *PromptRegistry) Select(name, subject string) Prompt {
+	if r == nil {
+		return DefaultPrompt(name)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	selection := r.selections[name]
+	if selection.CandidateVersion != "" && promptBucket(name, subject) < selection.CandidateShare {
+		if prompt, ok := r.prompts[name][selection.CandidateVersion]; ok {
+			return prompt
+		}
+	}
+	if prompt, ok := r.prompts[name][selection.ActiveVersion]; ok {
+		return prompt
+	}
+	return r.prompts[name][PROMPT_VERSION_BUILTIN]
+}
+
+// SelectWithBase returns the version of the prompt used for subject rendered
+// over its base prompt: baseFile when it is set and readable, else the version
+// of the base prompt picked for the same subject.
+func (r *PromptRegistry) SelectWithBase(name, subject, baseFile string) Prompt {
+	prompt := r.Select(name, subject)
+	base := analysis.BasePromptName(name)
+	if base == "" {
+		return prompt
+	}
+	if baseFile != "" {
+		file, err := r.loadBaseFile(base, baseFile)
+		if err == nil {
+			return prompt.WithBasePrompt(file)
+		}
+		log.Printf("Cannot read base prompt %s, using the %s prompt: %v", baseFile, base, err)
+	}
+	return prompt.WithBasePrompt(r.Select(base, subject))
+}
+
+// loadBaseFile reads a base file outside the prompts directory, such as the
+// prompt file of a community, again when it changed. The file name is the
+// version.
+func (r *PromptRegistry) loadBaseFile(name, path string) (Prompt, error) {
+	if r == nil {
+		return analysis.LoadPrompt(name, path)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	file, err := r.reloadFile(r.baseCache, path, name, strings.TrimSuffix(filepath.Base(path), PROMPT_FILE_EXT))
+	if err != nil {
+		return Prompt{}, err
+	}
+	r.baseCache[path] = file
+	if file.prompt == nil {
+		return Prompt{}, fmt.Errorf("cannot load %s", path)
+	}
+	return *file.prompt, nil
+}
+
+// promptBucket spreads the subjects over 0-99 independently for each prompt.
+func promptBucket(name, subject string) int {
+	hash := fnv.New32a()
+	io.WriteString(hash, name+":"+strings.ToLower(subject))
+	return int(hash.Sum32() % 100)
+}
+
+// Versions returns the available versions of the prompt, builtin first.
+func (r *PromptRegistry) Versions(name string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	versions := make([]string, 0, len(r.prompts[name]))
+	for version := range r.prompts[name] {
+		if version != PROMPT_VERSION_BUILTIN {
+			versions = append(versions, version)
+		}
+	}
+	sort.Strings(versions)
+	return append([]string{PROMPT_VERSION_BUILTIN}, versions...)
+}
+
+// Selection returns the active version and the A/B candidate of the prompt.
+func (r *PromptRegistry) Selection(name string) PromptSelectionModel {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	selection, ok := r.selections[name]
+	if !ok {
+		selection = PromptSelectionModel{Name: name}
+	}
+	if _, ok := r.prompts[name][selection.ActiveVersion]; !ok {
+		selection.ActiveVersion = PROMPT_VERSION_BUILTIN
+	}
+	return selection
+}
+
+// SetActive makes version the prompt used for every request without a
+// candidate. A candidate with the same version is cleared.
+func (r *PromptRegistry) SetActive(name, version, updatedBy string) error {
+	return r.updateSelection(name, version, updatedBy, func(selection *PromptSelectionModel) {
+		selection.ActiveVersion = version
+		if selection.CandidateVersion == version {
+			selection.CandidateVersion = ""
+			selection.CandidateShare = 0
+		}
+	})
+}
+
+// SetCandidate sends share percent of the subjects to version, an empty version
+// ends the A/B test.
+func (r *PromptRegistry) SetCandidate(name, version string, share int, updatedBy string) error {
+	if version != "" && (share < 1 || share > 99) {
+		return fmt.Errorf("the candidate share must be between 1 and 99 percent")
+	}
+	return r.updateSelection(name, version, updatedBy, func(selection *PromptSelectionModel) {
+		selection.CandidateVersion = version
+		selection.CandidateShare = share
+		if version == "" {
+			selection.CandidateShare = 0
+		}
+	})
+}
+
+func (r *PromptRegistry) updateSelection(name, version, updatedBy string, update func(selection *PromptSelectionModel)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	versions, ok := r.prompts[name]
+	if !ok {
+		return fmt.Errorf("unknown prompt %s, the prompts are %s", name, strings.Join(promptNames, ", "))
+	}
+	if _, ok := versions[version]; version != "" && !ok {
+		return fmt.Errorf("prompt %s has no version %s", name, version)
+	}
+
+	selection := r.selections[name]
+	selection.Name = name
+	update(&selection)
+	selection.UpdatedBy = updatedBy
+	selection.UpdatedAt = time.Now()
+	if r.dbService != nil {
+		if err := r.dbService.SavePromptSelection(selection); err != nil {
+			return err
+		}
+	}
+	r.selections[name] = selection
+	log.Printf("Prompt %s: active %s, candidate %q at %d%% (by %s)", name, selection.ActiveVersion, selection.CandidateVersion, selection.CandidateShare, updatedBy)
+	return nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grutapig/hackaton/claude"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePrompt(t *testing.T, dir, name, version, text string, modTime time.Time) {
+	path := filepath.Join(dir, name, version+PROMPT_FILE_EXT)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(text), 0644))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestPrompt_Builtin(t *testing.T) {
+	firstStep := DefaultPrompt(PROMPT_FIRST_STEP).WithBase([]byte("base prompt"))
+	assert.Equal(t, "first_step@builtin", firstStep.Label())
+
+	text, err := firstStep.Render(PromptVars{Ticker: "$TEST", Username: "bob"})
+	require.NoError(t, err)
+	assert.Equal(t, "base prompt\n<instruction>you must analyze bob user messages in the context of the full thread</instruction>", text)
+
+	text, err = firstStep.Render(PromptVars{Ticker: "$TEST", Username: "bob", KnownFUD: true})
+	require.NoError(t, err)
+	assert.Contains(t, text, "this is a FUD user")
+	assert.Contains(t, text, "the system ticker is:$TEST")
+
+	blocks, err := PrepareClaudeSecondStepSystem(DefaultPrompt(PROMPT_SECOND_STEP).WithBase([]byte("base prompt")), "$TEST", "bob", false)
+	require.NoError(t, err)
+	require.Len(t, blocks, 2)
+	assert.Equal(t, claude.CachedTextBlock("base prompt\nthe system ticker is:$TEST, it cannot be used for any criteria or flag about decision FUD or not"), blocks[0])
+	assert.Equal(t, claude.TextBlock("analyzed user is bob"), blocks[1])
+
+	manual, err := PrepareClaudeSecondStepSystem(DefaultPrompt(PROMPT_SECOND_STEP).WithBase([]byte("base prompt")), "$TEST", "erin", true)
+	require.NoError(t, err)
+	assert.Equal(t, blocks[0], manual[0], "the cached block does not depend on the user")
+	assert.Contains(t, manual[1].Text, "MANUAL ANALYSIS REQUEST")
+	assert.Contains(t, manual[1].Text, "analyzed user is erin")
+
+	text, err = DefaultPrompt(PROMPT_TWITTER_BOT).Render(PromptVars{})
+	require.NoError(t, err)
+	assert.Contains(t, text, "NOTHING_ASK")
+}
+
+func TestPromptRegistry_Reload(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	modTime := time.Now().Add(-time.Hour)
+	writePrompt(t, dir, PROMPT_SECOND_STEP, "v2", `{{.Base}} v2 {{.Ticker}}{{define "user"}}check {{.Username}}{{end}}`, modTime)
+	writePrompt(t, dir, PROMPT_SECOND_STEP, "broken", `{{.Base`, modTime)
+	writePrompt(t, dir, "unknown_prompt", "v1", `ignored`, modTime)
+
+	registry, err := NewPromptRegistry(dir, nil, db)
+	require.NoError(t, err)
+	assert.Equal(t, []string{PROMPT_VERSION_BUILTIN, "v2"}, registry.Versions(PROMPT_SECOND_STEP))
+	assert.Equal(t, PROMPT_VERSION_BUILTIN, registry.Select(PROMPT_SECOND_STEP, "u1").Version)
+
+	require.Error(t, registry.SetActive(PROMPT_SECOND_STEP, "v3", "admin"))
+	require.Error(t, registry.SetActive("unknown_prompt", "v1", "admin"))
+	require.NoError(t, registry.SetActive(PROMPT_SECOND_STEP, "v2", "admin"))
+
+	render := func() []claude.ContentBlock {
+		blocks, err := PrepareClaudeSecondStepSystem(registry.Select(PROMPT_SECOND_STEP, "u1").WithBase([]byte("base")), "$TEST", "bob", false)
+		require.NoError(t, err)
+		return blocks
+	}
+	assert.Equal(t, []claude.ContentBlock{claude.CachedTextBlock("base v2 $TEST"), claude.TextBlock("check bob")}, render())
+
+	// an edit is picked up by the next reload
+	modTime = modTime.Add(time.Minute)
+	writePrompt(t, dir, PROMPT_SECOND_STEP, "v2", `{{.Base}} v2 edited`, modTime)
+	require.NoError(t, registry.Reload())
+	assert.Equal(t, []claude.ContentBlock{claude.CachedTextBlock("base v2 edited")}, render())
+
+	// an edit which does not parse keeps the previous version
+	modTime = modTime.Add(time.Minute)
+	writePrompt(t, dir, PROMPT_SECOND_STEP, "v2", `{{.Base} v2 broken`, modTime)
+	require.NoError(t, registry.Reload())
+	assert.Equal(t, []claude.ContentBlock{claude.CachedTextBlock("base v2 edited")}, render())
+
+	// a removed version falls back to the builtin prompt
+	require.NoError(t, os.Remove(filepath.Join(dir, PROMPT_SECOND_STEP, "v2"+PROMPT_FILE_EXT)))
+	require.NoError(t, registry.Reload())
+	assert.Equal(t, PROMPT_VERSION_BUILTIN, registry.Select(PROMPT_SECOND_STEP, "u1").Version)
+	assert.Equal(t, PROMPT_VERSION_BUILTIN, registry.Selection(PROMPT_SECOND_STEP).ActiveVersion)
+}
+
+func TestPromptRegistry_BasePrompts(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Now().Add(-time.Hour)
+	baseFile := filepath.Join(t.TempDir(), "prompt2.txt")
+	require.NoError(t, os.WriteFile(baseFile, []byte("deployed base {{not a template}}"), 0644))
+	require.NoError(t, os.Chtimes(baseFile, modTime, modTime))
+	writePrompt(t, dir, PROMPT_SECOND_STEP_BASE, "v2", "base v2", modTime)
+
+	_, err := NewPromptRegistry(dir, map[string]string{PROMPT_SECOND_STEP_BASE: filepath.Join(dir, "missing.txt")}, nil)
+	require.Error(t, err, "the builtin base file is required")
+
+	registry, err := NewPromptRegistry(dir, map[string]string{PROMPT_SECOND_STEP_BASE: baseFile}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{PROMPT_VERSION_BUILTIN, "v2"}, registry.Versions(PROMPT_SECOND_STEP_BASE))
+	render := func(prompt Prompt) string {
+		blocks, err := PrepareClaudeSecondStepSystem(prompt, "$TEST", "bob", false)
+		require.NoError(t, err)
+		return blocks[0].Text
+	}
+	prompt := registry.SelectWithBase(PROMPT_SECOND_STEP, "u1", "")
+	assert.Equal(t, "second_step@builtin+second_step_base@builtin", prompt.Label())
+	assert.Contains(t, render(prompt), "deployed base {{not a template}}\nthe system ticker")
+
+	// an edit of the base file is picked up by the next reload
+	modTime = modTime.Add(time.Minute)
+	require.NoError(t, os.WriteFile(baseFile, []byte("deployed base edited"), 0644))
+	require.NoError(t, os.Chtimes(baseFile, modTime, modTime))
+	require.NoError(t, registry.Reload())
+	assert.Contains(t, render(registry.SelectWithBase(PROMPT_SECOND_STEP, "u1", "")), "deployed base edited")
+
+	require.NoError(t, registry.SetActive(PROMPT_SECOND_STEP_BASE, "v2", "admin"))
+	prompt = registry.SelectWithBase(PROMPT_SECOND_STEP, "u1", "")
+	assert.Equal(t, "second_step@builtin+second_step_base@v2", prompt.Label())
+	assert.Contains(t, render(prompt), "base v2")
+
+	// the base file of a community is read again when it changes
+	communityFile := filepath.Join(t.TempDir(), "hosico.txt")
+	require.NoError(t, os.WriteFile(communityFile, []byte("community base"), 0644))
+	require.NoError(t, os.Chtimes(communityFile, modTime, modTime))
+	prompt = registry.SelectWithBase(PROMPT_SECOND_STEP, "u1", communityFile)
+	assert.Equal(t, "second_step@builtin+second_step_base@hosico", prompt.Label())
+	assert.Contains(t, render(prompt), "community base")
+	modTime = modTime.Add(time.Minute)
+	require.NoError(t, os.WriteFile(communityFile, []byte("community base edited"), 0644))
+	require.NoError(t, os.Chtimes(communityFile, modTime, modTime))
+	assert.Contains(t, render(registry.SelectWithBase(PROMPT_SECOND_STEP, "u1", communityFile)), "community base edited")
+
+	// an unreadable community file falls back to the base prompt
+	prompt = registry.SelectWithBase(PROMPT_SECOND_STEP, "u1", filepath.Join(dir, "missing.txt"))
+	assert.Equal(t, "second_step@builtin+second_step_base@v2", prompt.Label())
+	assert.Equal(t, "twitter_bot@builtin", registry.SelectWithBase(PROMPT_TWITTER_BOT, "u1", "").Label())
+}
+
+func TestPromptRegistry_ABAssignment(t *testing.T) {
+	db := setupTestDB(t)
+	dir := t.TempDir()
+	writePrompt(t, dir, PROMPT_FIRST_STEP, "v2", `{{.Base}} v2`, time.Now())
+
+	registry, err := NewPromptRegistry(dir, nil, db)
+	require.NoError(t, err)
+	require.Error(t, registry.SetCandidate(PROMPT_FIRST_STEP, "v2", 0, "admin"))
+	require.Error(t, registry.SetCandidate(PROMPT_FIRST_STEP, "v2", 100, "admin"))
+	require.NoError(t, registry.SetCandidate(PROMPT_FIRST_STEP, "v2", 30, "admin"))
+
+	assigned := map[string]string{}
+	candidates := 0
+	for i := 0; i < 1000; i++ {
+		subject := fmt.Sprintf("user_%d", i)
+		version := registry.Select(PROMPT_FIRST_STEP, subject).Version
+		assigned[subject] = version
+		if version == "v2" {
+			candidates++
+		}
+	}
+	assert.InDelta(t, 300, candidates, 60)
+	assert.Equal(t, PROMPT_VERSION_BUILTIN, registry.Select(PROMPT_SECOND_STEP, "user_1").Version, "the other prompts keep their version")
+
+	// the selection survives a restart and a user keeps the version
+	restarted, err := NewPromptRegistry(dir, nil, db)
+	require.NoError(t, err)
+	selection := restarted.Selection(PROMPT_FIRST_STEP)
+	assert.Equal(t, "v2", selection.CandidateVersion)
+	assert.Equal(t, 30, selection.CandidateShare)
+	assert.Equal(t, "admin", selection.UpdatedBy)
+	for subject, version := range assigned {
+		require.Equal(t, version, restarted.Select(PROMPT_FIRST_STEP, subject).Version, subject)
+	}
+
+	// activating the candidate ends the test
+	require.NoError(t, restarted.SetActive(PROMPT_FIRST_STEP, "v2", "admin"))
+	selection = restarted.Selection(PROMPT_FIRST_STEP)
+	assert.Equal(t, "v2", selection.ActiveVersion)
+	assert.Empty(t, selection.CandidateVersion)
+
+	require.NoError(t, restarted.SetCandidate(PROMPT_FIRST_STEP, PROMPT_VERSION_BUILTIN, 50, "admin"))
+	require.NoError(t, restarted.SetCandidate(PROMPT_FIRST_STEP, "", 0, "admin"))
+	for subject := range assigned {
+		require.Equal(t, "v2", restarted.Select(PROMPT_FIRST_STEP, subject).Version)
+	}
+
+	var registryNil *PromptRegistry
+	assert.Equal(t, PROMPT_VERSION_BUILTIN, registryNil.Select(PROMPT_TWITTER_BOT, "bob").Version)
+}
+
+func TestDatabaseService_PromptReviewStats(t *testing.T) {
+	db := setupTestDB(t)
+	v1 := db.WithStatusChange(StatusChange{Source: STATUS_SOURCE_SECOND_STEP, PromptVersion: "second_step@v1"})
+	v2 := db.WithStatusChange(StatusChange{Source: STATUS_SOURCE_SECOND_STEP, PromptVersion: "second_step@v2"})
+
+	first, err := v1.OpenFUDReview("u1", "alice", "t1", "spam", 0.9)
+	require.NoError(t, err)
+	_, err = v1.OpenFUDReview("u2", "bob", "t2", "spam", 0.9)
+	require.NoError(t, err)
+	third, err := v2.OpenFUDReview("u3", "carol", "t3", "spam", 0.9)
+	require.NoError(t, err)
+	// a cached verdict keeps the prompt of the review
+	again, err := db.OpenFUDReview("u1", "alice", "t4", "spam", 0.9)
+	require.NoError(t, err)
+	assert.Equal(t, "second_step@v1", again.PromptVersion)
+
+	_, err = db.ResolveFUDReview(first.ID, REVIEW_STATUS_CONFIRMED, 1, "mod")
+	require.NoError(t, err)
+	_, err = db.ResolveFUDReview(third.ID, REVIEW_STATUS_REJECTED, 1, "mod")
+	require.NoError(t, err)
+
+	stats, err := db.GetPromptReviewStats()
+	require.NoError(t, err)
+	assert.Equal(t, PromptReviewStats{PromptVersion: "second_step@v1", Opened: 2, Confirmed: 1}, stats["second_step@v1"])
+	assert.Equal(t, PromptReviewStats{PromptVersion: "second_step@v2", Opened: 1, Rejected: 1}, stats["second_step@v2"])
+
+	// a review of a prompt rendered over a base prompt counts for both
+	based := db.WithStatusChange(StatusChange{Source: STATUS_SOURCE_SECOND_STEP, PromptVersion: "second_step@v2+second_step_base@v3"})
+	_, err = based.OpenFUDReview("u4", "dave", "t5", "spam", 0.9)
+	require.NoError(t, err)
+	stats, err = db.GetPromptReviewStats()
+	require.NoError(t, err)
+	assert.Equal(t, PromptReviewStats{PromptVersion: "second_step@v2", Opened: 2, Rejected: 1}, stats["second_step@v2"])
+	assert.Equal(t, PromptReviewStats{PromptVersion: "second_step_base@v3", Opened: 1}, stats["second_step_base@v3"])
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/grutapig/hackaton/claude"
+	"github.com/grutapig/hackaton/twitterapi"
+	"github.com/grutapig/hackaton/twittersource"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const REPLAY_COMMUNITY_ID = "community_replay"
+const REPLAY_TICKER = "$REPLAY"
+const REPLAY_CHAT_ID = 1001
+
+// scriptedLLM answers every structured request with the tool input returned by
+// its script, the analyzed reply is the last message of the request.
+type scriptedLLM struct {
+	mu     sync.Mutex
+	script func(tool string, analyzed string) string
+	calls  map[string][]string
+}
+
+func (c *scriptedLLM) SendMessage(messages claude.ClaudeMessages, systemMessage string) (*claude.ClaudeMessageResponse, error) {
+	return c.SendMessageWithOptions(context.Background(), messages, systemMessage, claude.MessageOptions{})
+}
+
+func (c *scriptedLLM) SendMessageContext(ctx context.Context, messages claude.ClaudeMessages, systemMessage string) (*claude.ClaudeMessageResponse, error) {
+	return c.SendMessageWithOptions(ctx, messages, systemMessage, claude.MessageOptions{})
+}
+
+func (c *scriptedLLM) SendMessageWithOptions(ctx context.Context, messages claude.ClaudeMessages, systemMessage string, options claude.MessageOptions) (*claude.ClaudeMessageResponse, error) {
+	tool := ""
+	if options.ToolChoice != nil {
+		tool = options.ToolChoice.Name
+	}
+	analyzed := ""
+	if len(messages) > 0 {
+		analyzed = messages[len(messages)-1].Content
+	}
+
+	c.mu.Lock()
+	c.calls[tool] = append(c.calls[tool], analyzed)
+	c.mu.Unlock()
+
+	return &claude.ClaudeMessageResponse{
+		Model:      c.GetModel(),
+		StopReason: "tool_use",
+		Content:    []claude.Content{{Type: claude.CONTENT_TYPE_TOOL_USE, ID: "toolu_replay", Name: tool, Input: json.RawMessage(c.script(tool, analyzed))}},
+	}, nil
+}
+
+func (c *scriptedLLM) SetRetryPolicy(policy claude.RetryPolicy) {}
+
+func (c *scriptedLLM) GetModel() string {
+	return "replay-model"
+}
+
+// keywordScript flags the replies containing one of the words, the second step
+// decides on the reply being analyzed as well.
+func keywordScript(words ...string) func(tool string, analyzed string) string {
+	return func(tool string, analyzed string) string {
+		isFUD := false
+		for _, word := range words {
+			if strings.Contains(strings.ToLower(analyzed), word) {
+				isFUD = true
+			}
+		}
+		if tool == FirstStepTool.Name {
+			return `{"is_fud":` + map[bool]string{true: "true", false: "false"}[isFUD] + `}`
+		}
+		if isFUD {
+			return `{"is_fud_attack":true,"is_fud_user":true,"fud_probability":0.9,"fud_type":"direct_attack","user_risk_level":"high","key_evidence":["calls the project a rug"],"decision_reason":"attacks the project","user_summary":"fudder"}`
+		}
+		return `{"is_fud_attack":false,"is_fud_user":false,"fud_probability":0.05,"fud_type":"none","user_risk_level":"low","key_evidence":[],"decision_reason":"regular holder","user_summary":"holder"}`
+	}
+}
+
+// telegramRecorder stands in for the Telegram Bot API and keeps the sent
+// messages.
+type telegramRecorder struct {
+	mu       sync.Mutex
+	messages []TelegramSendMessageRequest
+	answers  []TelegramAnswerCallbackRequest
+	edits    []TelegramEditReplyMarkupRequest
+}
+
+func (r *telegramRecorder) RoundTrip(request *http.Request) (*http.Response, error) {
+	r.mu.Lock()
+	switch {
+	case strings.HasSuffix(request.URL.Path, "/sendMessage"):
+		var message TelegramSendMessageRequest
+		json.NewDecoder(request.Body).Decode(&message)
+		r.messages = append(r.messages, message)
+	case strings.HasSuffix(request.URL.Path, "/answerCallbackQuery"):
+		var answer TelegramAnswerCallbackRequest
+		json.NewDecoder(request.Body).Decode(&answer)
+		r.answers = append(r.answers, answer)
+	case strings.HasSuffix(request.URL.Path, "/editMessageReplyMarkup"):
+		var edit TelegramEditReplyMarkupRequest
+		json.NewDecoder(request.Body).Decode(&edit)
+		r.edits = append(r.edits, edit)
+	}
+	r.mu.Unlock()
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(bytes.NewBufferString(`{"ok":true,"result":{"message_id":1,"chat":{"id":1}}}`)),
+		Request:    request,
+	}, nil
+}
+
+// replayPipeline runs the real monitoring, first step, second step and
+// notification handlers over a replay source, one monitoring pass per Advance.
+// The job queues are drained synchronously so every run is deterministic.
+type replayPipeline struct {
+	source      *twittersource.ReplaySource
+	dbService   *DatabaseService
+	jobQueue    *JobQueue
+	communities *CommunityService
+	llm         *scriptedLLM
+	telegram    *telegramRecorder
+	service     *TelegramService
+	baseline    map[string]int
+}
+
+func newReplayPipeline(t *testing.T, source *twittersource.ReplaySource, llm *scriptedLLM) *replayPipeline {
+	dbService := setupTestDB(t)
+	require.NoError(t, dbService.SetDefaultCommunity(REPLAY_COMMUNITY_ID))
+	communities, err := NewCommunityService(dbService, REPLAY_TICKER)
+	require.NoError(t, err)
+
+	telegram := &telegramRecorder{}
+	return &replayPipeline{
+		source:      source,
+		dbService:   dbService,
+		jobQueue:    NewJobQueue(dbService),
+		communities: communities,
+		llm:         llm,
+		telegram:    telegram,
+		service: &TelegramService{
+			apiKey:        "replay",
+			client:        &http.Client{Transport: telegram},
+			chatIDs:       map[int64]bool{REPLAY_CHAT_ID: true},
+			notifications: map[string]FUDAlertNotification{},
+			formatter:     NewNotificationFormatter(),
+			dbService:     dbService,
+		},
+		baseline: map[string]int{},
+	}
+}
+
+func (p *replayPipeline) run(ctx context.Context) {
+	for p.source.Advance() {
+		p.step(ctx)
+	}
+}
+
+func (p *replayPipeline) step(ctx context.Context) {
+	newMessageCh := make(chan twitterapi.NewMessage)
+	go func() {
+		defer close(newMessageCh)
+		MonitoringPass(ctx, p.source, newMessageCh, p.dbService, nil, p.baseline, REPLAY_COMMUNITY_ID)
+	}()
+	for message := range newMessageCh {
+		p.jobQueue.Enqueue(JOB_STAGE_FIRST_STEP, message)
+	}
+
+	notificationCh := make(chan FUDAlertNotification, 100)
+	p.drain(JOB_STAGE_FIRST_STEP, func(message twitterapi.NewMessage) error {
+		return FirstStepHandler(ctx, message, p.jobQueue, p.llm, DefaultPrompt(PROMPT_FIRST_STEP).WithBase([]byte("first step prompt")), REPLAY_TICKER, p.dbService, nil, nil, notificationCh)
+	})
+	p.drain(JOB_STAGE_SECOND_STEP, func(message twitterapi.NewMessage) error {
+		return SecondStepHandler(ctx, message, notificationCh, p.source, p.llm, DefaultPrompt(PROMPT_SECOND_STEP).WithBase([]byte("second step prompt")), REPLAY_TICKER, DefaultRelationOptions(), p.dbService, nil, nil)
+	})
+	close(notificationCh)
+	NotificationHandler(notificationCh, p.service, p.communities)
+}
+
+func (p *replayPipeline) drain(stage string, handle func(message twitterapi.NewMessage) error) {
+	for {
+		job, message, err := p.jobQueue.Lease(stage)
+		if err != nil || job == nil {
+			return
+		}
+		p.jobQueue.Finish(job, runJob(func() error { return handle(message) }))
+	}
+}
+
+func (p *replayPipeline) alerts() []string {
+	p.telegram.mu.Lock()
+	defer p.telegram.mu.Unlock()
+	var alerts []string
+	for _, message := range p.telegram.messages {
+		alerts = append(alerts, message.Text)
+	}
+	return alerts
+}
+
+func TestReplay_DetectsFUDAcrossThePipeline(t *testing.T) {
+	source, err := twittersource.LoadReplay("testdata/replay_community.jsonl")
+	require.NoError(t, err)
+	llm := &scriptedLLM{script: keywordScript("rug", "scam"), calls: map[string][]string{}}
+	pipeline := newReplayPipeline(t, source, llm)
+
+	pipeline.run(context.Background())
+
+	db := pipeline.dbService
+	assert.Equal(t, USER_STATUS_PENDING_REVIEW, db.GetUserStatus("u_mallory"))
+	assert.False(t, db.IsFUDUser("u_mallory"), "the verdict of the model waits for a reviewer")
+	for _, userID := range []string{"u_bob", "u_carol", "u_dave"} {
+		assert.Equal(t, USER_STATUS_CLEAN, db.GetUserStatus(userID), userID)
+	}
+	assert.Equal(t, USER_STATUS_UNKNOWN, db.GetUserStatus("u_alice"), "the baseline post is not analyzed")
+	for _, tweetID := range []string{"p1", "r1", "r2", "p2", "r3", "r4", "r5"} {
+		_, err := db.GetTweet(tweetID)
+		assert.NoError(t, err, tweetID)
+	}
+
+	assert.Len(t, llm.calls[SecondStepTool.Name], 4, "new users go straight to the second step")
+	require.Len(t, llm.calls[FirstStepTool.Name], 2, "known users go through the first step")
+	assert.Contains(t, llm.calls[FirstStepTool.Name][0], "mallory:told you all")
+	assert.Contains(t, llm.calls[FirstStepTool.Name][1], "bob:nope")
+
+	alerts := pipeline.alerts()
+	require.Len(t, alerts, 2)
+	assert.Contains(t, alerts[0], "mallory")
+	assert.Contains(t, alerts[0], "rug")
+	assert.Contains(t, alerts[1], "mallory")
+	assert.Contains(t, alerts[1], "scam", "the user waiting for review is reported again")
+
+	reviews, err := db.GetOpenFUDReviews(REVIEW_LIST_LIMIT)
+	require.NoError(t, err)
+	require.Len(t, reviews, 1)
+	review := reviews[0]
+	assert.Equal(t, "mallory", review.Username)
+	assert.Equal(t, 2, review.AlertCount)
+	assert.Equal(t, "second_step@builtin", review.PromptVersion)
+	for _, message := range pipeline.telegram.messages {
+		assert.Equal(t, reviewKeyboard(review.ID, true), message.ReplyMarkup)
+	}
+
+	approve := tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:      "callback_1",
+		From:    &tgbotapi.User{ID: 77, UserName: "moderator"},
+		Message: &tgbotapi.Message{MessageID: 5, Chat: &tgbotapi.Chat{ID: REPLAY_CHAT_ID}},
+		Data:    REVIEW_ACTION_APPROVE + strconv.FormatUint(uint64(review.ID), 10),
+	}}
+	pipeline.service.HandleUpdate(approve)
+	assert.Equal(t, USER_STATUS_FUD_CONFIRMED, db.GetUserStatus("u_mallory"))
+	assert.True(t, db.IsFUDUser("u_mallory"))
+	resolved, err := db.GetFUDReview(review.ID)
+	require.NoError(t, err)
+	assert.Equal(t, REVIEW_STATUS_CONFIRMED, resolved.Status)
+	assert.Equal(t, int64(77), resolved.ReviewerID)
+	assert.Equal(t, "moderator", resolved.ReviewerName)
+	assert.NotNil(t, resolved.ReviewedAt)
+
+	// the same button pressed on the other alert
+	approve.CallbackQuery.ID = "callback_2"
+	approve.CallbackQuery.From = &tgbotapi.User{ID: 78, FirstName: "Late"}
+	pipeline.service.HandleUpdate(approve)
+	require.Len(t, pipeline.telegram.answers, 2)
+	assert.Contains(t, pipeline.telegram.answers[1].Text, "already confirmed by moderator")
+	assert.Len(t, pipeline.telegram.edits, 2, "the buttons are removed from both alerts")
+
+	history, err := db.GetUserStatusHistory("u_mallory", TIMELINE_LIMIT)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, USER_STATUS_PENDING_REVIEW, history[1].NewStatus)
+	assert.Equal(t, USER_STATUS_UNKNOWN, history[1].OldStatus)
+	assert.Equal(t, STATUS_SOURCE_SECOND_STEP, history[1].Source)
+	assert.Equal(t, "r2", history[1].TweetID)
+	assert.Equal(t, "high", history[1].RiskLevel)
+	assert.NotEmpty(t, history[1].RequestUUID)
+	assert.Equal(t, "second_step@builtin", history[1].PromptVersion)
+	assert.Equal(t, USER_STATUS_FUD_CONFIRMED, history[0].NewStatus)
+	assert.Equal(t, STATUS_SOURCE_REVIEW, history[0].Source)
+	assert.Equal(t, "moderator", history[0].Actor)
+
+	pipeline.service.HandleUpdate(tgbotapi.Update{Message: &tgbotapi.Message{Text: "/timeline_mallory", Chat: &tgbotapi.Chat{ID: REPLAY_CHAT_ID}}})
+	alerts = pipeline.alerts()
+	timeline := alerts[len(alerts)-1]
+	assert.Contains(t, timeline, "unknown → <b>pending_review</b>")
+	assert.Contains(t, timeline, "By: review, moderator")
+	assert.Contains(t, timeline, "Prompt: <code>second_step@builtin</code>")
+	assert.Less(t, strings.Index(timeline, "pending_review</b>"), strings.Index(timeline, "fud_confirmed</b>"), "oldest first")
+
+	stats, err := pipeline.jobQueue.Stats()
+	require.NoError(t, err)
+	for _, stage := range stats {
+		assert.Zero(t, stage.Pending+stage.Leased+stage.Dead, stage.Stage)
+	}
+}
+
+func TestReplay_ReverseCaptureWithoutFUD(t *testing.T) {
+	source, err := twittersource.LoadReplay("docs/community_tweets.json")
+	require.NoError(t, err)
+	require.NotZero(t, source.Len())
+	llm := &scriptedLLM{script: keywordScript(), calls: map[string][]string{}}
+	pipeline := newReplayPipeline(t, source, llm)
+
+	pipeline.run(context.Background())
+
+	assert.Empty(t, pipeline.alerts())
+	assert.NotEmpty(t, llm.calls[SecondStepTool.Name])
+	fudUsers, err := pipeline.dbService.GetFUDUserCount()
+	require.NoError(t, err)
+	assert.Zero(t, fudUsers)
+}
+
+func TestReplay_UserOverrides(t *testing.T) {
+	source, err := twittersource.LoadReplay("testdata/replay_community.jsonl")
+	require.NoError(t, err)
+	llm := &scriptedLLM{script: keywordScript("rug", "scam"), calls: map[string][]string{}}
+	pipeline := newReplayPipeline(t, source, llm)
+	db := pipeline.dbService
+	_, err = db.SetUserOverride(UserOverrideModel{Username: "@Mallory", Kind: OVERRIDE_KIND_ALLOW, Reason: "project team", AddedBy: "admin"})
+	require.NoError(t, err)
+	_, err = db.SetUserOverride(UserOverrideModel{Username: "carol", Kind: OVERRIDE_KIND_DENY, Reason: "paid campaign", AddedBy: "admin"})
+	require.NoError(t, err)
+
+	pipeline.run(context.Background())
+
+	assert.Equal(t, USER_STATUS_CLEAN, db.GetUserStatus("u_mallory"))
+	assert.False(t, db.IsFUDUser("u_mallory"))
+	assert.Equal(t, USER_STATUS_FUD_CONFIRMED, db.GetUserStatus("u_carol"))
+	assert.True(t, db.IsFUDUser("u_carol"))
+	for _, calls := range llm.calls {
+		for _, analyzed := range calls {
+			assert.NotContains(t, analyzed, "mallory:", "overridden users are not analyzed")
+			assert.NotContains(t, analyzed, "carol:")
+		}
+	}
+	assert.Len(t, llm.calls[SecondStepTool.Name], 2)
+
+	alerts := pipeline.alerts()
+	require.Len(t, alerts, 1, "the allowlisted user is never reported")
+	assert.Contains(t, alerts[0], "carol")
+	assert.Contains(t, alerts[0], "Denylisted by admin: paid campaign")
+	reviews, err := db.GetOpenFUDReviews(REVIEW_LIST_LIMIT)
+	require.NoError(t, err)
+	assert.Empty(t, reviews)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/grutapig/hackaton/twitterapi_reverse"
+)
+
+// ReverseSessionService keeps the session pool of the reverse API in the
+// database. Credentials are stored encrypted with AES-GCM under a key derived
+// from reverse_session_key, without the key no session can be stored and the
+// reverse API keeps using the twitter_reverse_* credentials.
+type ReverseSessionService struct {
+	dbService *DatabaseService
+	key       []byte
+	pool      *twitterapi_reverse.SessionPool
+}
+
+func NewReverseSessionService(dbService *DatabaseService, secret string) (*ReverseSessionService, error) {
+	service := &ReverseSessionService{
+		dbService: dbService,
+		pool:      twitterapi_reverse.NewSessionPool(),
+	}
+	if secret == "" {
+		log.Printf("%s is not set, reverse API sessions cannot be stored", ENV_REVERSE_SESSION_KEY)
+		return service, nil
+	}
+	key := sha256.Sum256([]byte(secret))
+	service.key = key[:]
+
+	models, err := dbService.GetReverseSessions()
+	if err != nil {
+		return nil, fmt.Errorf("reverse sessions: %w", err)
+	}
+	for _, model := range models {
+		auth, err := service.decrypt(model.AuthCiphertext)
+		if err != nil {
+			log.Printf("Cannot decrypt reverse session %s (%s), is %s the key it was stored with? %v", model.ID, model.Label, ENV_REVERSE_SESSION_KEY, err)
+			continue
+		}
+		session := twitterapi_reverse.Session{
+			ID:        model.ID,
+			Label:     model.Label,
+			Auth:      auth,
+			Status:    model.Status,
+			LastError: model.LastError,
+		}
+		if model.CoolingUntil != nil {
+			session.CoolingUntil = *model.CoolingUntil
+		}
+		if model.LastThrottledAt != nil {
+			session.LastThrottled = *model.LastThrottledAt
+		}
+		service.pool.Put(session)
+	}
+	if service.pool.Len() > 0 {
+		log.Printf("Loaded %d reverse API sessions", service.pool.Len())
+	}
+
+	service.pool.OnChange(service.persist)
+	return service, nil
+}
+
+func (s *ReverseSessionService) Pool() *twitterapi_reverse.SessionPool {
+	return s.pool
+}
+
+func (s *ReverseSessionService) Sessions() []twitterapi_reverse.Session {
+	return s.pool.Sessions()
+}
+
+// Add stores the session and puts it in the pool. A session with the same
+// label is replaced, which is how an expired account is logged in again.
+func (s *ReverseSessionService) Add(label string, auth *twitterapi_reverse.TwitterAuth) (*twitterapi_reverse.Session, error) {
+	if s.key == nil {
+		return nil, fmt.Errorf("%s is not set", ENV_REVERSE_SESSION_KEY)
+	}
+	ciphertext, err := s.encrypt(auth)
+	if err != nil {
+		return nil, err
+	}
+
+	session := twitterapi_reverse.Session{Label: label, Auth: auth, Status: twitterapi_reverse.SESSION_STATUS_ACTIVE}
+	for _, existing := range s.pool.Sessions() {
+		if existing.Label == label {
+			session.ID = existing.ID
+		}
+	}
+	if session.ID == "" {
+		id := make([]byte, 4)
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+		session.ID = hex.EncodeToString(id)
+	}
+
+	err = s.dbService.SaveReverseSession(ReverseSessionModel{
+		ID:             session.ID,
+		Label:          label,
+		AuthCiphertext: ciphertext,
+		Status:         session.Status,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.pool.Put(session)
+	return &session, nil
+}
+
+// SetEnabled disables the session or returns it to the pool.
+func (s *ReverseSessionService) SetEnabled(id string, enabled bool) error {
+	status := twitterapi_reverse.SESSION_STATUS_DISABLED
+	if enabled {
+		status = twitterapi_reverse.SESSION_STATUS_ACTIVE
+	}
+	if !s.pool.SetStatus(id, status) {
+		return fmt.Errorf("reverse session %s not found", id)
+	}
+	return nil
+}
+
+func (s *ReverseSessionService) persist(session twitterapi_reverse.Session) {
+	var coolingUntil, lastThrottledAt *time.Time
+	if !session.CoolingUntil.IsZero() {
+		coolingUntil = &session.CoolingUntil
+	}
+	if !session.LastThrottled.IsZero() {
+		lastThrottledAt = &session.LastThrottled
+	}
+	if session.Status != twitterapi_reverse.SESSION_STATUS_ACTIVE {
+		log.Printf("Reverse session %s (%s) is %s: %s", session.ID, session.Label, session.Status, session.LastError)
+	}
+	err := s.dbService.UpdateReverseSessionStatus(session.ID, session.Status, coolingUntil, lastThrottledAt, session.LastError)
+	if err != nil {
+		log.Printf("Failed to save reverse session %s: %v", session.ID, err)
+	}
+}
+
+func (s *ReverseSessionService) encrypt(auth *twitterapi_reverse.TwitterAuth) (string, error) {
+	plaintext, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := s.cipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+func (s *ReverseSessionService) decrypt(ciphertext string) (*twitterapi_reverse.TwitterAuth, error) {
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := s.cipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, err
+	}
+	auth := &twitterapi_reverse.TwitterAuth{}
+	if err := json.Unmarshal(plaintext, auth); err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+func (s *ReverseSessionService) cipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/grutapig/hackaton/twitterapi_reverse"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReverseSessionService_StoresSessionsEncrypted(t *testing.T) {
+	dbService := setupTestDB(t)
+
+	service, err := NewReverseSessionService(dbService, "secret")
+	require.NoError(t, err)
+	auth := twitterapi_reverse.NewTwitterAuth("Bearer token", "csrf", "auth_token=cookie")
+	session, err := service.Add("main", auth)
+	require.NoError(t, err)
+
+	models, err := dbService.GetReverseSessions()
+	require.NoError(t, err)
+	require.Len(t, models, 1)
+	assert.NotContains(t, models[0].AuthCiphertext, "auth_token")
+
+	service.Pool().Report(session.ID, http.StatusTooManyRequests, http.Header{}, "rate limited")
+
+	reloaded, err := NewReverseSessionService(dbService, "secret")
+	require.NoError(t, err)
+	sessions := reloaded.Sessions()
+	require.Len(t, sessions, 1)
+	assert.Equal(t, auth, sessions[0].Auth)
+	assert.Equal(t, twitterapi_reverse.SESSION_STATUS_COOLING, sessions[0].Status)
+	assert.False(t, sessions[0].CoolingUntil.IsZero())
+
+	replaced, err := reloaded.Add("main", twitterapi_reverse.NewTwitterAuth("Bearer token", "csrf2", "auth_token=cookie2"))
+	require.NoError(t, err)
+	assert.Equal(t, session.ID, replaced.ID, "a session with the same label is replaced")
+	assert.Equal(t, twitterapi_reverse.SESSION_STATUS_ACTIVE, reloaded.Sessions()[0].Status)
+
+	wrongKey, err := NewReverseSessionService(dbService, "other secret")
+	require.NoError(t, err)
+	assert.Empty(t, wrongKey.Sessions())
+
+	withoutKey, err := NewReverseSessionService(dbService, "")
+	require.NoError(t, err)
+	_, err = withoutKey.Add("second", auth)
+	assert.Error(t, err)
+}
@@ -1,19 +1,35 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/grutapig/hackaton/claude"
 	"github.com/grutapig/hackaton/twitterapi"
+	"github.com/grutapig/hackaton/twittersource"
 	"log"
-	"os"
 	"time"
 )
 
-func SecondStepHandler(newMessage twitterapi.NewMessage, notificationCh chan FUDAlertNotification, twitterApi *twitterapi.TwitterAPIService, claudeApi *claude.ClaudeApi, systemPromptSecondStep []byte, ticker string, dbService *DatabaseService, loggingService *LoggingService) {
+// SecondStepHandler runs the detailed analysis of the message author with the
+// prompt selected for the user. An error is returned when the job should be
+// retried.
+func SecondStepHandler(ctx context.Context, newMessage twitterapi.NewMessage, notificationCh chan FUDAlertNotification, source twittersource.Source, claudeApi claude.LLMClient, prompt Prompt, ticker string, relationOptions RelationOptions, dbService *DatabaseService, loggingService *LoggingService, budget *AIBudgetService) error {
 
 	requestUUID := uuid.New().String()
+	statusSource := STATUS_SOURCE_SECOND_STEP
+	if newMessage.IsManualAnalysis {
+		statusSource = STATUS_SOURCE_MANUAL
+	}
+	change := StatusChange{Source: statusSource, TweetID: newMessage.TweetID, RequestUUID: requestUUID}
+	dbService = dbService.ForCommunity(newMessage.CommunityID).WithStatusChange(change)
+	if override := dbService.GetUserOverride(newMessage.Author.ID, newMessage.Author.UserName); override != nil {
+		log.Printf("%s user %s - using the override instead of the analysis", override.Label(), newMessage.Author.UserName)
+		applySecondStepDecision(newMessage, override.Decision(), notificationCh, dbService)
+		recordSecondStepOutcome(ctx, override.Decision(), true)
+		return nil
+	}
 
 	if loggingService != nil {
 		loggingService.StartRequestProcessing(requestUUID, newMessage.Author.ID, newMessage.Author.UserName, newMessage.TweetID, 5)
@@ -22,43 +38,92 @@ func SecondStepHandler(newMessage twitterapi.NewMessage, notificationCh chan FUD
 	if !newMessage.IsManualAnalysis {
 		if cachedResult, err := dbService.GetCachedAnalysis(newMessage.Author.ID); err == nil {
 			log.Printf("Using cached analysis for user %s", newMessage.Author.UserName)
+			applyCachedAnalysis(newMessage, *cachedResult, notificationCh, dbService)
+			recordSecondStepOutcome(ctx, *cachedResult, false)
+			return nil
+		}
+	}
 
-			aiDecision2 := *cachedResult
+	claudeMessages := collectSecondStepMessages(ctx, newMessage, limitedSecondStepSource(source), ticker, relationOptions, dbService, loggingService, requestUUID)
+	pretty, _ := json.MarshalIndent(claudeMessages, "", "\t")
+	fmt.Println("send to analyze:", string(pretty))
+	//fmt.Println("send to analyze:")
+	systemBlocks, err := PrepareClaudeSecondStepSystem(prompt, ticker, newMessage.Author.UserName, newMessage.IsManualAnalysis)
+	if err != nil {
+		failManualAnalysisTask(newMessage, err, dbService)
+		return fmt.Errorf("render prompt %s: %w", prompt.Label(), err)
+	}
+	change.PromptVersion = prompt.Label()
+	dbService = dbService.WithStatusChange(change)
 
-			dbService.UpdateUserAfterAnalysis(newMessage.Author.ID, newMessage.Author.UserName, aiDecision2, newMessage.TweetID)
+	if err := waitSecondStepLimit(ctx, SECOND_STEP_LIMIT_LLM); err != nil {
+		return err
+	}
+	aiDecision2 := SecondStepClaudeResponse{}
+	resp, err := sendStructuredAIRequest(ctx, claudeApi, loggingService, budget, AIRequestMeta{
+		RequestUUID:   requestUUID,
+		UserID:        newMessage.Author.ID,
+		Username:      newMessage.Author.UserName,
+		TweetID:       newMessage.TweetID,
+		RequestType:   REQUEST_TYPE_SECOND_STEP,
+		StepNumber:    2,
+		PromptVersion: prompt.Label(),
+	}, claude.StructuredRequest{
+		Messages:     claudeMessages,
+		SystemBlocks: systemBlocks,
+		Tool:         SecondStepTool,
+		MaxTokens:    SECOND_STEP_MAX_TOKENS,
+	}, &aiDecision2)
+	fmt.Println("claude make a decision for this user:", resp, err)
 
-			if !aiDecision2.IsFUDUser {
-				if dbService.IsFUDUser(newMessage.Author.ID) {
-					err := dbService.DeleteFUDUser(newMessage.Author.ID)
-					if err != nil {
-						log.Printf("Failed to remove user %s from FUD list: %v", newMessage.Author.UserName, err)
-					} else {
-						log.Printf("Removed user %s from FUD list - cached analysis shows user is clean", newMessage.Author.UserName)
-					}
-
-					err = dbService.UpdateUserFUDStatus(newMessage.Author.ID, false, "")
-					if err != nil {
-						log.Printf("Failed to update FUD status for user %s: %v", newMessage.Author.UserName, err)
-					}
-				}
-			}
+	if err != nil {
+		if loggingService != nil {
+			loggingService.UpdateRequestProcessingStatus(requestUUID, PROCESSING_STATUS_FAILED, 4)
+		}
+		failManualAnalysisTask(newMessage, err, dbService)
+		log.Printf("error claude second step: %s", err)
+		return err
+	}
 
-			if aiDecision2.IsFUDUser || newMessage.ForceNotification {
-				sendCachedNotification(newMessage, aiDecision2, notificationCh, dbService)
-			}
+	pretty, _ = json.MarshalIndent(aiDecision2, "", "\t")
+	fmt.Println(string(pretty))
 
-			dbService.MarkUserAsDetailAnalyzed(newMessage.Author.ID)
+	applySecondStepDecision(newMessage, aiDecision2, notificationCh, dbService)
+	recordSecondStepOutcome(ctx, aiDecision2, false)
 
-			if newMessage.IsManualAnalysis && newMessage.TaskID != "" {
-				completeManualAnalysisTask(newMessage, aiDecision2, dbService)
-			}
+	if loggingService != nil {
+		loggingService.UpdateRequestProcessingStatus(requestUUID, PROCESSING_STATUS_COMPLETED, 5)
+	}
+	return nil
+}
 
-			return
-		}
+// applyCachedAnalysis applies a previous second step decision to a new message
+// of the same user.
+func applyCachedAnalysis(newMessage twitterapi.NewMessage, aiDecision2 SecondStepClaudeResponse, notificationCh chan FUDAlertNotification, dbService *DatabaseService) {
+	dbService = dbService.WithStatusChange(StatusChange{Source: STATUS_SOURCE_CACHE, TweetID: newMessage.TweetID})
+	dbService.UpdateUserAfterAnalysis(newMessage.Author.ID, newMessage.Author.UserName, aiDecision2, newMessage.TweetID)
+
+	if !aiDecision2.IsFUDUser {
+		recordCleanVerdict(newMessage, aiDecision2, dbService)
+	}
+
+	if aiDecision2.IsFUDUser || newMessage.ForceNotification {
+		sendCachedNotification(newMessage, aiDecision2, notificationCh, dbService)
+	}
+
+	dbService.MarkUserAsDetailAnalyzed(newMessage.Author.ID)
+
+	if newMessage.IsManualAnalysis && newMessage.TaskID != "" {
+		completeManualAnalysisTask(newMessage, aiDecision2, dbService)
 	}
+}
 
+// collectSecondStepMessages gathers ticker mentions, community activity and the
+// follower graph of the analyzed user and builds the second step conversation.
+func collectSecondStepMessages(ctx context.Context, newMessage twitterapi.NewMessage, source twittersource.Source, ticker string, relationOptions RelationOptions, dbService *DatabaseService, loggingService *LoggingService, requestUUID string) claude.ClaudeMessages {
+	ctx = WithRequestUUID(ctx, requestUUID)
 	startTime := time.Now()
-	userTickerMentions := getUserTickerMentions(twitterApi, newMessage.Author.UserName, ticker, dbService)
+	userTickerMentions := getUserTickerMentions(ctx, source, newMessage.Author.UserName, ticker, dbService)
 	collectionTime := int(time.Since(startTime).Milliseconds())
 
 	if loggingService != nil {
@@ -94,184 +159,73 @@ func SecondStepHandler(newMessage twitterapi.NewMessage, notificationCh chan FUD
 		}(), "")
 	}
 
-	startTime = time.Now()
-	followers, err := twitterApi.GetUserFollowers(twitterapi.UserFollowersRequest{UserName: newMessage.Author.UserName})
-	collectionTime = int(time.Since(startTime).Milliseconds())
-
-	if loggingService != nil {
-		dataCount := 0
-		if followers != nil {
-			dataCount = len(followers.Followers)
+	relations := &UserRelationsData{}
+	for _, relationType := range []string{RELATION_TYPE_FOLLOWER, RELATION_TYPE_FOLLOWING} {
+		startTime = time.Now()
+		usernames, fetched, err := collectUserRelations(ctx, source, dbService, newMessage.Author.ID, newMessage.Author.UserName, relationType, relationOptions)
+		collectionTime = int(time.Since(startTime).Milliseconds())
+		if err != nil {
+			log.Printf("Error getting %ss of %s: %v", relationType, newMessage.Author.UserName, err)
 		}
-		loggingService.LogDataCollection(requestUUID, newMessage.Author.ID, newMessage.Author.UserName, DATA_TYPE_FOLLOWERS, dataCount, 0, collectionTime, err == nil, func() string {
-			if err != nil {
-				return err.Error()
-			}
-			return ""
-		}(), "")
-	}
 
-	startTime = time.Now()
-	followings, err := twitterApi.GetUserFollowings(twitterapi.UserFollowingsRequest{UserName: newMessage.Author.UserName})
-	collectionTime = int(time.Since(startTime).Milliseconds())
-
-	if loggingService != nil {
-		dataCount := 0
-		if followings != nil {
-			dataCount = len(followings.Followings)
+		dataType := DATA_TYPE_FOLLOWERS
+		if relationType == RELATION_TYPE_FOLLOWING {
+			relations.Followings = usernames
+			dataType = DATA_TYPE_FOLLOWING
+		} else {
+			relations.Followers = usernames
 		}
-		loggingService.LogDataCollection(requestUUID, newMessage.Author.ID, newMessage.Author.UserName, DATA_TYPE_FOLLOWING, dataCount, 0, collectionTime, err == nil, func() string {
-			if err != nil {
-				return err.Error()
-			}
-			return ""
-		}(), "")
-	}
-
-	if followers != nil && len(followers.Followers) > 0 {
-		followerIDs := make([]string, len(followers.Followers))
-		for i, follower := range followers.Followers {
-			followerIDs[i] = follower.Id
 
-			if !dbService.UserExists(follower.Id) {
-				user := UserModel{
-					ID:       follower.Id,
-					Username: follower.UserName,
-					Name:     follower.Name,
+		if loggingService != nil {
+			loggingService.LogDataCollection(requestUUID, newMessage.Author.ID, newMessage.Author.UserName, dataType, len(usernames), 0, collectionTime, err == nil, func() string {
+				if err != nil {
+					return err.Error()
 				}
-				dbService.SaveUser(user)
-			}
-		}
-		err = dbService.SaveUserRelations(newMessage.Author.ID, followerIDs, RELATION_TYPE_FOLLOWER)
-		if err != nil {
-			log.Printf("Failed to save followers for user %s: %v", newMessage.Author.UserName, err)
-		} else {
-			log.Printf("Saved %d followers for user %s", len(followerIDs), newMessage.Author.UserName)
+				return ""
+			}(), fmt.Sprintf("{\"fetched\":%t}", fetched))
 		}
 	}
 
-	if followings != nil && len(followings.Followings) > 0 {
-		followingIDs := make([]string, len(followings.Followings))
-		for i, following := range followings.Followings {
-			followingIDs[i] = following.Id
-
-			if !dbService.UserExists(following.Id) {
-				user := UserModel{
-					ID:       following.Id,
-					Username: following.UserName,
-					Name:     following.Name,
-				}
-				dbService.SaveUser(user)
-			}
-		}
-		err = dbService.SaveUserRelations(newMessage.Author.ID, followingIDs, RELATION_TYPE_FOLLOWING)
-		if err != nil {
-			log.Printf("Failed to save followings for user %s: %v", newMessage.Author.UserName, err)
-		} else {
-			log.Printf("Saved %d followings for user %s", len(followingIDs), newMessage.Author.UserName)
-		}
+	fudRelations, err := dbService.GetFUDRelationHistory(newMessage.Author.ID)
+	if err != nil {
+		log.Printf("Error getting FUD relations of %s: %v", newMessage.Author.UserName, err)
 	}
+	relations.FUDRelations = fudRelations
 
-	claudeMessages := PrepareClaudeSecondStepRequest(userTickerMentions, followers, followings, dbService, userCommunityActivity)
+	claudeMessages := PrepareClaudeSecondStepRequest(userTickerMentions, relations, dbService, userCommunityActivity)
 
 	if newMessage.GrandParentTweet.ID != "" {
-		claudeMessages = append(claudeMessages, claude.ClaudeMessage{claude.ROLE_USER, "the main post is: " + newMessage.GrandParentTweet.Author + ":" + newMessage.GrandParentTweet.Text})
-		claudeMessages = append(claudeMessages, claude.ClaudeMessage{claude.ROLE_USER, "reply in thread: " + newMessage.ParentTweet.Author + ":" + newMessage.ParentTweet.Text})
+		claudeMessages = append(claudeMessages, claude.ClaudeMessage{Role: claude.ROLE_USER, Content: "the main post is: " + newMessage.GrandParentTweet.Author + ":" + newMessage.GrandParentTweet.Text})
+		claudeMessages = append(claudeMessages, claude.ClaudeMessage{Role: claude.ROLE_USER, Content: "reply in thread: " + newMessage.ParentTweet.Author + ":" + newMessage.ParentTweet.Text})
 	} else {
-		claudeMessages = append(claudeMessages, claude.ClaudeMessage{claude.ROLE_USER, "the main post is: " + newMessage.ParentTweet.Author + ":" + newMessage.ParentTweet.Text})
+		claudeMessages = append(claudeMessages, claude.ClaudeMessage{Role: claude.ROLE_USER, Content: "the main post is: " + newMessage.ParentTweet.Author + ":" + newMessage.ParentTweet.Text})
 	}
 
-	claudeMessages = append(claudeMessages, claude.ClaudeMessage{claude.ROLE_USER, "user reply being analyzed: " + newMessage.Author.UserName + ":" + newMessage.Text})
-	claudeMessages = append(claudeMessages, claude.ClaudeMessage{Role: claude.ROLE_ASSISTANT, Content: "{"})
-	pretty, _ := json.MarshalIndent(claudeMessages, "", "\t")
-	fmt.Println("send to analyze:", string(pretty))
-	//fmt.Println("send to analyze:")
-	systemPromptModified := string(systemPromptSecondStep)
-	if newMessage.IsManualAnalysis {
-		systemPromptModified += "\n\nIMPORTANT: This is a MANUAL ANALYSIS REQUEST initiated by an administrator. Please provide a thorough analysis regardless of normal filtering criteria."
-	}
-	systemPromptModified += " analyzed user is " + newMessage.Author.UserName
-	systemTicker := os.Getenv(ENV_TWITTER_COMMUNITY_TICKER)
-
-	startTime = time.Now()
-	resp, err := claudeApi.SendMessage(claudeMessages, systemPromptModified+"\nthe system ticker is:"+systemTicker+", it cannot be used for any criteria or flag about decision FUD or not")
-	processingTime := int(time.Since(startTime).Milliseconds())
+	claudeMessages = append(claudeMessages, claude.ClaudeMessage{Role: claude.ROLE_USER, Content: "user reply being analyzed: " + newMessage.Author.UserName + ":" + newMessage.Text})
 
-	if loggingService != nil {
-		loggingService.LogAIRequest(requestUUID, newMessage.Author.ID, newMessage.Author.UserName, newMessage.TweetID, REQUEST_TYPE_SECOND_STEP, 2, claudeMessages, resp, 0, processingTime, err == nil, func() string {
-			if err != nil {
-				return err.Error()
-			}
-			return ""
-		}())
-	}
-
-	aiDecision2 := SecondStepClaudeResponse{}
-	fmt.Println("claude make a decision for this user:", resp, err)
-
-	if err != nil {
-		if loggingService != nil {
-			loggingService.UpdateRequestProcessingStatus(requestUUID, PROCESSING_STATUS_FAILED, 4)
-		}
-		failManualAnalysisTask(newMessage, err, dbService)
-		log.Printf("error claude second step: %s", err)
-		return
-	}
+	return claudeMessages
+}
 
-	err = json.Unmarshal([]byte("{"+resp.Content[0].Text), &aiDecision2)
-	if err != nil {
-		log.Printf("error unmarshaling claude response: %s", err)
-		return
+// applySecondStepDecision stores the second step decision, updates the FUD list,
+// sends the alert and saves the cached analysis. The override of the user, when
+// there is one, replaces the decision and is not cached.
+func applySecondStepDecision(newMessage twitterapi.NewMessage, aiDecision2 SecondStepClaudeResponse, notificationCh chan FUDAlertNotification, dbService *DatabaseService) {
+	override := applyUserOverride(newMessage, dbService)
+	if override != nil {
+		aiDecision2 = override.Decision()
 	}
-	pretty, _ = json.MarshalIndent(aiDecision2, "", "\t")
-	fmt.Println(string(pretty))
-
 	dbService.UpdateUserAfterAnalysis(newMessage.Author.ID, newMessage.Author.UserName, aiDecision2, newMessage.TweetID)
 
 	if !aiDecision2.IsFUDUser {
-		if dbService.IsFUDUser(newMessage.Author.ID) {
-			err := dbService.DeleteFUDUser(newMessage.Author.ID)
-			if err != nil {
-				log.Printf("Failed to remove user %s from FUD list: %v", newMessage.Author.UserName, err)
-			} else {
-				log.Printf("Removed user %s from FUD list - analysis shows user is clean", newMessage.Author.UserName)
-			}
-
-			err = dbService.UpdateUserFUDStatus(newMessage.Author.ID, false, "")
-			if err != nil {
-				log.Printf("Failed to update FUD status for user %s: %v", newMessage.Author.UserName, err)
-			}
-		}
+		recordCleanVerdict(newMessage, aiDecision2, dbService)
 	}
 
+	var err error
 	if aiDecision2.IsFUDUser || newMessage.ForceNotification {
 
+		var reviewID uint
 		if aiDecision2.IsFUDUser {
-			fudUser := FUDUserModel{
-				UserID:         newMessage.Author.ID,
-				Username:       newMessage.Author.UserName,
-				FUDType:        aiDecision2.FUDType,
-				FUDProbability: aiDecision2.FUDProbability,
-				DetectedAt:     time.Now(),
-				MessageCount:   1,
-				LastMessageID:  newMessage.TweetID,
-			}
-
-			if dbService.IsFUDUser(newMessage.Author.ID) {
-
-				err = dbService.IncrementFUDUserMessageCount(newMessage.Author.ID, newMessage.TweetID)
-				if err != nil {
-					log.Printf("Failed to increment FUD user message count: %v", err)
-				}
-			} else {
-
-				err = dbService.SaveFUDUser(fudUser)
-				if err != nil {
-					log.Printf("Failed to save FUD user: %v", err)
-				} else {
-					log.Printf("Stored new FUD user: %s", newMessage.Author.UserName)
-				}
-			}
+			reviewID = recordFUDVerdict(newMessage, aiDecision2, dbService)
 		}
 
 		originalPostText := ""
@@ -332,15 +286,20 @@ func SecondStepHandler(newMessage twitterapi.NewMessage, notificationCh chan FUD
 			GrandParentPostAuthor: grandParentPostAuthor,
 			HasThreadContext:      hasThreadContext,
 			TargetChatID:          newMessage.TelegramChatID,
+			CommunityID:           newMessage.CommunityID,
+			Backfill:              newMessage.Backfill,
+			ReviewID:              reviewID,
 		}
 		notificationCh <- alert
 	}
 
-	err = dbService.SaveCachedAnalysis(newMessage.Author.ID, newMessage.Author.UserName, aiDecision2)
-	if err != nil {
-		log.Printf("Failed to save cached analysis for user %s: %v", newMessage.Author.UserName, err)
-	} else {
-		log.Printf("Saved cached analysis for user %s", newMessage.Author.UserName)
+	if override == nil {
+		err = dbService.SaveCachedAnalysis(newMessage.Author.ID, newMessage.Author.UserName, aiDecision2)
+		if err != nil {
+			log.Printf("Failed to save cached analysis for user %s: %v", newMessage.Author.UserName, err)
+		} else {
+			log.Printf("Saved cached analysis for user %s", newMessage.Author.UserName)
+		}
 	}
 
 	err = dbService.MarkUserAsDetailAnalyzed(newMessage.Author.ID)
@@ -353,10 +312,40 @@ func SecondStepHandler(newMessage twitterapi.NewMessage, notificationCh chan FUD
 	if newMessage.IsManualAnalysis && newMessage.TaskID != "" {
 		completeManualAnalysisTask(newMessage, aiDecision2, dbService)
 	}
+}
 
-	if loggingService != nil {
-		loggingService.UpdateRequestProcessingStatus(requestUUID, PROCESSING_STATUS_COMPLETED, 5)
+// recordFUDVerdict counts the message of a confirmed FUD user, or opens the
+// review of the verdict and returns its id.
+func recordFUDVerdict(newMessage twitterapi.NewMessage, aiDecision2 SecondStepClaudeResponse, dbService *DatabaseService) uint {
+	if dbService.IsFUDUser(newMessage.Author.ID) {
+		err := dbService.IncrementFUDUserMessageCount(newMessage.Author.ID, newMessage.TweetID)
+		if err != nil {
+			log.Printf("Failed to increment FUD user message count: %v", err)
+		}
+		return 0
+	}
+
+	review, err := dbService.OpenFUDReview(newMessage.Author.ID, newMessage.Author.UserName, newMessage.TweetID, aiDecision2.FUDType, aiDecision2.FUDProbability)
+	if err != nil {
+		log.Printf("Failed to open FUD review for %s: %v", newMessage.Author.UserName, err)
+		return 0
+	}
+	log.Printf("FUD verdict for %s waits for review #%d", newMessage.Author.UserName, review.ID)
+	return review.ID
+}
+
+// recordCleanVerdict opens the review of a clean verdict on a confirmed FUD
+// user, who stays on the FUD list until a reviewer approves it.
+func recordCleanVerdict(newMessage twitterapi.NewMessage, aiDecision2 SecondStepClaudeResponse, dbService *DatabaseService) {
+	if !dbService.IsFUDUser(newMessage.Author.ID) {
+		return
 	}
+	review, err := dbService.OpenCleanReview(newMessage.Author.ID, newMessage.Author.UserName, newMessage.TweetID, aiDecision2.FUDProbability)
+	if err != nil {
+		log.Printf("Failed to open clean review for %s: %v", newMessage.Author.UserName, err)
+		return
+	}
+	log.Printf("Clean verdict for confirmed FUD user %s waits for review #%d", newMessage.Author.UserName, review.ID)
 }
 
 func mapRiskLevelToSeverity(riskLevel string) string {
@@ -410,32 +399,9 @@ func sendCachedNotification(newMessage twitterapi.NewMessage, aiDecision2 Second
 		hasThreadContext = true
 	}
 
+	var reviewID uint
 	if aiDecision2.IsFUDUser {
-		fudUser := FUDUserModel{
-			UserID:         newMessage.Author.ID,
-			Username:       newMessage.Author.UserName,
-			FUDType:        aiDecision2.FUDType,
-			FUDProbability: aiDecision2.FUDProbability,
-			DetectedAt:     time.Now(),
-			MessageCount:   1,
-			LastMessageID:  newMessage.TweetID,
-		}
-
-		if dbService.IsFUDUser(newMessage.Author.ID) {
-
-			err := dbService.IncrementFUDUserMessageCount(newMessage.Author.ID, newMessage.TweetID)
-			if err != nil {
-				log.Printf("Failed to increment FUD user message count: %v", err)
-			}
-		} else {
-
-			err := dbService.SaveFUDUser(fudUser)
-			if err != nil {
-				log.Printf("Failed to save FUD user: %v", err)
-			} else {
-				log.Printf("Stored new FUD user: %s", newMessage.Author.UserName)
-			}
-		}
+		reviewID = recordFUDVerdict(newMessage, aiDecision2, dbService)
 	}
 
 	alertType := aiDecision2.FUDType
@@ -472,6 +438,9 @@ func sendCachedNotification(newMessage twitterapi.NewMessage, aiDecision2 Second
 		GrandParentPostAuthor: grandParentPostAuthor,
 		HasThreadContext:      hasThreadContext,
 		TargetChatID:          newMessage.TelegramChatID,
+		CommunityID:           newMessage.CommunityID,
+		Backfill:              newMessage.Backfill,
+		ReviewID:              reviewID,
 	}
 	notificationCh <- alert
 }
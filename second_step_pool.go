@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/grutapig/hackaton/twitterapi"
+	"github.com/grutapig/hackaton/twittersource"
+)
+
+const SECOND_STEP_DEFAULT_WORKERS = 3
+
+const (
+	SECOND_STEP_LIMIT_TWITTER = "twitter"
+	SECOND_STEP_LIMIT_LLM     = "llm"
+)
+
+// SecondStepPool runs second step jobs on a fixed number of workers. A job of a
+// user whose analysis in the same community is already running does not take a
+// worker, it waits for that analysis and reuses its decision.
+type SecondStepPool struct {
+	jobQueue        *JobQueue
+	dbService       *DatabaseService
+	notificationCh  chan FUDAlertNotification
+	workers         int
+	twitterInterval time.Duration
+	llmInterval     time.Duration
+
+	mu       sync.Mutex
+	inflight map[string]*secondStepCall
+	metrics  SecondStepMetrics
+}
+
+// secondStepCall is a running analysis. The handler records its outcome for the
+// jobs attached to it: the decision, and whether an override of the user made it.
+type secondStepCall struct {
+	done     chan struct{}
+	err      error
+	decision *SecondStepClaudeResponse
+	override bool
+}
+
+type SecondStepMetrics struct {
+	Workers         int
+	Busy            int
+	Processed       int64
+	Failed          int64
+	Coalesced       int64
+	TotalWait       time.Duration
+	MaxWait         time.Duration
+	TotalProcessing time.Duration
+	MaxProcessing   time.Duration
+}
+
+// secondStepLimits are the rate limits of one worker, passed to the handler in
+// the context.
+type secondStepLimits map[string]*intervalLimiter
+
+type secondStepLimitsKey struct{}
+
+type secondStepCallKey struct{}
+
+// NewSecondStepPool creates a pool of workers, twitterPerMinute and llmPerMinute
+// limit the Twitter calls and LLM requests of each worker, 0 disables the limit.
+func NewSecondStepPool(jobQueue *JobQueue, dbService *DatabaseService, notificationCh chan FUDAlertNotification, workers int, twitterPerMinute int, llmPerMinute int) *SecondStepPool {
+	if workers <= 0 {
+		workers = SECOND_STEP_DEFAULT_WORKERS
+	}
+	return &SecondStepPool{
+		jobQueue:        jobQueue,
+		dbService:       dbService,
+		notificationCh:  notificationCh,
+		workers:         workers,
+		twitterInterval: perMinuteInterval(twitterPerMinute),
+		llmInterval:     perMinuteInterval(llmPerMinute),
+		inflight:        make(map[string]*secondStepCall),
+		metrics:         SecondStepMetrics{Workers: workers},
+	}
+}
+
+// Run leases second step jobs until ctx is done and waits for the running ones.
+// handleCtx is passed to handle, it carries the rate limits of the worker.
+func (p *SecondStepPool) Run(ctx context.Context, handleCtx context.Context, handle func(ctx context.Context, message twitterapi.NewMessage) error) {
+	idle := make(chan secondStepLimits, p.workers)
+	for i := 0; i < p.workers; i++ {
+		idle <- secondStepLimits{
+			SECOND_STEP_LIMIT_TWITTER: &intervalLimiter{interval: p.twitterInterval},
+			SECOND_STEP_LIMIT_LLM:     &intervalLimiter{interval: p.llmInterval},
+		}
+	}
+
+	running := sync.WaitGroup{}
+	defer running.Wait()
+
+	for {
+		var limits secondStepLimits
+		select {
+		case limits = <-idle:
+		case <-ctx.Done():
+			return
+		}
+
+		job, message, err := p.jobQueue.Lease(JOB_STAGE_SECOND_STEP)
+		if err != nil {
+			log.Printf("Second step pool: error leasing job: %v", err)
+		}
+		if job == nil {
+			idle <- limits
+			p.jobQueue.WaitForJob(ctx, JOB_STAGE_SECOND_STEP)
+			continue
+		}
+		wait := time.Since(job.AvailableAt)
+
+		userID := message.Author.ID
+		key := message.CommunityID + "/" + userID
+		p.mu.Lock()
+		call, attached := p.inflight[key]
+		if attached && userID != "" {
+			p.metrics.Coalesced++
+			p.mu.Unlock()
+			idle <- limits
+			log.Printf("Second step pool: message %s attached to the running analysis of %s", message.TweetID, message.Author.UserName)
+
+			running.Add(1)
+			go func() {
+				defer running.Done()
+				<-call.done
+				p.jobQueue.Finish(job, runJob(func() error { return p.reuse(message, call) }))
+			}()
+			continue
+		}
+		call = &secondStepCall{done: make(chan struct{})}
+		if userID != "" {
+			p.inflight[key] = call
+		}
+		p.metrics.Busy++
+		p.mu.Unlock()
+
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			started := time.Now()
+			call.err = runJob(func() error {
+				ctx := context.WithValue(handleCtx, secondStepLimitsKey{}, limits)
+				return handle(context.WithValue(ctx, secondStepCallKey{}, call), message)
+			})
+			p.record(wait, time.Since(started), call.err)
+
+			p.mu.Lock()
+			if p.inflight[key] == call {
+				delete(p.inflight, key)
+			}
+			p.metrics.Busy--
+			p.mu.Unlock()
+			close(call.done)
+
+			p.jobQueue.Finish(job, call.err)
+			idle <- limits
+		}()
+	}
+}
+
+// reuse applies the outcome of the analysis the message was attached to. When
+// that analysis failed the job is retried on its own.
+func (p *SecondStepPool) reuse(message twitterapi.NewMessage, call *secondStepCall) error {
+	if call.err != nil {
+		return fmt.Errorf("attached analysis failed: %w", call.err)
+	}
+	if call.decision == nil {
+		return fmt.Errorf("the attached analysis recorded no decision")
+	}
+	dbService := p.dbService.ForCommunity(message.CommunityID)
+	if call.override {
+		source := STATUS_SOURCE_SECOND_STEP
+		if message.IsManualAnalysis {
+			source = STATUS_SOURCE_MANUAL
+		}
+		applySecondStepDecision(message, *call.decision, p.notificationCh, dbService.WithStatusChange(StatusChange{Source: source, TweetID: message.TweetID}))
+		return nil
+	}
+	applyCachedAnalysis(message, *call.decision, p.notificationCh, dbService)
+	return nil
+}
+
+// recordSecondStepOutcome keeps the decision of the running analysis for the
+// jobs attached to it, override tells it came from an override of the user.
+// Outside of the worker pool it does nothing.
+func recordSecondStepOutcome(ctx context.Context, decision SecondStepClaudeResponse, override bool) {
+	if call, ok := ctx.Value(secondStepCallKey{}).(*secondStepCall); ok {
+		call.decision = &decision
+		call.override = override
+	}
+}
+
+func (p *SecondStepPool) record(wait, processing time.Duration, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.metrics.Processed++
+	if err != nil {
+		p.metrics.Failed++
+	}
+	p.metrics.TotalWait += wait
+	p.metrics.TotalProcessing += processing
+	if wait > p.metrics.MaxWait {
+		p.metrics.MaxWait = wait
+	}
+	if processing > p.metrics.MaxProcessing {
+		p.metrics.MaxProcessing = processing
+	}
+}
+
+func (p *SecondStepPool) Metrics() SecondStepMetrics {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.metrics
+}
+
+func (m SecondStepMetrics) AverageWait() time.Duration {
+	if m.Processed == 0 {
+		return 0
+	}
+	return m.TotalWait / time.Duration(m.Processed)
+}
+
+func (m SecondStepMetrics) AverageProcessing() time.Duration {
+	if m.Processed == 0 {
+		return 0
+	}
+	return m.TotalProcessing / time.Duration(m.Processed)
+}
+
+// waitSecondStepLimit waits for the worker rate limit of kind, it returns at once
+// outside of the worker pool.
+func waitSecondStepLimit(ctx context.Context, kind string) error {
+	limits, ok := ctx.Value(secondStepLimitsKey{}).(secondStepLimits)
+	if !ok || limits[kind] == nil {
+		return nil
+	}
+	return limits[kind].Wait(ctx)
+}
+
+// limitedSecondStepSource applies the Twitter rate limit of the worker to every
+// call of source, each page of a paged collection included.
+func limitedSecondStepSource(source twittersource.Source) twittersource.Source {
+	return twittersource.NewLimited(source, func(ctx context.Context) error {
+		return waitSecondStepLimit(ctx, SECOND_STEP_LIMIT_TWITTER)
+	})
+}
+
+// intervalLimiter spaces calls at least interval apart. It is used by one worker
+// at a time.
+type intervalLimiter struct {
+	interval time.Duration
+	next     time.Time
+}
+
+func (l *intervalLimiter) Wait(ctx context.Context) error {
+	if l.interval <= 0 {
+		return nil
+	}
+	if delay := time.Until(l.next); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	l.next = time.Now().Add(l.interval)
+	return nil
+}
+
+func perMinuteInterval(perMinute int) time.Duration {
+	if perMinute <= 0 {
+		return 0
+	}
+	return time.Minute / time.Duration(perMinute)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grutapig/hackaton/twitterapi"
+	"github.com/grutapig/hackaton/twittersource"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecondStepPool_CoalescesPerUser(t *testing.T) {
+	dbService := setupTestDB(t)
+	jobQueue := NewJobQueue(dbService)
+	for _, user := range []UserModel{{ID: "id_alice", Username: "alice"}, {ID: "id_bob", Username: "bob"}} {
+		require.NoError(t, dbService.SaveUser(user))
+	}
+	require.NoError(t, jobQueue.Enqueue(JOB_STAGE_SECOND_STEP, newJobMessage("1", "alice")))
+	require.NoError(t, jobQueue.Enqueue(JOB_STAGE_SECOND_STEP, newJobMessage("2", "alice")))
+	require.NoError(t, jobQueue.Enqueue(JOB_STAGE_SECOND_STEP, newJobMessage("3", "alice")))
+	require.NoError(t, jobQueue.Enqueue(JOB_STAGE_SECOND_STEP, newJobMessage("4", "bob")))
+
+	pool := NewSecondStepPool(jobQueue, dbService, make(chan FUDAlertNotification, 10), 2, 0, 0)
+
+	releaseAlice := make(chan struct{})
+	bobHandled := make(chan struct{})
+	mu := sync.Mutex{}
+	analyzed := map[string]int{}
+	handle := func(ctx context.Context, message twitterapi.NewMessage) error {
+		mu.Lock()
+		analyzed[message.Author.UserName]++
+		mu.Unlock()
+		if message.Author.UserName == "bob" {
+			close(bobHandled)
+			return nil
+		}
+		<-releaseAlice
+		decision := SecondStepClaudeResponse{UserRiskLevel: "low", UserSummary: "clean"}
+		recordSecondStepOutcome(ctx, decision, false)
+		return dbService.SaveCachedAnalysis(message.Author.ID, message.Author.UserName, decision)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pool.Run(ctx, context.Background(), handle)
+	}()
+
+	select {
+	case <-bobHandled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("bob was not analyzed while alice was running")
+	}
+	assert.Equal(t, 1, pool.Metrics().Busy)
+	close(releaseAlice)
+
+	require.Eventually(t, func() bool {
+		stats, err := jobQueue.Stats()
+		return err == nil && stats[1].Pending == 0 && stats[1].Leased == 0
+	}, 5*time.Second, 20*time.Millisecond)
+	cancel()
+	<-done
+
+	assert.Equal(t, map[string]int{"alice": 1, "bob": 1}, analyzed)
+	metrics := pool.Metrics()
+	assert.Equal(t, int64(2), metrics.Processed)
+	assert.Equal(t, int64(2), metrics.Coalesced)
+	assert.Equal(t, 0, metrics.Busy)
+	assert.True(t, dbService.IsUserDetailAnalyzed("id_alice"))
+}
+
+func TestSecondStepPool_CoalescedJobsReuseOverride(t *testing.T) {
+	dbService := setupTestDB(t)
+	jobQueue := NewJobQueue(dbService)
+	require.NoError(t, dbService.SaveUser(UserModel{ID: "id_alice", Username: "alice"}))
+	override, err := dbService.SetUserOverride(UserOverrideModel{Username: "alice", UserID: "id_alice", Kind: OVERRIDE_KIND_DENY, Reason: "paid", AddedBy: "admin"})
+	require.NoError(t, err)
+	require.NoError(t, jobQueue.Enqueue(JOB_STAGE_SECOND_STEP, newJobMessage("1", "alice")))
+	require.NoError(t, jobQueue.Enqueue(JOB_STAGE_SECOND_STEP, newJobMessage("2", "alice")))
+	require.NoError(t, jobQueue.Enqueue(JOB_STAGE_SECOND_STEP, newJobMessage("3", "alice")))
+
+	notificationCh := make(chan FUDAlertNotification, 10)
+	pool := NewSecondStepPool(jobQueue, dbService, notificationCh, 2, 0, 0)
+
+	releaseAlice := make(chan struct{})
+	started := make(chan struct{})
+	handle := func(ctx context.Context, message twitterapi.NewMessage) error {
+		close(started)
+		<-releaseAlice
+		// the override short-circuits the analysis, nothing is cached
+		recordSecondStepOutcome(ctx, override.Decision(), true)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pool.Run(ctx, context.Background(), handle)
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("alice was not analyzed")
+	}
+	require.Eventually(t, func() bool {
+		return pool.Metrics().Coalesced == 2
+	}, 5*time.Second, 20*time.Millisecond)
+	close(releaseAlice)
+
+	require.Eventually(t, func() bool {
+		stats, err := jobQueue.Stats()
+		return err == nil && stats[1].Pending == 0 && stats[1].Leased == 0
+	}, 5*time.Second, 20*time.Millisecond)
+	cancel()
+	<-done
+
+	stats, err := jobQueue.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), stats[1].Dead)
+	_, err = dbService.GetCachedAnalysis("id_alice")
+	assert.Error(t, err)
+	assert.Len(t, notificationCh, 2)
+}
+
+func TestIntervalLimiter_Wait(t *testing.T) {
+	limiter := &intervalLimiter{interval: perMinuteInterval(1200)}
+	started := time.Now()
+	require.NoError(t, limiter.Wait(context.Background()))
+	require.NoError(t, limiter.Wait(context.Background()))
+	assert.GreaterOrEqual(t, time.Since(started), 50*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, limiter.Wait(ctx), context.Canceled)
+
+	// outside of the pool there is no limit
+	assert.NoError(t, waitSecondStepLimit(context.Background(), SECOND_STEP_LIMIT_LLM))
+}
+
+func TestLimitedSecondStepSource_LimitsEveryCall(t *testing.T) {
+	replay, err := twittersource.LoadReplay("testdata/replay_community.jsonl")
+	require.NoError(t, err)
+	source := limitedSecondStepSource(replay)
+	ctx := context.WithValue(context.Background(), secondStepLimitsKey{}, secondStepLimits{
+		SECOND_STEP_LIMIT_TWITTER: &intervalLimiter{interval: 30 * time.Millisecond},
+	})
+
+	started := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err := source.Search(ctx, "$TEST", "")
+		require.NoError(t, err)
+	}
+	assert.GreaterOrEqual(t, time.Since(started), 60*time.Millisecond, "each call waits for the worker limit")
+}
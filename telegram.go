@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
@@ -35,12 +36,20 @@ type TelegramService struct {
 	claudeApi              interface{}
 	systemPromptSecondStep []byte
 	ticker                 string
-	analysisChannel        chan twitterapi.NewMessage
+	jobQueue               *JobQueue
 	loggingService         *LoggingService
+	budget                 *AIBudgetService
+	batchAnalysis          *BatchAnalysisService
+	secondStepPool         *SecondStepPool
+	communities            *CommunityService
+	reverseSessions        *ReverseSessionService
+	prompts                *PromptRegistry
+	restartHandler         func()
+	ctx                    context.Context
 	bot                    *tgbotapi.BotAPI
 }
 
-func NewTelegramService(apiKey string, proxyDSN string, initialChatIDs string, formatter *NotificationFormatter, dbService *DatabaseService, analysisChannel chan twitterapi.NewMessage) (*TelegramService, error) {
+func NewTelegramService(apiKey string, proxyDSN string, initialChatIDs string, formatter *NotificationFormatter, dbService *DatabaseService, jobQueue *JobQueue) (*TelegramService, error) {
 	transport := &http.Transport{}
 	if proxyDSN != "" {
 		proxyURL, err := url.Parse(proxyDSN)
@@ -59,16 +68,17 @@ func NewTelegramService(apiKey string, proxyDSN string, initialChatIDs string, f
 		return nil, fmt.Errorf("cannot initiate telegram bot, err: %s", err)
 	}
 	service := &TelegramService{
-		bot:             bot,
-		apiKey:          apiKey,
-		client:          client,
-		chatIDs:         make(map[int64]bool),
-		lastOffset:      0,
-		isRunning:       false,
-		notifications:   make(map[string]FUDAlertNotification),
-		formatter:       formatter,
-		dbService:       dbService,
-		analysisChannel: analysisChannel,
+		bot:           bot,
+		apiKey:        apiKey,
+		client:        client,
+		chatIDs:       make(map[int64]bool),
+		lastOffset:    0,
+		isRunning:     false,
+		notifications: make(map[string]FUDAlertNotification),
+		formatter:     formatter,
+		dbService:     dbService,
+		jobQueue:      jobQueue,
+		ctx:           context.Background(),
 	}
 	//Init chatIds from file if exists
 	data, err := os.ReadFile(os.Getenv(ENV_CHAT_IDS_FILEPATH))
@@ -126,7 +136,45 @@ func (t *TelegramService) SetAnalysisServices(twitterApi interface{}, claudeApi
 	t.ticker = ticker
 }
 
+func (t *TelegramService) SetBudgetService(budget *AIBudgetService, loggingService *LoggingService) {
+	t.budget = budget
+	t.loggingService = loggingService
+}
+
+func (t *TelegramService) SetBatchAnalysisService(batchAnalysis *BatchAnalysisService) {
+	t.batchAnalysis = batchAnalysis
+}
+
+func (t *TelegramService) SetSecondStepPool(secondStepPool *SecondStepPool) {
+	t.secondStepPool = secondStepPool
+}
+
+func (t *TelegramService) SetCommunityService(communities *CommunityService) {
+	t.communities = communities
+}
+
+func (t *TelegramService) SetReverseSessionService(reverseSessions *ReverseSessionService) {
+	t.reverseSessions = reverseSessions
+}
+
+func (t *TelegramService) SetPromptRegistry(prompts *PromptRegistry) {
+	t.prompts = prompts
+}
+
+// SetRestartHandler sets the function called by /restart, it should start an
+// orderly shutdown of the application.
+func (t *TelegramService) SetRestartHandler(restartHandler func()) {
+	t.restartHandler = restartHandler
+}
+
+// SetContext sets the root context of the application, the analyses started by
+// commands stop with it.
+func (t *TelegramService) SetContext(ctx context.Context) {
+	t.ctx = ctx
+}
+
 func (t *TelegramService) StartListening() {
+	t.isRunning = true
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 	updates := t.bot.GetUpdatesChan(u)
@@ -141,7 +189,11 @@ func (t *TelegramService) StartListening() {
 }
 
 func (t *TelegramService) StopListening() {
+	if !t.isRunning {
+		return
+	}
 	t.isRunning = false
+	t.bot.StopReceivingUpdates()
 	log.Println("Telegram service stopped listening")
 }
 
@@ -162,7 +214,30 @@ func (t *TelegramService) isAdminChat(chatID int64) bool {
 	return false
 }
 
+func (t *TelegramService) NotifyAdmins(text string) {
+	t.notifyAdmins(text, nil)
+}
+
+func (t *TelegramService) notifyAdmins(text string, keyboard *TelegramInlineKeyboardMarkup) {
+	for _, adminChatID := range strings.Split(os.Getenv(ENV_TELEGRAM_ADMIN_CHAT_ID), ",") {
+		chatID, err := strconv.ParseInt(strings.TrimSpace(adminChatID), 10, 64)
+		if err != nil {
+			continue
+		}
+		if err := t.SendMessageWithKeyboard(chatID, text, keyboard); err != nil {
+			log.Printf("Failed to notify admin chat %d: %v", chatID, err)
+		}
+	}
+}
+
 func (t *TelegramService) HandleUpdate(update tgbotapi.Update) {
+	if update.CallbackQuery != nil {
+		t.handleCallbackQuery(update.CallbackQuery)
+		return
+	}
+	if update.Message == nil {
+		return
+	}
 	chatID := update.Message.Chat.ID
 	t.chatMutex.Lock()
 	isNewChat := !t.chatIDs[chatID]
@@ -189,13 +264,17 @@ func (t *TelegramService) HandleUpdate(update tgbotapi.Update) {
 		args := parts[1:]
 
 		switch {
-		case strings.HasPrefix(command, "/reset"):
-			if strings.Contains(os.Getenv(ENV_TELEGRAM_ADMIN_CHAT_ID), strconv.Itoa(int(chatID))) {
-				t.SendMessage(chatID, "restarting bot")
-				panic("bot restart command from telegram")
-			} else {
+		case strings.HasPrefix(command, "/reset") || command == "/restart":
+			if !t.isAdminChat(chatID) {
 				t.SendMessage(chatID, "only admins can restart bot")
+				return
 			}
+			if t.restartHandler == nil {
+				t.SendMessage(chatID, "❌ Restart is not available")
+				return
+			}
+			t.SendMessage(chatID, "♻️ Restarting bot, in-flight analyses are finished first")
+			t.restartHandler()
 			return
 		case strings.HasPrefix(command, "/detail_"):
 			t.handleDetailCommand(chatID, text)
@@ -205,14 +284,32 @@ func (t *TelegramService) HandleUpdate(update tgbotapi.Update) {
 			t.handleExportCommand(chatID, text)
 		case strings.HasPrefix(command, "/ticker_history_"):
 			t.handleTickerHistoryCommand(chatID, text)
+		case strings.HasPrefix(command, "/relations_"):
+			t.handleRelationsCommand(chatID, text)
+		case strings.HasPrefix(command, "/timeline_"):
+			t.handleTimelineCommand(chatID, text)
 		case strings.HasPrefix(command, "/cache_"):
 			t.handleCacheCommand(chatID, text)
+		case command == "/reviews":
+			t.handleReviewsCommand(chatID)
+		case command == "/overrides" || strings.HasPrefix(command, "/override_"):
+			if !t.isAdminChat(chatID) {
+				t.SendMessage(chatID, "❌ Access denied. This command is restricted to administrators only.")
+				return
+			}
+			t.handleOverrideCommand(chatID, command, args, telegramUserName(update.Message.From))
+		case command == "/prompts" || command == "/prompt_use" || command == "/prompt_ab":
+			if !t.isAdminChat(chatID) {
+				t.SendMessage(chatID, "❌ Access denied. This command is restricted to administrators only.")
+				return
+			}
+			t.handlePromptCommand(chatID, command, args, telegramUserName(update.Message.From))
 		case command == "/analyze_all":
 			if !t.isAdminChat(chatID) {
 				t.SendMessage(chatID, "❌ Access denied. This command is restricted to administrators only.")
 				return
 			}
-			t.handleAnalyzeAllCommand(chatID)
+			t.handleAnalyzeAllCommand(chatID, args)
 			return
 		case strings.HasPrefix(command, "/analyze_"):
 			t.handleAnalyzeCommand(chatID, text)
@@ -243,9 +340,69 @@ func (t *TelegramService) HandleUpdate(update tgbotapi.Update) {
 				t.SendMessage(chatID, "❌ Access denied. This command is restricted to administrators only.")
 				return
 			}
-			t.handleTop100AnalyzeCommand(chatID)
+			t.handleTop100AnalyzeCommand(chatID, args)
 		case command == "/batch_analyze":
 			t.handleBatchAnalyzeCommand(chatID, args)
+		case command == "/budget":
+			if !t.isAdminChat(chatID) {
+				t.SendMessage(chatID, "❌ Access denied. This command is restricted to administrators only.")
+				return
+			}
+			t.handleBudgetCommand(chatID)
+		case command == "/batches":
+			if !t.isAdminChat(chatID) {
+				t.SendMessage(chatID, "❌ Access denied. This command is restricted to administrators only.")
+				return
+			}
+			t.handleBatchesCommand(chatID)
+		case command == "/queue":
+			if !t.isAdminChat(chatID) {
+				t.SendMessage(chatID, "❌ Access denied. This command is restricted to administrators only.")
+				return
+			}
+			t.handleQueueCommand(chatID)
+		case command == "/queue_retry":
+			if !t.isAdminChat(chatID) {
+				t.SendMessage(chatID, "❌ Access denied. This command is restricted to administrators only.")
+				return
+			}
+			t.handleQueueRetryCommand(chatID)
+		case command == "/communities":
+			if !t.isAdminChat(chatID) {
+				t.SendMessage(chatID, "❌ Access denied. This command is restricted to administrators only.")
+				return
+			}
+			t.handleCommunitiesCommand(chatID)
+		case command == "/community_add":
+			if !t.isAdminChat(chatID) {
+				t.SendMessage(chatID, "❌ Access denied. This command is restricted to administrators only.")
+				return
+			}
+			t.handleCommunityAddCommand(chatID, args)
+		case command == "/community_set":
+			if !t.isAdminChat(chatID) {
+				t.SendMessage(chatID, "❌ Access denied. This command is restricted to administrators only.")
+				return
+			}
+			t.handleCommunitySetCommand(chatID, args)
+		case command == "/reverse_sessions":
+			if !t.isAdminChat(chatID) {
+				t.SendMessage(chatID, "❌ Access denied. This command is restricted to administrators only.")
+				return
+			}
+			t.handleReverseSessionsCommand(chatID)
+		case command == "/reverse_session_add":
+			if !t.isAdminChat(chatID) {
+				t.SendMessage(chatID, "❌ Access denied. This command is restricted to administrators only.")
+				return
+			}
+			t.handleReverseSessionAddCommand(chatID, strings.TrimSpace(strings.TrimPrefix(text, command)))
+		case command == "/reverse_session_disable" || command == "/reverse_session_enable":
+			if !t.isAdminChat(chatID) {
+				t.SendMessage(chatID, "❌ Access denied. This command is restricted to administrators only.")
+				return
+			}
+			t.handleReverseSessionEnableCommand(chatID, args, command == "/reverse_session_enable")
 		case command == "/update_reverse_auth":
 			if !t.isAdminChat(chatID) {
 				t.SendMessage(chatID, "❌ Access denied. This command is restricted to administrators only.")
@@ -271,6 +428,10 @@ func (t *TelegramService) generateTaskID() (string, error) {
 }
 
 func (t *TelegramService) BroadcastMessage(text string) error {
+	return t.broadcast(text, nil)
+}
+
+func (t *TelegramService) broadcast(text string, keyboard *TelegramInlineKeyboardMarkup) error {
 	t.chatMutex.RLock()
 	defer t.chatMutex.RUnlock()
 
@@ -281,7 +442,7 @@ func (t *TelegramService) BroadcastMessage(text string) error {
 
 	var errors []error
 	for chatID := range t.chatIDs {
-		err := t.SendMessage(chatID, text)
+		err := t.SendMessageWithKeyboard(chatID, text, keyboard)
 		if err != nil {
 			log.Printf("Failed to send message to chat %d: %v", chatID, err)
 			errors = append(errors, err)
@@ -317,16 +478,36 @@ func (t *TelegramService) generateNotificationID() string {
 }
 
 func (t *TelegramService) StoreAndBroadcastNotification(alert FUDAlertNotification) error {
+	return t.broadcast(t.storeNotification(alert), reviewKeyboard(alert.ReviewID, true))
+}
 
+// StoreAndSendNotification sends the alert only to the given chats, used for
+// communities with their own Telegram destinations.
+func (t *TelegramService) StoreAndSendNotification(alert FUDAlertNotification, chatIDs []int64) error {
+	telegramMessage := t.storeNotification(alert)
+
+	failed := 0
+	keyboard := reviewKeyboard(alert.ReviewID, true)
+	for _, chatID := range chatIDs {
+		if err := t.SendMessageWithKeyboard(chatID, telegramMessage, keyboard); err != nil {
+			log.Printf("Failed to send notification to chat %d: %v", chatID, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to send to %d chats", failed)
+	}
+	return nil
+}
+
+func (t *TelegramService) storeNotification(alert FUDAlertNotification) string {
 	notificationID := t.generateNotificationID()
 
 	t.notifMutex.Lock()
 	t.notifications[notificationID] = alert
 	t.notifMutex.Unlock()
 
-	telegramMessage := t.formatter.FormatForTelegramWithDetail(alert, notificationID)
-
-	return t.BroadcastMessage(telegramMessage)
+	return t.formatter.FormatForTelegramWithDetail(alert, notificationID)
 }
 
 func (t *TelegramService) truncateText(text string, maxLength int) string {
@@ -347,7 +528,9 @@ func (t *TelegramService) writeToFile(filename, content string) error {
 	return err
 }
 
-func (t *TelegramService) processAnalysisTask(taskID string, chatID int64) {
+// processAnalysisTask queues the second step of the task user in the
+// community, an empty communityID is the default community.
+func (t *TelegramService) processAnalysisTask(taskID string, chatID int64, communityID string) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("Analysis task %s panicked: %v", taskID, r)
@@ -377,7 +560,7 @@ func (t *TelegramService) processAnalysisTask(taskID string, chatID int64) {
 	}
 
 	t.dbService.UpdateAnalysisTaskProgress(taskID, ANALYSIS_STEP_TICKER_SEARCH, "Searching for user's ticker mentions...")
-	tweet, err := t.dbService.GetUserTweetForAnalysis(username)
+	tweet, err := t.dbService.ForCommunity(communityID).GetUserTweetForAnalysis(username)
 
 	var newMessage twitterapi.NewMessage
 
@@ -420,6 +603,7 @@ func (t *TelegramService) processAnalysisTask(taskID string, chatID int64) {
 			ForceNotification: true,
 			TaskID:            taskID,
 			TelegramChatID:    chatID,
+			CommunityID:       communityID,
 		}
 	} else {
 		newMessage = twitterapi.NewMessage{
@@ -458,22 +642,20 @@ func (t *TelegramService) processAnalysisTask(taskID string, chatID int64) {
 			ForceNotification: true,
 			TaskID:            taskID,
 			TelegramChatID:    chatID,
+			CommunityID:       communityID,
 		}
 	}
 
 	t.dbService.UpdateAnalysisTaskProgress(taskID, ANALYSIS_STEP_CLAUDE_ANALYSIS, "Sending for FUD analysis...")
 
-	select {
-	case t.analysisChannel <- newMessage:
-
-		t.dbService.UpdateAnalysisTaskProgress(taskID, ANALYSIS_STEP_CLAUDE_ANALYSIS, "Processing with neural network...")
-
-		log.Printf("Manual analysis task %s sent to Claude processing pipeline", taskID)
+	if err := t.jobQueue.Enqueue(JOB_STAGE_SECOND_STEP, newMessage); err != nil {
+		t.dbService.SetAnalysisTaskError(taskID, fmt.Sprintf("Failed to queue analysis: %v", err))
+		return
+	}
 
-	default:
+	t.dbService.UpdateAnalysisTaskProgress(taskID, ANALYSIS_STEP_CLAUDE_ANALYSIS, "Processing with neural network...")
 
-		t.dbService.SetAnalysisTaskError(taskID, "Analysis channel is full, please try again later")
-	}
+	log.Printf("Manual analysis task %s sent to Claude processing pipeline", taskID)
 }
 func (t *TelegramService) exportTickerHistoryAsFile(chatID int64, username, ticker string, opinions []UserTickerOpinionModel) {
 
@@ -698,9 +880,12 @@ func (t *TelegramService) processBatchAnalysisTask(taskID string, targetChatID i
 	}
 
 	t.dbService.UpdateAnalysisTaskProgress(taskID, ANALYSIS_STEP_CLAUDE_ANALYSIS, "Starting AI analysis...")
-	t.analysisChannel <- newMessage
+	if err := t.jobQueue.Enqueue(JOB_STAGE_SECOND_STEP, newMessage); err != nil {
+		t.dbService.SetAnalysisTaskError(taskID, fmt.Sprintf("Failed to queue analysis: %v", err))
+		return
+	}
 
-	log.Printf("Sent batch analysis request for user %s (task %s) to analysis channel", username, taskID)
+	log.Printf("Queued batch analysis request for user %s (task %s)", username, taskID)
 }
 
 func (t *TelegramService) sendCachedBatchNotification(username, userID string, cachedResult SecondStepClaudeResponse, targetChatID int64) {
@@ -743,7 +928,7 @@ func (t *TelegramService) sendCachedBatchNotification(username, userID string, c
 	}
 }
 
-func (t *TelegramService) processAnalyzeAllUsers(chatID int64) {
+func (t *TelegramService) processAnalyzeAllUsers(chatID int64, communityID string) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("Analyze all users panicked: %v", r)
@@ -751,7 +936,8 @@ func (t *TelegramService) processAnalyzeAllUsers(chatID int64) {
 		}
 	}()
 
-	users, err := t.dbService.GetTopActiveUsers(0)
+	dbService := t.dbService.ForCommunity(communityID)
+	users, err := dbService.GetTopActiveUsers(0)
 	if err != nil {
 		t.SendMessage(chatID, fmt.Sprintf("❌ Error getting users list: %v", err))
 		return
@@ -762,11 +948,16 @@ func (t *TelegramService) processAnalyzeAllUsers(chatID int64) {
 		return
 	}
 
+	if t.batchAnalysis.Enabled() {
+		t.startBatchAnalysis(chatID, BATCH_SOURCE_ANALYZE_ALL, communityID, users)
+		return
+	}
+
 	var usersToAnalyze []UserModel
 	var skippedCount int
 
 	for _, user := range users {
-		if t.dbService.HasValidCachedAnalysis(user.ID) {
+		if dbService.HasValidCachedAnalysis(user.ID) {
 			skippedCount++
 			continue
 		}
@@ -839,12 +1030,16 @@ func (t *TelegramService) processAnalyzeAllUsers(chatID int64) {
 			IsManualAnalysis: true,
 			TaskID:           taskID,
 			TelegramChatID:   chatID,
+			CommunityID:      communityID,
 		}
 
-		t.analysisChannel <- newMessage
+		if err := t.jobQueue.Enqueue(JOB_STAGE_SECOND_STEP, newMessage); err != nil {
+			t.dbService.SetAnalysisTaskError(taskID, fmt.Sprintf("Failed to queue analysis: %v", err))
+			continue
+		}
 		sentCount++
 
-		log.Printf("Sent user %s (%d/%d) to main analysis channel", user.Username, i+1, toAnalyzeCount)
+		log.Printf("Queued user %s (%d/%d) for analysis", user.Username, i+1, toAnalyzeCount)
 
 		time.Sleep(300 * time.Millisecond)
 	}
@@ -44,10 +44,32 @@ type TelegramError struct {
 }
 
 type TelegramSendMessageRequest struct {
-	ChatID         int64  `json:"chat_id"`
-	Text           string `json:"text"`
-	ParseMode      string `json:"parse_mode,omitempty"`
-	DisablePreview bool   `json:"disable_web_page_preview,omitempty"`
+	ChatID         int64                         `json:"chat_id"`
+	Text           string                        `json:"text"`
+	ParseMode      string                        `json:"parse_mode,omitempty"`
+	DisablePreview bool                          `json:"disable_web_page_preview,omitempty"`
+	ReplyMarkup    *TelegramInlineKeyboardMarkup `json:"reply_markup,omitempty"`
+}
+
+type TelegramInlineKeyboardMarkup struct {
+	InlineKeyboard [][]TelegramInlineKeyboardButton `json:"inline_keyboard"`
+}
+
+type TelegramInlineKeyboardButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+type TelegramEditReplyMarkupRequest struct {
+	ChatID      int64                         `json:"chat_id"`
+	MessageID   int64                         `json:"message_id"`
+	ReplyMarkup *TelegramInlineKeyboardMarkup `json:"reply_markup"`
+}
+
+type TelegramAnswerCallbackRequest struct {
+	CallbackQueryID string `json:"callback_query_id"`
+	Text            string `json:"text,omitempty"`
+	ShowAlert       bool   `json:"show_alert,omitempty"`
 }
 
 type TelegramSendDocumentRequest struct {
@@ -102,11 +124,18 @@ func (t *TelegramService) getUpdates() ([]TelegramUpdate, error) {
 }
 
 func (t *TelegramService) SendMessage(chatID int64, text string) error {
+	return t.SendMessageWithKeyboard(chatID, text, nil)
+}
+
+// SendMessageWithKeyboard sends the message with inline buttons, a nil keyboard
+// sends a plain message.
+func (t *TelegramService) SendMessageWithKeyboard(chatID int64, text string, keyboard *TelegramInlineKeyboardMarkup) error {
 	reqBody := TelegramSendMessageRequest{
 		ChatID:         chatID,
 		Text:           text,
 		ParseMode:      "HTML",
 		DisablePreview: true,
+		ReplyMarkup:    keyboard,
 	}
 
 	jsonBody, err := json.Marshal(reqBody)
@@ -129,6 +158,42 @@ func (t *TelegramService) SendMessage(chatID int64, text string) error {
 	return nil
 }
 
+// RemoveKeyboard removes the inline buttons of a sent message.
+func (t *TelegramService) RemoveKeyboard(chatID int64, messageID int64) error {
+	reqBody := TelegramEditReplyMarkupRequest{
+		ChatID:      chatID,
+		MessageID:   messageID,
+		ReplyMarkup: &TelegramInlineKeyboardMarkup{InlineKeyboard: [][]TelegramInlineKeyboardButton{}},
+	}
+	return t.post("editMessageReplyMarkup", reqBody)
+}
+
+// AnswerCallback stops the loading state of the pressed button, the text is
+// shown to the user who pressed it.
+func (t *TelegramService) AnswerCallback(callbackQueryID string, text string) error {
+	return t.post("answerCallbackQuery", TelegramAnswerCallbackRequest{CallbackQueryID: callbackQueryID, Text: text})
+}
+
+func (t *TelegramService) post(method string, reqBody interface{}) error {
+	jsonBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("https://api.telegram.org/bot%s/%s", t.apiKey, method)
+	resp, err := t.client.Post(url, "application/json", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("telegram %s failed: %s", method, string(body))
+	}
+	return nil
+}
+
 func (t *TelegramService) SendMessageWithID(chatID int64, text string) (int64, error) {
 	reqBody := TelegramSendMessageRequest{
 		ChatID:         chatID,
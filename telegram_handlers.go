@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/grutapig/hackaton/twitterapi_reverse"
+	"html"
 	"log"
 	"os"
 	"strconv"
@@ -106,7 +108,7 @@ func (t *TelegramService) handleAnalyzeCommand(chatID int64, command string) {
 		return
 	}
 
-	go t.processAnalysisTask(taskID, chatID)
+	go t.processAnalysisTask(taskID, chatID, "")
 
 	go t.monitorAnalysisProgress(taskID)
 }
@@ -122,6 +124,9 @@ func (t *TelegramService) handleHelpCommand(chatID int64) {
 • /goodlist - Show analyzed good users (non-FUD)
 • /topfud - Show cached FUD users sorted by last message
 • /exportfudlist - Export FUD usernames as comma-separated list
+• /reviews - Show FUD verdicts waiting for review
+• /overrides - Show allowlisted, denylisted and pinned users
+• /prompts - Show the prompt versions and A/B tests
 
 ❓ <b>Help Commands:</b>
 • /help - Show this help message
@@ -587,6 +592,81 @@ func (t *TelegramService) handleTasksCommand(chatID int64) {
 	}
 }
 
+func (t *TelegramService) handleBudgetCommand(chatID int64) {
+	if t.budget == nil || t.loggingService == nil {
+		t.SendMessage(chatID, "❌ AI budget tracking is not configured")
+		return
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	var message strings.Builder
+	message.WriteString("💸 <b>AI Budget</b>\n\n")
+	message.WriteString(t.budget.Status().String())
+	message.WriteString("\n\n")
+
+	stages, err := t.loggingService.GetAIUsageByStage(monthStart, today)
+	if err != nil {
+		t.SendMessage(chatID, fmt.Sprintf("❌ Error retrieving AI usage: %v", err))
+		return
+	}
+	message.WriteString("📊 <b>This month by stage:</b>\n")
+	if len(stages) == 0 {
+		message.WriteString("• no AI calls yet\n")
+	}
+	for _, stage := range stages {
+		message.WriteString(fmt.Sprintf("• %s: %d calls, %d in / %d out tokens, cache %d write / %d read, $%.2f\n", stage.Stage, stage.Requests, stage.InputTokens, stage.OutputTokens, stage.CacheWrite, stage.CacheRead, stage.CostUSD))
+	}
+
+	users, err := t.loggingService.GetTopAIUsers(monthStart, today, 10)
+	if err != nil {
+		t.SendMessage(chatID, fmt.Sprintf("❌ Error retrieving AI usage: %v", err))
+		return
+	}
+	if len(users) > 0 {
+		message.WriteString("\n👤 <b>Most expensive users this month:</b>\n")
+		for i, user := range users {
+			message.WriteString(fmt.Sprintf("%d. @%s: %d calls, $%.2f\n", i+1, user.Username, user.Requests, user.CostUSD))
+		}
+	}
+
+	endpoints, err := t.loggingService.GetTwitterAPIUsageByEndpoint(monthStart, today)
+	if err != nil {
+		t.SendMessage(chatID, fmt.Sprintf("❌ Error retrieving Twitter API usage: %v", err))
+		return
+	}
+	if len(endpoints) > 0 {
+		message.WriteString("\n🐦 <b>twitterapi.io this month:</b>\n")
+		for _, endpoint := range endpoints {
+			message.WriteString(fmt.Sprintf("• %s: %d calls, %d credits", endpoint.Endpoint, endpoint.Requests, endpoint.Credits))
+			if endpoint.RateLimited > 0 || endpoint.Errors > 0 {
+				message.WriteString(fmt.Sprintf(", %d rate limited, %d errors", endpoint.RateLimited, endpoint.Errors))
+			}
+			message.WriteString("\n")
+		}
+	}
+
+	requests, err := t.loggingService.GetTopTwitterAPIRequests(monthStart, today, 5)
+	if err != nil {
+		t.SendMessage(chatID, fmt.Sprintf("❌ Error retrieving Twitter API usage: %v", err))
+		return
+	}
+	if len(requests) > 0 {
+		message.WriteString("\n🔎 <b>Most expensive analyses in credits this month:</b>\n")
+		for i, request := range requests {
+			subject := "@" + request.Username
+			if request.Username == "" {
+				subject = "request " + request.RequestUUID
+			}
+			message.WriteString(fmt.Sprintf("%d. %s: %d calls, %d credits\n", i+1, html.EscapeString(subject), request.Requests, request.Credits))
+		}
+	}
+
+	t.SendMessage(chatID, message.String())
+}
+
 func (t *TelegramService) handleTop20AnalyzeCommand(chatID int64) {
 
 	users, err := t.dbService.GetTopActiveUsers(20)
@@ -633,7 +713,7 @@ func (t *TelegramService) handleTop20AnalyzeCommand(chatID int64) {
 			continue
 		}
 
-		go t.processAnalysisTask(taskID, chatID)
+		go t.processAnalysisTask(taskID, chatID, "")
 		analysisCount++
 
 		time.Sleep(100 * time.Millisecond)
@@ -645,9 +725,14 @@ func (t *TelegramService) handleTop20AnalyzeCommand(chatID int64) {
 	log.Printf("Started top 20 analysis: %d analyses queued, %d skipped", analysisCount, skippedCount)
 }
 
-func (t *TelegramService) handleTop100AnalyzeCommand(chatID int64) {
+func (t *TelegramService) handleTop100AnalyzeCommand(chatID int64, args []string) {
+	communityID, ok := t.commandCommunity(chatID, args)
+	if !ok {
+		return
+	}
+	dbService := t.dbService.ForCommunity(communityID)
 
-	users, err := t.dbService.GetTopActiveUsers(100)
+	users, err := dbService.GetTopActiveUsers(100)
 	if err != nil {
 		t.SendMessage(chatID, fmt.Sprintf("❌ Error retrieving top users: %v", err))
 		return
@@ -658,6 +743,11 @@ func (t *TelegramService) handleTop100AnalyzeCommand(chatID int64) {
 		return
 	}
 
+	if t.batchAnalysis.Enabled() {
+		t.startBatchAnalysis(chatID, BATCH_SOURCE_TOP100, communityID, users)
+		return
+	}
+
 	t.SendMessage(chatID, fmt.Sprintf("🔄 <b>Starting Top 100 Analysis</b>\n\n📊 Found %d users to analyze\n⏳ This will take several minutes...\n\n💡 Use /tasks to monitor progress", len(users)))
 
 	analysisCount := 0
@@ -665,7 +755,7 @@ func (t *TelegramService) handleTop100AnalyzeCommand(chatID int64) {
 
 	for _, user := range users {
 
-		if t.dbService.HasValidCachedAnalysis(user.ID) {
+		if dbService.HasValidCachedAnalysis(user.ID) {
 			log.Printf("Skipping user %s - has valid cached analysis", user.Username)
 			skippedCount++
 			continue
@@ -691,7 +781,7 @@ func (t *TelegramService) handleTop100AnalyzeCommand(chatID int64) {
 			continue
 		}
 
-		go t.processAnalysisTask(taskID, chatID)
+		go t.processAnalysisTask(taskID, chatID, communityID)
 		analysisCount++
 
 		time.Sleep(100 * time.Millisecond)
@@ -703,11 +793,15 @@ func (t *TelegramService) handleTop100AnalyzeCommand(chatID int64) {
 	log.Printf("Started top 20 analysis: %d analyses queued, %d skipped", analysisCount, skippedCount)
 }
 
-func (t *TelegramService) handleAnalyzeAllCommand(chatID int64) {
+func (t *TelegramService) handleAnalyzeAllCommand(chatID int64, args []string) {
+	communityID, ok := t.commandCommunity(chatID, args)
+	if !ok {
+		return
+	}
 
 	t.SendMessage(chatID, "🔄 <b>Starting Full Database Analysis</b>\n\n📊 Getting list of all users with messages...\nThis may take a moment.")
 
-	go t.processAnalyzeAllUsers(chatID)
+	go t.processAnalyzeAllUsers(chatID, communityID)
 }
 
 func (t *TelegramService) handleBatchAnalyzeCommand(chatID int64, args []string) {
@@ -839,7 +933,7 @@ func (t *TelegramService) handleUpdateReverseAuthCommand(chatID int64, curlComma
 
 	reverseService := twitterapi_reverse.NewTwitterReverseApi(auth, os.Getenv(ENV_PROXY_DSN), false)
 
-	communityID := os.Getenv(ENV_DEMO_COMMUNITY_ID)
+	communityID := t.dbService.CommunityID()
 	tweets, err := reverseService.GetCommunityTweets(communityID, 10)
 	if err != nil {
 
@@ -944,6 +1038,134 @@ func (t *TelegramService) handleHistoryCommand(chatID int64, command string) {
 	t.SendMessage(chatID, historyMessage.String())
 }
 
+func (t *TelegramService) handleRelationsCommand(chatID int64, command string) {
+
+	prefix := "/relations_"
+	if !strings.HasPrefix(command, prefix) {
+		t.SendMessage(chatID, "❌ Invalid command format. Use /relations_username")
+		return
+	}
+
+	username := strings.TrimPrefix(command, prefix)
+
+	user, err := t.dbService.GetUserByUsername(username)
+	if err != nil {
+		t.SendMessage(chatID, fmt.Sprintf("📭 User @%s not found", username))
+		return
+	}
+
+	var message strings.Builder
+	message.WriteString(fmt.Sprintf("🕸 <b>Relations of @%s</b>\n\n", user.Username))
+
+	for _, relationType := range []string{RELATION_TYPE_FOLLOWER, RELATION_TYPE_FOLLOWING} {
+		relationSync, err := t.dbService.GetRelationSync(user.ID, relationType)
+		if err != nil || relationSync == nil {
+			message.WriteString(fmt.Sprintf("• %ss: not fetched yet\n", relationType))
+			continue
+		}
+		partial := ""
+		if !relationSync.Complete {
+			partial = " (page cap reached)"
+		}
+		message.WriteString(fmt.Sprintf("• %ss: %d%s, fetched %s\n", relationType, relationSync.Count, partial, relationSync.SyncedAt.Format("2006-01-02 15:04")))
+	}
+
+	fudRelations, err := t.dbService.GetFUDRelationHistory(user.ID)
+	if err != nil {
+		t.SendMessage(chatID, fmt.Sprintf("❌ Error retrieving relations for @%s: %v", user.Username, err))
+		return
+	}
+
+	if len(fudRelations) == 0 {
+		message.WriteString("\n✅ No relations with FUD users")
+		t.SendMessage(chatID, message.String())
+		return
+	}
+
+	message.WriteString(fmt.Sprintf("\n🚨 <b>Relations with FUD users</b> (%d)\n\n", len(fudRelations)))
+	const maxShown = 30
+	for i, relation := range fudRelations {
+		if i == maxShown {
+			message.WriteString(fmt.Sprintf("\n... and %d more", len(fudRelations)-maxShown))
+			break
+		}
+		direction := "follows"
+		if relation.RelationType == RELATION_TYPE_FOLLOWER {
+			direction = "followed by"
+		}
+		message.WriteString(fmt.Sprintf("<b>%d.</b> %s @%s (%s)\n", i+1, direction, relation.Username, relation.FUDType))
+		message.WriteString(fmt.Sprintf("    📅 First seen: %s, last seen: %s\n", relation.FirstSeenAt.Format("2006-01-02"), relation.LastSeenAt.Format("2006-01-02")))
+		if relation.EndedAt != nil {
+			message.WriteString(fmt.Sprintf("    ❌ Ended: %s\n", relation.EndedAt.Format("2006-01-02")))
+		}
+	}
+
+	t.SendMessage(chatID, message.String())
+}
+
+const TIMELINE_LIMIT = 30
+
+func (t *TelegramService) handleTimelineCommand(chatID int64, command string) {
+
+	prefix := "/timeline_"
+	if !strings.HasPrefix(command, prefix) {
+		t.SendMessage(chatID, "❌ Invalid command format. Use /timeline_username")
+		return
+	}
+
+	username := strings.TrimPrefix(command, prefix)
+
+	user, err := t.dbService.GetUserByUsername(username)
+	if err != nil {
+		t.SendMessage(chatID, fmt.Sprintf("📭 User @%s not found", username))
+		return
+	}
+
+	history, err := t.dbService.GetUserStatusHistory(user.ID, TIMELINE_LIMIT)
+	if err != nil {
+		t.SendMessage(chatID, fmt.Sprintf("❌ Error retrieving the status history of @%s: %v", user.Username, err))
+		return
+	}
+	if len(history) == 0 {
+		t.SendMessage(chatID, fmt.Sprintf("📭 No status changes recorded for @%s", user.Username))
+		return
+	}
+
+	var message strings.Builder
+	message.WriteString(fmt.Sprintf("🕰 <b>Status Timeline of @%s</b> (last %d, oldest first)\n\n", user.Username, len(history)))
+	for i := len(history) - 1; i >= 0; i-- {
+		entry := history[i]
+		message.WriteString(fmt.Sprintf("<b>%s</b> %s → <b>%s</b>\n", entry.CreatedAt.Format("2006-01-02 15:04"), entry.OldStatus, entry.NewStatus))
+		by := entry.Source
+		if entry.Actor != "" {
+			by += ", " + html.EscapeString(entry.Actor)
+		}
+		message.WriteString(fmt.Sprintf("• By: %s\n", by))
+		if entry.IsFUD || entry.FUDProbability > 0 {
+			message.WriteString(fmt.Sprintf("• FUD: %s %.0f%%", html.EscapeString(entry.FUDType), entry.FUDProbability*100))
+			if entry.RiskLevel != "" {
+				message.WriteString(", risk " + entry.RiskLevel)
+			}
+			message.WriteString("\n")
+		}
+		if entry.Note != "" {
+			message.WriteString(fmt.Sprintf("• %s\n", html.EscapeString(entry.Note)))
+		}
+		if entry.TweetID != "" {
+			message.WriteString(fmt.Sprintf("• Tweet: <code>%s</code>\n", entry.TweetID))
+		}
+		if entry.RequestUUID != "" {
+			message.WriteString(fmt.Sprintf("• AI request: <code>%s</code>\n", entry.RequestUUID))
+		}
+		if entry.PromptVersion != "" {
+			message.WriteString(fmt.Sprintf("• Prompt: <code>%s</code>\n", html.EscapeString(entry.PromptVersion)))
+		}
+		message.WriteString("\n")
+	}
+
+	t.SendMessage(chatID, message.String())
+}
+
 func (t *TelegramService) handleTickerHistoryCommand(chatID int64, command string) {
 
 	prefix := "/ticker_history_"
@@ -1093,6 +1315,8 @@ func (t *TelegramService) handleCacheCommand(chatID int64, command string) {
 	message.WriteString("🔍 <b>Related Commands:</b>\n")
 	message.WriteString(fmt.Sprintf("• /history_%s - Message history\n", user.Username))
 	message.WriteString(fmt.Sprintf("• /ticker_history_%s - Ticker posts\n", user.Username))
+	message.WriteString(fmt.Sprintf("• /relations_%s - Relations with FUD users\n", user.Username))
+	message.WriteString(fmt.Sprintf("• /timeline_%s - Status history\n", user.Username))
 	message.WriteString(fmt.Sprintf("• /export_%s - Full export\n", user.Username))
 	message.WriteString(fmt.Sprintf("• /analyze_%s - Force new analysis\n", user.Username))
 
@@ -1199,3 +1423,607 @@ func (t *TelegramService) handleLast5MessagesCommand(chatID int64) {
 		t.SendMessage(chatID, errorMsg)
 	}
 }
+
+func (t *TelegramService) startBatchAnalysis(chatID int64, source string, communityID string, users []UserModel) {
+	var usersToAnalyze []UserModel
+	skippedCount := 0
+	for _, user := range users {
+		if t.dbService.ForCommunity(communityID).HasValidCachedAnalysis(user.ID) {
+			skippedCount++
+			continue
+		}
+		usersToAnalyze = append(usersToAnalyze, user)
+	}
+
+	if len(usersToAnalyze) == 0 {
+		t.SendMessage(chatID, "✅ All users already have recent analysis (cached). No new analysis needed.")
+		return
+	}
+
+	t.SendMessage(chatID, fmt.Sprintf("🔄 <b>Starting Batch Analysis</b>\n\n👥 <b>Users to analyze:</b> %d\n💾 <b>Cached (skipped):</b> %d\n\n⏳ Collecting user data, the requests are submitted as one message batch...", len(usersToAnalyze), skippedCount))
+
+	batch, err := t.batchAnalysis.Submit(t.ctx, chatID, source, communityID, usersToAnalyze)
+	if err != nil {
+		log.Printf("Failed to submit batch analysis: %v", err)
+		t.SendMessage(chatID, fmt.Sprintf("❌ Batch submission failed: %v", err))
+		return
+	}
+
+	t.SendMessage(chatID, fmt.Sprintf("🚀 <b>Batch Submitted</b>\n\n🆔 <code>%s</code>\n📋 Requests: %d\n\n⏳ Results are applied when the batch ends, usually within an hour\n🔍 Use /batches to check the status", batch.ID, batch.RequestCount))
+}
+
+func (t *TelegramService) handleBatchesCommand(chatID int64) {
+	if !t.batchAnalysis.Enabled() {
+		t.SendMessage(chatID, "ℹ️ Batch analysis is not available for the second step LLM provider")
+		return
+	}
+
+	batches, err := t.dbService.GetRecentAnalysisBatches(BATCH_RECENT_LIMIT)
+	if err != nil {
+		t.SendMessage(chatID, fmt.Sprintf("❌ Error getting batches: %v", err))
+		return
+	}
+	if len(batches) == 0 {
+		t.SendMessage(chatID, "📭 No message batches submitted yet")
+		return
+	}
+
+	var message strings.Builder
+	message.WriteString("📦 <b>Message Batches</b>\n\n")
+	for _, batch := range batches {
+		message.WriteString(fmt.Sprintf("🆔 <code>%s</code> (%s)\n", batch.ID, batch.Source))
+		message.WriteString(fmt.Sprintf("• Status: %s, submitted %s\n", batch.Status, batch.SubmittedAt.Format("2006-01-02 15:04")))
+		message.WriteString(fmt.Sprintf("• Requests: %d, succeeded: %d, errored: %d\n", batch.RequestCount, batch.Succeeded, batch.Errored))
+		if batch.Status == BATCH_STATUS_APPLIED {
+			message.WriteString(fmt.Sprintf("• Applied: %d, re-run synchronously: %d\n", batch.Applied, batch.Requeued))
+		}
+		message.WriteString("\n")
+	}
+
+	t.SendMessage(chatID, message.String())
+}
+
+func (t *TelegramService) handleQueueCommand(chatID int64) {
+	stats, err := t.jobQueue.Stats()
+	if err != nil {
+		t.SendMessage(chatID, fmt.Sprintf("❌ Error getting queue depth: %v", err))
+		return
+	}
+
+	var message strings.Builder
+	message.WriteString("📥 <b>Analysis Queue</b>\n\n")
+	totalDead := int64(0)
+	for _, stage := range stats {
+		totalDead += stage.Dead
+		message.WriteString(fmt.Sprintf("<b>%s</b>\n", stage.Stage))
+		message.WriteString(fmt.Sprintf("• ⏳ Pending: %d\n• ⚙️ Running: %d\n• ☠️ Dead: %d\n", stage.Pending, stage.Leased, stage.Dead))
+		if stage.Deferred > 0 {
+			message.WriteString(fmt.Sprintf("• 💸 Deferred by AI budget: %d\n", stage.Deferred))
+		}
+		if stage.OldestPending != nil {
+			message.WriteString(fmt.Sprintf("• 🕐 Oldest pending: %s ago\n", time.Since(*stage.OldestPending).Round(time.Second)))
+		}
+		message.WriteString("\n")
+	}
+
+	if t.secondStepPool != nil {
+		metrics := t.secondStepPool.Metrics()
+		message.WriteString("⚙️ <b>Second step workers</b>\n")
+		message.WriteString(fmt.Sprintf("• Busy: %d/%d\n", metrics.Busy, metrics.Workers))
+		message.WriteString(fmt.Sprintf("• Processed: %d, failed: %d, attached to running analysis: %d\n", metrics.Processed, metrics.Failed, metrics.Coalesced))
+		message.WriteString(fmt.Sprintf("• Wait: avg %s, max %s\n", metrics.AverageWait().Round(time.Second), metrics.MaxWait.Round(time.Second)))
+		message.WriteString(fmt.Sprintf("• Processing: avg %s, max %s\n\n", metrics.AverageProcessing().Round(time.Second), metrics.MaxProcessing.Round(time.Second)))
+	}
+
+	if totalDead > 0 {
+		deadJobs, err := t.jobQueue.DeadJobs(JOB_DEAD_LIST_LIMIT)
+		if err == nil && len(deadJobs) > 0 {
+			message.WriteString("☠️ <b>Latest dead jobs:</b>\n")
+			for _, job := range deadJobs {
+				message.WriteString(fmt.Sprintf("• %s @%s: %s\n", job.Stage, job.Username, html.EscapeString(job.LastError)))
+			}
+			message.WriteString("\n💡 Use /queue_retry to queue dead jobs again")
+		}
+	}
+
+	t.SendMessage(chatID, message.String())
+}
+
+func (t *TelegramService) handleQueueRetryCommand(chatID int64) {
+	retried, err := t.jobQueue.RetryDead()
+	if err != nil {
+		t.SendMessage(chatID, fmt.Sprintf("❌ Error retrying dead jobs: %v", err))
+		return
+	}
+	t.SendMessage(chatID, fmt.Sprintf("🔁 Queued %d dead jobs again", retried))
+}
+
+func (t *TelegramService) handleCommunitiesCommand(chatID int64) {
+	communities, err := t.communities.All()
+	if err != nil {
+		t.SendMessage(chatID, fmt.Sprintf("❌ Error getting communities: %v", err))
+		return
+	}
+	if len(communities) == 0 {
+		t.SendMessage(chatID, "📭 No communities configured, use /community_add")
+		return
+	}
+
+	var message strings.Builder
+	message.WriteString("🏘 <b>Monitored Communities</b>\n\n")
+	for _, community := range communities {
+		state := "✅ enabled"
+		if !community.Enabled {
+			state = "⏸ disabled"
+		}
+		if community.ID == t.dbService.CommunityID() {
+			state += ", default"
+		}
+		message.WriteString(fmt.Sprintf("<b>%s</b> (%s)\n", html.EscapeString(community.DisplayName()), state))
+		message.WriteString(fmt.Sprintf("• ID: <code>%s</code>\n• Ticker: %s\n", community.ID, html.EscapeString(community.Ticker)))
+		chats := "all registered chats"
+		if community.TelegramChatIDs != "" {
+			chats = community.TelegramChatIDs
+		}
+		message.WriteString(fmt.Sprintf("• Alerts: %s, threshold %.0f%%\n", chats, community.AlertThreshold*100))
+		if community.FirstStepPromptFile != "" || community.SecondStepPromptFile != "" {
+			message.WriteString(fmt.Sprintf("• Prompts: %s / %s\n", html.EscapeString(community.FirstStepPromptFile), html.EscapeString(community.SecondStepPromptFile)))
+		}
+		message.WriteString("\n")
+	}
+	message.WriteString(fmt.Sprintf("💡 /community_set &lt;id&gt; &lt;setting&gt; &lt;value&gt;, settings: %s",
+		strings.Join([]string{COMMUNITY_FIELD_NAME, COMMUNITY_FIELD_TICKER, COMMUNITY_FIELD_CHATS, COMMUNITY_FIELD_THRESHOLD, COMMUNITY_FIELD_FIRST_PROMPT, COMMUNITY_FIELD_SECOND_PROMPT, COMMUNITY_FIELD_ENABLED}, ", ")))
+
+	t.SendMessage(chatID, message.String())
+}
+
+// commandCommunity resolves the optional community id argument of a command,
+// without one it is the default community. An unknown id is reported to the
+// chat.
+func (t *TelegramService) commandCommunity(chatID int64, args []string) (string, bool) {
+	if len(args) == 0 {
+		return t.dbService.CommunityID(), true
+	}
+	community, err := t.dbService.GetCommunity(args[0])
+	if err != nil {
+		t.SendMessage(chatID, fmt.Sprintf("❌ Community %s not found, see /communities", html.EscapeString(args[0])))
+		return "", false
+	}
+	return community.ID, true
+}
+
+func (t *TelegramService) handleCommunityAddCommand(chatID int64, args []string) {
+	if len(args) < 2 {
+		t.SendMessage(chatID, "❌ Usage: /community_add &lt;community_id&gt; &lt;ticker&gt; [name]")
+		return
+	}
+	community, err := t.communities.Add(args[0], args[1], strings.Join(args[2:], " "))
+	if err != nil {
+		t.SendMessage(chatID, fmt.Sprintf("❌ Error adding community: %v", err))
+		return
+	}
+	t.SendMessage(chatID, fmt.Sprintf("✅ Community <b>%s</b> added, monitoring starts within %s", html.EscapeString(community.DisplayName()), MONITORING_COMMUNITY_REFRESH))
+}
+
+func (t *TelegramService) handleCommunitySetCommand(chatID int64, args []string) {
+	if len(args) < 2 {
+		t.SendMessage(chatID, "❌ Usage: /community_set &lt;community_id&gt; &lt;setting&gt; [value]")
+		return
+	}
+	community, err := t.communities.Set(args[0], args[1], strings.Join(args[2:], " "))
+	if err != nil {
+		t.SendMessage(chatID, fmt.Sprintf("❌ Error updating community: %v", err))
+		return
+	}
+	t.SendMessage(chatID, fmt.Sprintf("✅ Community <b>%s</b>: %s updated", html.EscapeString(community.DisplayName()), args[1]))
+}
+
+func (t *TelegramService) handleReverseSessionsCommand(chatID int64) {
+	sessions := t.reverseSessions.Sessions()
+	if len(sessions) == 0 {
+		t.SendMessage(chatID, "📭 No reverse API sessions, add one with /reverse_session_add &lt;label&gt; &lt;curl_command&gt;")
+		return
+	}
+
+	var message strings.Builder
+	message.WriteString("🔑 <b>Reverse API Sessions</b>\n\n")
+	for _, session := range sessions {
+		state := "✅ active"
+		switch session.Status {
+		case twitterapi_reverse.SESSION_STATUS_COOLING:
+			state = fmt.Sprintf("⏳ rate limited until %s", session.CoolingUntil.Format("15:04"))
+		case twitterapi_reverse.SESSION_STATUS_DEAD:
+			state = "💀 logged out"
+		case twitterapi_reverse.SESSION_STATUS_DISABLED:
+			state = "⏸ disabled"
+		}
+		message.WriteString(fmt.Sprintf("<b>%s</b> (<code>%s</code>) %s\n", html.EscapeString(session.Label), session.ID, state))
+		message.WriteString(fmt.Sprintf("• Requests since start: %d\n", session.Requests))
+		if session.LastError != "" {
+			message.WriteString(fmt.Sprintf("• Last error: %s\n", html.EscapeString(session.LastError)))
+		}
+		message.WriteString("\n")
+	}
+	message.WriteString("💡 /reverse_session_disable &lt;id&gt;, /reverse_session_enable &lt;id&gt;, /reverse_session_add with an existing label replaces its credentials")
+
+	t.SendMessage(chatID, message.String())
+}
+
+func (t *TelegramService) handleReverseSessionAddCommand(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) < 2 {
+		t.SendMessage(chatID, "❌ Usage: /reverse_session_add &lt;label&gt; &lt;curl_command&gt;")
+		return
+	}
+	label := fields[0]
+	curlCommand := strings.TrimPrefix(args, label)
+
+	authorization, csrfToken, cookie, err := t.parseCurlCommand(curlCommand)
+	if err != nil {
+		t.SendMessage(chatID, fmt.Sprintf("❌ Failed to parse curl command: %v", err))
+		return
+	}
+	auth := twitterapi_reverse.NewTwitterAuth(authorization, csrfToken, cookie)
+
+	t.SendMessage(chatID, "🧪 Testing the session...")
+	reverseService := twitterapi_reverse.NewTwitterReverseApi(auth, os.Getenv(ENV_PROXY_DSN), false)
+	tweets, err := reverseService.GetCommunityTweets(t.dbService.CommunityID(), 10)
+	if err != nil {
+		t.SendMessage(chatID, fmt.Sprintf("❌ Test failed, session not added: %s", html.EscapeString(err.Error())))
+		return
+	}
+
+	session, err := t.reverseSessions.Add(label, auth)
+	if err != nil {
+		t.SendMessage(chatID, fmt.Sprintf("❌ Error adding session: %v", err))
+		return
+	}
+	t.SendMessage(chatID, fmt.Sprintf("✅ Session <b>%s</b> (<code>%s</code>) added, test found %d tweets", html.EscapeString(session.Label), session.ID, len(tweets)))
+}
+
+func (t *TelegramService) handleReverseSessionEnableCommand(chatID int64, args []string, enabled bool) {
+	if len(args) == 0 {
+		t.SendMessage(chatID, "❌ Usage: /reverse_session_disable &lt;id&gt; or /reverse_session_enable &lt;id&gt;")
+		return
+	}
+	if err := t.reverseSessions.SetEnabled(args[0], enabled); err != nil {
+		t.SendMessage(chatID, fmt.Sprintf("❌ %v", err))
+		return
+	}
+	state := "disabled"
+	if enabled {
+		state = "enabled"
+	}
+	t.SendMessage(chatID, fmt.Sprintf("✅ Session <code>%s</code> %s", args[0], state))
+}
+
+const REVIEW_LIST_LIMIT = 10
+
+const (
+	REVIEW_ACTION_APPROVE  = "review_approve_"
+	REVIEW_ACTION_REJECT   = "review_reject_"
+	REVIEW_ACTION_ESCALATE = "review_escalate_"
+)
+
+// reviewKeyboard returns the buttons of an alert waiting for review, nil when
+// the alert has no review.
+func reviewKeyboard(reviewID uint, escalate bool) *TelegramInlineKeyboardMarkup {
+	if reviewID == 0 {
+		return nil
+	}
+	id := strconv.FormatUint(uint64(reviewID), 10)
+	row := []TelegramInlineKeyboardButton{
+		{Text: "✅ Approve", CallbackData: REVIEW_ACTION_APPROVE + id},
+		{Text: "❎ Reject", CallbackData: REVIEW_ACTION_REJECT + id},
+	}
+	if escalate {
+		row = append(row, TelegramInlineKeyboardButton{Text: "⬆️ Escalate", CallbackData: REVIEW_ACTION_ESCALATE + id})
+	}
+	return &TelegramInlineKeyboardMarkup{InlineKeyboard: [][]TelegramInlineKeyboardButton{row}}
+}
+
+func formatReview(review *FUDReviewModel) string {
+	message := fmt.Sprintf("🧑‍⚖️ <b>Review #%d</b> - @%s\n", review.ID, html.EscapeString(review.Username))
+	if review.Verdict == REVIEW_VERDICT_CLEAN {
+		message += fmt.Sprintf("• Verdict: clean (FUD %.0f%%), the user is a confirmed FUD user\n", review.FUDProbability*100)
+	} else {
+		message += fmt.Sprintf("• Verdict: %s (%.0f%%)\n", html.EscapeString(review.FUDType), review.FUDProbability*100)
+	}
+	message += fmt.Sprintf("• Alerts: %d, first %s\n", review.AlertCount, review.CreatedAt.Format("2006-01-02 15:04"))
+	if review.TweetID != "" {
+		message += fmt.Sprintf("• Tweet: https://x.com/%s/status/%s\n", review.Username, review.TweetID)
+	}
+	if review.Status == REVIEW_STATUS_ESCALATED {
+		message += fmt.Sprintf("• ⬆️ Escalated by %s\n", html.EscapeString(review.EscalatedBy))
+	}
+	message += fmt.Sprintf("• /history_%s /timeline_%s", review.Username, review.Username)
+	return message
+}
+
+func (t *TelegramService) handleReviewsCommand(chatID int64) {
+	reviews, err := t.dbService.GetOpenFUDReviews(REVIEW_LIST_LIMIT)
+	if err != nil {
+		t.SendMessage(chatID, fmt.Sprintf("❌ Error getting reviews: %v", err))
+		return
+	}
+	if len(reviews) == 0 {
+		t.SendMessage(chatID, "📭 No FUD verdicts waiting for review")
+		return
+	}
+	t.SendMessage(chatID, fmt.Sprintf("🧑‍⚖️ <b>%d verdicts waiting for review</b>, oldest first", len(reviews)))
+	for i := range reviews {
+		review := &reviews[i]
+		// escalated reviews are decided by the admins only
+		if review.Status == REVIEW_STATUS_ESCALATED && !t.isAdminChat(chatID) {
+			t.SendMessage(chatID, formatReview(review))
+			continue
+		}
+		t.SendMessageWithKeyboard(chatID, formatReview(review), reviewKeyboard(review.ID, review.Status == REVIEW_STATUS_PENDING))
+	}
+}
+
+func (t *TelegramService) handleCallbackQuery(query *tgbotapi.CallbackQuery) {
+	if strings.HasPrefix(query.Data, "review_") {
+		t.handleReviewCallback(query)
+		return
+	}
+	t.AnswerCallback(query.ID, "")
+}
+
+func (t *TelegramService) handleReviewCallback(query *tgbotapi.CallbackQuery) {
+	var status, id string
+	switch {
+	case strings.HasPrefix(query.Data, REVIEW_ACTION_APPROVE):
+		status, id = REVIEW_STATUS_CONFIRMED, strings.TrimPrefix(query.Data, REVIEW_ACTION_APPROVE)
+	case strings.HasPrefix(query.Data, REVIEW_ACTION_REJECT):
+		status, id = REVIEW_STATUS_REJECTED, strings.TrimPrefix(query.Data, REVIEW_ACTION_REJECT)
+	case strings.HasPrefix(query.Data, REVIEW_ACTION_ESCALATE):
+		status, id = REVIEW_STATUS_ESCALATED, strings.TrimPrefix(query.Data, REVIEW_ACTION_ESCALATE)
+	}
+	reviewID, err := strconv.ParseUint(id, 10, 64)
+	if status == "" || err != nil {
+		t.AnswerCallback(query.ID, "❌ Unknown review action")
+		return
+	}
+	if query.Message == nil || query.From == nil {
+		t.AnswerCallback(query.ID, "❌ The alert is too old, use /reviews")
+		return
+	}
+	chatID := query.Message.Chat.ID
+	messageID := int64(query.Message.MessageID)
+
+	review, err := t.dbService.GetFUDReview(uint(reviewID))
+	if err != nil {
+		t.AnswerCallback(query.ID, fmt.Sprintf("❌ Review #%d not found", reviewID))
+		return
+	}
+	if !review.IsOpen() || (review.Status == REVIEW_STATUS_ESCALATED && status == REVIEW_STATUS_ESCALATED) {
+		t.AnswerCallback(query.ID, fmt.Sprintf("Review #%d is already %s by %s", review.ID, review.Status, reviewDecider(review)))
+		t.RemoveKeyboard(chatID, messageID)
+		return
+	}
+	if review.Status == REVIEW_STATUS_ESCALATED && !t.isAdminChat(chatID) {
+		t.AnswerCallback(query.ID, "❌ Access denied. Escalated reviews are decided by administrators only.")
+		return
+	}
+
+	reviewerName := telegramUserName(query.From)
+	review, err = t.dbService.ResolveFUDReview(review.ID, status, query.From.ID, reviewerName)
+	if err != nil {
+		log.Printf("Failed to resolve review #%d: %v", reviewID, err)
+		t.AnswerCallback(query.ID, fmt.Sprintf("❌ %v", err))
+		t.RemoveKeyboard(chatID, messageID)
+		return
+	}
+	t.AnswerCallback(query.ID, fmt.Sprintf("Review #%d %s", review.ID, review.Status))
+	t.RemoveKeyboard(chatID, messageID)
+
+	switch {
+	case review.Verdict == REVIEW_VERDICT_CLEAN && status == REVIEW_STATUS_CONFIRMED:
+		t.SendMessage(chatID, fmt.Sprintf("✅ @%s cleared from the FUD list by %s (review #%d)", html.EscapeString(review.Username), html.EscapeString(reviewerName), review.ID))
+	case review.Verdict == REVIEW_VERDICT_CLEAN && status == REVIEW_STATUS_REJECTED:
+		t.SendMessage(chatID, fmt.Sprintf("🚨 @%s kept as FUD user by %s (review #%d)", html.EscapeString(review.Username), html.EscapeString(reviewerName), review.ID))
+	case status == REVIEW_STATUS_CONFIRMED:
+		t.SendMessage(chatID, fmt.Sprintf("🚨 @%s confirmed as FUD user by %s (review #%d)", html.EscapeString(review.Username), html.EscapeString(reviewerName), review.ID))
+	case status == REVIEW_STATUS_REJECTED:
+		t.SendMessage(chatID, fmt.Sprintf("✅ FUD verdict on @%s rejected by %s (review #%d)", html.EscapeString(review.Username), html.EscapeString(reviewerName), review.ID))
+	case status == REVIEW_STATUS_ESCALATED:
+		t.SendMessage(chatID, fmt.Sprintf("⬆️ Review #%d of @%s escalated to the administrators by %s", review.ID, html.EscapeString(review.Username), html.EscapeString(reviewerName)))
+		t.notifyAdmins(formatReview(review), reviewKeyboard(review.ID, false))
+	}
+}
+
+func reviewDecider(review *FUDReviewModel) string {
+	if review.Status == REVIEW_STATUS_ESCALATED {
+		return review.EscalatedBy
+	}
+	return review.ReviewerName
+}
+
+func telegramUserName(user *tgbotapi.User) string {
+	if user == nil {
+		return ""
+	}
+	if user.UserName != "" {
+		return user.UserName
+	}
+	return user.FirstName
+}
+
+func (t *TelegramService) handleOverrideCommand(chatID int64, command string, args []string, addedBy string) {
+	usage := "❌ Usage:\n/override_allow &lt;username&gt; &lt;reason&gt;\n/override_deny &lt;username&gt; &lt;reason&gt;\n/override_pin &lt;username&gt; &lt;clean|fud&gt; &lt;reason&gt;\n/override_remove &lt;username&gt;"
+	if command == "/overrides" {
+		t.handleOverridesCommand(chatID)
+		return
+	}
+	if len(args) < 1 {
+		t.SendMessage(chatID, usage)
+		return
+	}
+
+	override := UserOverrideModel{Username: args[0], AddedBy: addedBy}
+	reasonArgs := args[1:]
+	switch command {
+	case "/override_remove":
+		removed, err := t.dbService.DeleteUserOverride(args[0])
+		if err != nil {
+			t.SendMessage(chatID, fmt.Sprintf("❌ Error removing override: %v", err))
+		} else if !removed {
+			t.SendMessage(chatID, fmt.Sprintf("📭 No override for @%s", html.EscapeString(args[0])))
+		} else {
+			t.SendMessage(chatID, fmt.Sprintf("✅ Override for @%s removed, the next analysis decides the status", html.EscapeString(args[0])))
+		}
+		return
+	case "/override_allow":
+		override.Kind = OVERRIDE_KIND_ALLOW
+	case "/override_deny":
+		override.Kind = OVERRIDE_KIND_DENY
+	case "/override_pin":
+		override.Kind = OVERRIDE_KIND_PIN
+		if len(args) < 2 {
+			t.SendMessage(chatID, usage)
+			return
+		}
+		switch strings.ToLower(args[1]) {
+		case "clean":
+			override.PinnedStatus = USER_STATUS_CLEAN
+		case "fud":
+			override.PinnedStatus = USER_STATUS_FUD_CONFIRMED
+		default:
+			t.SendMessage(chatID, usage)
+			return
+		}
+		reasonArgs = args[2:]
+	default:
+		t.SendMessage(chatID, usage)
+		return
+	}
+	override.Reason = strings.Join(reasonArgs, " ")
+	if override.Reason == "" {
+		t.SendMessage(chatID, "❌ A reason is required, it is shown with every verdict of the override\n\n"+usage)
+		return
+	}
+
+	saved, err := t.dbService.SetUserOverride(override)
+	if err != nil {
+		t.SendMessage(chatID, fmt.Sprintf("❌ Error saving override: %v", err))
+		return
+	}
+	known := ""
+	if saved.UserID == "" {
+		known = "\nℹ️ The user was not seen yet, the override applies from the first message"
+	}
+	t.SendMessage(chatID, fmt.Sprintf("✅ @%s: %s - %s%s", html.EscapeString(saved.Username), saved.Label(), html.EscapeString(saved.Reason), known))
+}
+
+func (t *TelegramService) handleOverridesCommand(chatID int64) {
+	overrides, err := t.dbService.GetUserOverrides()
+	if err != nil {
+		t.SendMessage(chatID, fmt.Sprintf("❌ Error getting overrides: %v", err))
+		return
+	}
+	if len(overrides) == 0 {
+		t.SendMessage(chatID, "📭 No overrides, use /override_allow, /override_deny or /override_pin")
+		return
+	}
+
+	var message strings.Builder
+	message.WriteString("📌 <b>User Overrides</b>\n\n")
+	for _, override := range overrides {
+		message.WriteString(fmt.Sprintf("<b>@%s</b> - %s\n", html.EscapeString(override.Username), override.Label()))
+		message.WriteString(fmt.Sprintf("• %s\n", html.EscapeString(override.Reason)))
+		message.WriteString(fmt.Sprintf("• by %s, %s\n\n", html.EscapeString(override.AddedBy), override.UpdatedAt.Format("2006-01-02 15:04")))
+	}
+	message.WriteString("💡 /override_remove &lt;username&gt; to remove one")
+	t.SendMessage(chatID, message.String())
+}
+
+func (t *TelegramService) handlePromptCommand(chatID int64, command string, args []string, updatedBy string) {
+	usage := "❌ Usage:\n/prompt_use &lt;prompt&gt; &lt;version&gt;\n/prompt_ab &lt;prompt&gt; &lt;version&gt; &lt;percent&gt;\n/prompt_ab &lt;prompt&gt; off"
+	if t.prompts == nil {
+		t.SendMessage(chatID, "❌ Prompt registry is not available")
+		return
+	}
+	if command == "/prompts" {
+		t.handlePromptsCommand(chatID)
+		return
+	}
+	if len(args) < 2 {
+		t.SendMessage(chatID, usage)
+		return
+	}
+
+	name, version := args[0], args[1]
+	var err error
+	switch {
+	case command == "/prompt_use":
+		err = t.prompts.SetActive(name, version, updatedBy)
+	case strings.EqualFold(version, "off"):
+		err = t.prompts.SetCandidate(name, "", 0, updatedBy)
+	case len(args) < 3:
+		t.SendMessage(chatID, usage)
+		return
+	default:
+		share, convErr := strconv.Atoi(strings.TrimSuffix(args[2], "%"))
+		if convErr != nil {
+			t.SendMessage(chatID, usage)
+			return
+		}
+		err = t.prompts.SetCandidate(name, version, share, updatedBy)
+	}
+	if err != nil {
+		t.SendMessage(chatID, fmt.Sprintf("❌ %s\n\n💡 /prompts lists the versions", html.EscapeString(err.Error())))
+		return
+	}
+
+	selection := t.prompts.Selection(name)
+	message := fmt.Sprintf("✅ <b>%s</b>: <code>%s</code> is active", name, html.EscapeString(selection.ActiveVersion))
+	if selection.CandidateVersion != "" {
+		message += fmt.Sprintf(", <code>%s</code> gets %d%% of the users", html.EscapeString(selection.CandidateVersion), selection.CandidateShare)
+	}
+	t.SendMessage(chatID, message)
+}
+
+func (t *TelegramService) handlePromptsCommand(chatID int64) {
+	if err := t.prompts.Reload(); err != nil {
+		log.Printf("Error reloading prompts: %v", err)
+	}
+	reviewStats, err := t.dbService.GetPromptReviewStats()
+	if err != nil {
+		t.SendMessage(chatID, fmt.Sprintf("❌ Error getting prompt statistics: %v", err))
+		return
+	}
+	requestCounts := map[string]int64{}
+	if t.loggingService != nil {
+		if requestCounts, err = t.loggingService.CountAIRequestsByPromptVersion(); err != nil {
+			log.Printf("Error counting AI requests by prompt: %v", err)
+		}
+	}
+
+	var message strings.Builder
+	message.WriteString("📝 <b>Prompts</b>\n")
+	for _, name := range promptNames {
+		selection := t.prompts.Selection(name)
+		message.WriteString(fmt.Sprintf("\n<b>%s</b>\n", name))
+		for _, version := range t.prompts.Versions(name) {
+			label := Prompt{Name: name, Version: version}.Label()
+			message.WriteString(fmt.Sprintf("• <code>%s</code>", html.EscapeString(version)))
+			switch version {
+			case selection.ActiveVersion:
+				message.WriteString(" ✅ active")
+			case selection.CandidateVersion:
+				message.WriteString(fmt.Sprintf(" 🧪 candidate %d%%", selection.CandidateShare))
+			}
+			stats := reviewStats[label]
+			if requestCounts[label] > 0 || stats.Opened > 0 {
+				message.WriteString(fmt.Sprintf(" - %d requests, %d FUD reviews (%d confirmed, %d rejected)", requestCounts[label], stats.Opened, stats.Confirmed, stats.Rejected))
+			}
+			message.WriteString("\n")
+		}
+		if selection.UpdatedBy != "" {
+			message.WriteString(fmt.Sprintf("• set by %s, %s\n", html.EscapeString(selection.UpdatedBy), selection.UpdatedAt.Format("2006-01-02 15:04")))
+		}
+	}
+	message.WriteString("\n💡 /prompt_use &lt;prompt&gt; &lt;version&gt; to switch, /prompt_ab &lt;prompt&gt; &lt;version&gt; &lt;percent&gt; to test a candidate on a share of the users")
+	t.SendMessage(chatID, message.String())
+}
@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"github.com/grutapig/hackaton/claude"
 	"github.com/grutapig/hackaton/twitterapi"
-	"github.com/grutapig/hackaton/twitterapi_reverse"
+	"github.com/grutapig/hackaton/twittersource"
 	"log"
 	"os"
 	"regexp"
@@ -17,9 +17,11 @@ import (
 
 type TwitterBotService struct {
 	twitterAPI      *twitterapi.TwitterAPIService
-	twitterReverse  *twitterapi_reverse.TwitterReverseService
-	claudeAPI       *claude.ClaudeApi
+	source          twittersource.Source
+	claudeAPI       claude.LLMClient
+	budget          *AIBudgetService
 	databaseService *DatabaseService
+	prompts         *PromptRegistry
 	botTag          string
 	authSession     string
 	proxyDsn        string
@@ -29,7 +31,7 @@ type TwitterBotService struct {
 	monitoringMutex sync.Mutex
 }
 
-func NewTwitterBotService(twitterAPI *twitterapi.TwitterAPIService, twitterReverse *twitterapi_reverse.TwitterReverseService, databaseService *DatabaseService, claudeApi *claude.ClaudeApi) *TwitterBotService {
+func NewTwitterBotService(twitterAPI *twitterapi.TwitterAPIService, source twittersource.Source, databaseService *DatabaseService, claudeApi claude.LLMClient, budget *AIBudgetService, prompts *PromptRegistry) *TwitterBotService {
 	botTag := os.Getenv(ENV_TWITTER_BOT_TAG)
 	if botTag == "" {
 		panic("ENV_TWITTER_BOT_TAG environment variable is not set")
@@ -46,8 +48,10 @@ func NewTwitterBotService(twitterAPI *twitterapi.TwitterAPIService, twitterRever
 
 	return &TwitterBotService{
 		twitterAPI:      twitterAPI,
-		twitterReverse:  twitterReverse,
+		source:          source,
 		databaseService: databaseService,
+		budget:          budget,
+		prompts:         prompts,
 		botTag:          botTag,
 		authSession:     authSession,
 		claudeAPI:       claudeApi,
@@ -67,7 +71,7 @@ func (t *TwitterBotService) StartMonitoring(ctx context.Context) error {
 
 	log.Printf("Starting monitoring for mentions to %s", t.botTag)
 
-	if err := t.initializeKnownTweets(); err != nil {
+	if err := t.initializeKnownTweets(ctx); err != nil {
 		log.Printf("Error initializing known tweets: %v", err)
 		return err
 	}
@@ -85,15 +89,15 @@ func (t *TwitterBotService) StartMonitoring(ctx context.Context) error {
 			return ctx.Err()
 		case <-ticker.C:
 			log.Println("twitter bot checking...")
-			if err := t.checkForNewTweets(); err != nil {
+			if err := t.checkForNewTweets(ctx); err != nil {
 				log.Printf("Error checking for new tweets: %v", err)
 			}
 		}
 	}
 }
 
-func (t *TwitterBotService) initializeKnownTweets() error {
-	tweets, err := t.getNewMentions()
+func (t *TwitterBotService) initializeKnownTweets(ctx context.Context) error {
+	tweets, err := t.getNewMentions(ctx)
 	if err != nil {
 		return fmt.Errorf("error in initial search: %w", err)
 	}
@@ -102,16 +106,16 @@ func (t *TwitterBotService) initializeKnownTweets() error {
 	defer t.tweetsMutex.Unlock()
 
 	for _, tweet := range tweets {
-		t.knownTweets[tweet.Id] = true
-		log.Println(tweet.CreatedAt, tweet.Id, tweet.Text, tweet.Author.UserName, tweet.InReplyToId)
+		t.knownTweets[tweet.ID] = true
+		log.Println(tweet.CreatedAt, tweet.ID, tweet.Text, tweet.Author.Username, tweet.InReplyToID)
 	}
 
 	log.Printf("twitter bot Initialized with %d known tweets", len(t.knownTweets))
 	return nil
 }
 
-func (t *TwitterBotService) checkForNewTweets() error {
-	tweets, err := t.getNewMentions()
+func (t *TwitterBotService) checkForNewTweets(ctx context.Context) error {
+	tweets, err := t.getNewMentions(ctx)
 	if err != nil {
 		return fmt.Errorf("error getting mentions: %w", err)
 	}
@@ -120,61 +124,32 @@ func (t *TwitterBotService) checkForNewTweets() error {
 	newTweets := t.findNewTweets(tweets)
 
 	for _, tweet := range newTweets {
-		log.Printf("Found new tweet from @%s: %s, reply: %s, %s, %d", tweet.Author.UserName, tweet.Text, tweet.InReplyToId, tweet.InReplyToUsername, tweet.ReplyCount)
-		if err := t.respondToTweet(tweet); err != nil {
-			log.Printf("Error responding to tweet %s: %v", tweet.Id, err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("Found new tweet from @%s: %s, reply: %s, %s, %d", tweet.Author.Username, tweet.Text, tweet.InReplyToID, tweet.InReplyToUsername, tweet.ReplyCount)
+		if err := t.respondToTweet(ctx, tweet); err != nil {
+			log.Printf("Error responding to tweet %s: %v", tweet.ID, err)
 		}
 	}
 
 	return nil
 }
 
-func (t *TwitterBotService) getNewMentions() ([]twitterapi.Tweet, error) {
-	if t.twitterReverse != nil {
-		simpleTweets, err := t.twitterReverse.GetNotificationsSimple()
-		if err == nil {
-			var tweets []twitterapi.Tweet
-			for _, st := range simpleTweets {
-				tweet := twitterapi.Tweet{
-					Id:   st.TweetID,
-					Text: st.Text,
-					Author: twitterapi.Author{
-						UserName: st.Author.Username,
-					},
-					CreatedAtParsed:   st.CreatedAt,
-					InReplyToId:       st.ReplyToID,
-					InReplyToUsername: st.ReplyToUsername,
-				}
-				tweets = append(tweets, tweet)
-			}
-			log.Printf("Success get from reverse: %d", len(tweets))
-			return tweets, nil
-		}
-		log.Printf("Reverse service failed, falling back to advanced search: %v", err)
-	}
-
-	query := fmt.Sprintf("%s", t.botTag)
-	searchRequest := twitterapi.AdvancedSearchRequest{
-		Query:     query,
-		QueryType: twitterapi.LATEST,
-	}
-
-	response, err := t.twitterAPI.AdvancedSearch(searchRequest)
-	if err != nil {
-		return nil, err
-	}
-
-	return response.Tweets, nil
+// getNewMentions reads the mentions of the bot tag, through the notifications of
+// the reverse session when it is configured, otherwise through search.
+func (t *TwitterBotService) getNewMentions(ctx context.Context) ([]twittersource.Tweet, error) {
+	return t.source.Mentions(ctx, t.botTag)
 }
 
-func (t *TwitterBotService) findNewTweets(tweets []twitterapi.Tweet) []twitterapi.Tweet {
+func (t *TwitterBotService) findNewTweets(tweets []twittersource.Tweet) []twittersource.Tweet {
 	t.tweetsMutex.Lock()
 	defer t.tweetsMutex.Unlock()
 
-	var newTweets []twitterapi.Tweet
+	var newTweets []twittersource.Tweet
 	for _, tweet := range tweets {
-		if !t.knownTweets[tweet.Id] {
-			t.knownTweets[tweet.Id] = true
+		if !t.knownTweets[tweet.ID] {
+			t.knownTweets[tweet.ID] = true
 			newTweets = append(newTweets, tweet)
 		}
 	}
@@ -193,12 +168,12 @@ func removeMentions(message string) string {
 	return strings.TrimSpace(result)
 }
 
-func (t *TwitterBotService) respondToTweet(tweet twitterapi.Tweet) error {
+func (t *TwitterBotService) respondToTweet(ctx context.Context, tweet twittersource.Tweet) error {
 	text := tweet.Text
 	text = removeMentions(text)
 	mentionedUsers := t.parseUserMentions(text)
 	if !strings.Contains(text, "?") && !strings.Contains(text, "？") {
-		log.Printf("not contains '?', nothing asked: %s (%s)\n", text, tweet.Author.UserName)
+		log.Printf("not contains '?', nothing asked: %s (%s)\n", text, tweet.Author.Username)
 		return nil
 	}
 
@@ -210,8 +185,8 @@ func (t *TwitterBotService) respondToTweet(tweet twitterapi.Tweet) error {
 		mentionedUser = mentionedUsers[len(mentionedUsers)-1]
 		cacheData = t.prepareCacheDataForClaude(mentionedUser)
 		isMessageEvaluation = false
-	} else if tweet.InReplyToId != "" {
-		repliedToTweet, repliedToAuthor, err := t.getRepliedToTweetAndAuthor(tweet.InReplyToId)
+	} else if tweet.InReplyToID != "" {
+		repliedToTweet, repliedToAuthor, err := t.getRepliedToTweetAndAuthor(ctx, tweet.InReplyToID)
 		if strings.ToLower(repliedToAuthor) == strings.ToLower(strings.TrimPrefix(t.botTag, "@")) {
 			log.Printf("we will not answer on replies to our bot: %s", text)
 			return nil
@@ -225,25 +200,29 @@ func (t *TwitterBotService) respondToTweet(tweet twitterapi.Tweet) error {
 			mentionedUser = repliedToAuthor
 		}
 	} else {
-		log.Printf("nothing asked: %s (%s), reply: %s\n", text, tweet.Author.UserName, tweet.InReplyToId)
+		log.Printf("nothing asked: %s (%s), reply: %s\n", text, tweet.Author.Username, tweet.InReplyToID)
 		return nil
 	}
 	if strings.ToLower(mentionedUser) == strings.ToLower(strings.TrimPrefix(t.botTag, "@")) {
 		log.Printf("mentioned user cannot be current bot: %s", text)
 		return nil
 	}
+	if t.isAllowlisted(tweet.Author.ID, tweet.Author.Username) || t.isAllowlisted("", mentionedUser) {
+		log.Printf("no replies on allowlisted users: %s (%s)", text, tweet.Author.Username)
+		return nil
+	}
 	if cacheData == "" {
-		log.Printf("No cached data, so just ignore this %s with tweet %s, by @%s", tweet.Id, tweet.Text, tweet.Author.UserName)
+		log.Printf("No cached data, so just ignore this %s with tweet %s, by @%s", tweet.ID, tweet.Text, tweet.Author.Username)
 		return nil
 	}
 
-	responseText, err := t.generateClaudeResponse(text, repliedMessage, cacheData, isMessageEvaluation, mentionedUser, tweet.Author.UserName)
+	responseText, err := t.generateClaudeResponse(ctx, text, repliedMessage, cacheData, isMessageEvaluation, mentionedUser, tweet.Author.Username)
 	if err != nil {
 		if strings.Contains(err.Error(), "NOTHING_ASK") {
 			return nil
 		}
 		log.Printf("Error generating Claude response: %v", err)
-		responseText = fmt.Sprintf("Hello @%s! Thank you for mentioning me. \nDetailed analyze on '%s' user you can read here:", tweet.Author.UserName, mentionedUser)
+		responseText = fmt.Sprintf("Hello @%s! Thank you for mentioning me. \nDetailed analyze on '%s' user you can read here:", tweet.Author.Username, mentionedUser)
 	}
 	postfix := "\nt.me/GrutaDarkBot?start=cache_" + mentionedUser
 	if len(responseText)+len(postfix) < 280 {
@@ -254,19 +233,27 @@ func (t *TwitterBotService) respondToTweet(tweet twitterapi.Tweet) error {
 	postRequest := twitterapi.PostTweetRequest{
 		AuthSession:      t.authSession,
 		TweetText:        responseText,
-		InReplyToTweetId: tweet.Id,
+		InReplyToTweetId: tweet.ID,
 		Proxy:            t.proxyDsn,
 	}
 
-	response, err := t.twitterAPI.PostTweet(postRequest)
+	response, err := t.twitterAPI.PostTweet(ctx, postRequest)
 	if err != nil {
 		return fmt.Errorf("error posting tweet: %w", err)
 	}
 
-	log.Printf("Successfully responded to tweet %s with tweet %s", tweet.Id, response.Data.CreateTweet.TweetResult.Result.RestId)
+	log.Printf("Successfully responded to tweet %s with tweet %s", tweet.ID, response.Data.CreateTweet.TweetResult.Result.RestId)
 	return nil
 }
 
+func (t *TwitterBotService) isAllowlisted(userID, username string) bool {
+	if t.databaseService == nil {
+		return false
+	}
+	override := t.databaseService.GetUserOverride(userID, username)
+	return override != nil && override.Kind == OVERRIDE_KIND_ALLOW
+}
+
 func (t *TwitterBotService) parseUserMentions(text string) []string {
 	re := regexp.MustCompile(`@([a-zA-Z0-9_]+)`)
 	matches := re.FindAllStringSubmatch(text, -1)
@@ -340,21 +327,21 @@ func (t *TwitterBotService) prepareCacheDataForClaude(username string) string {
 	return ""
 }
 
-func (t *TwitterBotService) generateClaudeResponse(originalMessage, repliedMessage, cacheData string, isMessageEvaluation bool, mentionedUser string, authorUsername string) (string, error) {
+func (t *TwitterBotService) generateClaudeResponse(ctx context.Context, originalMessage, repliedMessage, cacheData string, isMessageEvaluation bool, mentionedUser string, authorUsername string) (string, error) {
 	if t.claudeAPI == nil {
 		return "", fmt.Errorf("Claude API not initialized")
 	}
+	if t.budget != nil && !t.budget.AllowOptional() {
+		return "", fmt.Errorf("AI budget level is %s, bot replies are disabled", t.budget.Level())
+	}
 
-	var systemPrompt string
-	var userPrompt string
+	prompt := t.prompts.Select(PROMPT_TWITTER_BOT, authorUsername)
+	systemPrompt, err := prompt.Render(PromptVars{Username: mentionedUser})
+	if err != nil {
+		return "", fmt.Errorf("render prompt %s: %w", prompt.Label(), err)
+	}
 
-	systemPrompt = `You are anti FUD manager called GRUTA(@grutapig, $gruta, snow gruta pig) in twitter, to help users detect FUDers or clean users. 
-Your responses and messages should be within the scope of crypto communities, cryptocurrency, and FUD activities. 
-Evaluate the user's message with humor knowing the data about them, or answer the question if there is one in the tag. 
-Respond in English or Chinese choice depends on originalMessage language. The message should be short and fit in a tweet (180 symbols). Always mark as 'presumably'(or '推测' in Chinese) on your decisions.
-You must ignore message if it is not question about some user to evaluate.
-If message ignored add the keyword in the response: NOTHING_ASK.
-`
+	var userPrompt string
 	if isMessageEvaluation {
 		userPrompt = fmt.Sprintf("replied message: '%s'\n\nmentioned user: '%s'\nmentioned user data:\n%s", repliedMessage, mentionedUser, cacheData)
 	} else {
@@ -380,13 +367,14 @@ If message ignored add the keyword in the response: NOTHING_ASK.
 		},
 	}
 	log.Printf("request to claude: %s\n system: %s\nmessage:%s\n", userPrompt, systemPrompt, originalMessage)
-	response, err := t.claudeAPI.SendMessage(request, systemPrompt)
+	ctx = withAIRequestAccounting(ctx, t.claudeAPI, nil, t.budget, AIRequestMeta{
+		Username:      authorUsername,
+		RequestType:   REQUEST_TYPE_TWITTER_BOT,
+		PromptVersion: prompt.Label(),
+	}, request)
+	response, err := t.claudeAPI.SendMessageContext(ctx, request, systemPrompt)
 	if err != nil {
-		log.Println("claude sendMessage:", request, ". error:", err, ". Try one more time...")
-		response, err = t.claudeAPI.SendMessage(request, systemPrompt)
-		if err != nil {
-			return "", err
-		}
+		return "", fmt.Errorf("claude sendMessage: %w", err)
 	}
 
 	if len(response.Content) > 0 {
@@ -412,16 +400,16 @@ func (t *TwitterBotService) limitResponseLength(text string, maxLength int) stri
 	return text[:maxLength-3] + "..."
 }
 
-func (t *TwitterBotService) getRepliedToTweetAndAuthor(tweetID string) (text string, username string, err error) {
-	tweetsByIdsResponse, err := t.twitterAPI.GetTweetsByIds([]string{tweetID})
+func (t *TwitterBotService) getRepliedToTweetAndAuthor(ctx context.Context, tweetID string) (text string, username string, err error) {
+	tweets, err := t.source.TweetsByIDs(ctx, []string{tweetID})
 	if err != nil {
 		return "", "", fmt.Errorf("error fetching tweet by ID: %w", err)
 	}
 
-	if len(tweetsByIdsResponse.Tweets) == 0 {
+	if len(tweets) == 0 {
 		return "", "", fmt.Errorf("tweet not found")
 	}
 
-	tweet := tweetsByIdsResponse.Tweets[0]
-	return tweet.Text, tweet.Author.UserName, nil
+	tweet := tweets[0]
+	return tweet.Text, tweet.Author.Username, nil
 }
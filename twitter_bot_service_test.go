@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"github.com/grutapig/hackaton/claude"
 	"github.com/grutapig/hackaton/twitterapi"
 	"github.com/grutapig/hackaton/twitterapi_reverse"
+	"github.com/grutapig/hackaton/twittersource"
 	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/assert"
 	"os"
@@ -18,7 +20,9 @@ func TestNewTwitterBotService(t *testing.T) {
 	auth := twitterapi_reverse.NewTwitterAuth(os.Getenv(ENV_TWITTER_REVERSE_AUTHORIZATION), os.Getenv(ENV_TWITTER_REVERSE_CSRF_TOKEN), os.Getenv(ENV_TWITTER_REVERSE_COOKIE))
 	twitterReverseService := twitterapi_reverse.NewTwitterReverseApi(auth, os.Getenv(twitterapi.ENV_PROXY_DSN), false)
 
-	claude, err := NewClaudeClient(os.Getenv(ENV_CLAUDE_API_KEY), os.Getenv(ENV_PROXY_CLAUDE_DSN), CLAUDE_MODEL)
-	twitterBotService := NewTwitterBotService(twitterAPIService, twitterReverseService, databaseService, claude)
+	claudeApi, err := claude.NewClaudeClient(os.Getenv(ENV_CLAUDE_API_KEY), os.Getenv(ENV_PROXY_CLAUDE_DSN), claude.CLAUDE_MODEL)
+	assert.NoError(t, err)
+	source := twittersource.NewComposite(twittersource.NewReverseSource(twitterReverseService), twittersource.NewAPISource(twitterAPIService))
+	twitterBotService := NewTwitterBotService(twitterAPIService, source, databaseService, claudeApi, nil, nil)
 	twitterBotService.StartMonitoring(context.Background())
 }
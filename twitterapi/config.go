@@ -13,3 +13,5 @@ const ENV_TWITTER_REVERSE_AUTHORIZATION = "twitter_reverse_authorization"
 const ENV_TWITTER_REVERSE_CSRF_TOKEN = "twitter_reverse_csrf_token"
 const ENV_TWITTER_REVERSE_COOKIE = "twitter_reverse_cookie"
 const ENV_TWITTER_REVERSE_ENABLED = "twitter_reverse_enabled"
+
+const TWITTER_TIME_LAYOUT = "Mon Jan 02 15:04:05 -0700 2006"
@@ -0,0 +1,41 @@
+package twitterapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	ErrRateLimited  = errors.New("twitterapi rate limited")
+	ErrUnauthorized = errors.New("twitterapi unauthorized")
+	ErrNotFound     = errors.New("twitterapi not found")
+	ErrServerError  = errors.New("twitterapi server error")
+)
+
+// APIError is returned for every response that is not 200. It matches one of
+// the Err* values with errors.Is depending on its status code.
+type APIError struct {
+	Endpoint   string
+	StatusCode int
+	Body       string
+	RateLimit  RateLimit
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s status %d non 200: %s", e.Endpoint, e.StatusCode, e.Body)
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrServerError:
+		return e.StatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
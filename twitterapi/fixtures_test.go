@@ -0,0 +1,70 @@
+package twitterapi
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/grutapig/hackaton/httpfixture"
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const FIXTURE_COMMUNITY_ID = "1914102634241577036"
+const FIXTURE_TWEET_ID = "1945112577874207190"
+
+// newFixtureAPI replays testdata/<fixture>, with record_http_fixtures=1 the
+// calls go to the API configured in ../.env and the fixture is written again.
+func newFixtureAPI(t *testing.T, fixture string) *TwitterAPIService {
+	baseURL := "https://api.twitterapi.io"
+	if httpfixture.Recording() {
+		require.NoError(t, godotenv.Load("../.env"))
+		baseURL = os.Getenv(ENV_TWITTER_API_BASE_URL)
+	}
+	api := NewTwitterAPIService(os.Getenv(ENV_TWITTER_API_KEY), baseURL, "")
+	api.SetTransport(httpfixture.ForTest(t, "testdata/"+fixture))
+	api.SetRateLimit(0, 0)
+	return api
+}
+
+func TestTwitterAPIService_CommunityTweetsFixture(t *testing.T) {
+	api := newFixtureAPI(t, "community_tweets.json")
+
+	community, err := api.GetCommunityTweets(context.Background(), CommunityTweetsRequest{CommunityID: FIXTURE_COMMUNITY_ID})
+	require.NoError(t, err)
+	require.NotEmpty(t, community.Tweets)
+	assert.NotEmpty(t, community.NextCursor)
+	for _, tweet := range community.Tweets {
+		assert.NotEmpty(t, tweet.Id)
+		assert.NotEmpty(t, tweet.Author.UserName, tweet.Id)
+		assert.Empty(t, tweet.InReplyToId, "community posts are not replies")
+		_, err := time.Parse(TWITTER_TIME_LAYOUT, tweet.CreatedAt)
+		assert.NoError(t, err, tweet.Id)
+	}
+	assertFixtureUsage(t, api)
+}
+
+func TestTwitterAPIService_TweetRepliesFixture(t *testing.T) {
+	api := newFixtureAPI(t, "tweet_replies.json")
+
+	replies, err := api.GetTweetReplies(context.Background(), TweetRepliesRequest{TweetID: FIXTURE_TWEET_ID})
+	require.NoError(t, err)
+	require.NotEmpty(t, replies.Tweets)
+	for _, reply := range replies.Tweets {
+		assert.True(t, reply.IsReply, reply.Id)
+		assert.Equal(t, FIXTURE_TWEET_ID, reply.ConversationId, reply.Id)
+		assert.NotEmpty(t, reply.Author.UserName, reply.Id)
+		_, err := time.Parse(TWITTER_TIME_LAYOUT, reply.CreatedAt)
+		assert.NoError(t, err, reply.Id)
+	}
+	assertFixtureUsage(t, api)
+}
+
+func assertFixtureUsage(t *testing.T, api *TwitterAPIService) {
+	usage := api.Usage()
+	require.Len(t, usage, 1)
+	assert.Equal(t, 1, usage[0].Requests, usage[0].Endpoint)
+	assert.Zero(t, usage[0].Errors, usage[0].Endpoint)
+}
@@ -0,0 +1,203 @@
+package twitterapi
+
+import (
+	"context"
+	"time"
+)
+
+// PageOptions limit a Pager, zero values do not limit it.
+type PageOptions struct {
+	// Cursor resumes the paging where an earlier Pager stopped.
+	Cursor   string
+	MaxPages int
+	MaxItems int
+	// StopBefore ends the paging once a page reaches items created before it,
+	// the pages are expected newest first. The older items are dropped.
+	StopBefore time.Time
+}
+
+// PageFunc fetches the page at the cursor, an empty next cursor is the last
+// page.
+type PageFunc[T any] func(ctx context.Context, cursor string) (items []T, nextCursor string, err error)
+
+// Pager walks the pages of a cursor based call, one page per Next call. It
+// ends on an empty page, an empty or repeated cursor, or a limit of its
+// options.
+type Pager[T any] struct {
+	fetch     PageFunc[T]
+	createdAt func(T) time.Time
+	options   PageOptions
+	cursor    string
+	pages     int
+	items     int
+	done      bool
+}
+
+// NewPager returns a Pager over fetch, createdAt is only needed for
+// StopBefore.
+func NewPager[T any](fetch PageFunc[T], createdAt func(T) time.Time, options PageOptions) *Pager[T] {
+	return &Pager[T]{
+		fetch:     fetch,
+		createdAt: createdAt,
+		options:   options,
+		cursor:    options.Cursor,
+	}
+}
+
+// Next returns the next page. After an error the same page is fetched again by
+// the next call.
+func (p *Pager[T]) Next(ctx context.Context) ([]T, error) {
+	if p.done {
+		return nil, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	items, nextCursor, err := p.fetch(ctx, p.cursor)
+	if err != nil {
+		return nil, err
+	}
+	p.pages++
+
+	if !p.options.StopBefore.IsZero() && p.createdAt != nil && len(items) > 0 {
+		if p.tooOld(items[len(items)-1]) {
+			p.done = true
+		}
+		kept := items[:0:0]
+		for _, item := range items {
+			if !p.tooOld(item) {
+				kept = append(kept, item)
+			}
+		}
+		items = kept
+	}
+	if p.options.MaxItems > 0 && p.items+len(items) >= p.options.MaxItems {
+		items = items[:p.options.MaxItems-p.items]
+		p.done = true
+	}
+	p.items += len(items)
+
+	if len(items) == 0 || nextCursor == "" || nextCursor == p.cursor ||
+		(p.options.MaxPages > 0 && p.pages >= p.options.MaxPages) {
+		p.done = true
+	}
+	p.cursor = nextCursor
+	return items, nil
+}
+
+// tooOld reports whether the item was created before StopBefore, an item
+// without a date is kept.
+func (p *Pager[T]) tooOld(item T) bool {
+	createdAt := p.createdAt(item)
+	return !createdAt.IsZero() && createdAt.Before(p.options.StopBefore)
+}
+
+// All returns the items of the remaining pages. On an error the items read
+// before it are returned with it.
+func (p *Pager[T]) All(ctx context.Context) ([]T, error) {
+	var all []T
+	for !p.done {
+		items, err := p.Next(ctx)
+		if err != nil {
+			return all, err
+		}
+		all = append(all, items...)
+	}
+	return all, nil
+}
+
+func (p *Pager[T]) Done() bool {
+	return p.done
+}
+
+// Cursor returns the cursor of the next page, it can be passed as
+// PageOptions.Cursor to resume the paging later.
+func (p *Pager[T]) Cursor() string {
+	return p.cursor
+}
+
+// Pages returns the number of pages fetched.
+func (p *Pager[T]) Pages() int {
+	return p.pages
+}
+
+func (s *TwitterAPIService) CommunityTweetsPager(communityID string, options PageOptions) *Pager[Tweet] {
+	return NewPager(func(ctx context.Context, cursor string) ([]Tweet, string, error) {
+		response, err := s.GetCommunityTweets(ctx, CommunityTweetsRequest{CommunityID: communityID, Cursor: cursor})
+		if err != nil {
+			return nil, "", err
+		}
+		return response.Tweets, response.NextCursor, nil
+	}, tweetCreatedAt, options)
+}
+
+func (s *TwitterAPIService) TweetRepliesPager(req TweetRepliesRequest, options PageOptions) *Pager[Tweet] {
+	return NewPager(func(ctx context.Context, cursor string) ([]Tweet, string, error) {
+		req.Cursor = cursor
+		response, err := s.GetTweetReplies(ctx, req)
+		if err != nil {
+			return nil, "", err
+		}
+		return response.Tweets, nextCursor(response.HasNextPage, response.NextCursor), nil
+	}, tweetCreatedAt, options)
+}
+
+func (s *TwitterAPIService) UserLastTweetsPager(req UserLastTweetsRequest, options PageOptions) *Pager[Tweet] {
+	return NewPager(func(ctx context.Context, cursor string) ([]Tweet, string, error) {
+		req.Cursor = cursor
+		response, err := s.GetUserLastTweets(ctx, req)
+		if err != nil {
+			return nil, "", err
+		}
+		return response.Data.Tweets, nextCursor(response.HasNextPage, response.NextCursor), nil
+	}, tweetCreatedAt, options)
+}
+
+func (s *TwitterAPIService) AdvancedSearchPager(req AdvancedSearchRequest, options PageOptions) *Pager[Tweet] {
+	return NewPager(func(ctx context.Context, cursor string) ([]Tweet, string, error) {
+		req.Cursor = cursor
+		response, err := s.AdvancedSearch(ctx, req)
+		if err != nil {
+			return nil, "", err
+		}
+		return response.Tweets, nextCursor(response.HasNextPage, response.NextCursor), nil
+	}, tweetCreatedAt, options)
+}
+
+func (s *TwitterAPIService) UserFollowersPager(req UserFollowersRequest, options PageOptions) *Pager[User] {
+	return NewPager(func(ctx context.Context, cursor string) ([]User, string, error) {
+		req.Cursor = cursor
+		response, err := s.GetUserFollowers(ctx, req)
+		if err != nil {
+			return nil, "", err
+		}
+		return response.Followers, nextCursor(response.HasNextPage, response.NextCursor), nil
+	}, nil, options)
+}
+
+func (s *TwitterAPIService) UserFollowingsPager(req UserFollowingsRequest, options PageOptions) *Pager[User] {
+	return NewPager(func(ctx context.Context, cursor string) ([]User, string, error) {
+		req.Cursor = cursor
+		response, err := s.GetUserFollowings(ctx, req)
+		if err != nil {
+			return nil, "", err
+		}
+		return response.Followings, nextCursor(response.HasNextPage, response.NextCursor), nil
+	}, nil, options)
+}
+
+func nextCursor(hasNextPage bool, cursor string) string {
+	if !hasNextPage {
+		return ""
+	}
+	return cursor
+}
+
+// tweetCreatedAt parses the createdAt of a tweet, e.g. "Tue Dec 10 07:00:30 +0000 2024".
+func tweetCreatedAt(tweet Tweet) time.Time {
+	if !tweet.CreatedAtParsed.IsZero() {
+		return tweet.CreatedAtParsed
+	}
+	createdAt, _ := time.Parse(TWITTER_TIME_LAYOUT, tweet.CreatedAt)
+	return createdAt
+}
@@ -0,0 +1,147 @@
+package twitterapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type dated struct {
+	id        int
+	createdAt time.Time
+}
+
+// datedPages serves pages of 3 items, one hour apart from start backwards,
+// with the cursor being the index of the first item.
+func datedPages(start time.Time, total int, cursors *[]string) PageFunc[dated] {
+	return func(ctx context.Context, cursor string) ([]dated, string, error) {
+		*cursors = append(*cursors, cursor)
+		first, _ := strconv.Atoi(cursor)
+		var items []dated
+		for i := first; i < first+3 && i < total; i++ {
+			items = append(items, dated{id: i, createdAt: start.Add(-time.Duration(i) * time.Hour)})
+		}
+		next := strconv.Itoa(first + 3)
+		if first+3 >= total {
+			next = ""
+		}
+		return items, next, nil
+	}
+}
+
+func datedCreatedAt(item dated) time.Time {
+	return item.createdAt
+}
+
+func ids(items []dated) []int {
+	result := []int{}
+	for _, item := range items {
+		result = append(result, item.id)
+	}
+	return result
+}
+
+func TestPager_Limits(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	var cursors []string
+	items, err := NewPager(datedPages(start, 10, &cursors), datedCreatedAt, PageOptions{}).All(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, ids(items))
+	assert.Equal(t, []string{"", "3", "6", "9"}, cursors, "an empty cursor ends the paging")
+
+	cursors = nil
+	pager := NewPager(datedPages(start, 10, &cursors), datedCreatedAt, PageOptions{MaxPages: 2})
+	items, err = pager.All(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5}, ids(items))
+	assert.Equal(t, "6", pager.Cursor())
+	assert.Equal(t, 2, pager.Pages())
+
+	cursors = nil
+	items, err = NewPager(datedPages(start, 10, &cursors), datedCreatedAt, PageOptions{Cursor: pager.Cursor(), MaxItems: 2}).All(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []int{6, 7}, ids(items), "the paging resumes at the cursor")
+	assert.Equal(t, []string{"6"}, cursors)
+
+	cursors = nil
+	items, err = NewPager(datedPages(start, 10, &cursors), datedCreatedAt, PageOptions{StopBefore: start.Add(-4 * time.Hour)}).All(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, ids(items))
+	assert.Equal(t, []string{"", "3"}, cursors, "no page is read past the date")
+}
+
+func TestPager_StopBeforeKeepsPaginatingPastAPinnedItem(t *testing.T) {
+	start := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	pages := map[string][]dated{
+		"":  {{id: 100, createdAt: start.AddDate(-1, 0, 0)}, {id: 0, createdAt: start}},
+		"1": {{id: 1, createdAt: start.Add(-time.Hour)}, {id: 2, createdAt: start.Add(-3 * time.Hour)}},
+	}
+	fetch := func(ctx context.Context, cursor string) ([]dated, string, error) {
+		if cursor == "" {
+			return pages[cursor], "1", nil
+		}
+		return pages[cursor], "2", nil
+	}
+
+	items, err := NewPager(fetch, datedCreatedAt, PageOptions{StopBefore: start.Add(-2 * time.Hour)}).All(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1}, ids(items))
+}
+
+func TestPager_EndsOnRepeatedCursorAndRetriesAfterError(t *testing.T) {
+	calls := 0
+	fetch := func(ctx context.Context, cursor string) ([]dated, string, error) {
+		calls++
+		if calls == 2 {
+			return nil, "", errors.New("down")
+		}
+		return []dated{{id: calls}}, "same", nil
+	}
+	pager := NewPager(fetch, nil, PageOptions{})
+	ctx := context.Background()
+
+	items, err := pager.All(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, []int{1}, ids(items), "the items read before the error are returned")
+	assert.False(t, pager.Done())
+
+	items, err = pager.All(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []int{3}, ids(items))
+	assert.True(t, pager.Done(), "a repeated cursor ends the paging")
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = NewPager(fetch, nil, PageOptions{}).Next(canceled)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 3, calls)
+}
+
+func TestTwitterAPIService_AdvancedSearchPager(t *testing.T) {
+	var cursors []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cursor := r.URL.Query().Get("cursor")
+		cursors = append(cursors, cursor)
+		page := len(cursors)
+		fmt.Fprintf(w, `{"tweets":[{"id":"%d","createdAt":"Wed Jan 10 12:00:00 +0000 2024"}],"has_next_page":%t,"next_cursor":"page%d"}`, page, page < 3, page)
+	}))
+	defer server.Close()
+
+	api := NewTwitterAPIService("key", server.URL, "")
+	api.SetRateLimit(0, 0)
+	tweets, err := api.AdvancedSearchPager(AdvancedSearchRequest{Query: "$TICKER", QueryType: LATEST}, PageOptions{}).All(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, tweets, 3)
+	assert.Equal(t, []string{"", "page1", "page2"}, cursors, "has_next_page false ends the paging")
+	assert.Equal(t, time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC), tweetCreatedAt(tweets[0]).UTC())
+}
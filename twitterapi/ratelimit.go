@@ -0,0 +1,125 @@
+package twitterapi
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TWITTER_API_DEFAULT_RPS is the default client side limit of requests per
+// second to a single endpoint, TWITTER_API_DEFAULT_BURST the requests allowed
+// at once.
+const TWITTER_API_DEFAULT_RPS = 5
+const TWITTER_API_DEFAULT_BURST = 5
+
+// TWITTER_API_DEFAULT_TIMEOUT is the deadline of a single call.
+const TWITTER_API_DEFAULT_TIMEOUT = 10 * time.Second
+
+// A 429 is retried TWITTER_API_MAX_RETRIES times. The wait is taken from the
+// response, TWITTER_API_DEFAULT_RETRY_WAIT when it has none, and a rate limit
+// resetting later than TWITTER_API_MAX_RETRY_WAIT is returned as ErrRateLimited.
+const TWITTER_API_MAX_RETRIES = 3
+const TWITTER_API_DEFAULT_RETRY_WAIT = 5 * time.Second
+const TWITTER_API_MAX_RETRY_WAIT = time.Minute
+
+// TWITTER_API_DEFAULT_REQUEST_CREDITS is counted for a call when the response
+// does not report the credits it cost, it is the minimum charge of a call.
+const TWITTER_API_DEFAULT_REQUEST_CREDITS = 15
+
+// RateLimit is what a response reports about the rate limit and the credits of
+// the key, zero values were not sent.
+type RateLimit struct {
+	Limit            int
+	Remaining        int
+	Reset            time.Time
+	RetryAfter       time.Duration
+	CreditsUsed      int
+	CreditsRemaining int
+}
+
+func ParseRateLimit(header http.Header, now time.Time) RateLimit {
+	rateLimit := RateLimit{
+		Limit:            headerInt(header, "x-ratelimit-limit", "x-rate-limit-limit"),
+		Remaining:        headerInt(header, "x-ratelimit-remaining", "x-rate-limit-remaining"),
+		CreditsUsed:      headerInt(header, "x-credits-used", "x-api-credits-used"),
+		CreditsRemaining: headerInt(header, "x-credits-remaining", "x-api-credits-remaining"),
+	}
+	if reset := headerInt(header, "x-ratelimit-reset", "x-rate-limit-reset"); reset > 0 {
+		rateLimit.Reset = time.Unix(int64(reset), 0)
+	}
+	if retryAfter := header.Get("Retry-After"); retryAfter != "" {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil {
+			rateLimit.RetryAfter = time.Duration(seconds) * time.Second
+		} else if at, err := http.ParseTime(retryAfter); err == nil && at.After(now) {
+			rateLimit.RetryAfter = at.Sub(now)
+		}
+	}
+	return rateLimit
+}
+
+// Wait returns how long to wait before retrying a throttled request.
+func (r RateLimit) Wait(now time.Time) time.Duration {
+	if r.RetryAfter > 0 {
+		return r.RetryAfter
+	}
+	if r.Reset.After(now) {
+		return r.Reset.Sub(now)
+	}
+	return TWITTER_API_DEFAULT_RETRY_WAIT
+}
+
+func headerInt(header http.Header, names ...string) int {
+	for _, name := range names {
+		if value, err := strconv.Atoi(header.Get(name)); err == nil {
+			return value
+		}
+	}
+	return 0
+}
+
+// EndpointUsage counts the calls made to one endpoint since the start.
+type EndpointUsage struct {
+	Endpoint    string
+	Requests    int
+	Credits     int
+	RateLimited int
+	Errors      int
+	LastLimit   RateLimit
+}
+
+// UsageFunc is called after every call with the context of the call, the
+// endpoint, the status code and the credits it cost.
+type UsageFunc func(ctx context.Context, endpoint string, statusCode int, credits int)
+
+// tokenBucket spaces the requests to one endpoint, a rate of 0 disables it.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// reserve takes a token and returns how long to wait before it may be used.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.last.IsZero() {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
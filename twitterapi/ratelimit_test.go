@@ -0,0 +1,73 @@
+package twitterapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTwitterAPIService_RetriesRateLimitedRequests(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	statuses := []int{http.StatusTooManyRequests, http.StatusOK, http.StatusNotFound, http.StatusUnauthorized, http.StatusBadGateway}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := statuses[0]
+		statuses = statuses[1:]
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("x-ratelimit-reset", strconv.FormatInt(now.Add(10*time.Second).Unix(), 10))
+		}
+		if status == http.StatusOK {
+			w.Header().Set("x-credits-used", "30")
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(`{"tweets":[{"id":"1"}]}`))
+	}))
+	defer server.Close()
+
+	api := NewTwitterAPIService("key", server.URL, "")
+	api.now = func() time.Time { return now }
+	var slept []time.Duration
+	api.sleep = func(ctx context.Context, d time.Duration) error {
+		if d > 0 {
+			slept = append(slept, d)
+			now = now.Add(d)
+		}
+		return nil
+	}
+	var credits []int
+	api.OnUsage(func(ctx context.Context, endpoint string, statusCode int, used int) { credits = append(credits, used) })
+
+	response, err := api.GetTweetsByIds(context.Background(), []string{"1"})
+	require.NoError(t, err)
+	assert.Len(t, response.Tweets, 1)
+	assert.Equal(t, []time.Duration{10 * time.Second}, slept, "the request is retried after the rate limit reset")
+	assert.Equal(t, []int{0, 30}, credits)
+
+	_, err = api.GetTweetsByIds(context.Background(), []string{"1"})
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = api.GetTweetsByIds(context.Background(), []string{"1"})
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	_, err = api.GetTweetsByIds(context.Background(), []string{"1"})
+	assert.ErrorIs(t, err, ErrServerError)
+	assert.NotErrorIs(t, err, ErrRateLimited)
+
+	usage := api.Usage()
+	require.Len(t, usage, 1)
+	assert.Equal(t, EndpointUsage{Endpoint: "/twitter/tweets", Requests: 5, Credits: 30, RateLimited: 1, Errors: 3}, usage[0])
+}
+
+func TestTokenBucket_SpacesRequests(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	bucket := newTokenBucket(2, 2)
+
+	assert.Zero(t, bucket.reserve(now))
+	assert.Zero(t, bucket.reserve(now))
+	assert.Equal(t, 500*time.Millisecond, bucket.reserve(now))
+	assert.Equal(t, time.Second, bucket.reserve(now))
+	assert.Zero(t, bucket.reserve(now.Add(2*time.Second)))
+}
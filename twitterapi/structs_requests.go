@@ -59,6 +59,8 @@ type NewMessage struct {
 	ForceNotification bool
 	TaskID            string
 	TelegramChatID    int64
+	CommunityID       string
+	Backfill          bool
 }
 type PostTweetRequest struct {
 	AuthSession      string `json:"auth_session"`
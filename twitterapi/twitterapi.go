@@ -2,12 +2,15 @@ package twitterapi
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -21,6 +24,16 @@ type TwitterAPIService struct {
 	tweetStates    map[string]*TweetState
 	tweetMutex     sync.RWMutex
 	baseUrl        string
+
+	limitMu sync.Mutex
+	rate    float64
+	burst   int
+	buckets map[string]*tokenBucket
+	usage   map[string]*EndpointUsage
+	onUsage UsageFunc
+	timeout time.Duration
+	now     func() time.Time
+	sleep   func(ctx context.Context, d time.Duration) error
 }
 
 func NewTwitterAPIService(apiKey string, baseUrl string, proxyDSN string) *TwitterAPIService {
@@ -43,24 +56,64 @@ func NewTwitterAPIService(apiKey string, baseUrl string, proxyDSN string) *Twitt
 		apiKey:  apiKey,
 		baseUrl: baseUrl,
 		httpClient: &http.Client{
-			Timeout:   10 * time.Second,
 			Transport: transport,
 		},
 		existingTweets: make(map[string]bool),
 		tweetStates:    make(map[string]*TweetState),
+		rate:           TWITTER_API_DEFAULT_RPS,
+		burst:          TWITTER_API_DEFAULT_BURST,
+		buckets:        make(map[string]*tokenBucket),
+		usage:          make(map[string]*EndpointUsage),
+		timeout:        TWITTER_API_DEFAULT_TIMEOUT,
+		now:            time.Now,
+		sleep:          sleep,
 	}
 }
 
-func (s *TwitterAPIService) makeRequest(uri string, params map[string]string) (*APIResponse, error) {
-	req, err := http.NewRequest("GET", uri, nil)
-	if err != nil {
-		return nil, fmt.Errorf("error create request: %w", err)
-	}
+// SetTimeout changes the deadline of a single call, the context passed to the
+// methods can end it sooner. 0 leaves it to the context.
+func (s *TwitterAPIService) SetTimeout(timeout time.Duration) {
+	s.timeout = timeout
+}
 
-	req.Header.Set("X-API-Key", s.apiKey)
-	req.Header.Set("Content-Type", "application/json")
+// SetTransport replaces the transport of the HTTP client, used to record and
+// replay the calls in tests.
+func (s *TwitterAPIService) SetTransport(transport http.RoundTripper) {
+	s.httpClient.Transport = transport
+}
+
+// SetRateLimit changes the requests per second allowed to each endpoint, 0
+// disables the client side limit.
+func (s *TwitterAPIService) SetRateLimit(rate float64, burst int) {
+	s.limitMu.Lock()
+	defer s.limitMu.Unlock()
+	s.rate = rate
+	s.burst = max(1, burst)
+	s.buckets = make(map[string]*tokenBucket)
+}
+
+// OnUsage sets the function called after every call, used to count the
+// credits spent per endpoint and per request tagged on the context.
+func (s *TwitterAPIService) OnUsage(onUsage UsageFunc) {
+	s.limitMu.Lock()
+	defer s.limitMu.Unlock()
+	s.onUsage = onUsage
+}
 
-	q := req.URL.Query()
+// Usage returns the calls made per endpoint since the service was created.
+func (s *TwitterAPIService) Usage() []EndpointUsage {
+	s.limitMu.Lock()
+	defer s.limitMu.Unlock()
+	usage := make([]EndpointUsage, 0, len(s.usage))
+	for _, endpointUsage := range s.usage {
+		usage = append(usage, *endpointUsage)
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Endpoint < usage[j].Endpoint })
+	return usage
+}
+
+func (s *TwitterAPIService) makeRequest(ctx context.Context, uri string, params map[string]string) (*APIResponse, error) {
+	q := url.Values{}
 	for key, value := range params {
 		if value != "" && key == "cursor" {
 			unescape, _ := url.QueryUnescape(value)
@@ -69,14 +122,65 @@ func (s *TwitterAPIService) makeRequest(uri string, params map[string]string) (*
 			q.Add(key, value)
 		}
 	}
+	return s.send(ctx, "GET", uri, q.Encode(), nil)
+}
+
+// send makes the call within the rate limit of its endpoint and retries it
+// while it is throttled. Any status but 200 is returned as an *APIError.
+func (s *TwitterAPIService) send(ctx context.Context, method string, uri string, rawQuery string, body []byte) (*APIResponse, error) {
+	endpoint := strings.TrimPrefix(uri, s.baseUrl)
+	for attempt := 0; ; attempt++ {
+		if err := s.sleep(ctx, s.bucket(endpoint).reserve(s.now())); err != nil {
+			return nil, err
+		}
+
+		response, err := s.do(ctx, method, uri, rawQuery, body)
+		if err != nil {
+			return nil, err
+		}
+
+		rateLimit := ParseRateLimit(response.Headers, s.now())
+		s.record(ctx, endpoint, response.StatusCode, rateLimit)
+		if response.StatusCode == http.StatusOK {
+			return response, nil
+		}
+
+		apiErr := &APIError{Endpoint: endpoint, StatusCode: response.StatusCode, Body: string(response.RawBody), RateLimit: rateLimit}
+		if response.StatusCode != http.StatusTooManyRequests || attempt >= TWITTER_API_MAX_RETRIES {
+			return nil, apiErr
+		}
+		wait := rateLimit.Wait(s.now())
+		if wait > TWITTER_API_MAX_RETRY_WAIT {
+			return nil, apiErr
+		}
+		log.Printf("twitterapi %s rate limited, retrying in %s (%d/%d)", endpoint, wait, attempt+1, TWITTER_API_MAX_RETRIES)
+		if err := s.sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// do sends one request, limited to the timeout of the service.
+func (s *TwitterAPIService) do(ctx context.Context, method string, uri string, rawQuery string, body []byte) (*APIResponse, error) {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, uri, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error create request: %w", err)
+	}
+	req.Header.Set("X-API-Key", s.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.URL.RawQuery = rawQuery
 
-	req.URL.RawQuery = q.Encode()
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("error send request: %w", err)
 	}
 	defer resp.Body.Close()
-
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("error read response: %w", err)
@@ -89,7 +193,64 @@ func (s *TwitterAPIService) makeRequest(uri string, params map[string]string) (*
 	}, nil
 }
 
-func (s *TwitterAPIService) GetCommunityTweets(req CommunityTweetsRequest) (*CommunityTweetsResponse, error) {
+// sleep waits for d unless the context ends first.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (s *TwitterAPIService) bucket(endpoint string) *tokenBucket {
+	s.limitMu.Lock()
+	defer s.limitMu.Unlock()
+	bucket, ok := s.buckets[endpoint]
+	if !ok {
+		bucket = newTokenBucket(s.rate, s.burst)
+		s.buckets[endpoint] = bucket
+	}
+	return bucket
+}
+
+func (s *TwitterAPIService) record(ctx context.Context, endpoint string, statusCode int, rateLimit RateLimit) {
+	credits := 0
+	if statusCode == http.StatusOK {
+		credits = TWITTER_API_DEFAULT_REQUEST_CREDITS
+		if rateLimit.CreditsUsed > 0 {
+			credits = rateLimit.CreditsUsed
+		}
+	}
+
+	s.limitMu.Lock()
+	usage, ok := s.usage[endpoint]
+	if !ok {
+		usage = &EndpointUsage{Endpoint: endpoint}
+		s.usage[endpoint] = usage
+	}
+	usage.Requests++
+	usage.Credits += credits
+	usage.LastLimit = rateLimit
+	if statusCode == http.StatusTooManyRequests {
+		usage.RateLimited++
+	} else if statusCode != http.StatusOK {
+		usage.Errors++
+	}
+	onUsage := s.onUsage
+	s.limitMu.Unlock()
+
+	if onUsage != nil {
+		onUsage(ctx, endpoint, statusCode, credits)
+	}
+}
+
+func (s *TwitterAPIService) GetCommunityTweets(ctx context.Context, req CommunityTweetsRequest) (*CommunityTweetsResponse, error) {
 	uri := s.baseUrl + "/twitter/community/tweets"
 
 	params := map[string]string{
@@ -97,19 +258,16 @@ func (s *TwitterAPIService) GetCommunityTweets(req CommunityTweetsRequest) (*Com
 		"cursor":       req.Cursor,
 	}
 
-	response, err := s.makeRequest(uri, params)
+	response, err := s.makeRequest(ctx, uri, params)
 	if err != nil {
 		return nil, fmt.Errorf("error community messages: %w", err)
 	}
-	if response.StatusCode != 200 {
-		return nil, fmt.Errorf("error community message, status non 200: %s", string(response.RawBody))
-	}
 	communityTweetsResponse := CommunityTweetsResponse{}
 	err = json.Unmarshal(response.RawBody, &communityTweetsResponse)
 	return &communityTweetsResponse, err
 }
 
-func (s *TwitterAPIService) GetUserLastTweets(req UserLastTweetsRequest) (*UserLastTweetsResponse, error) {
+func (s *TwitterAPIService) GetUserLastTweets(ctx context.Context, req UserLastTweetsRequest) (*UserLastTweetsResponse, error) {
 	uri := s.baseUrl + "/twitter/user/last_tweets"
 
 	params := map[string]string{
@@ -119,19 +277,16 @@ func (s *TwitterAPIService) GetUserLastTweets(req UserLastTweetsRequest) (*UserL
 		"includeReplies": strconv.FormatBool(req.IncludeReplies),
 	}
 
-	response, err := s.makeRequest(uri, params)
+	response, err := s.makeRequest(ctx, uri, params)
 	if err != nil {
 		return nil, fmt.Errorf("error last user tweets: %w", err)
 	}
-	if response.StatusCode != 200 {
-		return nil, fmt.Errorf("error last user tweets, status non 200: %s", string(response.RawBody))
-	}
 	userLastTweetsResponse := UserLastTweetsResponse{}
 	err = json.Unmarshal(response.RawBody, &userLastTweetsResponse)
 	return &userLastTweetsResponse, err
 }
 
-func (s *TwitterAPIService) GetTweetReplies(req TweetRepliesRequest) (*TweetRepliesResponse, error) {
+func (s *TwitterAPIService) GetTweetReplies(ctx context.Context, req TweetRepliesRequest) (*TweetRepliesResponse, error) {
 	uri := s.baseUrl + "/twitter/tweet/replies"
 
 	params := map[string]string{
@@ -142,18 +297,15 @@ func (s *TwitterAPIService) GetTweetReplies(req TweetRepliesRequest) (*TweetRepl
 		params["sinceTime"] = strconv.Itoa(int(req.SinceTime))
 	}
 
-	response, err := s.makeRequest(uri, params)
+	response, err := s.makeRequest(ctx, uri, params)
 	if err != nil {
 		return nil, fmt.Errorf("error last tweets: %w", err)
 	}
-	if response.StatusCode != 200 {
-		return nil, fmt.Errorf("error last tweets, status non 200: %s", string(response.RawBody))
-	}
 	tweetRepliesResponse := TweetRepliesResponse{}
 	err = json.Unmarshal(response.RawBody, &tweetRepliesResponse)
 	return &tweetRepliesResponse, err
 }
-func (s *TwitterAPIService) GetTweetThreadContext(req TweetRepliesRequest) (*TweetRepliesResponse, error) {
+func (s *TwitterAPIService) GetTweetThreadContext(ctx context.Context, req TweetRepliesRequest) (*TweetRepliesResponse, error) {
 	uri := s.baseUrl + "/twitter/tweet/thread_context"
 
 	params := map[string]string{
@@ -164,18 +316,15 @@ func (s *TwitterAPIService) GetTweetThreadContext(req TweetRepliesRequest) (*Twe
 		params["sinceTime"] = strconv.Itoa(int(req.SinceTime))
 	}
 
-	response, err := s.makeRequest(uri, params)
+	response, err := s.makeRequest(ctx, uri, params)
 	if err != nil {
 		return nil, fmt.Errorf("error last tweets: %w", err)
 	}
-	if response.StatusCode != 200 {
-		return nil, fmt.Errorf("error last tweets, status non 200: %s", string(response.RawBody))
-	}
 	tweetRepliesResponse := TweetRepliesResponse{}
 	err = json.Unmarshal(response.RawBody, &tweetRepliesResponse)
 	return &tweetRepliesResponse, err
 }
-func (s *TwitterAPIService) GetUserFollowers(req UserFollowersRequest) (*UserFollowersResponse, error) {
+func (s *TwitterAPIService) GetUserFollowers(ctx context.Context, req UserFollowersRequest) (*UserFollowersResponse, error) {
 	uri := s.baseUrl + "/twitter/user/followers"
 
 	params := map[string]string{
@@ -184,19 +333,16 @@ func (s *TwitterAPIService) GetUserFollowers(req UserFollowersRequest) (*UserFol
 		"pageSize": strconv.Itoa(min(200, max(20, req.PageSize))),
 	}
 
-	response, err := s.makeRequest(uri, params)
+	response, err := s.makeRequest(ctx, uri, params)
 	if err != nil {
 		return nil, fmt.Errorf("error followers: %w", err)
 	}
-	if response.StatusCode != 200 {
-		return nil, fmt.Errorf("error followers, status not 200: %s", string(response.RawBody))
-	}
 	userFollowersResponse := UserFollowersResponse{}
 
 	err = json.Unmarshal(response.RawBody, &userFollowersResponse)
 	return &userFollowersResponse, err
 }
-func (s *TwitterAPIService) GetUserFollowings(req UserFollowingsRequest) (*UserFollowingsResponse, error) {
+func (s *TwitterAPIService) GetUserFollowings(ctx context.Context, req UserFollowingsRequest) (*UserFollowingsResponse, error) {
 	uri := s.baseUrl + "/twitter/user/followings"
 
 	params := map[string]string{
@@ -205,38 +351,32 @@ func (s *TwitterAPIService) GetUserFollowings(req UserFollowingsRequest) (*UserF
 		"pageSize": strconv.Itoa(min(200, max(20, req.PageSize))),
 	}
 
-	response, err := s.makeRequest(uri, params)
+	response, err := s.makeRequest(ctx, uri, params)
 	if err != nil {
 		return nil, fmt.Errorf("error followings: %w", err)
 	}
-	if response.StatusCode != 200 {
-		return nil, fmt.Errorf("error followings, status non 200: %s", string(response.RawBody))
-	}
 	userFollowingsResponse := UserFollowingsResponse{}
 	err = json.Unmarshal(response.RawBody, &userFollowingsResponse)
 	return &userFollowingsResponse, err
 }
 
-func (s *TwitterAPIService) GetTweetsByIds(tweetIds []string) (*TweetsByIdsResponse, error) {
+func (s *TwitterAPIService) GetTweetsByIds(ctx context.Context, tweetIds []string) (*TweetsByIdsResponse, error) {
 	uri := s.baseUrl + "/twitter/tweets"
 
 	params := map[string]string{
 		"tweet_ids": strings.Join(tweetIds, ","),
 	}
 
-	response, err := s.makeRequest(uri, params)
+	response, err := s.makeRequest(ctx, uri, params)
 	if err != nil {
 		return nil, fmt.Errorf("error tweets_by_ids: %w", err)
 	}
-	if response.StatusCode != 200 {
-		return nil, fmt.Errorf("error tweets_by_ids, status non 200: %s", string(response.RawBody))
-	}
 	tweetsByIdsResponse := TweetsByIdsResponse{}
 	err = json.Unmarshal(response.RawBody, &tweetsByIdsResponse)
 	return &tweetsByIdsResponse, err
 }
 
-func (s *TwitterAPIService) AdvancedSearch(request AdvancedSearchRequest) (*AdvancedSearchResponse, error) {
+func (s *TwitterAPIService) AdvancedSearch(ctx context.Context, request AdvancedSearchRequest) (*AdvancedSearchResponse, error) {
 	uri := s.baseUrl + "/twitter/tweet/advanced_search"
 
 	params := map[string]string{
@@ -251,42 +391,25 @@ func (s *TwitterAPIService) AdvancedSearch(request AdvancedSearchRequest) (*Adva
 		params["cursor"] = request.Cursor
 	}
 
-	response, err := s.makeRequest(uri, params)
+	response, err := s.makeRequest(ctx, uri, params)
 	if err != nil {
 		return nil, fmt.Errorf("error advanced_search: %w", err)
 	}
 
-	if response.StatusCode != 200 {
-		return nil, fmt.Errorf("error advanced_search, status non 200: %s", string(response.RawBody))
-	}
-
 	searchResponse := AdvancedSearchResponse{}
 	err = json.Unmarshal(response.RawBody, &searchResponse)
 	return &searchResponse, err
 }
 
-func (s *TwitterAPIService) PostTweet(request PostTweetRequest) (*PostTweetResponse, error) {
+func (s *TwitterAPIService) PostTweet(ctx context.Context, request PostTweetRequest) (*PostTweetResponse, error) {
 	uri := s.baseUrl + "/twitter/create_tweet"
 	requestBody, _ := json.Marshal(request)
-	payload := bytes.NewReader(requestBody)
-
-	req, _ := http.NewRequest("POST", uri, payload)
-	req.Header.Set("X-API-Key", s.apiKey)
-	req.Header.Set("Content-Type", "application/json")
 
-	res, err := s.httpClient.Do(req)
+	response, err := s.send(ctx, "POST", uri, "", requestBody)
 	if err != nil {
-		return nil, fmt.Errorf("error on post_tweet httpClient.Do: %s", err)
-	}
-	defer res.Body.Close()
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error on post_tweet io.ReadAll: %s", err)
-	}
-
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("error on post_tweet http status code is not 200: %d, body: %s", res.StatusCode, body)
+		return nil, fmt.Errorf("error on post_tweet: %w", err)
 	}
+	body := response.RawBody
 	fmt.Println(string(body))
 	postTweetResponse := PostTweetResponse{}
 	err = json.Unmarshal(body, &postTweetResponse)
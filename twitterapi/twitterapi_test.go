@@ -1,6 +1,7 @@
 package twitterapi
 
 import (
+	"context"
 	"fmt"
 	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/assert"
@@ -8,32 +9,19 @@ import (
 	"testing"
 )
 
-func TestTwitterAPIService_GetCommunityTweets(t *testing.T) {
-	err := godotenv.Load("../.env")
-	assert.NoError(t, err)
-	api := NewTwitterAPIService(os.Getenv(ENV_TWITTER_API_KEY), os.Getenv(ENV_TWITTER_API_BASE_URL), os.Getenv(ENV_PROXY_DSN))
-	communityTweetsResponse, err := api.GetCommunityTweets(CommunityTweetsRequest{CommunityID: os.Getenv(ENV_DEMO_COMMUNITY_ID), Cursor: ""})
-	assert.NoError(t, err)
-	fmt.Println(communityTweetsResponse.NextCursor, len(communityTweetsResponse.Tweets))
-	for i, tweet := range communityTweetsResponse.Tweets {
-		fmt.Println(i, tweet.Author.UserName, tweet.Text, tweet.Id, tweet.QuoteCount, "reply_count", tweet.ReplyCount, "|||", tweet.CreatedAt, err)
-	}
-}
-
-func TestTwitterAPIService_GetTweetReplies(t *testing.T) {
+// newLiveAPI calls the API configured in ../.env, the test is skipped without
+// a key.
+func newLiveAPI(t *testing.T) *TwitterAPIService {
 	godotenv.Load("../.env")
-	api := NewTwitterAPIService(os.Getenv(ENV_TWITTER_API_KEY), os.Getenv(ENV_TWITTER_API_BASE_URL), os.Getenv(ENV_PROXY_DSN))
-	tweetRepliesResponse, err := api.GetTweetReplies(TweetRepliesRequest{TweetID: os.Getenv(ENV_DEMO_TWEET_ID)})
-	fmt.Println(tweetRepliesResponse.HasNextPage, tweetRepliesResponse.NextCursor)
-	assert.NoError(t, err)
-	for i, tweet := range tweetRepliesResponse.Tweets {
-		fmt.Println(i, tweet.Author.Name, " || ", tweet.Author.UserName, " || ", tweet.Text, tweet.ReplyCount, "in reply to id||", tweet.InReplyToId, "date:", tweet.CreatedAt, err)
+	if os.Getenv(ENV_TWITTER_API_KEY) == "" {
+		t.Skipf("%s is not set", ENV_TWITTER_API_KEY)
 	}
+	return NewTwitterAPIService(os.Getenv(ENV_TWITTER_API_KEY), os.Getenv(ENV_TWITTER_API_BASE_URL), os.Getenv(ENV_PROXY_DSN))
 }
+
 func TestTwitterAPIService_GetTweetThreadContext(t *testing.T) {
-	godotenv.Load("../.env")
-	api := NewTwitterAPIService(os.Getenv(ENV_TWITTER_API_KEY), os.Getenv(ENV_TWITTER_API_BASE_URL), os.Getenv(ENV_PROXY_DSN))
-	tweetRepliesResponse, err := api.GetTweetThreadContext(TweetRepliesRequest{TweetID: os.Getenv(ENV_DEMO_TWEET_ID)})
+	api := newLiveAPI(t)
+	tweetRepliesResponse, err := api.GetTweetThreadContext(context.Background(), TweetRepliesRequest{TweetID: os.Getenv(ENV_DEMO_TWEET_ID)})
 	fmt.Println("next page", tweetRepliesResponse.HasNextPage, tweetRepliesResponse.NextCursor)
 	assert.NoError(t, err)
 	for i, tweet := range tweetRepliesResponse.Tweets {
@@ -41,18 +29,16 @@ func TestTwitterAPIService_GetTweetThreadContext(t *testing.T) {
 	}
 }
 func TestTwitterAPIService_GetTweetsByIds(t *testing.T) {
-	godotenv.Load("../.env")
-	api := NewTwitterAPIService(os.Getenv(ENV_TWITTER_API_KEY), os.Getenv(ENV_TWITTER_API_BASE_URL), os.Getenv(ENV_PROXY_DSN))
-	tweetRepliesResponse, err := api.GetTweetsByIds([]string{os.Getenv(ENV_DEMO_TWEET_ID)})
+	api := newLiveAPI(t)
+	tweetRepliesResponse, err := api.GetTweetsByIds(context.Background(), []string{os.Getenv(ENV_DEMO_TWEET_ID)})
 	assert.NoError(t, err)
 	for i, tweet := range tweetRepliesResponse.Tweets {
 		fmt.Println(i, tweet.Author.Name, " || ", tweet.Author.UserName, " || ", tweet.Text, tweet.ReplyCount, err)
 	}
 }
 func TestTwitterAPIService_GetUserLastTweets(t *testing.T) {
-	godotenv.Load()
-	api := NewTwitterAPIService(os.Getenv(ENV_TWITTER_API_KEY), os.Getenv(ENV_TWITTER_API_BASE_URL), os.Getenv(ENV_PROXY_DSN))
-	lastTweetsResponse, err := api.GetUserLastTweets(UserLastTweetsRequest{
+	api := newLiveAPI(t)
+	lastTweetsResponse, err := api.GetUserLastTweets(context.Background(), UserLastTweetsRequest{
 		UserId: os.Getenv(ENV_DEMO_USER_ID),
 	})
 	assert.NoError(t, err)
@@ -62,9 +48,8 @@ func TestTwitterAPIService_GetUserLastTweets(t *testing.T) {
 	}
 }
 func TestTwitterAPIService_GetUserFollowers(t *testing.T) {
-	godotenv.Load()
-	api := NewTwitterAPIService(os.Getenv(ENV_TWITTER_API_KEY), os.Getenv(ENV_TWITTER_API_BASE_URL), os.Getenv(ENV_PROXY_DSN))
-	followersResponse, err := api.GetUserFollowers(UserFollowersRequest{
+	api := newLiveAPI(t)
+	followersResponse, err := api.GetUserFollowers(context.Background(), UserFollowersRequest{
 		UserName: os.Getenv(ENV_DEMO_USER_NAME),
 		Cursor:   "",
 		PageSize: 100,
@@ -76,9 +61,8 @@ func TestTwitterAPIService_GetUserFollowers(t *testing.T) {
 	}
 }
 func TestTwitterAPIService_GetUserFollowings(t *testing.T) {
-	godotenv.Load()
-	api := NewTwitterAPIService(os.Getenv(ENV_TWITTER_API_KEY), os.Getenv(ENV_TWITTER_API_BASE_URL), os.Getenv(ENV_PROXY_DSN))
-	followings, err := api.GetUserFollowings(UserFollowingsRequest{
+	api := newLiveAPI(t)
+	followings, err := api.GetUserFollowings(context.Background(), UserFollowingsRequest{
 		UserName: os.Getenv(ENV_DEMO_USER_NAME),
 		Cursor:   "",
 		PageSize: 100,
@@ -90,10 +74,8 @@ func TestTwitterAPIService_GetUserFollowings(t *testing.T) {
 	}
 }
 func TestTwitterAPIService_AdvancedSearch(t *testing.T) {
-	godotenv.Load("../.env")
-
-	api := NewTwitterAPIService(os.Getenv(ENV_TWITTER_API_KEY), os.Getenv(ENV_TWITTER_API_BASE_URL), os.Getenv(ENV_PROXY_DSN))
-	advancedSearchResponse, err := api.AdvancedSearch(AdvancedSearchRequest{
+	api := newLiveAPI(t)
+	advancedSearchResponse, err := api.AdvancedSearch(context.Background(), AdvancedSearchRequest{
 		Query:     fmt.Sprintf("@GrutaPig"),
 		QueryType: LATEST,
 		Cursor:    "",
@@ -104,9 +86,8 @@ func TestTwitterAPIService_AdvancedSearch(t *testing.T) {
 	fmt.Println("tweets:", len(advancedSearchResponse.Tweets))
 }
 func TestTwitterAPIService_PostTweet(t *testing.T) {
-	godotenv.Load("../.env")
-	api := NewTwitterAPIService(os.Getenv(ENV_TWITTER_API_KEY), os.Getenv(ENV_TWITTER_API_BASE_URL), os.Getenv(ENV_PROXY_DSN))
-	postTweetResponse, err := api.PostTweet(PostTweetRequest{
+	api := newLiveAPI(t)
+	postTweetResponse, err := api.PostTweet(context.Background(), PostTweetRequest{
 		AuthSession: os.Getenv(ENV_TWITTER_AUTH),
 		TweetText: `hi all!
 `,
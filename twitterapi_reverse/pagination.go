@@ -0,0 +1,76 @@
+package twitterapi_reverse
+
+const (
+	CURSOR_TYPE_TOP    = "Top"
+	CURSOR_TYPE_BOTTOM = "Bottom"
+)
+
+// CommunityTweetsPage is one page of a community timeline. NextCursor continues
+// to older tweets, PrevCursor returns tweets posted after the page.
+type CommunityTweetsPage struct {
+	Tweets     []SimpleTweet
+	NextCursor string
+	PrevCursor string
+}
+
+func (p *CommunityTweetsPage) setCursor(cursorType, value string) {
+	switch cursorType {
+	case CURSOR_TYPE_BOTTOM:
+		p.NextCursor = value
+	case CURSOR_TYPE_TOP:
+		p.PrevCursor = value
+	}
+}
+
+// TweetRepliesPage is one page of a conversation, NextCursor is empty on the
+// last page.
+type TweetRepliesPage struct {
+	Tweets     []SimpleTweet
+	NextCursor string
+}
+
+// CommunityTweetsIterator walks a community timeline from the latest tweets to
+// the oldest, one page per Next call.
+type CommunityTweetsIterator struct {
+	service     *TwitterReverseService
+	communityID string
+	count       int
+	cursor      string
+	done        bool
+}
+
+func (s *TwitterReverseService) IterateCommunityTweets(communityID string, count int) *CommunityTweetsIterator {
+	return &CommunityTweetsIterator{
+		service:     s,
+		communityID: communityID,
+		count:       count,
+	}
+}
+
+// Next returns the next page of tweets. The timeline keeps returning a bottom
+// cursor after the last tweet, so an empty page or a repeated cursor ends the
+// iteration.
+func (it *CommunityTweetsIterator) Next() ([]SimpleTweet, error) {
+	if it.done {
+		return nil, nil
+	}
+	page, err := it.service.GetCommunityTweetsPage(it.communityID, it.count, it.cursor)
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Tweets) == 0 || page.NextCursor == "" || page.NextCursor == it.cursor {
+		it.done = true
+	}
+	it.cursor = page.NextCursor
+	return page.Tweets, nil
+}
+
+func (it *CommunityTweetsIterator) Done() bool {
+	return it.done
+}
+
+// Cursor returns the cursor of the next page, it can be passed to
+// GetCommunityTweetsPage to resume the iteration later.
+func (it *CommunityTweetsIterator) Cursor() string {
+	return it.cursor
+}
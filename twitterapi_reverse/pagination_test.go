@@ -0,0 +1,115 @@
+package twitterapi_reverse
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func timelineTweetEntry(id string) string {
+	return fmt.Sprintf(`{"entryId":"tweet-%[1]s","content":{"entryType":"TimelineTimelineItem","itemContent":{"tweet_results":{"result":{"rest_id":"%[1]s","legacy":{"full_text":"text %[1]s","reply_count":1,"user_id_str":"42","created_at":"Mon Jan 02 15:04:05 +0000 2006"}}}}}}`, id)
+}
+
+func timelineCursorEntry(cursorType, value string) string {
+	return fmt.Sprintf(`{"entryId":"cursor-%s","content":{"entryType":"TimelineTimelineCursor","value":"%s","cursorType":"%s"}}`, strings.ToLower(cursorType), value, cursorType)
+}
+
+func communityTimeline(entries ...string) string {
+	return `{"data":{"communityResults":{"result":{"ranked_community_timeline":{"timeline":{"instructions":[{"type":"TimelineAddEntries","entries":[` + strings.Join(entries, ",") + `]}]}}}}}}`
+}
+
+func TestTwitterReverseService_IterateCommunityTweets(t *testing.T) {
+	pages := map[string]string{
+		"": communityTimeline(
+			timelineTweetEntry("3"), timelineTweetEntry("2"),
+			timelineCursorEntry(CURSOR_TYPE_TOP, "top-1"), timelineCursorEntry(CURSOR_TYPE_BOTTOM, "bottom-1"),
+		),
+		"bottom-1": communityTimeline(timelineTweetEntry("1"), timelineCursorEntry(CURSOR_TYPE_BOTTOM, "bottom-2")),
+		"bottom-2": communityTimeline(timelineCursorEntry(CURSOR_TYPE_BOTTOM, "bottom-2")),
+	}
+	var cursors []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		variables := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(r.URL.Query().Get("variables")), &variables))
+		cursor, _ := variables["cursor"].(string)
+		cursors = append(cursors, cursor)
+		w.Write([]byte(pages[cursor]))
+	}))
+	defer server.Close()
+
+	service := NewTwitterReverseApi(nil, "", false)
+	service.baseURL = server.URL
+
+	page, err := service.GetCommunityTweetsPage("community", 20, "")
+	require.NoError(t, err)
+	assert.Equal(t, "bottom-1", page.NextCursor)
+	assert.Equal(t, "top-1", page.PrevCursor)
+	assert.Len(t, page.Tweets, 2)
+	assert.Equal(t, "42", page.Tweets[0].Author.ID)
+
+	it := service.IterateCommunityTweets("community", 20)
+	var tweetIDs []string
+	for !it.Done() {
+		tweets, err := it.Next()
+		require.NoError(t, err)
+		for _, tweet := range tweets {
+			tweetIDs = append(tweetIDs, tweet.TweetID)
+		}
+	}
+	assert.Equal(t, []string{"3", "2", "1"}, tweetIDs)
+	assert.Equal(t, []string{"", "", "bottom-1", "bottom-2"}, cursors)
+}
+
+func conversationTweet(id, inReplyToID string) string {
+	return fmt.Sprintf(`{"rest_id":"%[1]s","core":{"user_results":{"result":{"core":{"screen_name":"user%[1]s","name":"User %[1]s","created_at":"Mon Jan 02 15:04:05 +0000 2006"}}}},"legacy":{"id_str":"%[1]s","user_id_str":"u%[1]s","full_text":"text %[1]s","reply_count":0,"in_reply_to_status_id_str":"%[2]s","created_at":"Mon Jan 02 15:04:05 +0000 2006"}}`, id, inReplyToID)
+}
+
+func conversation(entries ...string) string {
+	return `{"data":{"threaded_conversation_with_injections_v2":{"instructions":[{"type":"TimelineAddEntries","entries":[` + strings.Join(entries, ",") + `]}]}}}`
+}
+
+func TestTwitterReverseService_GetTweetReplies(t *testing.T) {
+	pages := map[string]string{
+		"": conversation(
+			`{"entryId":"tweet-1","content":{"itemContent":{"tweet_results":{"result":`+conversationTweet("1", "")+`}}}}`,
+			`{"entryId":"conversationthread-2","content":{"items":[`+
+				`{"item":{"itemContent":{"tweet_results":{"result":`+conversationTweet("2", "1")+`}}}},`+
+				`{"item":{"itemContent":{"tweet_results":{"result":`+conversationTweet("3", "2")+`}}}}]}}`,
+			`{"entryId":"cursor-bottom-1","content":{"itemContent":{"itemType":"TimelineTimelineCursor","value":"replies-2","cursorType":"Bottom"}}}`,
+		),
+		"replies-2": conversation(
+			`{"entryId":"conversationthread-4","content":{"items":[{"item":{"itemContent":{"tweet_results":{"result":` + conversationTweet("4", "1") + `}}}}]}}`,
+		),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		variables := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(r.URL.Query().Get("variables")), &variables))
+		assert.Equal(t, "1", variables["focalTweetId"])
+		cursor, _ := variables["cursor"].(string)
+		w.Write([]byte(pages[cursor]))
+	}))
+	defer server.Close()
+
+	service := NewTwitterReverseApi(nil, "", false)
+	service.baseURL = server.URL
+
+	page, err := service.GetTweetReplies("1", "")
+	require.NoError(t, err)
+	assert.Equal(t, "replies-2", page.NextCursor)
+	require.Len(t, page.Tweets, 2)
+	assert.Equal(t, "1", page.Tweets[0].ReplyToID)
+	assert.Equal(t, "2", page.Tweets[1].ReplyToID)
+	assert.Equal(t, "user3", page.Tweets[1].Author.Username)
+
+	page, err = service.GetTweetReplies("1", page.NextCursor)
+	require.NoError(t, err)
+	assert.Empty(t, page.NextCursor)
+	require.Len(t, page.Tweets, 1)
+	assert.Equal(t, "4", page.Tweets[0].TweetID)
+}
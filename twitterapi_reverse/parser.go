@@ -13,6 +13,10 @@ type Tweet struct {
 	UserID            string    `json:"user_id"`
 	FullText          string    `json:"full_text"`
 	ReplyCount        int64     `json:"reply_count"`
+	LikeCount         int64     `json:"favorite_count"`
+	RetweetCount      int64     `json:"retweet_count"`
+	QuoteCount        int64     `json:"quote_count"`
+	ConversationID    string    `json:"conversation_id_str"`
 	CreatedAt         time.Time `json:"created_at"`
 	InReplyToStatusID string    `json:"in_reply_to_status_id_str"`
 	Author            Author    `json:"author"`
@@ -229,6 +233,23 @@ func ParseThreadedConversationTweets(data []byte) ([]Tweet, error) {
 	return tweets, nil
 }
 
+// ParseThreadedConversationCursor returns the bottom cursor of a TweetDetail
+// response, empty when the conversation has no more replies.
+func ParseThreadedConversationCursor(data []byte) string {
+	cursor := ""
+	jsonparser.ArrayEach(data, func(instruction []byte, dataType jsonparser.ValueType, offset int, err error) {
+		jsonparser.ArrayEach(instruction, func(entry []byte, dataType jsonparser.ValueType, offset int, err error) {
+			for _, contentPath := range [][]string{{"content", "itemContent"}, {"content"}} {
+				cursorType, _ := jsonparser.GetString(entry, append(contentPath, "cursorType")...)
+				if cursorType == CURSOR_TYPE_BOTTOM {
+					cursor, _ = jsonparser.GetString(entry, append(contentPath, "value")...)
+				}
+			}
+		}, "entries")
+	}, "data", "threaded_conversation_with_injections_v2", "instructions")
+	return cursor
+}
+
 func parseTweetData(tweetResultsData []byte, parseErrors *[]string) Tweet {
 	tweet := Tweet{}
 
@@ -271,6 +292,11 @@ func parseTweetData(tweetResultsData []byte, parseErrors *[]string) Tweet {
 		log.Println("in_reply_to_status_id_str parse info (normal for non-replies)", err)
 	}
 
+	tweet.LikeCount, _ = jsonparser.GetInt(tweetResultsData, "legacy", "favorite_count")
+	tweet.RetweetCount, _ = jsonparser.GetInt(tweetResultsData, "legacy", "retweet_count")
+	tweet.QuoteCount, _ = jsonparser.GetInt(tweetResultsData, "legacy", "quote_count")
+	tweet.ConversationID, _ = jsonparser.GetString(tweetResultsData, "legacy", "conversation_id_str")
+
 	createdAtPath := []string{"legacy", "created_at"}
 	if createdAtStr, err := jsonparser.GetString(tweetResultsData, createdAtPath...); err == nil {
 		if parsedTime, err := ParseTwitterTime(createdAtStr); err == nil {
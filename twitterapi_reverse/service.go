@@ -2,6 +2,7 @@ package twitterapi_reverse
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -13,6 +14,7 @@ import (
 
 type TwitterReverseService struct {
 	auth      *TwitterAuth
+	pool      *SessionPool
 	client    *http.Client
 	proxyURL  string
 	baseURL   string
@@ -48,10 +50,34 @@ func (s *TwitterReverseService) initHTTPClient() {
 	}
 }
 
+// SetTransport replaces the transport of the HTTP client, used to record and
+// replay the calls in tests.
+func (s *TwitterReverseService) SetTransport(transport http.RoundTripper) {
+	s.client.Transport = transport
+}
+
 func (s *TwitterReverseService) UpdateAuth(auth *TwitterAuth) {
 	s.auth = auth
 }
 
+// SetSessionPool makes the service take the session of every request from the
+// pool. The auth of the service is only used while the pool is empty.
+func (s *TwitterReverseService) SetSessionPool(pool *SessionPool) {
+	s.pool = pool
+}
+
+// StatusError is returned for a response other than 200 OK.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("HTTP error: %d %s - Response: %s", e.StatusCode, e.Status, e.Body)
+}
+
 func (s *TwitterReverseService) makeRequest(method, endpoint string, params map[string]interface{}) ([]byte, error) {
 	reqURL := s.baseURL + endpoint
 
@@ -71,6 +97,35 @@ func (s *TwitterReverseService) makeRequest(method, endpoint string, params map[
 		reqURL += "?" + values.Encode()
 	}
 
+	if s.pool == nil || s.pool.Len() == 0 {
+		return s.send(method, reqURL, s.auth)
+	}
+
+	// a throttled or logged out session is retried once with every other session
+	tried := map[string]bool{}
+	var lastErr error
+	for {
+		session, err := s.pool.Acquire(tried)
+		if err != nil {
+			if lastErr != nil {
+				return nil, fmt.Errorf("%w, last error: %v", err, lastErr)
+			}
+			return nil, err
+		}
+		tried[session.ID] = true
+
+		body, err := s.send(method, reqURL, session.Auth)
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && s.pool.Report(session.ID, statusErr.StatusCode, statusErr.Header, statusErr.Body) {
+			log.Printf("Reverse API session %s got HTTP %d, trying another session", session.Label, statusErr.StatusCode)
+			lastErr = err
+			continue
+		}
+		return body, err
+	}
+}
+
+func (s *TwitterReverseService) send(method, reqURL string, auth *TwitterAuth) ([]byte, error) {
 	req, err := http.NewRequest(method, reqURL, nil)
 	if err != nil {
 		return nil, err
@@ -86,15 +141,15 @@ func (s *TwitterReverseService) makeRequest(method, endpoint string, params map[
 	req.Header.Set("Sec-Fetch-Mode", "cors")
 	req.Header.Set("Sec-Fetch-Site", "same-origin")
 
-	if s.auth != nil {
-		if s.auth.Authorization != "" {
-			req.Header.Set("Authorization", s.auth.Authorization)
+	if auth != nil {
+		if auth.Authorization != "" {
+			req.Header.Set("Authorization", auth.Authorization)
 		}
-		if s.auth.XCSRFToken != "" {
-			req.Header.Set("x-csrf-token", s.auth.XCSRFToken)
+		if auth.XCSRFToken != "" {
+			req.Header.Set("x-csrf-token", auth.XCSRFToken)
 		}
-		if s.auth.Cookie != "" {
-			req.Header.Set("Cookie", s.auth.Cookie)
+		if auth.Cookie != "" {
+			req.Header.Set("Cookie", auth.Cookie)
 		}
 	}
 
@@ -118,24 +173,83 @@ func (s *TwitterReverseService) makeRequest(method, endpoint string, params map[
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP error: %d %s - Response: %s", resp.StatusCode, resp.Status, string(body))
+		return nil, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Header: resp.Header, Body: string(body)}
 	}
 
 	return body, nil
 }
 
 func (s *TwitterReverseService) GetTweetDetail(tweetID string) (*SimpleTweet, error) {
+	data, err := s.fetchTweetDetail(tweetID, "", "Relevance")
+	if err != nil {
+		return nil, err
+	}
+
+	tweets, err := ParseThreadedConversationTweets(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse tweet detail response: %v", err)
+	}
+
+	if len(tweets) == 0 {
+		return nil, fmt.Errorf("no tweets found in response")
+	}
+
+	for _, tweet := range tweets {
+		if tweet.ID == tweetID {
+			return convertTweetToSimple(tweet), nil
+		}
+	}
+
+	return convertTweetToSimple(tweets[0]), nil
+}
+
+// GetTweetReplies returns one page of the conversation below the tweet, newest
+// replies first. Replies to replies are included, ReplyToID links them to
+// their parent. An empty cursor starts from the first page.
+func (s *TwitterReverseService) GetTweetReplies(tweetID string, cursor string) (*TweetRepliesPage, error) {
+	data, err := s.fetchTweetDetail(tweetID, cursor, "Recency")
+	if err != nil {
+		return nil, err
+	}
+
+	tweets, err := ParseThreadedConversationTweets(data)
+	if err != nil {
+		if len(tweets) == 0 {
+			return nil, fmt.Errorf("failed to parse tweet replies response: %v", err)
+		}
+		log.Printf("Tweet %s replies parsed with errors: %v", tweetID, err)
+	}
+
+	// the focal tweet and the tweets it replies to come before the replies
+	for i, tweet := range tweets {
+		if tweet.ID == tweetID {
+			tweets = tweets[i+1:]
+			break
+		}
+	}
+
+	page := &TweetRepliesPage{NextCursor: ParseThreadedConversationCursor(data)}
+	for _, tweet := range tweets {
+		page.Tweets = append(page.Tweets, *convertTweetToSimple(tweet))
+	}
+	return page, nil
+}
+
+func (s *TwitterReverseService) fetchTweetDetail(tweetID string, cursor string, rankingMode string) ([]byte, error) {
 	variables := map[string]interface{}{
 		"focalTweetId":                           tweetID,
 		"referrer":                               "community",
 		"with_rux_injections":                    false,
-		"rankingMode":                            "Relevance",
+		"rankingMode":                            rankingMode,
 		"includePromotedContent":                 true,
 		"withCommunity":                          true,
 		"withQuickPromoteEligibilityTweetFields": true,
 		"withBirdwatchNotes":                     true,
 		"withVoice":                              true,
 	}
+	if cursor != "" {
+		variables["cursor"] = cursor
+	}
 
 	features := map[string]interface{}{
 		"rweb_video_screen_enabled":                                               false,
@@ -185,30 +299,20 @@ func (s *TwitterReverseService) GetTweetDetail(tweetID string) (*SimpleTweet, er
 		"fieldToggles": fieldToggles,
 	}
 
-	data, err := s.makeRequest("GET", "/i/api/graphql/-0WTL1e9Pij-JWAF5ztCCA/TweetDetail", params)
-	if err != nil {
-		return nil, err
-	}
+	return s.makeRequest("GET", "/i/api/graphql/-0WTL1e9Pij-JWAF5ztCCA/TweetDetail", params)
+}
 
-	tweets, err := ParseThreadedConversationTweets(data)
+func (s *TwitterReverseService) GetCommunityTweets(communityID string, count int) ([]SimpleTweet, error) {
+	page, err := s.GetCommunityTweetsPage(communityID, count, "")
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse tweet detail response: %v", err)
-	}
-
-	if len(tweets) == 0 {
-		return nil, fmt.Errorf("no tweets found in response")
-	}
-
-	for _, tweet := range tweets {
-		if tweet.ID == tweetID {
-			return convertTweetToSimple(tweet), nil
-		}
+		return nil, err
 	}
-
-	return convertTweetToSimple(tweets[0]), nil
+	return page.Tweets, nil
 }
 
-func (s *TwitterReverseService) GetCommunityTweets(communityID string, count int) ([]SimpleTweet, error) {
+// GetCommunityTweetsPage returns one page of the community timeline, an empty
+// cursor starts from the latest tweets.
+func (s *TwitterReverseService) GetCommunityTweetsPage(communityID string, count int, cursor string) (*CommunityTweetsPage, error) {
 	variables := map[string]interface{}{
 		"communityId":     communityID,
 		"count":           count,
@@ -216,6 +320,9 @@ func (s *TwitterReverseService) GetCommunityTweets(communityID string, count int
 		"rankingMode":     "Recency",
 		"withCommunity":   true,
 	}
+	if cursor != "" {
+		variables["cursor"] = cursor
+	}
 
 	features := map[string]interface{}{
 		"rweb_video_screen_enabled":                                               false,
@@ -263,42 +370,62 @@ func (s *TwitterReverseService) GetCommunityTweets(communityID string, count int
 		return nil, err
 	}
 
-	communityTweetsResponse := CommunityTweetsResponse{}
-	err = json.Unmarshal(data, &communityTweetsResponse)
+	page, err := parseCommunityTweetsPage(data)
 	if err != nil {
 		return nil, fmt.Errorf("json unmarshal community tweets error. community: %s, err: %s", communityID, err)
 	}
-	var simpleTweets []SimpleTweet
+	return page, nil
+}
+
+func parseCommunityTweetsPage(data []byte) (*CommunityTweetsPage, error) {
+	communityTweetsResponse := CommunityTweetsResponse{}
+	err := json.Unmarshal(data, &communityTweetsResponse)
+	if err != nil {
+		return nil, err
+	}
+	page := &CommunityTweetsPage{}
 	for _, instruction := range communityTweetsResponse.Data.CommunityResults.Result.RankedCommunityTimeline.Timeline.Instructions {
 		if instruction.Entry.EntryId != "" {
 			tweet := instruction.Entry
+			page.setCursor(tweet.Content.CursorType, tweet.Content.Value)
 			date, err := ParseTwitterTime(tweet.Content.ItemContent.TweetResults.Result.Legacy.CreatedAt)
 			if err != nil {
 				date = time.Time{}
 			}
 			simpleTweet := SimpleTweet{
-				TweetID:      tweet.Content.ItemContent.TweetResults.Result.RestId,
-				Text:         tweet.Content.ItemContent.TweetResults.Result.Legacy.FullText,
-				CreatedAt:    date,
-				RepliesCount: tweet.Content.ItemContent.TweetResults.Result.Legacy.ReplyCount,
+				TweetID:        tweet.Content.ItemContent.TweetResults.Result.RestId,
+				Text:           tweet.Content.ItemContent.TweetResults.Result.Legacy.FullText,
+				CreatedAt:      date,
+				RepliesCount:   tweet.Content.ItemContent.TweetResults.Result.Legacy.ReplyCount,
+				LikeCount:      tweet.Content.ItemContent.TweetResults.Result.Legacy.FavoriteCount,
+				RetweetCount:   tweet.Content.ItemContent.TweetResults.Result.Legacy.RetweetCount,
+				QuoteCount:     tweet.Content.ItemContent.TweetResults.Result.Legacy.QuoteCount,
+				ConversationID: tweet.Content.ItemContent.TweetResults.Result.Legacy.ConversationIdStr,
 				Author: SimpleUser{
 					ID:       tweet.Content.ItemContent.TweetResults.Result.Legacy.UserIdStr,
 					Username: tweet.Content.ItemContent.TweetResults.Result.Core.UserResults.Result.Core.ScreenName,
 					Name:     tweet.Content.ItemContent.TweetResults.Result.Core.UserResults.Result.Core.Name,
 				},
 			}
-			simpleTweets = append(simpleTweets, simpleTweet)
+			if simpleTweet.TweetID != "" {
+				page.Tweets = append(page.Tweets, simpleTweet)
+			}
 		}
 		for _, tweet := range instruction.Entries {
+			page.setCursor(tweet.Content.CursorType, tweet.Content.Value)
 			date, err := ParseTwitterTime(tweet.Content.ItemContent.TweetResults.Result.Legacy.CreatedAt)
 			if err != nil {
 				date = time.Time{}
 			}
 			simpleTweet := SimpleTweet{
-				TweetID:      tweet.Content.ItemContent.TweetResults.Result.RestId,
-				Text:         tweet.Content.ItemContent.TweetResults.Result.Legacy.FullText,
-				CreatedAt:    date,
-				RepliesCount: tweet.Content.ItemContent.TweetResults.Result.Legacy.ReplyCount,
+				TweetID:        tweet.Content.ItemContent.TweetResults.Result.RestId,
+				Text:           tweet.Content.ItemContent.TweetResults.Result.Legacy.FullText,
+				CreatedAt:      date,
+				RepliesCount:   tweet.Content.ItemContent.TweetResults.Result.Legacy.ReplyCount,
+				LikeCount:      tweet.Content.ItemContent.TweetResults.Result.Legacy.FavoriteCount,
+				RetweetCount:   tweet.Content.ItemContent.TweetResults.Result.Legacy.RetweetCount,
+				QuoteCount:     tweet.Content.ItemContent.TweetResults.Result.Legacy.QuoteCount,
+				ConversationID: tweet.Content.ItemContent.TweetResults.Result.Legacy.ConversationIdStr,
 				Author: SimpleUser{
 					ID:       tweet.Content.ItemContent.TweetResults.Result.Legacy.UserIdStr,
 					Username: tweet.Content.ItemContent.TweetResults.Result.Core.UserResults.Result.Core.ScreenName,
@@ -306,21 +433,25 @@ func (s *TwitterReverseService) GetCommunityTweets(communityID string, count int
 				},
 			}
 			if simpleTweet.TweetID != "" {
-				simpleTweets = append(simpleTweets, simpleTweet)
+				page.Tweets = append(page.Tweets, simpleTweet)
 			}
 		}
 	}
 
-	return simpleTweets, nil
+	return page, nil
 }
 
 func convertTweetToSimple(tweet Tweet) *SimpleTweet {
 	return &SimpleTweet{
-		TweetID:      tweet.ID,
-		Text:         tweet.FullText,
-		CreatedAt:    tweet.CreatedAt,
-		ReplyToID:    tweet.InReplyToStatusID,
-		RepliesCount: int(tweet.ReplyCount),
+		TweetID:        tweet.ID,
+		Text:           tweet.FullText,
+		CreatedAt:      tweet.CreatedAt,
+		ReplyToID:      tweet.InReplyToStatusID,
+		RepliesCount:   int(tweet.ReplyCount),
+		LikeCount:      int(tweet.LikeCount),
+		RetweetCount:   int(tweet.RetweetCount),
+		QuoteCount:     int(tweet.QuoteCount),
+		ConversationID: tweet.ConversationID,
 		Author: SimpleUser{
 			ID:       tweet.Author.ID,
 			Username: tweet.Author.ScreenName,
@@ -333,7 +464,7 @@ func (s *TwitterReverseService) GetNotifications() (*NotificationsResponse, erro
 	ver := strconv.Itoa(int(time.Now().Unix()))
 	body, err := s.makeRequest(http.MethodGet, "/i/api/graphql/Wa5HH91bSTqp3ZvBfTEtzQ/NotificationsTimeline?variables=%7B%22timeline_type%22%3A%22All%22%2C%22count%22%3A45%7D&features=%7B%22rweb_video_screen_enabled%22%3Afalse%2C%22payments_enabled%22%3Afalse%2C%22profile_label_improvements_pcf_label_in_post_enabled%22%3Atrue%2C%22rweb_tipjar_consumption_enabled%22%3Atrue%2C%22verified_phone_label_enabled%22%3Afalse%2C%22creator_subscriptions_tweet_preview_api_enabled%22%3Atrue%2C%22responsive_web_graphql_timeline_navigation_enabled%22%3Atrue%2C%22responsive_web_graphql_skip_user_profile_image_extensions_enabled%22%3Afalse%2C%22premium_content_api_read_enabled%22%3Afalse%2C%22communities_web_enable_tweet_community_results_fetch%22%3Atrue%2C%22c9s_tweet_anatomy_moderator_badge_enabled%22%3Atrue%2C%22responsive_web_grok_analyze_button_fetch_trends_enabled%22%3Afalse%2C%22responsive_web_grok_analyze_post_followups_enabled%22%3Atrue%2C%22responsive_web_jetfuel_frame%22%3Atrue%2C%22responsive_web_grok_share_attachment_enabled%22%3Atrue%2C%22articles_preview_enabled%22%3Atrue%2C%22responsive_web_edit_tweet_api_enabled%22%3Atrue%2C%22graphql_is_translatable_rweb_tweet_is_translatable_enabled%22%3Atrue%2C%22view_counts_everywhere_api_enabled%22%3Atrue%2C%22longform_notetweets_consumption_enabled%22%3Atrue%2C%22responsive_web_twitter_article_tweet_consumption_enabled%22%3Atrue%2C%22tweet_awards_web_tipping_enabled%22%3Afalse%2C%22responsive_web_grok_show_grok_translated_post%22%3Afalse%2C%22responsive_web_grok_analysis_button_from_backend%22%3Atrue%2C%22creator_subscriptions_quote_tweet_preview_enabled%22%3Afalse%2C%22freedom_of_speech_not_reach_fetch_enabled%22%3Atrue%2C%22standardized_nudges_misinfo%22%3Atrue%2C%22tweet_with_visibility_results_prefer_gql_limited_actions_policy_enabled%22%3Atrue%2C%22longform_notetweets_rich_text_read_enabled%22%3Atrue%2C%22longform_notetweets_inline_media_enabled%22%3Atrue%2C%22responsive_web_grok_image_annotation_enabled%22%3Atrue%2C%22responsive_web_grok_community_note_auto_translation_is_enabled%22%3Afalse%2C%22responsive_web_enhance_cards_enabled%22%3Afalse%7D&ver="+ver, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error on make request GetNotifications: %s", err)
+		return nil, fmt.Errorf("error on make request GetNotifications: %w", err)
 	}
 	notificationsResponse := &NotificationsResponse{}
 	err = json.Unmarshal(body, notificationsResponse)
@@ -346,7 +477,7 @@ func (s *TwitterReverseService) GetNotificationsSimple() ([]SimpleTweet, error)
 	tweets := []SimpleTweet{}
 	notificationsResponse, err := s.GetNotifications()
 	if err != nil {
-		return nil, fmt.Errorf("GetNotificationsSimple error: %s", err)
+		return nil, fmt.Errorf("GetNotificationsSimple error: %w", err)
 	}
 	for _, instruction := range notificationsResponse.Data.ViewerV2.UserResults.Result.NotificationTimeline.Timeline.Instructions {
 		for _, entry := range instruction.Entries {
@@ -366,6 +497,10 @@ func (s *TwitterReverseService) GetNotificationsSimple() ([]SimpleTweet, error)
 					ReplyToID:       replyToStatusIdStr,
 					ReplyToUsername: entry.Content.ItemContent.TweetResults.Result.Legacy.InReplyToScreenName,
 					RepliesCount:    entry.Content.ItemContent.TweetResults.Result.Legacy.ReplyCount,
+					LikeCount:       entry.Content.ItemContent.TweetResults.Result.Legacy.FavoriteCount,
+					RetweetCount:    entry.Content.ItemContent.TweetResults.Result.Legacy.RetweetCount,
+					QuoteCount:      entry.Content.ItemContent.TweetResults.Result.Legacy.QuoteCount,
+					ConversationID:  entry.Content.ItemContent.TweetResults.Result.Legacy.ConversationIdStr,
 					Author: SimpleUser{
 						ID:       entry.Content.ItemContent.TweetResults.Result.Legacy.UserIdStr,
 						Username: entry.Content.ItemContent.TweetResults.Result.Core.UserResults.Result.Core.ScreenName,
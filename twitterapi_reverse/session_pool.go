@@ -0,0 +1,214 @@
+package twitterapi_reverse
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	SESSION_STATUS_ACTIVE   = "active"
+	SESSION_STATUS_COOLING  = "cooling"
+	SESSION_STATUS_DEAD     = "dead"
+	SESSION_STATUS_DISABLED = "disabled"
+)
+
+// SESSION_DEFAULT_COOLDOWN is used for a 429 without a x-rate-limit-reset header.
+const SESSION_DEFAULT_COOLDOWN = 15 * time.Minute
+
+var ErrNoSession = errors.New("no reverse API session available")
+
+// Session is one logged in x.com account of the pool.
+type Session struct {
+	ID            string
+	Label         string
+	Auth          *TwitterAuth
+	Status        string
+	CoolingUntil  time.Time
+	LastUsedAt    time.Time
+	LastThrottled time.Time
+	Requests      int
+	LastError     string
+}
+
+// SessionPool hands out the sessions of several accounts. The session throttled
+// least recently is used first, sessions never throttled take turns. A 429
+// cools the session down until the rate limit resets, a 401 or 403 marks it
+// dead until it is replaced.
+type SessionPool struct {
+	mu       sync.Mutex
+	sessions []*Session
+	now      func() time.Time
+	onChange func(Session)
+}
+
+func NewSessionPool() *SessionPool {
+	return &SessionPool{now: time.Now}
+}
+
+// OnChange sets the function called with a copy of a session after its status
+// changed, used to persist the pool.
+func (p *SessionPool) OnChange(onChange func(Session)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onChange = onChange
+}
+
+// Put adds the session or replaces the session with the same ID.
+func (p *SessionPool) Put(session Session) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if session.Status == "" {
+		session.Status = SESSION_STATUS_ACTIVE
+	}
+	for i, existing := range p.sessions {
+		if existing.ID == session.ID {
+			p.sessions[i] = &session
+			return
+		}
+	}
+	p.sessions = append(p.sessions, &session)
+}
+
+func (p *SessionPool) Remove(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, session := range p.sessions {
+		if session.ID == id {
+			p.sessions = append(p.sessions[:i], p.sessions[i+1:]...)
+			return
+		}
+	}
+}
+
+// SetStatus changes the status of a session from outside the pool, e.g. to
+// disable or re-enable it.
+func (p *SessionPool) SetStatus(id string, status string) bool {
+	p.mu.Lock()
+	session := p.find(id)
+	if session == nil {
+		p.mu.Unlock()
+		return false
+	}
+	session.Status = status
+	session.CoolingUntil = time.Time{}
+	if status == SESSION_STATUS_ACTIVE {
+		session.LastError = ""
+	}
+	p.changed(session)
+	return true
+}
+
+func (p *SessionPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.sessions)
+}
+
+// Sessions returns copies of the sessions of the pool.
+func (p *SessionPool) Sessions() []Session {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sessions := make([]Session, 0, len(p.sessions))
+	for _, session := range p.sessions {
+		sessions = append(sessions, *session)
+	}
+	return sessions
+}
+
+// Acquire returns the session to use for the next request, the sessions in
+// skip were already tried for it.
+func (p *SessionPool) Acquire(skip map[string]bool) (*Session, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+
+	var best *Session
+	for _, session := range p.sessions {
+		if skip[session.ID] {
+			continue
+		}
+		if session.Status == SESSION_STATUS_COOLING && !now.Before(session.CoolingUntil) {
+			session.Status = SESSION_STATUS_ACTIVE
+			session.CoolingUntil = time.Time{}
+		}
+		if session.Status != SESSION_STATUS_ACTIVE {
+			continue
+		}
+		if best == nil || session.LastThrottled.Before(best.LastThrottled) ||
+			(session.LastThrottled.Equal(best.LastThrottled) && session.LastUsedAt.Before(best.LastUsedAt)) {
+			best = session
+		}
+	}
+	if best == nil {
+		return nil, ErrNoSession
+	}
+	best.LastUsedAt = now
+	best.Requests++
+	copied := *best
+	return &copied, nil
+}
+
+// Report updates the session with the response of its request. It returns
+// true when the request should be retried with another session.
+func (p *SessionPool) Report(id string, statusCode int, header http.Header, body string) bool {
+	if statusCode != http.StatusTooManyRequests && statusCode != http.StatusUnauthorized && statusCode != http.StatusForbidden {
+		return false
+	}
+
+	p.mu.Lock()
+	session := p.find(id)
+	if session == nil {
+		p.mu.Unlock()
+		return true
+	}
+	now := p.now()
+	session.LastError = strconv.Itoa(statusCode) + " " + truncate(body, 200)
+	if statusCode == http.StatusTooManyRequests {
+		session.Status = SESSION_STATUS_COOLING
+		session.CoolingUntil = rateLimitReset(header, now)
+		session.LastThrottled = now
+	} else {
+		session.Status = SESSION_STATUS_DEAD
+	}
+	p.changed(session)
+	return true
+}
+
+// changed calls onChange outside of the lock, p.mu must be held and is
+// released.
+func (p *SessionPool) changed(session *Session) {
+	copied := *session
+	onChange := p.onChange
+	p.mu.Unlock()
+	if onChange != nil {
+		onChange(copied)
+	}
+}
+
+func (p *SessionPool) find(id string) *Session {
+	for _, session := range p.sessions {
+		if session.ID == id {
+			return session
+		}
+	}
+	return nil
+}
+
+func rateLimitReset(header http.Header, now time.Time) time.Time {
+	if reset, err := strconv.ParseInt(header.Get("x-rate-limit-reset"), 10, 64); err == nil {
+		if resetAt := time.Unix(reset, 0); resetAt.After(now) {
+			return resetAt
+		}
+	}
+	return now.Add(SESSION_DEFAULT_COOLDOWN)
+}
+
+func truncate(text string, length int) string {
+	if len(text) <= length {
+		return text
+	}
+	return text[:length] + "..."
+}
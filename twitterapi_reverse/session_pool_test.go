@@ -0,0 +1,90 @@
+package twitterapi_reverse
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTwitterReverseService_SessionPoolRotation(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	reset := now.Add(5 * time.Minute)
+	statuses := map[string]int{"cookie_a": http.StatusTooManyRequests, "cookie_b": http.StatusOK}
+	var used []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie := r.Header.Get("Cookie")
+		used = append(used, cookie)
+		if statuses[cookie] == http.StatusTooManyRequests {
+			w.Header().Set("x-rate-limit-reset", strconv.FormatInt(reset.Unix(), 10))
+		}
+		w.WriteHeader(statuses[cookie])
+		w.Write([]byte(communityTimeline(timelineTweetEntry("1"))))
+	}))
+	defer server.Close()
+
+	pool := NewSessionPool()
+	pool.now = func() time.Time { return now }
+	var changes []Session
+	pool.OnChange(func(session Session) { changes = append(changes, session) })
+	pool.Put(Session{ID: "a", Label: "account a", Auth: &TwitterAuth{Cookie: "cookie_a"}})
+	pool.Put(Session{ID: "b", Label: "account b", Auth: &TwitterAuth{Cookie: "cookie_b"}})
+
+	service := NewTwitterReverseApi(nil, "", false)
+	service.baseURL = server.URL
+	service.SetSessionPool(pool)
+
+	page, err := service.GetCommunityTweetsPage("community", 20, "")
+	require.NoError(t, err)
+	assert.Len(t, page.Tweets, 1)
+	assert.Equal(t, []string{"cookie_a", "cookie_b"}, used, "a throttled session is retried with the next one")
+	require.Len(t, changes, 1)
+	assert.Equal(t, SESSION_STATUS_COOLING, changes[0].Status)
+	assert.Equal(t, reset, changes[0].CoolingUntil)
+
+	used = nil
+	_, err = service.GetCommunityTweetsPage("community", 20, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cookie_b"}, used, "a cooling session is skipped")
+
+	statuses["cookie_b"] = http.StatusUnauthorized
+	used = nil
+	_, err = service.GetCommunityTweetsPage("community", 20, "")
+	assert.ErrorIs(t, err, ErrNoSession)
+	assert.Equal(t, []string{"cookie_b"}, used)
+	assert.Equal(t, SESSION_STATUS_DEAD, changes[1].Status)
+
+	now = reset
+	statuses["cookie_a"] = http.StatusOK
+	used = nil
+	_, err = service.GetCommunityTweetsPage("community", 20, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cookie_a"}, used, "the session is used again after the rate limit reset")
+}
+
+func TestSessionPool_PrefersLeastRecentlyThrottled(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	pool := NewSessionPool()
+	pool.now = func() time.Time { return now }
+	pool.Put(Session{ID: "a", LastThrottled: now.Add(-time.Hour)})
+	pool.Put(Session{ID: "b", LastThrottled: now.Add(-2 * time.Hour)})
+	pool.Put(Session{ID: "c", LastThrottled: now.Add(-2 * time.Hour)})
+
+	var order []string
+	for i := 0; i < 4; i++ {
+		now = now.Add(time.Second)
+		session, err := pool.Acquire(nil)
+		require.NoError(t, err)
+		order = append(order, session.ID)
+	}
+	assert.Equal(t, []string{"b", "c", "b", "c"}, order)
+
+	assert.True(t, pool.SetStatus("b", SESSION_STATUS_DISABLED))
+	session, err := pool.Acquire(map[string]bool{"c": true})
+	require.NoError(t, err)
+	assert.Equal(t, "a", session.ID)
+}
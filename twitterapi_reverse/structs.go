@@ -8,6 +8,10 @@ type SimpleTweet struct {
 	CreatedAt       time.Time  `json:"created_at"`
 	ReplyToID       string     `json:"reply_to_id"`
 	RepliesCount    int        `json:"replies_count"`
+	LikeCount       int        `json:"like_count"`
+	RetweetCount    int        `json:"retweet_count"`
+	QuoteCount      int        `json:"quote_count"`
+	ConversationID  string     `json:"conversation_id"`
 	Author          SimpleUser `json:"author"`
 	ReplyToUsername string
 }
@@ -722,6 +726,8 @@ type CommunityTweetsResponse struct {
 										Component string `json:"component"`
 										Element   string `json:"element"`
 									} `json:"clientEventInfo"`
+									Value      string `json:"value,omitempty"`
+									CursorType string `json:"cursorType,omitempty"`
 								} `json:"content"`
 							} `json:"entry,omitempty"`
 							Entries []struct {
@@ -0,0 +1,178 @@
+package twittersource
+
+import (
+	"context"
+	"github.com/grutapig/hackaton/twitterapi"
+)
+
+const SOURCE_TWITTERAPI = "twitterapi"
+
+// APISource reads from twitterapi.io, it serves every call.
+type APISource struct {
+	api *twitterapi.TwitterAPIService
+}
+
+func NewAPISource(api *twitterapi.TwitterAPIService) *APISource {
+	return &APISource{api: api}
+}
+
+func (s *APISource) Name() string {
+	return SOURCE_TWITTERAPI
+}
+
+func (s *APISource) CommunityTweets(ctx context.Context, communityID string, cursor string) (*Page[Tweet], error) {
+	response, err := s.api.GetCommunityTweets(ctx, twitterapi.CommunityTweetsRequest{
+		CommunityID: communityID,
+		Cursor:      cursor,
+	})
+	if err != nil {
+		return nil, err
+	}
+	page := &Page[Tweet]{Items: fromAPITweets(response.Tweets)}
+	if len(response.Tweets) > 0 {
+		page.NextCursor = response.NextCursor
+	}
+	return page, nil
+}
+
+func (s *APISource) TweetReplies(ctx context.Context, tweetID string, cursor string) (*Page[Tweet], error) {
+	response, err := s.api.GetTweetReplies(ctx, twitterapi.TweetRepliesRequest{
+		TweetID: tweetID,
+		Cursor:  cursor,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return apiPage(response.Tweets, response.HasNextPage, response.NextCursor), nil
+}
+
+func (s *APISource) TweetsByIDs(ctx context.Context, tweetIDs []string) ([]Tweet, error) {
+	response, err := s.api.GetTweetsByIds(ctx, tweetIDs)
+	if err != nil {
+		return nil, err
+	}
+	return fromAPITweets(response.Tweets), nil
+}
+
+func (s *APISource) UserTimeline(ctx context.Context, username string, cursor string) (*Page[Tweet], error) {
+	response, err := s.api.GetUserLastTweets(ctx, twitterapi.UserLastTweetsRequest{
+		UserName:       username,
+		Cursor:         cursor,
+		IncludeReplies: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return apiPage(response.Data.Tweets, response.HasNextPage, response.NextCursor), nil
+}
+
+func (s *APISource) Search(ctx context.Context, query string, cursor string) (*Page[Tweet], error) {
+	response, err := s.api.AdvancedSearch(ctx, twitterapi.AdvancedSearchRequest{
+		Query:     query,
+		QueryType: twitterapi.LATEST,
+		Cursor:    cursor,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return apiPage(response.Tweets, response.HasNextPage, response.NextCursor), nil
+}
+
+func (s *APISource) Followers(ctx context.Context, username string, cursor string) (*Page[User], error) {
+	response, err := s.api.GetUserFollowers(ctx, twitterapi.UserFollowersRequest{
+		UserName: username,
+		Cursor:   cursor,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return apiUsersPage(response.Followers, response.HasNextPage, response.NextCursor), nil
+}
+
+func (s *APISource) Followings(ctx context.Context, username string, cursor string) (*Page[User], error) {
+	response, err := s.api.GetUserFollowings(ctx, twitterapi.UserFollowingsRequest{
+		UserName: username,
+		Cursor:   cursor,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return apiUsersPage(response.Followings, response.HasNextPage, response.NextCursor), nil
+}
+
+// Mentions searches the latest tweets containing the handle.
+func (s *APISource) Mentions(ctx context.Context, handle string) ([]Tweet, error) {
+	page, err := s.Search(ctx, handle, "")
+	if err != nil {
+		return nil, err
+	}
+	return page.Items, nil
+}
+
+func apiPage(tweets []twitterapi.Tweet, hasNextPage bool, nextCursor string) *Page[Tweet] {
+	page := &Page[Tweet]{Items: fromAPITweets(tweets)}
+	if hasNextPage {
+		page.NextCursor = nextCursor
+	}
+	return page
+}
+
+func apiUsersPage(users []twitterapi.User, hasNextPage bool, nextCursor string) *Page[User] {
+	page := &Page[User]{}
+	for _, user := range users {
+		username := user.UserName
+		if username == "" {
+			username = user.ScreenName
+		}
+		page.Items = append(page.Items, User{
+			ID:             user.Id,
+			Username:       username,
+			Name:           user.Name,
+			Description:    user.Description,
+			FollowersCount: user.FollowersCount,
+			FollowingCount: user.FollowingCount,
+			CreatedAt:      ParseCreatedAt(user.CreatedAt),
+		})
+	}
+	if hasNextPage {
+		page.NextCursor = nextCursor
+	}
+	return page
+}
+
+func fromAPITweets(tweets []twitterapi.Tweet) []Tweet {
+	converted := make([]Tweet, 0, len(tweets))
+	for _, tweet := range tweets {
+		converted = append(converted, FromAPITweet(tweet))
+	}
+	return converted
+}
+
+func FromAPITweet(tweet twitterapi.Tweet) Tweet {
+	createdAt := tweet.CreatedAtParsed
+	if createdAt.IsZero() {
+		createdAt = ParseCreatedAt(tweet.CreatedAt)
+	}
+	return Tweet{
+		ID:                tweet.Id,
+		Text:              tweet.Text,
+		CreatedAt:         createdAt,
+		InReplyToID:       tweet.InReplyToId,
+		InReplyToUsername: tweet.InReplyToUsername,
+		ConversationID:    tweet.ConversationId,
+		ReplyCount:        tweet.ReplyCount,
+		LikeCount:         tweet.LikeCount,
+		RetweetCount:      tweet.RetweetCount,
+		QuoteCount:        tweet.QuoteCount,
+		ViewCount:         tweet.ViewCount,
+		Author: User{
+			ID:             tweet.Author.Id,
+			Username:       tweet.Author.UserName,
+			Name:           tweet.Author.Name,
+			Description:    tweet.Author.Description,
+			FollowersCount: tweet.Author.Followers,
+			FollowingCount: tweet.Author.Following,
+			CreatedAt:      ParseCreatedAt(tweet.Author.CreatedAt),
+		},
+	}
+}
@@ -0,0 +1,251 @@
+package twittersource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grutapig/hackaton/twitterapi"
+	"github.com/grutapig/hackaton/twitterapi_reverse"
+)
+
+const (
+	BREAKER_FAILURES = 3
+	BREAKER_COOLDOWN = time.Minute
+)
+
+// Composite tries its sources in order and returns the first answer. A source
+// returning ErrNotSupported is skipped, a source failing BREAKER_FAILURES times
+// in a row is skipped for BREAKER_COOLDOWN, then it gets one trial call. Only
+// transport errors, 5xx and 429 responses are failures, other client errors
+// are about the request and are returned as they are.
+//
+// Page cursors are prefixed with the name of the source that returned them, the
+// next page is always read from the same source.
+type Composite struct {
+	sources  []Source
+	failures int
+	cooldown time.Duration
+	now      func() time.Time
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+type breaker struct {
+	failures  int
+	openUntil time.Time
+	trial     bool
+}
+
+func NewComposite(sources ...Source) *Composite {
+	return &Composite{
+		sources:  sources,
+		failures: BREAKER_FAILURES,
+		cooldown: BREAKER_COOLDOWN,
+		now:      time.Now,
+		breakers: make(map[string]*breaker),
+	}
+}
+
+func (c *Composite) Name() string {
+	names := make([]string, 0, len(c.sources))
+	for _, source := range c.sources {
+		names = append(names, source.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func (c *Composite) CommunityTweets(ctx context.Context, communityID string, cursor string) (*Page[Tweet], error) {
+	return callPage(ctx, c, "CommunityTweets", cursor, func(source Source, cursor string) (*Page[Tweet], error) {
+		return source.CommunityTweets(ctx, communityID, cursor)
+	})
+}
+
+func (c *Composite) TweetReplies(ctx context.Context, tweetID string, cursor string) (*Page[Tweet], error) {
+	return callPage(ctx, c, "TweetReplies", cursor, func(source Source, cursor string) (*Page[Tweet], error) {
+		return source.TweetReplies(ctx, tweetID, cursor)
+	})
+}
+
+func (c *Composite) TweetsByIDs(ctx context.Context, tweetIDs []string) ([]Tweet, error) {
+	return call(ctx, c, "TweetsByIDs", "", func(source Source) ([]Tweet, error) {
+		return source.TweetsByIDs(ctx, tweetIDs)
+	})
+}
+
+func (c *Composite) UserTimeline(ctx context.Context, username string, cursor string) (*Page[Tweet], error) {
+	return callPage(ctx, c, "UserTimeline", cursor, func(source Source, cursor string) (*Page[Tweet], error) {
+		return source.UserTimeline(ctx, username, cursor)
+	})
+}
+
+func (c *Composite) Search(ctx context.Context, query string, cursor string) (*Page[Tweet], error) {
+	return callPage(ctx, c, "Search", cursor, func(source Source, cursor string) (*Page[Tweet], error) {
+		return source.Search(ctx, query, cursor)
+	})
+}
+
+func (c *Composite) Followers(ctx context.Context, username string, cursor string) (*Page[User], error) {
+	return callPage(ctx, c, "Followers", cursor, func(source Source, cursor string) (*Page[User], error) {
+		return source.Followers(ctx, username, cursor)
+	})
+}
+
+func (c *Composite) Followings(ctx context.Context, username string, cursor string) (*Page[User], error) {
+	return callPage(ctx, c, "Followings", cursor, func(source Source, cursor string) (*Page[User], error) {
+		return source.Followings(ctx, username, cursor)
+	})
+}
+
+func (c *Composite) Mentions(ctx context.Context, handle string) ([]Tweet, error) {
+	return call(ctx, c, "Mentions", "", func(source Source) ([]Tweet, error) {
+		return source.Mentions(ctx, handle)
+	})
+}
+
+// callPage reads a page from the source pinned by the cursor, or from the first
+// available source for the first page, and pins the next cursor to it.
+func callPage[T any](ctx context.Context, c *Composite, method string, cursor string, fn func(Source, string) (*Page[T], error)) (*Page[T], error) {
+	pinned, cursor := c.splitCursor(cursor)
+	var servedBy string
+	page, err := call(ctx, c, method, pinned, func(source Source) (*Page[T], error) {
+		servedBy = source.Name()
+		return fn(source, cursor)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if page.NextCursor != "" {
+		page.NextCursor = servedBy + ":" + page.NextCursor
+	}
+	return page, nil
+}
+
+// call runs fn on the sources in order, only on the pinned source if one is
+// given. The last error is returned when every source failed, a canceled
+// context or a client error ends the call without counting a failure.
+func call[T any](ctx context.Context, c *Composite, method string, pinned string, fn func(Source) (T, error)) (T, error) {
+	var zero T
+	var lastErr error
+	for _, source := range c.sources {
+		name := source.Name()
+		if pinned != "" && name != pinned {
+			continue
+		}
+		if !c.allow(name) {
+			continue
+		}
+		result, err := fn(source)
+		if err != nil && ctx.Err() != nil {
+			c.release(name)
+			return zero, ctx.Err()
+		}
+		if errors.Is(err, ErrNotSupported) {
+			c.release(name)
+			if lastErr == nil {
+				lastErr = err
+			}
+			continue
+		}
+		if err != nil && !isSourceFailure(err) {
+			c.release(name)
+			return zero, fmt.Errorf("%s: %s: %w", method, name, err)
+		}
+		if err != nil {
+			c.failure(name)
+			log.Printf("Twitter source %s failed on %s: %v", name, method, err)
+			lastErr = fmt.Errorf("%s: %w", name, err)
+			continue
+		}
+		c.success(name)
+		return result, nil
+	}
+	if lastErr == nil {
+		lastErr = ErrNoSource
+	}
+	return zero, fmt.Errorf("%s: %w", method, lastErr)
+}
+
+// isSourceFailure reports whether err tells the source is unhealthy: an error
+// without a response, a 5xx or a 429. A 404 or another 4xx is about the request.
+func isSourceFailure(err error) bool {
+	status := 0
+	var apiErr *twitterapi.APIError
+	var statusErr *twitterapi_reverse.StatusError
+	switch {
+	case errors.As(err, &apiErr):
+		status = apiErr.StatusCode
+	case errors.As(err, &statusErr):
+		status = statusErr.StatusCode
+	}
+	return status == 0 || status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// splitCursor returns the source a cursor is pinned to and the cursor of that
+// source. Cursors without a known source prefix are passed to every source.
+func (c *Composite) splitCursor(cursor string) (string, string) {
+	name, sourceCursor, found := strings.Cut(cursor, ":")
+	if !found {
+		return "", cursor
+	}
+	for _, source := range c.sources {
+		if source.Name() == name {
+			return name, sourceCursor
+		}
+	}
+	return "", cursor
+}
+
+func (c *Composite) allow(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state := c.breakers[name]
+	if state == nil || state.openUntil.IsZero() {
+		return true
+	}
+	if c.now().Before(state.openUntil) || state.trial {
+		return false
+	}
+	state.trial = true
+	return true
+}
+
+// release ends a trial call that did not tell anything about the source.
+func (c *Composite) release(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if state := c.breakers[name]; state != nil {
+		state.trial = false
+	}
+}
+
+func (c *Composite) success(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if state := c.breakers[name]; state != nil && !state.openUntil.IsZero() {
+		log.Printf("Twitter source %s recovered", name)
+	}
+	delete(c.breakers, name)
+}
+
+func (c *Composite) failure(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state := c.breakers[name]
+	if state == nil {
+		state = &breaker{}
+		c.breakers[name] = state
+	}
+	state.failures++
+	if state.trial || state.failures >= c.failures {
+		state.openUntil = c.now().Add(c.cooldown)
+		state.trial = false
+		log.Printf("Twitter source %s failed %d times in a row, skipping it for %s", name, state.failures, c.cooldown)
+	}
+}
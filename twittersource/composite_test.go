@@ -0,0 +1,219 @@
+package twittersource
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grutapig/hackaton/twitterapi"
+	"github.com/grutapig/hackaton/twitterapi_reverse"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSource struct {
+	name    string
+	err     error
+	calls   int
+	cursors []string
+}
+
+func (s *fakeSource) Name() string {
+	return s.name
+}
+
+func (s *fakeSource) page(cursor string) (*Page[Tweet], error) {
+	s.calls++
+	s.cursors = append(s.cursors, cursor)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &Page[Tweet]{Items: []Tweet{{ID: s.name + cursor}}, NextCursor: cursor + "next"}, nil
+}
+
+func (s *fakeSource) CommunityTweets(ctx context.Context, communityID string, cursor string) (*Page[Tweet], error) {
+	return s.page(cursor)
+}
+
+func (s *fakeSource) TweetReplies(ctx context.Context, tweetID string, cursor string) (*Page[Tweet], error) {
+	return s.page(cursor)
+}
+
+func (s *fakeSource) TweetsByIDs(ctx context.Context, tweetIDs []string) ([]Tweet, error) {
+	page, err := s.page("")
+	if err != nil {
+		return nil, err
+	}
+	return page.Items, nil
+}
+
+func (s *fakeSource) UserTimeline(ctx context.Context, username string, cursor string) (*Page[Tweet], error) {
+	return s.page(cursor)
+}
+
+func (s *fakeSource) Search(ctx context.Context, query string, cursor string) (*Page[Tweet], error) {
+	return s.page(cursor)
+}
+
+func (s *fakeSource) Followers(ctx context.Context, username string, cursor string) (*Page[User], error) {
+	return nil, ErrNotSupported
+}
+
+func (s *fakeSource) Followings(ctx context.Context, username string, cursor string) (*Page[User], error) {
+	return nil, ErrNotSupported
+}
+
+func (s *fakeSource) Mentions(ctx context.Context, handle string) ([]Tweet, error) {
+	return s.TweetsByIDs(ctx, nil)
+}
+
+func TestComposite_FallsBackInOrder(t *testing.T) {
+	ctx := context.Background()
+	first := &fakeSource{name: "first", err: errors.New("down")}
+	second := &fakeSource{name: "second"}
+	composite := NewComposite(first, second)
+
+	tweets, err := composite.TweetsByIDs(ctx, []string{"1"})
+	require.NoError(t, err)
+	assert.Equal(t, "second", tweets[0].ID)
+	assert.Equal(t, 1, first.calls)
+
+	first.err = nil
+	tweets, err = composite.TweetsByIDs(ctx, []string{"1"})
+	require.NoError(t, err)
+	assert.Equal(t, "first", tweets[0].ID)
+}
+
+func TestComposite_NotSupportedEverywhere(t *testing.T) {
+	ctx := context.Background()
+	composite := NewComposite(&fakeSource{name: "first"}, &fakeSource{name: "second"})
+
+	_, err := composite.Followers(ctx, "user", "")
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
+
+func TestComposite_CircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	first := &fakeSource{name: "first", err: errors.New("down")}
+	second := &fakeSource{name: "second"}
+	composite := NewComposite(first, second)
+	composite.now = func() time.Time { return now }
+
+	for i := 0; i < BREAKER_FAILURES+2; i++ {
+		_, err := composite.Search(ctx, "query", "")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, BREAKER_FAILURES, first.calls, "an open breaker skips the source")
+
+	now = now.Add(BREAKER_COOLDOWN)
+	_, err := composite.Search(ctx, "query", "")
+	require.NoError(t, err)
+	assert.Equal(t, BREAKER_FAILURES+1, first.calls, "one trial call after the cooldown")
+
+	_, err = composite.Search(ctx, "query", "")
+	require.NoError(t, err)
+	assert.Equal(t, BREAKER_FAILURES+1, first.calls, "a failed trial opens the breaker again")
+
+	now = now.Add(BREAKER_COOLDOWN)
+	first.err = nil
+	page, err := composite.Search(ctx, "query", "")
+	require.NoError(t, err)
+	assert.Equal(t, "first", page.Items[0].ID)
+
+	first.err = errors.New("down")
+	composite.Search(ctx, "query", "")
+	composite.Search(ctx, "query", "")
+	assert.Equal(t, BREAKER_FAILURES+4, first.calls, "a successful trial closes the breaker")
+}
+
+func TestComposite_ClientErrorIsNoFailure(t *testing.T) {
+	ctx := context.Background()
+	first := &fakeSource{name: "first", err: &twitterapi.APIError{Endpoint: "/twitter/tweets", StatusCode: http.StatusNotFound}}
+	second := &fakeSource{name: "second"}
+	composite := NewComposite(first, second)
+
+	for i := 0; i < BREAKER_FAILURES+1; i++ {
+		_, err := composite.TweetsByIDs(ctx, []string{"1"})
+		assert.ErrorIs(t, err, twitterapi.ErrNotFound)
+	}
+	assert.Equal(t, BREAKER_FAILURES+1, first.calls, "a 404 does not open the breaker")
+	assert.Zero(t, second.calls, "a client error does not fall back")
+
+	for _, err := range []error{
+		&twitterapi.APIError{StatusCode: http.StatusTooManyRequests},
+		&twitterapi_reverse.StatusError{StatusCode: http.StatusBadGateway},
+	} {
+		first.err = err
+		_, fallbackErr := composite.TweetsByIDs(ctx, []string{"1"})
+		assert.NoError(t, fallbackErr, "%v falls back", err)
+	}
+	assert.Equal(t, 2, second.calls)
+}
+
+func TestComposite_CursorIsPinnedToItsSource(t *testing.T) {
+	ctx := context.Background()
+	first := &fakeSource{name: "first"}
+	second := &fakeSource{name: "second"}
+	composite := NewComposite(first, second)
+
+	page, err := composite.CommunityTweets(ctx, "community", "")
+	require.NoError(t, err)
+	assert.Equal(t, "first:next", page.NextCursor)
+
+	first.err = errors.New("down")
+	_, err = composite.CommunityTweets(ctx, "community", page.NextCursor)
+	assert.Error(t, err, "the next page is never read from another source")
+	assert.Equal(t, []string{"", "next"}, first.cursors)
+	assert.Zero(t, second.calls)
+
+	page, err = composite.CommunityTweets(ctx, "community", "")
+	require.NoError(t, err)
+	assert.Equal(t, "second:next", page.NextCursor)
+	page, err = composite.CommunityTweets(ctx, "community", page.NextCursor)
+	require.NoError(t, err)
+	assert.Equal(t, "secondnext", page.Items[0].ID)
+	assert.Equal(t, []string{"", "next"}, second.cursors)
+}
+
+func TestComposite_CanceledContextIsNoFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	first := &fakeSource{name: "first", err: context.Canceled}
+	second := &fakeSource{name: "second"}
+	composite := NewComposite(first, second)
+
+	for i := 0; i < BREAKER_FAILURES; i++ {
+		_, err := composite.Search(ctx, "query", "")
+		assert.ErrorIs(t, err, context.Canceled)
+	}
+	assert.Zero(t, second.calls, "a canceled call does not fall back")
+
+	first.err = nil
+	page, err := composite.Search(context.Background(), "query", "")
+	require.NoError(t, err)
+	assert.Equal(t, "first", page.Items[0].ID, "the breaker stays closed")
+}
+
+func TestLimited_WaitsForEveryCall(t *testing.T) {
+	source := &fakeSource{name: "api"}
+	waits := 0
+	limited := NewLimited(source, func(ctx context.Context) error {
+		waits++
+		return ctx.Err()
+	})
+
+	tweets, err := TimelinePages(limited, "alice", PageOptions{MaxPages: 3}).All(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, tweets, 3)
+	assert.Equal(t, 3, waits, "every page waits")
+	assert.Equal(t, "api", limited.Name())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = limited.TweetsByIDs(ctx, []string{"1"})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 3, source.calls, "the source is not called when the wait fails")
+}
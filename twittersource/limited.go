@@ -0,0 +1,75 @@
+package twittersource
+
+import "context"
+
+// Limited calls wait before every call of its source, each page of a paged
+// call included. An error of wait, such as the end of the context, is returned
+// instead of calling the source.
+type Limited struct {
+	source Source
+	wait   func(ctx context.Context) error
+}
+
+func NewLimited(source Source, wait func(ctx context.Context) error) *Limited {
+	return &Limited{source: source, wait: wait}
+}
+
+func (l *Limited) Name() string {
+	return l.source.Name()
+}
+
+func (l *Limited) CommunityTweets(ctx context.Context, communityID string, cursor string) (*Page[Tweet], error) {
+	if err := l.wait(ctx); err != nil {
+		return nil, err
+	}
+	return l.source.CommunityTweets(ctx, communityID, cursor)
+}
+
+func (l *Limited) TweetReplies(ctx context.Context, tweetID string, cursor string) (*Page[Tweet], error) {
+	if err := l.wait(ctx); err != nil {
+		return nil, err
+	}
+	return l.source.TweetReplies(ctx, tweetID, cursor)
+}
+
+func (l *Limited) TweetsByIDs(ctx context.Context, tweetIDs []string) ([]Tweet, error) {
+	if err := l.wait(ctx); err != nil {
+		return nil, err
+	}
+	return l.source.TweetsByIDs(ctx, tweetIDs)
+}
+
+func (l *Limited) UserTimeline(ctx context.Context, username string, cursor string) (*Page[Tweet], error) {
+	if err := l.wait(ctx); err != nil {
+		return nil, err
+	}
+	return l.source.UserTimeline(ctx, username, cursor)
+}
+
+func (l *Limited) Search(ctx context.Context, query string, cursor string) (*Page[Tweet], error) {
+	if err := l.wait(ctx); err != nil {
+		return nil, err
+	}
+	return l.source.Search(ctx, query, cursor)
+}
+
+func (l *Limited) Followers(ctx context.Context, username string, cursor string) (*Page[User], error) {
+	if err := l.wait(ctx); err != nil {
+		return nil, err
+	}
+	return l.source.Followers(ctx, username, cursor)
+}
+
+func (l *Limited) Followings(ctx context.Context, username string, cursor string) (*Page[User], error) {
+	if err := l.wait(ctx); err != nil {
+		return nil, err
+	}
+	return l.source.Followings(ctx, username, cursor)
+}
+
+func (l *Limited) Mentions(ctx context.Context, handle string) ([]Tweet, error) {
+	if err := l.wait(ctx); err != nil {
+		return nil, err
+	}
+	return l.source.Mentions(ctx, handle)
+}
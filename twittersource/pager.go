@@ -0,0 +1,61 @@
+package twittersource
+
+import (
+	"context"
+	"time"
+
+	"github.com/grutapig/hackaton/twitterapi"
+)
+
+type PageOptions = twitterapi.PageOptions
+
+// Pages walks the pages of a Source call with twitterapi.Pager.
+func Pages[T any](fetch func(ctx context.Context, cursor string) (*Page[T], error), createdAt func(T) time.Time, options PageOptions) *twitterapi.Pager[T] {
+	return twitterapi.NewPager(func(ctx context.Context, cursor string) ([]T, string, error) {
+		page, err := fetch(ctx, cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		return page.Items, page.NextCursor, nil
+	}, createdAt, options)
+}
+
+func CommunityTweetPages(source Source, communityID string, options PageOptions) *twitterapi.Pager[Tweet] {
+	return Pages(func(ctx context.Context, cursor string) (*Page[Tweet], error) {
+		return source.CommunityTweets(ctx, communityID, cursor)
+	}, tweetCreatedAt, options)
+}
+
+func ReplyPages(source Source, tweetID string, options PageOptions) *twitterapi.Pager[Tweet] {
+	return Pages(func(ctx context.Context, cursor string) (*Page[Tweet], error) {
+		return source.TweetReplies(ctx, tweetID, cursor)
+	}, tweetCreatedAt, options)
+}
+
+func TimelinePages(source Source, username string, options PageOptions) *twitterapi.Pager[Tweet] {
+	return Pages(func(ctx context.Context, cursor string) (*Page[Tweet], error) {
+		return source.UserTimeline(ctx, username, cursor)
+	}, tweetCreatedAt, options)
+}
+
+func SearchPages(source Source, query string, options PageOptions) *twitterapi.Pager[Tweet] {
+	return Pages(func(ctx context.Context, cursor string) (*Page[Tweet], error) {
+		return source.Search(ctx, query, cursor)
+	}, tweetCreatedAt, options)
+}
+
+func FollowerPages(source Source, username string, options PageOptions) *twitterapi.Pager[User] {
+	return Pages(func(ctx context.Context, cursor string) (*Page[User], error) {
+		return source.Followers(ctx, username, cursor)
+	}, nil, options)
+}
+
+func FollowingPages(source Source, username string, options PageOptions) *twitterapi.Pager[User] {
+	return Pages(func(ctx context.Context, cursor string) (*Page[User], error) {
+		return source.Followings(ctx, username, cursor)
+	}, nil, options)
+}
+
+func tweetCreatedAt(tweet Tweet) time.Time {
+	return tweet.CreatedAt
+}
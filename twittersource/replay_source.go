@@ -0,0 +1,292 @@
+package twittersource
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grutapig/hackaton/twitterapi"
+	"github.com/grutapig/hackaton/twitterapi_reverse"
+)
+
+const REPLAY_PAGE_SIZE = 20
+
+// ReplaySource serves a recorded stream of community tweets without network.
+// Tweets become visible in the order they were created, one Advance at a time,
+// so a monitoring pass after each Advance sees the community as it grew.
+type ReplaySource struct {
+	mu         sync.RWMutex
+	tweets     []Tweet
+	visible    int
+	followers  map[string][]User
+	followings map[string][]User
+}
+
+// NewReplaySource returns a source over the tweets, none of them visible yet.
+func NewReplaySource(tweets []Tweet) *ReplaySource {
+	sorted := append([]Tweet{}, tweets...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt.Before(sorted[j].CreatedAt)
+	})
+	return &ReplaySource{
+		tweets:     sorted,
+		followers:  map[string][]User{},
+		followings: map[string][]User{},
+	}
+}
+
+// LoadReplay reads a capture: a .jsonl file holds one twitterapi.io tweet per
+// line, a .json file is a community timeline response of the reverse API.
+func LoadReplay(path string) (*ReplaySource, error) {
+	if filepath.Ext(path) == ".json" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		reverseTweets, err := twitterapi_reverse.ParseCommunityTweets(data)
+		if err != nil && len(reverseTweets) == 0 {
+			return nil, fmt.Errorf("parse replay %s: %w", path, err)
+		}
+		if err != nil {
+			// instructions without entries are reported but the tweets are usable
+			log.Printf("Replay %s parsed with errors: %v", path, err)
+		}
+		tweets := make([]Tweet, 0, len(reverseTweets))
+		for _, reverseTweet := range reverseTweets {
+			tweets = append(tweets, fromReverseTweet(reverseTweet))
+		}
+		return NewReplaySource(tweets), nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var tweets []Tweet
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var tweet twitterapi.Tweet
+		if err := json.Unmarshal(scanner.Bytes(), &tweet); err != nil {
+			return nil, fmt.Errorf("parse replay %s line %d: %w", path, line, err)
+		}
+		tweets = append(tweets, FromAPITweet(tweet))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return NewReplaySource(tweets), nil
+}
+
+func fromReverseTweet(tweet twitterapi_reverse.Tweet) Tweet {
+	return Tweet{
+		ID:             tweet.ID,
+		Text:           tweet.FullText,
+		CreatedAt:      tweet.CreatedAt,
+		InReplyToID:    tweet.InReplyToStatusID,
+		ConversationID: tweet.ConversationID,
+		ReplyCount:     int(tweet.ReplyCount),
+		LikeCount:      int(tweet.LikeCount),
+		RetweetCount:   int(tweet.RetweetCount),
+		QuoteCount:     int(tweet.QuoteCount),
+		Author: User{
+			ID:        tweet.Author.ID,
+			Username:  tweet.Author.ScreenName,
+			Name:      tweet.Author.Name,
+			CreatedAt: tweet.Author.CreatedAt,
+		},
+	}
+}
+
+// Advance makes the next tweets visible, all the tweets created at the same
+// time as the next one. It returns false once every tweet is visible.
+func (s *ReplaySource) Advance() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.visible >= len(s.tweets) {
+		return false
+	}
+	next := s.tweets[s.visible].CreatedAt
+	for s.visible < len(s.tweets) && !s.tweets[s.visible].CreatedAt.After(next) {
+		s.visible++
+	}
+	return true
+}
+
+// Clock returns the creation time of the newest visible tweet.
+func (s *ReplaySource) Clock() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.visible == 0 {
+		return time.Time{}
+	}
+	return s.tweets[s.visible-1].CreatedAt
+}
+
+func (s *ReplaySource) Len() int {
+	return len(s.tweets)
+}
+
+func (s *ReplaySource) SetFollowers(username string, users []User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.followers[strings.ToLower(username)] = users
+}
+
+func (s *ReplaySource) SetFollowings(username string, users []User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.followings[strings.ToLower(username)] = users
+}
+
+func (s *ReplaySource) Name() string {
+	return "replay"
+}
+
+// CommunityTweets returns the visible posts, newest first. The reply count of
+// a post is the number of visible tweets of its conversation.
+func (s *ReplaySource) CommunityTweets(ctx context.Context, communityID string, cursor string) (*Page[Tweet], error) {
+	posts := s.filter(func(tweet Tweet) bool { return tweet.InReplyToID == "" })
+	reverse(posts)
+	return replayPage(posts, cursor)
+}
+
+// TweetReplies returns the visible conversation below the tweet, oldest first.
+func (s *ReplaySource) TweetReplies(ctx context.Context, tweetID string, cursor string) (*Page[Tweet], error) {
+	replies := s.filter(func(tweet Tweet) bool {
+		return tweet.ID != tweetID && (tweet.InReplyToID == tweetID || tweet.ConversationID == tweetID)
+	})
+	return replayPage(replies, cursor)
+}
+
+func (s *ReplaySource) TweetsByIDs(ctx context.Context, tweetIDs []string) ([]Tweet, error) {
+	wanted := map[string]bool{}
+	for _, tweetID := range tweetIDs {
+		wanted[tweetID] = true
+	}
+	return s.filter(func(tweet Tweet) bool { return wanted[tweet.ID] }), nil
+}
+
+func (s *ReplaySource) UserTimeline(ctx context.Context, username string, cursor string) (*Page[Tweet], error) {
+	tweets := s.filter(func(tweet Tweet) bool { return strings.EqualFold(tweet.Author.Username, username) })
+	reverse(tweets)
+	return replayPage(tweets, cursor)
+}
+
+// Search matches the from:username operator and requires every other term in
+// the text, case insensitive.
+func (s *ReplaySource) Search(ctx context.Context, query string, cursor string) (*Page[Tweet], error) {
+	var from string
+	var terms []string
+	for _, field := range strings.Fields(strings.ToLower(query)) {
+		if strings.HasPrefix(field, "from:") {
+			from = strings.TrimPrefix(field, "from:")
+			continue
+		}
+		terms = append(terms, field)
+	}
+	tweets := s.filter(func(tweet Tweet) bool {
+		if from != "" && strings.ToLower(tweet.Author.Username) != from {
+			return false
+		}
+		text := strings.ToLower(tweet.Text)
+		for _, term := range terms {
+			if !strings.Contains(text, term) {
+				return false
+			}
+		}
+		return true
+	})
+	reverse(tweets)
+	return replayPage(tweets, cursor)
+}
+
+func (s *ReplaySource) Followers(ctx context.Context, username string, cursor string) (*Page[User], error) {
+	s.mu.RLock()
+	users := s.followers[strings.ToLower(username)]
+	s.mu.RUnlock()
+	return replayPage(users, cursor)
+}
+
+func (s *ReplaySource) Followings(ctx context.Context, username string, cursor string) (*Page[User], error) {
+	s.mu.RLock()
+	users := s.followings[strings.ToLower(username)]
+	s.mu.RUnlock()
+	return replayPage(users, cursor)
+}
+
+func (s *ReplaySource) Mentions(ctx context.Context, handle string) ([]Tweet, error) {
+	page, err := s.Search(ctx, handle, "")
+	if err != nil {
+		return nil, err
+	}
+	return page.Items, nil
+}
+
+// filter returns the visible tweets matching keep, oldest first, with the
+// reply counts of the visible replies.
+func (s *ReplaySource) filter(keep func(Tweet) bool) []Tweet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	visible := s.tweets[:s.visible]
+	replyCounts := map[string]int{}
+	for _, tweet := range visible {
+		if tweet.InReplyToID != "" {
+			replyCounts[tweet.InReplyToID]++
+		}
+		if tweet.ConversationID != "" && tweet.ConversationID != tweet.ID && tweet.ConversationID != tweet.InReplyToID {
+			replyCounts[tweet.ConversationID]++
+		}
+	}
+
+	var tweets []Tweet
+	for _, tweet := range visible {
+		if keep(tweet) {
+			tweet.ReplyCount = replyCounts[tweet.ID]
+			tweets = append(tweets, tweet)
+		}
+	}
+	return tweets
+}
+
+func replayPage[T any](items []T, cursor string) (*Page[T], error) {
+	offset := 0
+	if cursor != "" {
+		var err error
+		offset, err = strconv.Atoi(cursor)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("invalid replay cursor %q", cursor)
+		}
+	}
+	page := &Page[T]{}
+	if offset >= len(items) {
+		return page, nil
+	}
+	end := min(offset+REPLAY_PAGE_SIZE, len(items))
+	page.Items = items[offset:end]
+	if end < len(items) {
+		page.NextCursor = strconv.Itoa(end)
+	}
+	return page, nil
+}
+
+func reverse[T any](items []T) {
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+}
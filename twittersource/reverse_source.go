@@ -0,0 +1,120 @@
+package twittersource
+
+import (
+	"context"
+
+	"github.com/grutapig/hackaton/twitterapi_reverse"
+)
+
+const SOURCE_REVERSE = "reverse"
+const REVERSE_PAGE_SIZE = 20
+
+// ReverseSource reads through the logged in x.com session. It serves community
+// timelines, conversations, single tweets and the mentions of the session
+// account. The reverse API takes no context, it is checked between calls.
+type ReverseSource struct {
+	reverse *twitterapi_reverse.TwitterReverseService
+}
+
+func NewReverseSource(reverse *twitterapi_reverse.TwitterReverseService) *ReverseSource {
+	return &ReverseSource{reverse: reverse}
+}
+
+func (s *ReverseSource) Name() string {
+	return SOURCE_REVERSE
+}
+
+func (s *ReverseSource) CommunityTweets(ctx context.Context, communityID string, cursor string) (*Page[Tweet], error) {
+	page, err := s.reverse.GetCommunityTweetsPage(communityID, REVERSE_PAGE_SIZE, cursor)
+	if err != nil {
+		return nil, err
+	}
+	return reversePage(page.Tweets, cursor, page.NextCursor), nil
+}
+
+func (s *ReverseSource) TweetReplies(ctx context.Context, tweetID string, cursor string) (*Page[Tweet], error) {
+	page, err := s.reverse.GetTweetReplies(tweetID, cursor)
+	if err != nil {
+		return nil, err
+	}
+	return reversePage(page.Tweets, cursor, page.NextCursor), nil
+}
+
+func (s *ReverseSource) TweetsByIDs(ctx context.Context, tweetIDs []string) ([]Tweet, error) {
+	tweets := make([]Tweet, 0, len(tweetIDs))
+	for _, tweetID := range tweetIDs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		tweet, err := s.reverse.GetTweetDetail(tweetID)
+		if err != nil {
+			return nil, err
+		}
+		tweets = append(tweets, FromSimpleTweet(*tweet))
+	}
+	return tweets, nil
+}
+
+func (s *ReverseSource) UserTimeline(ctx context.Context, username string, cursor string) (*Page[Tweet], error) {
+	return nil, ErrNotSupported
+}
+
+func (s *ReverseSource) Search(ctx context.Context, query string, cursor string) (*Page[Tweet], error) {
+	return nil, ErrNotSupported
+}
+
+func (s *ReverseSource) Followers(ctx context.Context, username string, cursor string) (*Page[User], error) {
+	return nil, ErrNotSupported
+}
+
+func (s *ReverseSource) Followings(ctx context.Context, username string, cursor string) (*Page[User], error) {
+	return nil, ErrNotSupported
+}
+
+// Mentions returns the notifications of the session account, the handle is
+// expected to be that account.
+func (s *ReverseSource) Mentions(ctx context.Context, handle string) ([]Tweet, error) {
+	simpleTweets, err := s.reverse.GetNotificationsSimple()
+	if err != nil {
+		return nil, err
+	}
+	return fromSimpleTweets(simpleTweets), nil
+}
+
+// reversePage ends the pagination on an empty page or a repeated cursor, the
+// timelines keep returning a bottom cursor after the last tweet.
+func reversePage(simpleTweets []twitterapi_reverse.SimpleTweet, cursor string, nextCursor string) *Page[Tweet] {
+	page := &Page[Tweet]{Items: fromSimpleTweets(simpleTweets)}
+	if len(simpleTweets) > 0 && nextCursor != cursor {
+		page.NextCursor = nextCursor
+	}
+	return page
+}
+
+func fromSimpleTweets(simpleTweets []twitterapi_reverse.SimpleTweet) []Tweet {
+	tweets := make([]Tweet, 0, len(simpleTweets))
+	for _, simpleTweet := range simpleTweets {
+		tweets = append(tweets, FromSimpleTweet(simpleTweet))
+	}
+	return tweets
+}
+
+func FromSimpleTweet(simpleTweet twitterapi_reverse.SimpleTweet) Tweet {
+	return Tweet{
+		ID:                simpleTweet.TweetID,
+		Text:              simpleTweet.Text,
+		CreatedAt:         simpleTweet.CreatedAt,
+		InReplyToID:       simpleTweet.ReplyToID,
+		InReplyToUsername: simpleTweet.ReplyToUsername,
+		ConversationID:    simpleTweet.ConversationID,
+		ReplyCount:        simpleTweet.RepliesCount,
+		LikeCount:         simpleTweet.LikeCount,
+		RetweetCount:      simpleTweet.RetweetCount,
+		QuoteCount:        simpleTweet.QuoteCount,
+		Author: User{
+			ID:       simpleTweet.Author.ID,
+			Username: simpleTweet.Author.Username,
+			Name:     simpleTweet.Author.Name,
+		},
+	}
+}
@@ -0,0 +1,78 @@
+package twittersource
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/grutapig/hackaton/twitterapi"
+)
+
+// ErrNotSupported is returned by a backend for the calls it cannot serve, the
+// composite source moves on to the next backend without counting a failure.
+var ErrNotSupported = errors.New("not supported by this twitter source")
+
+// ErrNoSource is returned when every backend able to serve a call is skipped
+// by its circuit breaker.
+var ErrNoSource = errors.New("no twitter source available")
+
+// Tweet is the tweet of the bot, every backend converts its own format to it.
+type Tweet struct {
+	ID                string
+	Text              string
+	CreatedAt         time.Time
+	Author            User
+	InReplyToID       string
+	InReplyToUsername string
+	ConversationID    string
+	ReplyCount        int
+	LikeCount         int
+	RetweetCount      int
+	QuoteCount        int
+	ViewCount         int
+}
+
+type User struct {
+	ID             string
+	Username       string
+	Name           string
+	Description    string
+	FollowersCount int
+	FollowingCount int
+	CreatedAt      time.Time
+}
+
+// Page is one page of results, NextCursor is empty on the last page. Cursors
+// are only valid for the source that returned them.
+type Page[T any] struct {
+	Items      []T
+	NextCursor string
+}
+
+// Source reads Twitter data, the context ends a call early where the backend
+// supports it. twitterapi.io and the reverse API implement it,
+// Composite chains them with fallback.
+type Source interface {
+	Name() string
+	CommunityTweets(ctx context.Context, communityID string, cursor string) (*Page[Tweet], error)
+	TweetReplies(ctx context.Context, tweetID string, cursor string) (*Page[Tweet], error)
+	TweetsByIDs(ctx context.Context, tweetIDs []string) ([]Tweet, error)
+	UserTimeline(ctx context.Context, username string, cursor string) (*Page[Tweet], error)
+	Search(ctx context.Context, query string, cursor string) (*Page[Tweet], error)
+	Followers(ctx context.Context, username string, cursor string) (*Page[User], error)
+	Followings(ctx context.Context, username string, cursor string) (*Page[User], error)
+	Mentions(ctx context.Context, handle string) ([]Tweet, error)
+}
+
+// ParseCreatedAt parses the tweet dates of both backends, the zero time is
+// returned for an unknown format.
+func ParseCreatedAt(value string) time.Time {
+	for _, layout := range []string{TWITTER_TIME_LAYOUT, time.RFC1123, time.RFC1123Z, time.RFC3339} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed
+		}
+	}
+	return time.Time{}
+}
+
+const TWITTER_TIME_LAYOUT = twitterapi.TWITTER_TIME_LAYOUT
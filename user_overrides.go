@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/grutapig/hackaton/twitterapi"
+)
+
+// applyUserOverride returns the override of the message author, nil when the
+// author has none, after pinning the status of the user to it.
+func applyUserOverride(newMessage twitterapi.NewMessage, dbService *DatabaseService) *UserOverrideModel {
+	override := dbService.GetUserOverride(newMessage.Author.ID, newMessage.Author.UserName)
+	if override == nil {
+		return nil
+	}
+	if err := dbService.ApplyUserOverride(override); err != nil {
+		log.Printf("Failed to apply the override of %s: %v", newMessage.Author.UserName, err)
+	}
+	return override
+}
+
+// denylistAlert reports a message of a denylisted user, the message is not
+// analyzed.
+func denylistAlert(newMessage twitterapi.NewMessage, override *UserOverrideModel) FUDAlertNotification {
+	decision := override.Decision()
+	alert := FUDAlertNotification{
+		FUDMessageID:      newMessage.TweetID,
+		FUDUserID:         newMessage.Author.ID,
+		FUDUsername:       newMessage.Author.UserName,
+		ThreadID:          newMessage.ReplyTweetID,
+		DetectedAt:        time.Now().Format(time.RFC3339),
+		AlertSeverity:     "high",
+		FUDType:           "denylisted_user_activity",
+		FUDProbability:    decision.FUDProbability,
+		MessagePreview:    newMessage.Text,
+		RecommendedAction: "MONITOR_CLOSELY",
+		KeyEvidence:       decision.KeyEvidence,
+		DecisionReason:    decision.DecisionReason,
+		UserSummary:       decision.UserSummary,
+		CommunityID:       newMessage.CommunityID,
+		Backfill:          newMessage.Backfill,
+	}
+	if newMessage.GrandParentTweet.ID != "" {
+		alert.GrandParentPostText = newMessage.GrandParentTweet.Text
+		alert.GrandParentPostAuthor = newMessage.GrandParentTweet.Author
+		alert.OriginalPostText = newMessage.GrandParentTweet.Text
+		alert.OriginalPostAuthor = newMessage.GrandParentTweet.Author
+	} else {
+		alert.OriginalPostText = newMessage.ParentTweet.Text
+		alert.OriginalPostAuthor = newMessage.ParentTweet.Author
+	}
+	if newMessage.ParentTweet.ID != "" {
+		alert.ParentPostText = newMessage.ParentTweet.Text
+		alert.ParentPostAuthor = newMessage.ParentTweet.Author
+		alert.HasThreadContext = true
+	}
+	return alert
+}
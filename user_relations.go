@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/grutapig/hackaton/twitterapi"
+	"github.com/grutapig/hackaton/twittersource"
+)
+
+const RELATIONS_DEFAULT_MAX_PAGES = 5
+const RELATIONS_DEFAULT_REFRESH_DAYS = 7
+
+// RelationOptions limit the follower graph collection of the second step.
+type RelationOptions struct {
+	// MaxPages caps the pages read per relation type, 0 reads every page.
+	MaxPages int
+	// RefreshAfter is the age of the stored relations after which they are
+	// fetched again, 0 fetches them on every analysis.
+	RefreshAfter time.Duration
+}
+
+func DefaultRelationOptions() RelationOptions {
+	return RelationOptions{
+		MaxPages:     RELATIONS_DEFAULT_MAX_PAGES,
+		RefreshAfter: RELATIONS_DEFAULT_REFRESH_DAYS * 24 * time.Hour,
+	}
+}
+
+// UserRelationsData is the follower graph of the analyzed user: the usernames
+// of the current relations and the relations with confirmed FUD users.
+type UserRelationsData struct {
+	Followers    []string
+	Followings   []string
+	FUDRelations []FUDRelation
+}
+
+// collectUserRelations returns the usernames related to the user. The relations
+// are read from the source and stored as a snapshot when the stored ones are
+// older than options.RefreshAfter, otherwise the stored ones are used.
+func collectUserRelations(ctx context.Context, source twittersource.Source, dbService *DatabaseService, userID, username, relationType string, options RelationOptions) ([]string, bool, error) {
+	relationSync, err := dbService.GetRelationSync(userID, relationType)
+	if err != nil {
+		log.Printf("Failed to read %s sync of %s: %v", relationType, username, err)
+	}
+	if relationSync != nil && options.RefreshAfter > 0 && time.Since(relationSync.SyncedAt) < options.RefreshAfter {
+		usernames, err := dbService.GetRelatedUsernames(userID, relationType)
+		return usernames, false, err
+	}
+
+	pageOptions := twittersource.PageOptions{MaxPages: options.MaxPages}
+	var pager *twitterapi.Pager[twittersource.User]
+	switch relationType {
+	case RELATION_TYPE_FOLLOWER:
+		pager = twittersource.FollowerPages(source, username, pageOptions)
+	case RELATION_TYPE_FOLLOWING:
+		pager = twittersource.FollowingPages(source, username, pageOptions)
+	default:
+		return nil, false, fmt.Errorf("unknown relation type %s", relationType)
+	}
+
+	users, err := pager.All(ctx)
+	usernames := make([]string, 0, len(users))
+	relatedIDs := make([]string, 0, len(users))
+	for _, user := range users {
+		usernames = append(usernames, user.Username)
+		relatedIDs = append(relatedIDs, user.ID)
+		if !dbService.UserExists(user.ID) {
+			dbService.SaveUser(UserModel{
+				ID:       user.ID,
+				Username: user.Username,
+				Name:     user.Name,
+			})
+		}
+	}
+	if err != nil {
+		// a partial list is used for this analysis but not stored, the next
+		// analysis fetches the relations again
+		return usernames, true, err
+	}
+
+	started, ended, err := dbService.SaveUserRelations(userID, relatedIDs, relationType, pager.Cursor() == "")
+	if err != nil {
+		log.Printf("Failed to save %ss for user %s: %v", relationType, username, err)
+	} else {
+		log.Printf("Saved %d %ss for user %s (%d new, %d ended)", len(relatedIDs), relationType, username, started, ended)
+	}
+	return usernames, true, nil
+}
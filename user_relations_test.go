@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grutapig/hackaton/twitterapi"
+	"github.com/grutapig/hackaton/twittersource"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// followersStandIn serves the followers as pages of two, the cursor is the index
+// of the first follower of the page.
+type followersStandIn struct {
+	followers []string
+	requests  int
+}
+
+func (s *followersStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests++
+	first := 0
+	fmt.Sscanf(r.URL.Query().Get("cursor"), "%d", &first)
+	var users []string
+	for i := first; i < first+2 && i < len(s.followers); i++ {
+		users = append(users, fmt.Sprintf(`{"id":"id_%s","userName":"%s"}`, s.followers[i], s.followers[i]))
+	}
+	hasNext := first+2 < len(s.followers)
+	fmt.Fprintf(w, `{"followers":[%s],"has_next_page":%t,"next_cursor":"%d"}`, strings.Join(users, ","), hasNext, first+2)
+}
+
+func TestCollectUserRelations_PaginatesAndKeepsHistory(t *testing.T) {
+	dbService := setupTestDB(t)
+	require.NoError(t, dbService.SetDefaultCommunity("community_a"))
+	standIn := &followersStandIn{followers: []string{"alice", "bob", "carol", "dave", "erin"}}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+	api := twitterapi.NewTwitterAPIService("test-key", server.URL, "")
+	api.SetRateLimit(0, 0)
+	source := twittersource.NewAPISource(api)
+	ctx := context.Background()
+
+	usernames, fetched, err := collectUserRelations(ctx, source, dbService, "user_1", "trader", RELATION_TYPE_FOLLOWER, RelationOptions{MaxPages: 2})
+	require.NoError(t, err)
+	assert.True(t, fetched)
+	assert.Equal(t, []string{"alice", "bob", "carol", "dave"}, usernames, "the page cap limits the collection")
+	relationSync, err := dbService.GetRelationSync("user_1", RELATION_TYPE_FOLLOWER)
+	require.NoError(t, err)
+	assert.False(t, relationSync.Complete)
+
+	standIn.followers = []string{"alice", "carol", "erin"}
+	usernames, _, err = collectUserRelations(ctx, source, dbService, "user_1", "trader", RELATION_TYPE_FOLLOWER, RelationOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice", "carol", "erin"}, usernames)
+
+	requests := standIn.requests
+	usernames, fetched, err = collectUserRelations(ctx, source, dbService, "user_1", "trader", RELATION_TYPE_FOLLOWER, RelationOptions{RefreshAfter: time.Hour})
+	require.NoError(t, err)
+	assert.False(t, fetched, "fresh relations are not fetched again")
+	assert.Equal(t, requests, standIn.requests)
+	assert.Equal(t, []string{"alice", "carol", "erin"}, usernames)
+
+	fud := SecondStepClaudeResponse{IsFUDUser: true, FUDType: "coordinated_campaign", FUDProbability: 0.9}
+	for _, username := range []string{"bob", "erin"} {
+		require.NoError(t, dbService.UpdateUserAfterAnalysis("id_"+username, username, fud, ""))
+		review, err := dbService.OpenFUDReview("id_"+username, username, "", fud.FUDType, fud.FUDProbability)
+		require.NoError(t, err)
+		_, err = dbService.ResolveFUDReview(review.ID, REVIEW_STATUS_CONFIRMED, 7, "reviewer")
+		require.NoError(t, err)
+	}
+
+	history, err := dbService.GetFUDRelationHistory("user_1")
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, "bob", history[0].Username)
+	assert.NotNil(t, history[0].EndedAt, "a relation missing from a complete refresh is ended")
+	assert.Equal(t, "erin", history[1].Username)
+	assert.Nil(t, history[1].EndedAt)
+	assert.False(t, history[1].FirstSeenAt.Before(history[0].FirstSeenAt))
+
+	standIn.followers = []string{"alice", "bob"}
+	_, _, err = collectUserRelations(ctx, source, dbService, "user_1", "trader", RELATION_TYPE_FOLLOWER, RelationOptions{})
+	require.NoError(t, err)
+	history, err = dbService.GetFUDRelationHistory("user_1")
+	require.NoError(t, err)
+	require.Len(t, history, 3, "following again starts a new relation")
+	assert.Nil(t, history[2].EndedAt)
+	assert.Equal(t, "bob", history[2].Username)
+}